@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Scopes a site API key can be granted. Each palacms endpoint that accepts
+// keys asks for exactly one of them.
+const (
+	ScopeContentRead  = "content:read"
+	ScopeContentWrite = "content:write"
+	ScopePublish      = "publish"
+	ScopeExport       = "export"
+	ScopeImport       = "import"
+)
+
+// siteAPIKeyPrefix marks palacms keys, so they are told apart from user
+// tokens at a glance (and by secret scanners).
+const siteAPIKeyPrefix = "pk_"
+
+// siteAPIKeyTouchInterval limits how often last_used is written, so a busy
+// frontend doesn't turn every read into a write.
+const siteAPIKeyTouchInterval = time.Minute
+
+// RegisterSiteAPIKeys issues a key whenever a site_api_keys record is
+// created. Only a hash is stored; the key itself is returned once, as the
+// "key" property of the create response.
+func RegisterSiteAPIKeys(pb *pocketbase.PocketBase) error {
+	pb.OnRecordCreateRequest("site_api_keys").BindFunc(func(e *core.RecordRequestEvent) error {
+		key := issueSiteAPIKey(e.Record)
+		e.Record.WithCustomData(true)
+		e.Record.Set("key", key)
+		return e.Next()
+	})
+	return nil
+}
+
+// issueSiteAPIKey generates a new key for record, storing its hash and a
+// short hint to recognise it by, and returns the key.
+func issueSiteAPIKey(record *core.Record) string {
+	key := siteAPIKeyPrefix + security.RandomString(40)
+	record.Set("key_hash", hashSiteAPIKey(key))
+	record.Set("hint", key[:len(siteAPIKeyPrefix)+4])
+	if len(record.GetStringSlice("scopes")) == 0 {
+		record.Set("scopes", []string{ScopeContentRead})
+	}
+	return key
+}
+
+func hashSiteAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestSiteAPIKey returns the key sent as "Authorization: Bearer <key>",
+// or "" when the request carries none. User auth tokens travel in the same
+// header; they are JWTs, so anything with a dot is left to PocketBase.
+func requestSiteAPIKey(e *core.RequestEvent) string {
+	if e.Auth != nil {
+		return ""
+	}
+	header := e.Request.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		token = header
+	}
+	token = strings.TrimSpace(token)
+	if token == "" || strings.Contains(token, ".") {
+		return ""
+	}
+	return token
+}
+
+// authorizeSiteAPIKey checks key may use scope on site, and records that it
+// was used. The returned error is ready to hand back from a handler.
+func authorizeSiteAPIKey(e *core.RequestEvent, key string, site *core.Record, scope string) error {
+	record, err := e.App.FindFirstRecordByData("site_api_keys", "key_hash", hashSiteAPIKey(key))
+	if err != nil || record.GetString("site") != site.Id {
+		return e.UnauthorizedError("Invalid API key", nil)
+	}
+
+	now := time.Now()
+	if expires := record.GetDateTime("expires"); !expires.IsZero() && expires.Time().Before(now) {
+		return e.UnauthorizedError("API key expired", nil)
+	}
+	if !slices.Contains(record.GetStringSlice("scopes"), scope) {
+		return e.ForbiddenError("API key lacks the "+scope+" scope", nil)
+	}
+
+	if lastUsed := record.GetDateTime("last_used"); lastUsed.IsZero() || now.Sub(lastUsed.Time()) > siteAPIKeyTouchInterval {
+		used, _ := types.ParseDateTime(now)
+		record.Set("last_used", used)
+		if err := e.App.SaveNoValidate(record); err != nil {
+			e.App.Logger().Warn("Failed to record API key use", "key", record.Id, "error", err)
+		}
+	}
+	return nil
+}
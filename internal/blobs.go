@@ -0,0 +1,443 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// blobCollections are the upload collections whose bytes live in
+// content-addressed storage.
+var blobCollections = []string{"site_uploads", "library_uploads"}
+
+// blobKey is the storage key of the blob with the given sha256 hex hash.
+// The two-character fan-out keeps any single directory small on backends
+// that list slowly (local disk, some S3-compatibles).
+func blobKey(hash string) string {
+	return "blobs/" + hash[:2] + "/" + hash
+}
+
+// uploadStorageKey is where an upload's bytes actually live: the shared
+// blob once it has been interned, else PocketBase's per-record file path.
+// Anything reading upload bytes must go through this rather than building
+// the record path itself.
+func uploadStorageKey(upload *core.Record) string {
+	if hash := upload.GetString("blob"); hash != "" {
+		return blobKey(hash)
+	}
+	return upload.BaseFilesPath() + "/" + upload.GetString("file")
+}
+
+// RegisterUploadBlobs moves upload bytes into content-addressed storage and
+// keeps the reference counts in upload_blobs in step with the upload rows.
+//
+// A freshly uploaded file is first written by PocketBase to the record's own
+// path; once the save succeeds it is hashed, copied to blobs/<hash> (unless
+// an identical blob exists already) and the per-record copy is removed. A
+// file at the record path therefore always means "not interned yet", which
+// is how replaced files are told apart from metadata-only updates.
+// Records created with blob already set (clone, snapshot restore, zip
+// import) only take a reference.
+func RegisterUploadBlobs(pb *pocketbase.PocketBase) error {
+	for _, name := range blobCollections {
+		pb.OnRecordAfterCreateSuccess(name).BindFunc(func(event *core.RecordEvent) error {
+			if err := syncUploadBlob(event.App, event.Record, true); err != nil {
+				event.App.Logger().Error("Failed to intern upload", "record", event.Record.Id, "error", err)
+			}
+			return event.Next()
+		})
+
+		pb.OnRecordAfterUpdateSuccess(name).BindFunc(func(event *core.RecordEvent) error {
+			if err := syncUploadBlob(event.App, event.Record, false); err != nil {
+				event.App.Logger().Error("Failed to intern upload", "record", event.Record.Id, "error", err)
+			}
+			return event.Next()
+		})
+
+		pb.OnRecordAfterDeleteSuccess(name).BindFunc(func(event *core.RecordEvent) error {
+			if hash := event.Record.GetString("blob"); hash != "" {
+				if err := releaseBlob(event.App, hash); err != nil {
+					event.App.Logger().Error("Failed to release upload blob", "hash", hash, "error", err)
+				}
+			}
+			return event.Next()
+		})
+	}
+
+	// Files are still requested through /api/files/<collection>/<id>/<name>;
+	// point interned uploads at their blob so existing URLs keep working.
+	// A blob nothing holds a reference to is never served: the row's hash
+	// wasn't set by the hooks above, so the bytes may not be this upload's.
+	pb.OnFileDownloadRequest(blobCollections...).BindFunc(func(event *core.FileDownloadRequestEvent) error {
+		if hash := event.Record.GetString("blob"); hash != "" && !blobReferenced(event.App, hash) {
+			return event.NotFoundError("", nil)
+		}
+		if thumb, ok := event.Get(blobThumbKey).(string); ok {
+			event.ServedPath = thumb
+			event.ServedName = path.Base(thumb)
+		} else if hash := event.Record.GetString("blob"); hash != "" {
+			event.ServedPath = blobKey(hash)
+		}
+		return event.Next()
+	})
+
+	// PocketBase only makes thumbs from the record's own file, which an
+	// interned upload no longer has, so ?thumb= is answered from the blob
+	// before the download handler gets to look.
+	pb.OnServe().BindFunc(func(serveEvent *core.ServeEvent) error {
+		serveEvent.Router.BindFunc(prepareBlobThumb)
+		return serveEvent.Next()
+	})
+
+	return nil
+}
+
+// blobThumbKey is the request store key prepareBlobThumb leaves the thumb
+// path under for the download hook.
+const blobThumbKey = "blobThumb"
+
+var blobThumbTypes = []string{"image/png", "image/jpg", "image/jpeg", "image/gif", "image/webp"}
+
+// prepareBlobThumb renders the requested thumb of an interned upload next
+// to where PocketBase keeps thumbs and drops the thumb parameter, so the
+// download handler (and its access checks) runs as for a plain file and
+// the download hook swaps in the thumb. Requests it can't help with pass
+// through untouched; a thumb that fails to render falls back to the
+// original, as PocketBase does.
+func prepareBlobThumb(e *core.RequestEvent) error {
+	thumbSize := e.Request.URL.Query().Get("thumb")
+	if thumbSize == "" || (e.Request.Method != http.MethodGet && e.Request.Method != http.MethodHead) {
+		return e.Next()
+	}
+	parts := strings.Split(strings.TrimPrefix(e.Request.URL.Path, "/api/files/"), "/")
+	if len(parts) != 3 || !strings.HasPrefix(e.Request.URL.Path, "/api/files/") || !slices.Contains(blobCollections, parts[0]) {
+		return e.Next()
+	}
+	record, err := e.App.FindRecordById(parts[0], parts[1])
+	if err != nil || record.GetString("blob") == "" {
+		return e.Next()
+	}
+	filename := parts[2]
+	field := record.FindFileFieldByFile(filename)
+	if field == nil || (thumbSize != "100x100" && !slices.Contains(field.Thumbs, thumbSize)) {
+		return e.Next()
+	}
+
+	fsys, err := e.App.NewFilesystem()
+	if err != nil {
+		return e.InternalServerError("Filesystem initialization failure.", err)
+	}
+	defer fsys.Close()
+
+	source := blobKey(record.GetString("blob"))
+	attrs, err := fsys.Attributes(source)
+	if err != nil || !slices.Contains(blobThumbTypes, attrs.ContentType) {
+		return e.Next()
+	}
+	thumb := record.BaseFilesPath() + "/thumbs_" + filename + "/" + thumbSize + "_" + filename
+	if exists, _ := fsys.Exists(thumb); !exists {
+		if err := fsys.CreateThumb(source, thumb, thumbSize); err != nil {
+			e.App.Logger().Warn("Fallback to original - failed to create blob thumb", "thumb", thumb, "error", err)
+			return e.Next()
+		}
+	}
+
+	query := e.Request.URL.Query()
+	query.Del("thumb")
+	e.Request.URL.RawQuery = query.Encode()
+	e.Set(blobThumbKey, thumb)
+	return e.Next()
+}
+
+func syncUploadBlob(app core.App, upload *core.Record, created bool) error {
+	name := upload.GetString("file")
+	if name == "" {
+		return nil
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	recordKey := upload.BaseFilesPath() + "/" + name
+	exists, err := fsys.Exists(recordKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if created && upload.GetString("blob") != "" {
+			return acquireBlob(app, upload.GetString("blob"))
+		}
+		return nil
+	}
+
+	reader, err := fsys.GetReader(recordKey)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+
+	previous := upload.GetString("blob")
+	hash, err := storeBlob(app, fsys, data)
+	if err != nil {
+		return err
+	}
+	if err := acquireBlob(app, hash); err != nil {
+		return err
+	}
+
+	// Write the column directly so the bookkeeping doesn't fire the
+	// update hooks (and this function) a second time.
+	if _, err := app.DB().Update(upload.Collection().Name, dbx.Params{"blob": hash}, dbx.HashExp{"id": upload.Id}).Execute(); err != nil {
+		return err
+	}
+	upload.Set("blob", hash)
+
+	if err := fsys.Delete(recordKey); err != nil {
+		return err
+	}
+
+	if previous != "" && !created {
+		return releaseBlob(app, previous)
+	}
+	return nil
+}
+
+// storeBlob writes data to content-addressed storage and makes sure its
+// upload_blobs row exists, without taking a reference: the reference
+// belongs to whichever upload row ends up pointing at the hash. A row left
+// at zero refs (the upload save failed) is harmless and is reused the next
+// time the same bytes are stored.
+func storeBlob(app core.App, fsys *filesystem.System, data []byte) (string, error) {
+	hash := blobHash(data)
+
+	exists, err := fsys.Exists(blobKey(hash))
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := fsys.Upload(data, blobKey(hash)); err != nil {
+			return "", fmt.Errorf("failed to store blob %s: %w", hash, err)
+		}
+	}
+
+	if _, err := app.FindFirstRecordByData("upload_blobs", "hash", hash); err == nil {
+		return hash, nil
+	}
+
+	blobsColl, err := app.FindCollectionByNameOrId("upload_blobs")
+	if err != nil {
+		return "", err
+	}
+	blob := core.NewRecord(blobsColl)
+	blob.Set("hash", hash)
+	blob.Set("size", len(data))
+	blob.Set("mime", mimetype.Detect(data).String())
+	blob.Set("refs", 0)
+	if err := app.Save(blob); err != nil {
+		return "", fmt.Errorf("failed to record blob %s: %w", hash, err)
+	}
+	return hash, nil
+}
+
+func blobHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// blobWrites collects the storage side of upload changes made inside a
+// transaction. Storage can't roll back with the rows, so blobs and
+// per-record copies the transaction stops using are only let go of once it
+// has committed, and blobs it wrote are removed again if it didn't.
+type blobWrites struct {
+	fsys     *filesystem.System
+	stored   []string
+	released []string
+	orphaned []string
+}
+
+func newBlobWrites(fsys *filesystem.System) *blobWrites {
+	return &blobWrites{fsys: fsys}
+}
+
+// store is storeBlob, remembering blobs whose bytes weren't stored yet.
+func (w *blobWrites) store(app core.App, data []byte) (string, error) {
+	hash := blobHash(data)
+	exists, err := w.fsys.Exists(blobKey(hash))
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		w.stored = append(w.stored, hash)
+	}
+	return storeBlob(app, w.fsys, data)
+}
+
+// release drops a reference to hash after commit.
+func (w *blobWrites) release(hash string) {
+	w.released = append(w.released, hash)
+}
+
+// orphan deletes the file at key after commit.
+func (w *blobWrites) orphan(key string) {
+	w.orphaned = append(w.orphaned, key)
+}
+
+// finish applies the collected changes once the transaction is over; txErr
+// is its result. app must be outside the transaction. The rows are already
+// committed or rolled back by then, so failures are only logged: at worst
+// a blob is left without a reference.
+func (w *blobWrites) finish(app core.App, txErr error) {
+	if txErr != nil {
+		for _, hash := range w.stored {
+			if _, err := app.FindFirstRecordByData("upload_blobs", "hash", hash); err == nil {
+				continue
+			}
+			if err := w.fsys.Delete(blobKey(hash)); err != nil {
+				app.Logger().Warn("Failed to delete blob of rolled back upload", "hash", hash, "error", err)
+			}
+		}
+		return
+	}
+	for _, hash := range w.released {
+		if err := releaseBlob(app, hash); err != nil {
+			app.Logger().Warn("Failed to release upload blob", "hash", hash, "error", err)
+		}
+	}
+	for _, key := range w.orphaned {
+		if err := w.fsys.Delete(key); err != nil {
+			app.Logger().Warn("Failed to delete replaced upload file", "key", key, "error", err)
+		}
+	}
+}
+
+// blobReferenced reports whether hash is a stored blob at least one upload
+// holds a reference to.
+func blobReferenced(app core.App, hash string) bool {
+	_, err := app.FindFirstRecordByFilter("upload_blobs", "hash = {:hash} && refs > 0", dbx.Params{"hash": hash})
+	return err == nil
+}
+
+// acquireBlob and releaseBlob adjust the count with a single UPDATE so two
+// uploads sharing a blob can't lose an increment between read and write.
+func acquireBlob(app core.App, hash string) error {
+	_, err := app.DB().NewQuery("UPDATE upload_blobs SET refs = refs + 1 WHERE hash = {:hash}").
+		Bind(dbx.Params{"hash": hash}).
+		Execute()
+	return err
+}
+
+// releaseBlob drops one reference and deletes the blob (row and bytes) once
+// nothing points at it anymore.
+func releaseBlob(app core.App, hash string) error {
+	if _, err := app.DB().NewQuery("UPDATE upload_blobs SET refs = refs - 1 WHERE hash = {:hash} AND refs > 0").
+		Bind(dbx.Params{"hash": hash}).
+		Execute(); err != nil {
+		return err
+	}
+
+	blob, err := app.FindFirstRecordByData("upload_blobs", "hash", hash)
+	if err != nil || blob.GetInt("refs") > 0 {
+		return nil
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	if err := fsys.Delete(blobKey(hash)); err != nil {
+		return fmt.Errorf("failed to delete blob %s: %w", hash, err)
+	}
+	return app.Delete(blob)
+}
+
+// internSiteUploads interns the site's uploads that predate
+// content-addressed storage, so clone can share their bytes instead of
+// copying them. It deletes the per-record copies, so app must not be a
+// transaction that could roll the blob column back.
+func internSiteUploads(app core.App, siteId string) error {
+	uploads, err := app.FindRecordsByFilter("site_uploads", "site = {:site} && file != '' && blob = ''", "", 0, 0, dbx.Params{"site": siteId})
+	if err != nil {
+		return err
+	}
+	for _, upload := range uploads {
+		if err := syncUploadBlob(app, upload, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newBlobUploadRecord builds an upload record that references stored bytes
+// by hash instead of carrying a file to upload. The caller saves it with
+// SaveNoValidate, since the file field only accepts plain filenames for
+// files it already knows about; the create hook then takes the blob
+// reference. filename should already be normalized (PocketBase's random
+// suffix), e.g. copied from the source record.
+func newBlobUploadRecord(collection *core.Collection, filename, hash string) *core.Record {
+	rec := core.NewRecord(collection)
+	rec.Set("file", filename)
+	rec.Set("blob", hash)
+	return rec
+}
+
+// UploadUsage compares what a site's uploads would take if every row had
+// its own copy (logical) against the distinct blobs it uses (physical), and
+// how much of that physical size is shared with other sites or the library.
+type UploadUsage struct {
+	Uploads       int `json:"uploads"`
+	LogicalBytes  int `json:"logical_bytes"`
+	PhysicalBytes int `json:"physical_bytes"`
+	SharedBytes   int `json:"shared_bytes"`
+}
+
+func siteUploadUsage(app core.App, siteId string) (*UploadUsage, error) {
+	uploads, err := app.FindRecordsByFilter("site_uploads", "site = {:site}", "", 0, 0, dbx.Params{"site": siteId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch uploads: %w", err)
+	}
+
+	usage := &UploadUsage{Uploads: len(uploads)}
+	hashRefs := map[string]int{}
+	for _, upload := range uploads {
+		usage.LogicalBytes += upload.GetInt("size")
+		if hash := upload.GetString("blob"); hash != "" {
+			hashRefs[hash]++
+		} else {
+			// Not interned yet: its bytes are its own.
+			usage.PhysicalBytes += upload.GetInt("size")
+		}
+	}
+
+	for hash, refs := range hashRefs {
+		blob, err := app.FindFirstRecordByData("upload_blobs", "hash", hash)
+		if err != nil {
+			continue
+		}
+		usage.PhysicalBytes += blob.GetInt("size")
+		if blob.GetInt("refs") > refs {
+			usage.SharedBytes += blob.GetInt("size")
+		}
+	}
+
+	return usage, nil
+}
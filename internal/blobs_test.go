@@ -0,0 +1,499 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+func TestCloneSharesUploadBlobs(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterUploadMetadata(app); err != nil {
+		t.Fatalf("register upload metadata: %v", err)
+	}
+	if err := RegisterUploadBlobs(app); err != nil {
+		t.Fatalf("register upload blobs: %v", err)
+	}
+
+	site := createImportTestSite(t, app)
+
+	file, err := filesystem.NewFileFromBytes([]byte("shared logo bytes"), "logo.txt")
+	if err != nil {
+		t.Fatalf("new file: %v", err)
+	}
+	uploads, err := app.FindCollectionByNameOrId("site_uploads")
+	if err != nil {
+		t.Fatalf("find site_uploads: %v", err)
+	}
+	upload := core.NewRecord(uploads)
+	upload.Set("site", site.Id)
+	upload.Set("file", file)
+	if err := app.Save(upload); err != nil {
+		t.Fatalf("save upload: %v", err)
+	}
+
+	upload, err = app.FindRecordById("site_uploads", upload.Id)
+	if err != nil {
+		t.Fatalf("reload upload: %v", err)
+	}
+	hash := upload.GetString("blob")
+	if hash == "" {
+		t.Fatal("expected upload to be interned")
+	}
+	assertBlobRefs(t, app, hash, 1)
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatalf("filesystem: %v", err)
+	}
+	defer fsys.Close()
+	if exists, _ := fsys.Exists(upload.BaseFilesPath() + "/" + upload.GetString("file")); exists {
+		t.Fatal("expected per-record copy to be removed after interning")
+	}
+
+	clone, err := cloneLocalSite(app, site, "Clone", "clone.localhost", site.GetString("group"))
+	if err != nil {
+		t.Fatalf("clone: %v", err)
+	}
+
+	cloned, err := app.FindFirstRecordByData("site_uploads", "site", clone.Id)
+	if err != nil {
+		t.Fatalf("find cloned upload: %v", err)
+	}
+	if cloned.GetString("blob") != hash {
+		t.Fatalf("expected cloned upload to share blob %s, got %q", hash, cloned.GetString("blob"))
+	}
+	assertBlobRefs(t, app, hash, 2)
+
+	usage, err := siteUploadUsage(app, clone.Id)
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	if usage.LogicalBytes != len("shared logo bytes") || usage.SharedBytes != usage.PhysicalBytes {
+		t.Fatalf("unexpected usage: %#v", usage)
+	}
+
+	if err := app.Delete(upload); err != nil {
+		t.Fatalf("delete original: %v", err)
+	}
+	assertBlobRefs(t, app, hash, 1)
+	if exists, _ := fsys.Exists(blobKey(hash)); !exists {
+		t.Fatal("blob removed while still referenced")
+	}
+
+	if err := app.Delete(cloned); err != nil {
+		t.Fatalf("delete clone upload: %v", err)
+	}
+	if exists, _ := fsys.Exists(blobKey(hash)); exists {
+		t.Fatal("expected blob to be removed with its last reference")
+	}
+}
+
+func TestCloneInternsLegacyUploads(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	// Saved before the blob hooks exist, the upload keeps its per-record
+	// file like one from before content-addressed storage.
+	site := createImportTestSite(t, app)
+	file, err := filesystem.NewFileFromBytes([]byte("legacy logo bytes"), "logo.txt")
+	if err != nil {
+		t.Fatalf("new file: %v", err)
+	}
+	uploads, err := app.FindCollectionByNameOrId("site_uploads")
+	if err != nil {
+		t.Fatalf("find site_uploads: %v", err)
+	}
+	upload := core.NewRecord(uploads)
+	upload.Set("site", site.Id)
+	upload.Set("file", file)
+	if err := app.Save(upload); err != nil {
+		t.Fatalf("save upload: %v", err)
+	}
+	if err := RegisterUploadBlobs(app); err != nil {
+		t.Fatalf("register upload blobs: %v", err)
+	}
+
+	done := make(chan error, 1)
+	var clone *core.Record
+	go func() {
+		var err error
+		clone, err = cloneLocalSite(app, site, "Clone", "clone.localhost", site.GetString("group"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("clone: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("clone of a legacy upload did not finish")
+	}
+
+	upload, err = app.FindRecordById("site_uploads", upload.Id)
+	if err != nil {
+		t.Fatalf("reload upload: %v", err)
+	}
+	hash := upload.GetString("blob")
+	if hash == "" {
+		t.Fatal("expected the legacy upload interned")
+	}
+	cloned, err := app.FindFirstRecordByData("site_uploads", "site", clone.Id)
+	if err != nil {
+		t.Fatalf("find cloned upload: %v", err)
+	}
+	if cloned.GetString("blob") != hash {
+		t.Fatalf("expected cloned upload to share blob %s, got %q", hash, cloned.GetString("blob"))
+	}
+	assertBlobRefs(t, app, hash, 2)
+}
+
+func TestRolledBackSnapshotRestoreLeavesNoBlobs(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterUploadBlobs(app); err != nil {
+		t.Fatalf("register upload blobs: %v", err)
+	}
+	group := createImportTestSite(t, app).GetString("group")
+
+	data := []byte("snapshot logo bytes")
+	snapshot := &Snapshot{
+		Records: SnapshotRecords{
+			Sites: []map[string]any{{"id": "snapshotsite01"}},
+			// The second upload has no file, which the collection requires,
+			// so the restore rolls back after storing the first.
+			SiteUploads: []map[string]any{
+				{"id": "snapshotupld01", "file": float64(0)},
+				{"id": "snapshotupld02"},
+			},
+		},
+		Files:    [][]byte{data},
+		FileMeta: []FileEntry{{Name: "logo.txt", Size: len(data)}},
+	}
+	if _, err := restoreSnapshot(app, snapshot, "Restored", "restored.localhost", group); err == nil {
+		t.Fatal("expected the restore to fail")
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatalf("filesystem: %v", err)
+	}
+	defer fsys.Close()
+	if exists, _ := fsys.Exists(blobKey(blobHash(data))); exists {
+		t.Fatal("expected the blob of the rolled back restore removed")
+	}
+}
+
+func assertBlobRefs(t *testing.T, app core.App, hash string, want int) {
+	t.Helper()
+
+	blob, err := app.FindFirstRecordByData("upload_blobs", "hash", hash)
+	if err != nil {
+		t.Fatalf("find blob %s: %v", hash, err)
+	}
+	if got := blob.GetInt("refs"); got != want {
+		t.Fatalf("blob %s refs = %d, want %d", hash, got, want)
+	}
+}
+
+func TestInternedUploadServesThumbs(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterUploadBlobs(app); err != nil {
+		t.Fatalf("register upload blobs: %v", err)
+	}
+
+	site := createImportTestSite(t, app)
+	file, err := filesystem.NewFileFromBytes(testPNG(t, 300, 200), "photo.png")
+	if err != nil {
+		t.Fatalf("new file: %v", err)
+	}
+	uploads, err := app.FindCollectionByNameOrId("site_uploads")
+	if err != nil {
+		t.Fatalf("find site_uploads: %v", err)
+	}
+	upload := core.NewRecord(uploads)
+	upload.Set("site", site.Id)
+	upload.Set("file", file)
+	if err := app.Save(upload); err != nil {
+		t.Fatalf("save upload: %v", err)
+	}
+	upload, err = app.FindRecordById("site_uploads", upload.Id)
+	if err != nil {
+		t.Fatalf("reload upload: %v", err)
+	}
+	if upload.GetString("blob") == "" {
+		t.Fatal("expected upload to be interned")
+	}
+
+	baseRouter, err := apis.NewRouter(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mux http.Handler
+	serveEvent := &core.ServeEvent{App: app, Router: baseRouter}
+	if err := app.OnServe().Trigger(serveEvent, func(e *core.ServeEvent) error {
+		mux, err = e.Router.BuildMux()
+		return err
+	}); err != nil {
+		t.Fatalf("build router: %v", err)
+	}
+
+	get := func(query string) image.Config {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files/site_uploads/"+upload.Id+"/"+upload.GetString("file")+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", query, rec.Code)
+		}
+		config, _, err := image.DecodeConfig(rec.Body)
+		if err != nil {
+			t.Fatalf("decode %s: %v", query, err)
+		}
+		return config
+	}
+
+	if config := get(""); config.Width != 300 || config.Height != 200 {
+		t.Fatalf("expected the original served, got %dx%d", config.Width, config.Height)
+	}
+	for range 2 {
+		if config := get("?thumb=100x100"); config.Width != 100 || config.Height != 100 {
+			t.Fatalf("expected a 100x100 thumb, got %dx%d", config.Width, config.Height)
+		}
+	}
+}
+
+func TestUploadBlobIsServerSet(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterUploadBlobs(app); err != nil {
+		t.Fatalf("register upload blobs: %v", err)
+	}
+
+	site := createImportTestSite(t, app)
+	file, err := filesystem.NewFileFromBytes([]byte("own logo bytes"), "logo.txt")
+	if err != nil {
+		t.Fatalf("new file: %v", err)
+	}
+	uploads, err := app.FindCollectionByNameOrId("site_uploads")
+	if err != nil {
+		t.Fatalf("find site_uploads: %v", err)
+	}
+	upload := core.NewRecord(uploads)
+	upload.Set("site", site.Id)
+	upload.Set("file", file)
+	if err := app.Save(upload); err != nil {
+		t.Fatalf("save upload: %v", err)
+	}
+
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatalf("find users: %v", err)
+	}
+	user := core.NewRecord(users)
+	user.Set("email", "editor@example.com")
+	user.Set("password", "correct horse battery")
+	user.Set("serverRole", "editor")
+	if err := app.Save(user); err != nil {
+		t.Fatalf("save user: %v", err)
+	}
+
+	canUpdate := func(body map[string]any) bool {
+		ok, err := app.CanAccessRecord(upload, &core.RequestInfo{Auth: user, Body: body}, uploads.UpdateRule)
+		if err != nil {
+			t.Fatalf("check update rule: %v", err)
+		}
+		return ok
+	}
+	if !canUpdate(map[string]any{"alt": "Logo"}) {
+		t.Fatal("expected a metadata update allowed")
+	}
+	if canUpdate(map[string]any{"blob": blobHash([]byte("someone else's bytes"))}) {
+		t.Fatal("expected a client-set blob refused")
+	}
+
+	// A row pointing at stored bytes nothing holds a reference to is not
+	// served.
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatalf("filesystem: %v", err)
+	}
+	defer fsys.Close()
+	other, err := storeBlob(app, fsys, []byte("someone else's bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.DB().Update("site_uploads", dbx.Params{"blob": other}, dbx.HashExp{"id": upload.Id}).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	baseRouter, err := apis.NewRouter(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mux http.Handler
+	serveEvent := &core.ServeEvent{App: app, Router: baseRouter}
+	if err := app.OnServe().Trigger(serveEvent, func(e *core.ServeEvent) error {
+		mux, err = e.Router.BuildMux()
+		return err
+	}); err != nil {
+		t.Fatalf("build router: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files/site_uploads/"+upload.Id+"/"+upload.GetString("file"), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected an unreferenced blob not served, got %d", rec.Code)
+	}
+}
+
+func TestBulkUploadImportFoldersAndConflicts(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterUploadBlobs(app); err != nil {
+		t.Fatalf("register upload blobs: %v", err)
+	}
+
+	site := createImportTestSite(t, app)
+
+	archive := func(files map[string]string) *zip.Reader {
+		data := zipFiles(t, files)
+		reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("open zip: %v", err)
+		}
+		return reader
+	}
+
+	result, err := importUploadArchive(app, site, archive(map[string]string{
+		"logos/logo.txt":      "logo v1",
+		"hero.txt":            "hero",
+		"__MACOSX/._hero.txt": "junk",
+		"metadata.json":       `{"hero.txt": {"alt": "Hero image", "folder": "banners"}}`,
+	}), "", uploadConflictRename)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(result.Created) != 2 {
+		t.Fatalf("expected 2 created uploads, got %#v", result)
+	}
+
+	hero, err := app.FindFirstRecordByData("site_uploads", "name", "hero.txt")
+	if err != nil {
+		t.Fatalf("find hero: %v", err)
+	}
+	if hero.GetString("folder") != "banners" || hero.GetString("alt") != "Hero image" {
+		t.Fatalf("metadata not applied: folder=%q alt=%q", hero.GetString("folder"), hero.GetString("alt"))
+	}
+	logo, err := app.FindFirstRecordByData("site_uploads", "name", "logo.txt")
+	if err != nil {
+		t.Fatalf("find logo: %v", err)
+	}
+	if logo.GetString("folder") != "logos" {
+		t.Fatalf("expected folder from zip path, got %q", logo.GetString("folder"))
+	}
+	assertBlobRefs(t, app, logo.GetString("blob"), 1)
+
+	result, err = importUploadArchive(app, site, archive(map[string]string{"logos/logo.txt": "logo v2"}), "", uploadConflictRename)
+	if err != nil {
+		t.Fatalf("rename import: %v", err)
+	}
+	if len(result.Created) != 1 || result.Created[0].Name != "logo-1.txt" {
+		t.Fatalf("expected renamed upload, got %#v", result)
+	}
+
+	result, err = importUploadArchive(app, site, archive(map[string]string{"logos/logo.txt": "logo v3"}), "", uploadConflictReplace)
+	if err != nil {
+		t.Fatalf("replace import: %v", err)
+	}
+	if len(result.Replaced) != 1 || result.Replaced[0].ID != logo.Id {
+		t.Fatalf("expected logo to be replaced in place, got %#v", result)
+	}
+	previous := logo.GetString("blob")
+	logo, err = app.FindRecordById("site_uploads", logo.Id)
+	if err != nil {
+		t.Fatalf("reload logo: %v", err)
+	}
+	if logo.GetString("blob") == previous {
+		t.Fatal("expected replaced upload to point at the new bytes")
+	}
+	assertBlobRefs(t, app, logo.GetString("blob"), 1)
+	if _, err := app.FindFirstRecordByData("upload_blobs", "hash", previous); err == nil {
+		t.Fatal("expected the replaced blob released once the import committed")
+	}
+
+	result, err = importUploadArchive(app, site, archive(map[string]string{"logos/logo.txt": "logo v4"}), "", uploadConflictSkip)
+	if err != nil {
+		t.Fatalf("skip import: %v", err)
+	}
+	if len(result.Skipped) != 1 || len(result.Created) != 0 {
+		t.Fatalf("expected logo to be skipped, got %#v", result)
+	}
+
+	// An entry over the upload size limit fails the import after the logo
+	// was already replaced in the transaction: the old blob must survive
+	// and the new one must not be left behind.
+	uploads, err := app.FindCollectionByNameOrId("site_uploads")
+	if err != nil {
+		t.Fatalf("find site_uploads: %v", err)
+	}
+	uploads.Fields.GetByName("file").(*core.FileField).MaxSize = 16
+	if err := app.Save(uploads); err != nil {
+		t.Fatalf("save site_uploads: %v", err)
+	}
+	var ordered bytes.Buffer
+	zw := zip.NewWriter(&ordered)
+	for _, entry := range [][2]string{{"logos/logo.txt", "logo v5"}, {"huge.txt", strings.Repeat("x", 17)}} {
+		w, err := zw.Create(entry[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entry[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(ordered.Bytes()), int64(ordered.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importUploadArchive(app, site, reader, "", uploadConflictReplace); err == nil {
+		t.Fatal("expected the oversized entry to fail the import")
+	}
+	current := logo.GetString("blob")
+	logo, err = app.FindRecordById("site_uploads", logo.Id)
+	if err != nil {
+		t.Fatalf("reload logo: %v", err)
+	}
+	if logo.GetString("blob") != current {
+		t.Fatal("expected the failed import rolled back")
+	}
+	assertBlobRefs(t, app, current, 1)
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	if exists, _ := fsys.Exists(blobKey(current)); !exists {
+		t.Fatal("expected the blob still in use kept")
+	}
+	if exists, _ := fsys.Exists(blobKey(blobHash([]byte("logo v5")))); exists {
+		t.Fatal("expected the blob of the rolled back replace removed")
+	}
+}
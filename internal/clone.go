@@ -175,14 +175,7 @@ func cloneFromSnapshotData(pb *pocketbase.PocketBase, data []byte, name, host, g
 		return nil, err
 	}
 
-	var newSite *core.Record
-	err = pb.RunInTransaction(func(txApp core.App) error {
-		var txErr error
-		newSite, txErr = importSnapshotRecords(txApp, snapshot, name, host, groupId)
-		return txErr
-	})
-
-	return newSite, err
+	return restoreSnapshot(pb, snapshot, name, host, groupId)
 }
 
 func cloneFromSnapshot(pb *pocketbase.PocketBase, snapshotURL, name, host, groupId string) (*core.Record, error) {
@@ -210,12 +203,26 @@ func cloneFromSnapshot(pb *pocketbase.PocketBase, snapshotURL, name, host, group
 		return nil, err
 	}
 
+	return restoreSnapshot(pb, snapshot, name, host, groupId)
+}
+
+// restoreSnapshot creates a site from snapshot in one transaction. The
+// upload bytes it stores are removed again if the transaction rolls back.
+func restoreSnapshot(pb *pocketbase.PocketBase, snapshot *Snapshot, name, host, groupId string) (*core.Record, error) {
+	fsys, err := pb.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+
 	var newSite *core.Record
+	writes := newBlobWrites(fsys)
 	err = pb.RunInTransaction(func(txApp core.App) error {
 		var txErr error
-		newSite, txErr = importSnapshotRecords(txApp, snapshot, name, host, groupId)
+		newSite, txErr = importSnapshotRecords(txApp, writes, snapshot, name, host, groupId)
 		return txErr
 	})
+	writes.finish(pb, err)
 
 	return newSite, err
 }
@@ -235,8 +242,20 @@ func cloneFromLocalSite(pb *pocketbase.PocketBase, e *core.RequestEvent, sourceS
 		return nil, errors.New("access denied to source site")
 	}
 
+	return cloneLocalSite(pb, sourceSite, name, host, groupId)
+}
+
+// cloneLocalSite interns the source's legacy uploads and then clones its
+// records in one transaction. Interning writes through pb and removes
+// per-record files, so it has to happen before the transaction opens:
+// from inside, it would wait on the connection the transaction holds.
+func cloneLocalSite(pb *pocketbase.PocketBase, sourceSite *core.Record, name, host, groupId string) (*core.Record, error) {
+	if err := internSiteUploads(pb, sourceSite.Id); err != nil {
+		return nil, err
+	}
+
 	var newSite *core.Record
-	err = pb.RunInTransaction(func(txApp core.App) error {
+	err := pb.RunInTransaction(func(txApp core.App) error {
 		var txErr error
 		newSite, txErr = cloneSiteRecords(txApp, pb, sourceSite, name, host, groupId)
 		return txErr
@@ -305,7 +324,7 @@ func parseSnapshot(data []byte) (*Snapshot, error) {
 	}, nil
 }
 
-func importSnapshotRecords(app core.App, snapshot *Snapshot, name, host, groupId string) (*core.Record, error) {
+func importSnapshotRecords(app core.App, writes *blobWrites, snapshot *Snapshot, name, host, groupId string) (*core.Record, error) {
 	// ID maps for remapping foreign keys
 	siteMap := make(IDMap)
 	uploadMap := make(IDMap)
@@ -352,19 +371,35 @@ func importSnapshotRecords(app core.App, snapshot *Snapshot, name, host, groupId
 		copyRecordFields(rec, uploadData, uploadsColl)
 		rec.Set("site", newSite.Id)
 
-		// Handle file - in snapshot the "file" field contains the index into the files array
+		// Handle file - in snapshot the "file" field contains the index into the files array.
+		// The bytes go to content-addressed storage, so restoring a snapshot
+		// whose images already exist on this instance stores nothing new.
+		var hash string
 		if fileIdx, ok := uploadData["file"].(float64); ok {
 			idx := int(fileIdx)
-			if idx >= 0 && idx < len(snapshot.Files) {
+			if idx >= 0 && idx < len(snapshot.Files) && len(snapshot.Files[idx]) > 0 {
 				file, err := filesystem.NewFileFromBytes(snapshot.Files[idx], snapshot.FileMeta[idx].Name)
 				if err != nil {
 					return nil, err
 				}
-				rec.Set("file", file)
+				hash, err = writes.store(app, snapshot.Files[idx])
+				if err != nil {
+					return nil, err
+				}
+				rec.Set("file", file.Name)
+				rec.Set("blob", hash)
+				setUploadMetadata(rec, snapshot.Files[idx])
 			}
 		}
 
-		if err := app.Save(rec); err != nil {
+		if hash != "" {
+			err = app.SaveNoValidate(rec)
+		} else {
+			rec.Set("file", nil)
+			rec.Set("blob", "")
+			err = app.Save(rec)
+		}
+		if err != nil {
 			return nil, err
 		}
 		uploadMap[getString(uploadData, "id")] = rec.Id
@@ -544,7 +579,7 @@ func importSnapshotRecords(app core.App, snapshot *Snapshot, name, host, groupId
 			} else {
 				rec.Set("parent", pageTypeSectionEntryMap[oldParent])
 			}
-			if err := app.Save(rec); err != nil {
+			if err := app.SaveWithContext(keepContent(), rec); err != nil {
 				return nil, err
 			}
 			pageTypeSectionEntryMap[oldId] = rec.Id
@@ -624,7 +659,7 @@ func importSnapshotRecords(app core.App, snapshot *Snapshot, name, host, groupId
 			} else {
 				rec.Set("parent", pageEntryMap[oldParent])
 			}
-			if err := app.Save(rec); err != nil {
+			if err := app.SaveWithContext(keepContent(), rec); err != nil {
 				return nil, err
 			}
 			pageEntryMap[oldId] = rec.Id
@@ -680,7 +715,7 @@ func importSnapshotRecords(app core.App, snapshot *Snapshot, name, host, groupId
 			} else {
 				rec.Set("parent", pageSectionEntryMap[oldParent])
 			}
-			if err := app.Save(rec); err != nil {
+			if err := app.SaveWithContext(keepContent(), rec); err != nil {
 				return nil, err
 			}
 			pageSectionEntryMap[oldId] = rec.Id
@@ -752,30 +787,25 @@ func cloneSiteRecords(txApp core.App, pb *pocketbase.PocketBase, sourceSite *cor
 	defer fsys.Close()
 
 	for _, upload := range uploads {
-		rec := core.NewRecord(uploadsColl)
-		copyRecordFieldsFromRecord(rec, upload, uploadsColl)
-		rec.Set("site", newSite.Id)
-
-		// Copy the file
+		// Share the source's blob instead of copying bytes; cloneLocalSite
+		// has interned any legacy uploads already.
 		filename := upload.GetString("file")
 		if filename != "" {
-			sourceKey := "site_uploads/" + upload.Id + "/" + filename
-			fileReader, err := fsys.GetFile(sourceKey)
-			if err != nil {
-				return nil, err
-			}
-			fileData, err := io.ReadAll(fileReader)
-			fileReader.Close()
-			if err != nil {
-				return nil, err
-			}
-			file, err := filesystem.NewFileFromBytes(fileData, filename)
-			if err != nil {
+			hash := upload.GetString("blob")
+			rec := newBlobUploadRecord(uploadsColl, filename, hash)
+			copyRecordFieldsFromRecord(rec, upload, uploadsColl)
+			rec.Set("site", newSite.Id)
+			rec.Set("blob", hash)
+			if err := txApp.SaveNoValidate(rec); err != nil {
 				return nil, err
 			}
-			rec.Set("file", file)
+			uploadMap[upload.Id] = rec.Id
+			continue
 		}
 
+		rec := core.NewRecord(uploadsColl)
+		copyRecordFieldsFromRecord(rec, upload, uploadsColl)
+		rec.Set("site", newSite.Id)
 		if err := txApp.Save(rec); err != nil {
 			return nil, err
 		}
@@ -973,7 +1003,7 @@ func cloneSiteRecords(txApp core.App, pb *pocketbase.PocketBase, sourceSite *cor
 			} else {
 				newRec.Set("parent", pageTypeSectionEntryMap[oldParent])
 			}
-			if err := txApp.Save(newRec); err != nil {
+			if err := txApp.SaveWithContext(keepContent(), newRec); err != nil {
 				return nil, err
 			}
 			pageTypeSectionEntryMap[rec.Id] = newRec.Id
@@ -1053,7 +1083,7 @@ func cloneSiteRecords(txApp core.App, pb *pocketbase.PocketBase, sourceSite *cor
 			} else {
 				newRec.Set("parent", pageEntryMap[oldParent])
 			}
-			if err := txApp.Save(newRec); err != nil {
+			if err := txApp.SaveWithContext(keepContent(), newRec); err != nil {
 				return nil, err
 			}
 			pageEntryMap[rec.Id] = newRec.Id
@@ -1110,7 +1140,7 @@ func cloneSiteRecords(txApp core.App, pb *pocketbase.PocketBase, sourceSite *cor
 			} else {
 				newRec.Set("parent", pageSectionEntryMap[oldParent])
 			}
-			if err := txApp.Save(newRec); err != nil {
+			if err := txApp.SaveWithContext(keepContent(), newRec); err != nil {
 				return nil, err
 			}
 			pageSectionEntryMap[rec.Id] = newRec.Id
@@ -1194,7 +1224,7 @@ func importHierarchicalRecords(app core.App, coll *core.Collection, records []ma
 			} else {
 				rec.Set(parentField, idMap[oldParent])
 			}
-			if err := app.Save(rec); err != nil {
+			if err := app.SaveWithContext(keepContent(), rec); err != nil {
 				return err
 			}
 			idMap[oldId] = rec.Id
@@ -1240,7 +1270,7 @@ func importHierarchicalRecordsWithFK(app core.App, coll *core.Collection, record
 			} else {
 				rec.Set(parentField, idMap[oldParent])
 			}
-			if err := app.Save(rec); err != nil {
+			if err := app.SaveWithContext(keepContent(), rec); err != nil {
 				return err
 			}
 			idMap[oldId] = rec.Id
@@ -1283,7 +1313,7 @@ func cloneHierarchicalRecords(app core.App, coll *core.Collection, records []*co
 			} else {
 				rec.Set(parentField, idMap[oldParent])
 			}
-			if err := app.Save(rec); err != nil {
+			if err := app.SaveWithContext(keepContent(), rec); err != nil {
 				return err
 			}
 			idMap[src.Id] = rec.Id
@@ -1325,7 +1355,7 @@ func cloneHierarchicalRecordsWithFK(app core.App, coll *core.Collection, records
 			} else {
 				rec.Set(parentField, idMap[oldParent])
 			}
-			if err := app.Save(rec); err != nil {
+			if err := app.SaveWithContext(keepContent(), rec); err != nil {
 				return err
 			}
 			idMap[src.Id] = rec.Id
@@ -1560,7 +1590,7 @@ func updateEntryValueReferences(app core.App, collName string, fieldMap, pageMap
 						if newPage := pageMap[strVal]; newPage != "" {
 							cloneLog("[updateEntryValueReferences] Remapping page value %s -> %s", strVal, newPage)
 							rec.Set("value", newPage)
-							app.Save(rec)
+							app.SaveWithContext(keepContent(), rec)
 						}
 					}
 					continue
@@ -1575,7 +1605,7 @@ func updateEntryValueReferences(app core.App, collName string, fieldMap, pageMap
 					if newPage := pageMap[oldPage]; newPage != "" {
 						cloneLog("[updateEntryValueReferences] Remapping page value %s -> %s", oldPage, newPage)
 						rec.Set("value", newPage)
-						app.Save(rec)
+						app.SaveWithContext(keepContent(), rec)
 					}
 				}
 			}
@@ -1622,7 +1652,7 @@ func updateEntryValueReferences(app core.App, collName string, fieldMap, pageMap
 		if needsUpdate {
 			cloneLog("[updateEntryValueReferences] Saving updated value for %s %s", collName, newId)
 			rec.Set("value", valueMap)
-			if err := app.Save(rec); err != nil {
+			if err := app.SaveWithContext(keepContent(), rec); err != nil {
 				cloneLog("[updateEntryValueReferences] ERROR saving: %v", err)
 			}
 		}
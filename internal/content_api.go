@@ -0,0 +1,560 @@
+package internal
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterContentAPIEndpoint serves a site's resolved content as JSON, for
+// frontends that render it themselves (mobile apps, other frameworks):
+//
+//	GET /api/palacms/content/{host}/site           site fields and the page tree
+//	GET /api/palacms/content/{host}/pages/{path...} one page, with its sections
+//
+// Values come out the way blocks see them when a site is published:
+// markdown and rich text as HTML, images with absolute upload URLs, links
+// and page references resolved to paths, and page/site field references
+// replaced by the values they point at. Requests carry one of the site's
+// API keys (site_api_keys) as a bearer token; signed-in collaborators can
+// read without one.
+//
+// PATCH /api/palacms/sites/{siteId}/pages/{path...} is the write side: it
+// sets fields by key without a full push (see ContentPatch), with an
+// update rule or a content:write key.
+func RegisterContentAPIEndpoint(pb *pocketbase.PocketBase) error {
+	pb.OnServe().BindFunc(func(serveEvent *core.ServeEvent) error {
+		serveEvent.Router.GET("/api/palacms/content/{host}/site", func(e *core.RequestEvent) error {
+			site, err := requireContentAccess(pb, e)
+			if err != nil {
+				return err
+			}
+			resolver, err := newContentResolver(pb, site, requestBaseURL(pb, e))
+			if err != nil {
+				return e.InternalServerError("Failed to load site content", err)
+			}
+			return e.JSON(http.StatusOK, resolver.siteResponse())
+		})
+
+		serveEvent.Router.GET("/api/palacms/content/{host}/pages/{path...}", func(e *core.RequestEvent) error {
+			site, err := requireContentAccess(pb, e)
+			if err != nil {
+				return err
+			}
+			resolver, err := newContentResolver(pb, site, requestBaseURL(pb, e))
+			if err != nil {
+				return e.InternalServerError("Failed to load site content", err)
+			}
+			page := resolver.pageByPath[strings.Trim(e.Request.PathValue("path"), "/")]
+			if page == nil {
+				return e.NotFoundError("Page not found", nil)
+			}
+			response, err := resolver.pageResponse(page)
+			if err != nil {
+				return e.InternalServerError("Failed to load page content", err)
+			}
+			return e.JSON(http.StatusOK, response)
+		})
+
+		// Field-level writes by page path, for scripts (see ContentPatch)
+		serveEvent.Router.PATCH("/api/palacms/sites/{siteId}/pages/{path...}", func(e *core.RequestEvent) error {
+			return handleContentPatch(pb, e)
+		})
+
+		return serveEvent.Next()
+	})
+	return nil
+}
+
+// requireContentAccess finds the site for the {host} path value and checks
+// the request may read it: an API key with the content:read scope, or a
+// signed-in user the site's view rule lets in.
+func requireContentAccess(pb *pocketbase.PocketBase, e *core.RequestEvent) (*core.Record, error) {
+	site, err := pb.FindFirstRecordByData("sites", "host", e.Request.PathValue("host"))
+	if err != nil {
+		return nil, e.NotFoundError("Site not found", nil)
+	}
+
+	if key := requestSiteAPIKey(e); key != "" {
+		if err := authorizeSiteAPIKey(e, key, site, ScopeContentRead); err != nil {
+			return nil, err
+		}
+		return site, nil
+	}
+
+	if e.Auth != nil {
+		info, err := e.RequestInfo()
+		if err != nil {
+			return nil, e.InternalServerError("Failed to get request info", err)
+		}
+		if canAccess, _ := e.App.CanAccessRecord(site, info, site.Collection().ViewRule); canAccess {
+			return site, nil
+		}
+		return nil, e.ForbiddenError("Access denied", nil)
+	}
+	return nil, e.UnauthorizedError("A valid API key is required", nil)
+}
+
+// requestBaseURL is the origin upload URLs are made absolute against: the
+// configured application URL, or else the one the request came in on.
+func requestBaseURL(pb *pocketbase.PocketBase, e *core.RequestEvent) string {
+	if appURL := pb.Settings().Meta.AppURL; appURL != "" {
+		return strings.TrimSuffix(appURL, "/")
+	}
+	scheme := "http"
+	if e.Request.TLS != nil || e.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + e.Request.Host
+}
+
+// contentResolver loads a site's pages once and resolves content from
+// them. Page content is cached, since sections, page fields and page lists
+// all refer back to it.
+type contentResolver struct {
+	*contentStructure
+	app     core.App
+	site    *core.Record
+	baseURL string
+
+	pageByPath map[string]*core.Record
+	pagePaths  map[string]string // page id -> path, without the leading slash
+	pageById   map[string]*core.Record
+	uploads    map[string]*core.Record // loaded as content refers to them
+
+	siteContent map[string]interface{}
+	pageContent map[string]map[string]interface{}
+	resolving   map[string]bool
+}
+
+// contentStructure is a site's fields, page types and blocks: what its
+// content is shaped by, as opposed to the content itself. It changes
+// rarely, so the GraphQL endpoint keeps it between queries; it's only
+// read once loaded.
+type contentStructure struct {
+	pageTypes      map[string]*core.Record
+	pageTypeFields map[string][]*core.Record // page type id -> fields
+	siteFields     []*core.Record
+	symbolNames    map[string]string
+	symbolFields   map[string][]*core.Record // symbol id -> fields
+}
+
+func loadContentStructure(app core.App, site *core.Record) (*contentStructure, error) {
+	s := &contentStructure{
+		pageTypes:      map[string]*core.Record{},
+		pageTypeFields: map[string][]*core.Record{},
+		symbolNames:    map[string]string{},
+		symbolFields:   map[string][]*core.Record{},
+	}
+
+	pageTypes, err := app.FindRecordsByFilter("page_types", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return nil, err
+	}
+	for _, pageType := range pageTypes {
+		s.pageTypes[pageType.Id] = pageType
+	}
+	pageTypeFields, err := app.FindRecordsByFilter("page_type_fields", "page_type.site = {:site}", "+index", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range pageTypeFields {
+		pageType := field.GetString("page_type")
+		s.pageTypeFields[pageType] = append(s.pageTypeFields[pageType], field)
+	}
+
+	if s.siteFields, err = app.FindRecordsByFilter("site_fields", "site = {:site}", "+index", 0, 0, dbx.Params{"site": site.Id}); err != nil {
+		return nil, err
+	}
+
+	symbols, err := app.FindRecordsByFilter("site_symbols", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return nil, err
+	}
+	for _, symbol := range symbols {
+		s.symbolNames[symbol.Id] = symbol.GetString("name")
+	}
+	symbolFields, err := app.FindRecordsByFilter("site_symbol_fields", "symbol.site = {:site}", "+index", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range symbolFields {
+		symbol := field.GetString("symbol")
+		s.symbolFields[symbol] = append(s.symbolFields[symbol], field)
+	}
+	return s, nil
+}
+
+func newContentResolver(app core.App, site *core.Record, baseURL string) (*contentResolver, error) {
+	structure, err := loadContentStructure(app, site)
+	if err != nil {
+		return nil, err
+	}
+	return newContentResolverWithStructure(app, site, baseURL, structure)
+}
+
+// newContentResolverWithStructure is newContentResolver with the site's
+// structure already loaded.
+func newContentResolverWithStructure(app core.App, site *core.Record, baseURL string, structure *contentStructure) (*contentResolver, error) {
+	r := &contentResolver{
+		contentStructure: structure,
+		app:              app,
+		site:             site,
+		baseURL:          baseURL,
+		pagePaths:        map[string]string{},
+		pageById:         map[string]*core.Record{},
+		uploads:          map[string]*core.Record{},
+		pageContent:      map[string]map[string]interface{}{},
+		resolving:        map[string]bool{},
+	}
+
+	var err error
+	if r.pageByPath, err = sitePagesByPath(app, site.Id); err != nil {
+		return nil, err
+	}
+	for pagePath, page := range r.pageByPath {
+		r.pagePaths[page.Id] = pagePath
+		r.pageById[page.Id] = page
+	}
+	return r, nil
+}
+
+// upload returns the site's upload with id, or nil when there is none.
+func (r *contentResolver) upload(id string) *core.Record {
+	if id == "" {
+		return nil
+	}
+	if upload, ok := r.uploads[id]; ok {
+		return upload
+	}
+	upload, err := r.app.FindFirstRecordByFilter("site_uploads", "id = {:id} && site = {:site}", dbx.Params{"id": id, "site": r.site.Id})
+	if err != nil {
+		upload = nil
+	}
+	r.uploads[id] = upload
+	return upload
+}
+
+func (r *contentResolver) siteResponse() map[string]interface{} {
+	pagePaths := make([]string, 0, len(r.pageByPath))
+	for pagePath := range r.pageByPath {
+		pagePaths = append(pagePaths, pagePath)
+	}
+	sort.Strings(pagePaths)
+
+	pages := make([]map[string]interface{}, 0, len(pagePaths))
+	for _, pagePath := range pagePaths {
+		pages = append(pages, r.pageMeta(r.pageByPath[pagePath]))
+	}
+	return map[string]interface{}{
+		"site":  r.siteMeta(),
+		"pages": pages,
+	}
+}
+
+func (r *contentResolver) pageResponse(page *core.Record) (map[string]interface{}, error) {
+	meta := r.pageMeta(page)
+	meta["content"] = r.resolvePageContent(page)
+
+	pageSections, err := r.pageSections(page)
+	if err != nil {
+		return nil, err
+	}
+	sections := make([]map[string]interface{}, 0, len(pageSections))
+	for _, section := range pageSections {
+		sections = append(sections, section.fields)
+	}
+
+	return map[string]interface{}{
+		"site":     r.siteMeta(),
+		"page":     meta,
+		"sections": sections,
+	}, nil
+}
+
+// contentSection is a resolved section and the block it uses.
+type contentSection struct {
+	symbol string
+	fields map[string]interface{}
+}
+
+// pageSections resolves the sections shown on page: its page type's
+// header, the page's own sections, then the page type's footer.
+func (r *contentResolver) pageSections(page *core.Record) ([]contentSection, error) {
+	sections := []contentSection{}
+	zones := []struct {
+		zone       string
+		collection string
+		filter     string
+		id         string
+		entries    string
+	}{
+		{"header", "page_type_sections", "page_type = {:id} && zone = 'header'", page.GetString("page_type"), "page_type_section_entries"},
+		{"body", "page_sections", "page = {:id}", page.Id, "page_section_entries"},
+		{"footer", "page_type_sections", "page_type = {:id} && zone = 'footer'", page.GetString("page_type"), "page_type_section_entries"},
+	}
+	for _, z := range zones {
+		records, err := r.app.FindRecordsByFilter(z.collection, z.filter, "+index", 0, 0, dbx.Params{"id": z.id})
+		if err != nil {
+			return nil, err
+		}
+		for _, section := range records {
+			entries, err := r.app.FindRecordsByFilter(z.entries, "section = {:section}", "", 0, 0, dbx.Params{"section": section.Id})
+			if err != nil {
+				return nil, err
+			}
+			symbolId := section.GetString("symbol")
+			sections = append(sections, contentSection{symbol: symbolId, fields: map[string]interface{}{
+				"id":      section.Id,
+				"block":   r.symbolNames[symbolId],
+				"zone":    z.zone,
+				"content": r.resolveFields(r.symbolFields[symbolId], entries, "", "", page),
+			}})
+		}
+	}
+	return sections, nil
+}
+
+func (r *contentResolver) siteMeta() map[string]interface{} {
+	if r.siteContent == nil {
+		entries, _ := r.app.FindRecordsByFilter("site_entries", "field.site = {:site}", "", 0, 0, dbx.Params{"site": r.site.Id})
+		// Set before resolving, so a site field that refers to another
+		// site field finds an empty map instead of recursing.
+		r.siteContent = map[string]interface{}{}
+		r.siteContent = r.resolveFields(r.siteFields, entries, "", "", nil)
+	}
+	return map[string]interface{}{
+		"id":      r.site.Id,
+		"name":    r.site.GetString("name"),
+		"host":    r.site.GetString("host"),
+		"content": r.siteContent,
+	}
+}
+
+func (r *contentResolver) pageMeta(page *core.Record) map[string]interface{} {
+	pageType := ""
+	if pt := r.pageTypes[page.GetString("page_type")]; pt != nil {
+		pageType = pt.GetString("name")
+	}
+	return map[string]interface{}{
+		"id":        page.Id,
+		"name":      page.GetString("name"),
+		"slug":      page.GetString("slug"),
+		"url":       "/" + r.pagePaths[page.Id],
+		"page_type": pageType,
+		"parent":    page.GetString("parent"),
+		"index":     page.GetInt("index"),
+		"created":   page.GetString("created"),
+		"updated":   page.GetString("updated"),
+	}
+}
+
+// resolvePageContent resolves a page's page type fields. A page reached
+// again while its own content is being resolved (two pages referencing
+// each other) resolves to nothing rather than looping.
+func (r *contentResolver) resolvePageContent(page *core.Record) map[string]interface{} {
+	if content, ok := r.pageContent[page.Id]; ok {
+		return content
+	}
+	if r.resolving[page.Id] {
+		return map[string]interface{}{}
+	}
+	r.resolving[page.Id] = true
+	defer delete(r.resolving, page.Id)
+
+	entries, _ := r.app.FindRecordsByFilter("page_entries", "page = {:page}", "", 0, 0, dbx.Params{"page": page.Id})
+	content := r.resolveFields(r.pageTypeFields[page.GetString("page_type")], entries, "", "", page)
+	r.pageContent[page.Id] = content
+	return content
+}
+
+// pageReference is a referenced page's content with its _meta, the shape
+// page and page-list fields hand to blocks.
+func (r *contentResolver) pageReference(page *core.Record) map[string]interface{} {
+	out := map[string]interface{}{}
+	for key, value := range r.resolvePageContent(page) {
+		out[key] = value
+	}
+	out["_meta"] = map[string]interface{}{
+		"created_at": page.GetString("created"),
+		"name":       page.GetString("name"),
+		"slug":       page.GetString("slug"),
+		"url":        "/" + r.pagePaths[page.Id],
+	}
+	return out
+}
+
+// resolveFields resolves the fields under parentField (top-level when
+// empty) from the entries under parentEntry. page is the page the content
+// is shown on, which page-field references read from.
+func (r *contentResolver) resolveFields(fields, entries []*core.Record, parentField, parentEntry string, page *core.Record) map[string]interface{} {
+	entriesByField := map[string][]*core.Record{}
+	for _, entry := range entries {
+		if entry.GetString("parent") == parentEntry {
+			entriesByField[entry.GetString("field")] = append(entriesByField[entry.GetString("field")], entry)
+		}
+	}
+	for _, fieldEntries := range entriesByField {
+		sort.SliceStable(fieldEntries, func(i, j int) bool {
+			return fieldEntries[i].GetInt("index") < fieldEntries[j].GetInt("index")
+		})
+	}
+
+	fieldById := make(map[string]*core.Record, len(fields))
+	for _, field := range fields {
+		fieldById[field.Id] = field
+	}
+
+	content := map[string]interface{}{}
+	for _, field := range fields {
+		key := field.GetString("key")
+		if key == "" || field.GetString("parent") != parentField {
+			continue
+		}
+		// Fields hidden by their condition are left out, as on the page.
+		if fieldHidden(field, siblingEntryValues(field, fieldById, entriesByField)) {
+			continue
+		}
+		fieldEntries := entriesByField[field.Id]
+		var value interface{}
+		if len(fieldEntries) > 0 {
+			value = normalizeValue(fieldEntries[0].Get("value"))
+		}
+		config, _ := normalizeValue(field.Get("config")).(map[string]interface{})
+
+		switch field.GetString("type") {
+		case "repeater":
+			items := make([]interface{}, 0, len(fieldEntries))
+			for _, entry := range fieldEntries {
+				items = append(items, r.resolveFields(fields, entries, field.Id, entry.Id, page))
+			}
+			content[key] = items
+
+		case "group":
+			group := map[string]interface{}{}
+			if len(fieldEntries) > 0 {
+				group = r.resolveFields(fields, entries, field.Id, fieldEntries[0].Id, page)
+			}
+			content[key] = group
+
+		case "page-field":
+			if page == nil {
+				continue
+			}
+			for _, pageField := range r.pageTypeFields[page.GetString("page_type")] {
+				if pageField.Id == getString(config, "field") {
+					content[key] = r.resolvePageContent(page)[pageField.GetString("key")]
+				}
+			}
+
+		case "site-field":
+			for _, siteField := range r.siteFields {
+				if siteField.Id == getString(config, "field") {
+					content[key] = r.siteMeta()["content"].(map[string]interface{})[siteField.GetString("key")]
+				}
+			}
+
+		case "markdown":
+			if s, ok := value.(string); ok {
+				content[key] = r.absoluteUploadURLs(markdownToHTML(s))
+			} else {
+				content[key] = ""
+			}
+
+		case "rich-text":
+			content[key] = r.absoluteUploadURLs(richTextToHTML(value))
+
+		case "image":
+			image, _ := value.(map[string]interface{})
+			url := getString(image, "url")
+			out := map[string]interface{}{"alt": getString(image, "alt"), "url": url}
+			if upload := r.upload(getString(image, "upload")); upload != nil {
+				entry := uploadManifestEntry(upload)
+				if url == "" {
+					out["url"] = r.baseURL + entry.URL
+				}
+				if out["alt"] == "" {
+					out["alt"] = entry.Alt
+				}
+				if entry.Width > 0 && entry.Height > 0 {
+					out["width"], out["height"] = entry.Width, entry.Height
+				}
+				if entry.FocalPoint != nil {
+					out["focal_point"] = map[string]interface{}{"x": entry.FocalPoint.X, "y": entry.FocalPoint.Y}
+				}
+			} else {
+				out["url"] = r.absoluteUploadURL(url)
+			}
+			content[key] = out
+
+		case "link":
+			link, _ := value.(map[string]interface{})
+			url := getString(link, "url")
+			if pagePath, ok := r.pagePaths[getString(link, "page")]; ok {
+				url = "/" + pagePath
+			}
+			label := getString(link, "label")
+			content[key] = map[string]interface{}{"url": r.absoluteUploadURL(url), "label": label, "text": label}
+
+		case "page":
+			pageId, _ := value.(string)
+			if referenced := r.pageById[pageId]; referenced != nil {
+				content[key] = r.pageReference(referenced)
+			} else {
+				content[key] = nil
+			}
+
+		case "page-list":
+			pageType := getString(config, "page_type")
+			listed := []*core.Record{}
+			for _, candidate := range r.pageById {
+				if pageType != "" && candidate.GetString("page_type") == pageType {
+					listed = append(listed, candidate)
+				}
+			}
+			sort.Slice(listed, func(i, j int) bool {
+				if listed[i].GetInt("index") != listed[j].GetInt("index") {
+					return listed[i].GetInt("index") < listed[j].GetInt("index")
+				}
+				return r.pagePaths[listed[i].Id] < r.pagePaths[listed[j].Id]
+			})
+			items := make([]interface{}, 0, len(listed))
+			for _, referenced := range listed {
+				items = append(items, r.pageReference(referenced))
+			}
+			content[key] = items
+
+		default:
+			if len(fieldEntries) > 1 {
+				values := make([]interface{}, 0, len(fieldEntries))
+				for _, entry := range fieldEntries {
+					values = append(values, normalizeValue(entry.Get("value")))
+				}
+				value = values
+			}
+			content[key] = value
+		}
+	}
+	return content
+}
+
+// absoluteUploadURL prefixes a site-relative file URL with the base URL.
+func (r *contentResolver) absoluteUploadURL(url string) string {
+	if strings.HasPrefix(url, "/api/files/") {
+		return r.baseURL + url
+	}
+	return url
+}
+
+// absoluteUploadURLs does absoluteUploadURL for the src and href
+// attributes of rendered HTML.
+func (r *contentResolver) absoluteUploadURLs(html string) string {
+	return strings.NewReplacer(
+		`src="/api/files/`, `src="`+r.baseURL+`/api/files/`,
+		`href="/api/files/`, `href="`+r.baseURL+`/api/files/`,
+	).Replace(html)
+}
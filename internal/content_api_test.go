@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	imagepng "image/png"
+	"strings"
+	"testing"
+)
+
+func TestContentAPIResolvesPage(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	project := map[string]string{
+		"blocks/hero/config.yaml":      "name: Hero\n",
+		"blocks/hero/component.svelte": "<section>{heading}</section>\n",
+		"blocks/hero/fields.yaml": "" +
+			"- name: heading\n  type: text\n" +
+			"- name: intro\n  type: markdown\n" +
+			"- name: subtitle\n  type: page-field\n  config:\n    field: default--subtitle\n" +
+			"- name: brand\n  type: site-field\n  config:\n    field: brand\n" +
+			"- name: cta\n  type: link\n" +
+			"- name: items\n  type: repeater\n  subfields:\n    - name: label\n      type: text\n",
+		"blocks/hero/content.yaml":       "{}\n",
+		"page-types/default/config.yaml": "name: Default\nallowed_blocks:\n  - hero\n",
+		"page-types/default/fields.yaml": "- name: subtitle\n  type: text\n- name: cover\n  type: image\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: default\nsections: []\n",
+		"pages/about.yaml": "" +
+			"name: About\npage_type: default\n" +
+			"content:\n  subtitle: Who we are\n" +
+			"sections:\n" +
+			"  - block: hero\n" +
+			"    content:\n" +
+			"      heading: Hello\n" +
+			"      intro: Some **bold** text\n" +
+			"      cta:\n        url: /\n        label: Home\n" +
+			"      items:\n        - label: one\n        - label: two\n",
+		"site/fields.yaml":  "- name: brand\n  type: text\n",
+		"site/content.yaml": "brand: Acme\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	var png bytes.Buffer
+	if err := imagepng.Encode(&png, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	upload, err := createSiteUpload(app, fsys, site, "cover.png", png.Bytes(), siteUploadOptions{Alt: "Cover"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Set("has_focal", true)
+	if err := app.Save(upload); err != nil {
+		t.Fatal(err)
+	}
+	pages, err := sitePagesByPath(app, site.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := newPageFieldWriter(app, pages["about"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.set(writer.field("cover"), map[string]interface{}{"url": "", "alt": "", "upload": upload.Id}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := newContentResolver(app, site, "https://cms.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := resolver.pageResponse(resolver.pageByPath["about"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"url":"/about"`,
+		`"subtitle":"Who we are"`,
+		`"url":"https://cms.example.com/api/files/site_uploads/` + upload.Id + `/`,
+		`"alt":"Cover"`,
+		`"focal_point":{"x":0,"y":0}`,
+		`"width":40`,
+		`"block":"Hero"`,
+		`"zone":"body"`,
+		`"heading":"Hello"`,
+		`"brand":"Acme"`,
+		`"items":[{"label":"one"},{"label":"two"}]`,
+	} {
+		if !strings.Contains(string(encoded), want) {
+			t.Fatalf("expected the response to contain %s, got %s", want, encoded)
+		}
+	}
+	section := response["sections"].([]map[string]interface{})[0]["content"].(map[string]interface{})
+	if section["intro"] != "<p>Some <strong>bold</strong> text</p>\n" {
+		t.Fatalf("expected markdown rendered to HTML, got %v", section["intro"])
+	}
+	if section["subtitle"] != "Who we are" {
+		t.Fatalf("expected the page-field to resolve from the page, got %v", section["subtitle"])
+	}
+	if cta := section["cta"].(map[string]interface{}); cta["url"] != "/" || cta["text"] != "Home" {
+		t.Fatalf("expected the link to resolve to the home page, got %v", cta)
+	}
+}
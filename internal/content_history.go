@@ -0,0 +1,557 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Saving a page, section, block or entry overwrites it in place. Content
+// history keeps what it was: every create, update and delete in those
+// collections adds a content_revisions record with the values before and
+// after, written in the same transaction as the change. Writes through
+// the record API carry the signed-in user; server-side writes (imports,
+// content patches, cascades) are recorded without one.
+
+// contentRevisionCollections are the collections whose changes are
+// recorded.
+var contentRevisionCollections = []string{
+	"pages",
+	"page_sections",
+	"page_type_sections",
+	"site_symbols",
+	"site_entries",
+	"site_symbol_entries",
+	"page_type_entries",
+	"page_entries",
+	"page_section_entries",
+	"page_type_section_entries",
+}
+
+// revisionSkippedFields are left out of revisions: timestamps, and output
+// the server compiles from the rest.
+var revisionSkippedFields = map[string]bool{
+	"id":            true,
+	"created":       true,
+	"updated":       true,
+	"compiled_html": true,
+	"compiled_js":   true,
+}
+
+// revisionActor is who a pending save is attributed to, keyed by the
+// record being saved.
+type revisionActor struct {
+	User         string
+	RestoredFrom string
+}
+
+var revisionActors sync.Map // *core.Record -> revisionActor
+
+// revisionDeleting holds the records being deleted, by collection/id. A
+// cascade removes a record's row before those of what it owns, so their
+// scopes are looked up here.
+var revisionDeleting sync.Map // string -> *core.Record
+
+// ContentRevision is one recorded change, as the history endpoints
+// return it.
+type ContentRevision struct {
+	ID           string              `json:"id"`
+	Collection   string              `json:"collection"`
+	Record       string              `json:"record"`
+	Action       string              `json:"action"`
+	User         string              `json:"user,omitempty"`
+	Page         string              `json:"page,omitempty"`
+	Symbol       string              `json:"symbol,omitempty"`
+	Section      string              `json:"section,omitempty"`
+	RestoredFrom string              `json:"restored_from,omitempty"`
+	Created      string              `json:"created"`
+	Changes      []ImportValueChange `json:"changes"`
+}
+
+// ContentRevisionDiff compares a record at two revisions.
+type ContentRevisionDiff struct {
+	Collection string              `json:"collection"`
+	Record     string              `json:"record"`
+	From       string              `json:"from,omitempty"`
+	To         string              `json:"to"`
+	Changes    []ImportValueChange `json:"changes"`
+}
+
+// ContentRestore counts what restoring a page or section changed.
+type ContentRestore struct {
+	Revision string `json:"revision"`
+	Created  int    `json:"created"`
+	Updated  int    `json:"updated"`
+	Deleted  int    `json:"deleted"`
+}
+
+// RegisterContentHistory records content revisions and serves the history
+// of a page, block or section, diffs between revisions, and restores of a
+// page or section to an earlier revision.
+func RegisterContentHistory(pb *pocketbase.PocketBase) error {
+	attribute := func(e *core.RecordRequestEvent) error {
+		if user := revisionUser(e.RequestEvent); user != "" {
+			revisionActors.Store(e.Record, revisionActor{User: user})
+			defer revisionActors.Delete(e.Record)
+		}
+		return e.Next()
+	}
+	pb.OnRecordCreateRequest(contentRevisionCollections...).BindFunc(attribute)
+	pb.OnRecordUpdateRequest(contentRevisionCollections...).BindFunc(attribute)
+	pb.OnRecordDeleteRequest(contentRevisionCollections...).BindFunc(attribute)
+
+	pb.OnRecordCreate(contentRevisionCollections...).BindFunc(func(e *core.RecordEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+		return saveContentRevision(e.App, e.Record, "create", nil, revisionValues(e.Record), contentRevisionScope(e.App, e.Record))
+	})
+	pb.OnRecordUpdate(contentRevisionCollections...).BindFunc(func(e *core.RecordEvent) error {
+		// Read from the database: Original() is stale when one record
+		// instance is saved more than once.
+		stored, err := e.App.FindRecordById(e.Record.Collection(), e.Record.Id)
+		if err != nil {
+			return e.Next()
+		}
+		before := revisionValues(stored)
+		if err := e.Next(); err != nil {
+			return err
+		}
+		after := revisionValues(e.Record)
+		if reflect.DeepEqual(before, after) {
+			return nil
+		}
+		return saveContentRevision(e.App, e.Record, "update", before, after, contentRevisionScope(e.App, e.Record))
+	})
+	pb.OnRecordDelete(contentRevisionCollections...).BindFunc(func(e *core.RecordEvent) error {
+		scope := contentRevisionScope(e.App, e.Record)
+		key := e.Record.Collection().Name + "/" + e.Record.Id
+		revisionDeleting.Store(key, e.Record)
+		err := e.Next()
+		revisionDeleting.Delete(key)
+		if err != nil {
+			return err
+		}
+		return saveContentRevision(e.App, e.Record, "delete", revisionValues(e.Record), nil, scope)
+	})
+
+	// Revisions written while a site's content cascades away would
+	// otherwise outlive it.
+	pb.OnRecordAfterDeleteSuccess("sites").BindFunc(func(e *core.RecordEvent) error {
+		if _, err := e.App.DB().Delete("content_revisions", dbx.HashExp{"site": e.Record.Id}).Execute(); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+
+	pb.OnServe().BindFunc(func(serveEvent *core.ServeEvent) error {
+		history := func(scopeField, param string) func(e *core.RequestEvent) error {
+			return func(e *core.RequestEvent) error {
+				site, err := requireSiteAccess(pb, e, false, ScopeContentRead)
+				if err != nil {
+					return err
+				}
+				query := e.Request.URL.Query()
+				limit, _ := strconv.Atoi(query.Get("limit"))
+				offset, _ := strconv.Atoi(query.Get("offset"))
+				revisions, err := contentHistory(pb, site, scopeField, e.Request.PathValue(param), query.Get("collection"), limit, offset)
+				if err != nil {
+					return e.InternalServerError("History failed: "+err.Error(), err)
+				}
+				return e.JSON(200, revisions)
+			}
+		}
+		serveEvent.Router.GET("/api/palacms/sites/{siteId}/pages/{pageId}/history", history("page", "pageId"))
+		serveEvent.Router.GET("/api/palacms/sites/{siteId}/blocks/{symbolId}/history", history("symbol", "symbolId"))
+		serveEvent.Router.GET("/api/palacms/sites/{siteId}/sections/{sectionId}/history", history("section", "sectionId"))
+
+		serveEvent.Router.GET("/api/palacms/sites/{siteId}/revisions/diff", func(e *core.RequestEvent) error {
+			site, err := requireSiteAccess(pb, e, false, ScopeContentRead)
+			if err != nil {
+				return err
+			}
+			query := e.Request.URL.Query()
+			diff, err := diffContentRevisions(pb, site, query.Get("from"), query.Get("to"))
+			if err != nil {
+				return e.BadRequestError("Diff failed: "+err.Error(), err)
+			}
+			return e.JSON(200, diff)
+		})
+
+		restore := func(scopeField, param string) func(e *core.RequestEvent) error {
+			return func(e *core.RequestEvent) error {
+				site, err := requireSiteAccess(pb, e, true, ScopeContentWrite)
+				if err != nil {
+					return err
+				}
+				var body struct {
+					Revision string `json:"revision"`
+				}
+				if err := e.BindBody(&body); err != nil {
+					return e.BadRequestError("Invalid request body", err)
+				}
+				revision, err := pb.FindRecordById("content_revisions", body.Revision)
+				if err != nil || revision.GetString("site") != site.Id || revision.GetString(scopeField) != e.Request.PathValue(param) {
+					return e.NotFoundError("Revision not found", err)
+				}
+				result, err := restoreContentRevision(pb, site, scopeField, revision, revisionUser(e))
+				if err != nil {
+					return e.InternalServerError("Restore failed: "+err.Error(), err)
+				}
+				return e.JSON(200, result)
+			}
+		}
+		serveEvent.Router.POST("/api/palacms/sites/{siteId}/pages/{pageId}/restore", restore("page", "pageId"))
+		serveEvent.Router.POST("/api/palacms/sites/{siteId}/sections/{sectionId}/restore", restore("section", "sectionId"))
+
+		return serveEvent.Next()
+	})
+
+	return nil
+}
+
+// revisionUser is the user a request's changes are attributed to, or ""
+// for API keys, localhost and superusers.
+func revisionUser(e *core.RequestEvent) string {
+	if e.Auth == nil || e.Auth.Collection().Name != "users" {
+		return ""
+	}
+	return e.Auth.Id
+}
+
+// revisionValues is what a revision keeps of a record: every field but
+// those in revisionSkippedFields, with JSON decoded.
+func revisionValues(record *core.Record) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, field := range record.Collection().Fields {
+		name := field.GetName()
+		if revisionSkippedFields[name] {
+			continue
+		}
+		values[name] = normalizeValue(record.Get(name))
+	}
+	return values
+}
+
+// revisionScope places a record in its site and, where it has them, the
+// page, block and section it belongs to.
+type revisionScope struct {
+	Site, Page, Symbol, Section string
+}
+
+// contentRevisionScope finds the scope of a record in one of the
+// contentRevisionCollections. Owners that can't be found are left empty.
+func contentRevisionScope(app core.App, record *core.Record) revisionScope {
+	find := func(collection, id string) (*core.Record, bool) {
+		if owner, ok := revisionDeleting.Load(collection + "/" + id); ok {
+			return owner.(*core.Record), true
+		}
+		owner, err := app.FindRecordById(collection, id)
+		return owner, err == nil
+	}
+	lookup := func(collection, id, field string) string {
+		if id == "" {
+			return ""
+		}
+		owner, ok := find(collection, id)
+		if !ok {
+			return ""
+		}
+		return owner.GetString(field)
+	}
+
+	var scope revisionScope
+	switch record.Collection().Name {
+	case "pages":
+		scope.Site, scope.Page = record.GetString("site"), record.Id
+	case "site_symbols":
+		scope.Site, scope.Symbol = record.GetString("site"), record.Id
+	case "page_sections":
+		scope.Page, scope.Symbol, scope.Section = record.GetString("page"), record.GetString("symbol"), record.Id
+		scope.Site = lookup("pages", scope.Page, "site")
+	case "page_type_sections":
+		scope.Symbol, scope.Section = record.GetString("symbol"), record.Id
+		scope.Site = lookup("page_types", record.GetString("page_type"), "site")
+	case "site_entries":
+		scope.Site = lookup("site_fields", record.GetString("field"), "site")
+	case "site_symbol_entries":
+		scope.Symbol = lookup("site_symbol_fields", record.GetString("field"), "symbol")
+		scope.Site = lookup("site_symbols", scope.Symbol, "site")
+	case "page_type_entries":
+		scope.Site = lookup("page_types", lookup("page_type_fields", record.GetString("field"), "page_type"), "site")
+	case "page_entries":
+		scope.Page = record.GetString("page")
+		scope.Site = lookup("pages", scope.Page, "site")
+	case "page_section_entries", "page_type_section_entries":
+		sections := "page_sections"
+		if record.Collection().Name == "page_type_section_entries" {
+			sections = "page_type_sections"
+		}
+		if section, ok := find(sections, record.GetString("section")); ok {
+			scope = contentRevisionScope(app, section)
+		}
+	}
+	return scope
+}
+
+func saveContentRevision(app core.App, record *core.Record, action string, before, after map[string]interface{}, scope revisionScope) error {
+	if scope.Site == "" {
+		// Orphaned content (see the integrity check) has no site to file
+		// its history under.
+		return nil
+	}
+	collection, err := app.FindCollectionByNameOrId("content_revisions")
+	if err != nil {
+		return err
+	}
+
+	revision := core.NewRecord(collection)
+	revision.Set("site", scope.Site)
+	revision.Set("collection", record.Collection().Name)
+	revision.Set("record", record.Id)
+	revision.Set("action", action)
+	revision.Set("page", scope.Page)
+	revision.Set("symbol", scope.Symbol)
+	revision.Set("section", scope.Section)
+	revision.Set("before", before)
+	revision.Set("after", after)
+	if actor, ok := revisionActors.Load(record); ok {
+		revision.Set("user", actor.(revisionActor).User)
+		revision.Set("restored_from", actor.(revisionActor).RestoredFrom)
+	}
+	if err := app.Save(revision); err != nil {
+		return fmt.Errorf("failed to record revision of %s/%s: %w", record.Collection().Name, record.Id, err)
+	}
+	return nil
+}
+
+// findContentRevisions lists a site's revisions with scopeField (page,
+// symbol or section) equal to id, oldest first. Revisions are ordered by
+// rowid: several written in one millisecond share a created time.
+func findContentRevisions(app core.App, site *core.Record, scopeField, id string) ([]*core.Record, error) {
+	var revisions []*core.Record
+	err := app.RecordQuery("content_revisions").
+		AndWhere(dbx.HashExp{"site": site.Id, scopeField: id}).
+		OrderBy("rowid ASC").
+		All(&revisions)
+	return revisions, err
+}
+
+// contentHistory lists the revisions of a page, block or section, newest
+// first, optionally of one collection only. limit defaults to 100.
+func contentHistory(app core.App, site *core.Record, scopeField, id, collection string, limit, offset int) ([]ContentRevision, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	filter := dbx.HashExp{"site": site.Id, scopeField: id}
+	if collection != "" {
+		filter["collection"] = collection
+	}
+
+	var records []*core.Record
+	err := app.RecordQuery("content_revisions").
+		AndWhere(filter).
+		OrderBy("rowid DESC").
+		Limit(int64(limit)).
+		Offset(int64(offset)).
+		All(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]ContentRevision, 0, len(records))
+	for _, record := range records {
+		revisions = append(revisions, ContentRevision{
+			ID:           record.Id,
+			Collection:   record.GetString("collection"),
+			Record:       record.GetString("record"),
+			Action:       record.GetString("action"),
+			User:         record.GetString("user"),
+			Page:         record.GetString("page"),
+			Symbol:       record.GetString("symbol"),
+			Section:      record.GetString("section"),
+			RestoredFrom: record.GetString("restored_from"),
+			Created:      record.GetString("created"),
+			Changes:      diffValues("", normalizeValue(record.Get("before")), normalizeValue(record.Get("after"))),
+		})
+	}
+	return revisions, nil
+}
+
+// diffContentRevisions compares a record as it was after revision from
+// with how it was after revision to. Without from, it shows what to
+// itself changed.
+func diffContentRevisions(app core.App, site *core.Record, fromId, toId string) (*ContentRevisionDiff, error) {
+	find := func(id string) (*core.Record, error) {
+		revision, err := app.FindRecordById("content_revisions", id)
+		if err != nil || revision.GetString("site") != site.Id {
+			return nil, fmt.Errorf("revision %q not found", id)
+		}
+		return revision, nil
+	}
+
+	to, err := find(toId)
+	if err != nil {
+		return nil, err
+	}
+	before := normalizeValue(to.Get("before"))
+	if fromId != "" {
+		from, err := find(fromId)
+		if err != nil {
+			return nil, err
+		}
+		if from.GetString("collection") != to.GetString("collection") || from.GetString("record") != to.GetString("record") {
+			return nil, fmt.Errorf("revisions %q and %q are of different records", fromId, toId)
+		}
+		before = normalizeValue(from.Get("after"))
+	}
+
+	return &ContentRevisionDiff{
+		Collection: to.GetString("collection"),
+		Record:     to.GetString("record"),
+		From:       fromId,
+		To:         toId,
+		Changes:    diffValues("", before, normalizeValue(to.Get("after"))),
+	}, nil
+}
+
+// restoreContentRevision brings every record in the page or section of
+// revision back to how it was right after it: records changed since get
+// their values back, deleted ones are recreated with their ids, and ones
+// created since are deleted. Records with no history are left as they
+// are. It all happens in one transaction, and the restore is recorded as
+// new revisions.
+func restoreContentRevision(app core.App, site *core.Record, scopeField string, target *core.Record, user string) (*ContentRestore, error) {
+	revisions, err := findContentRevisions(app, site, scopeField, target.GetString(scopeField))
+	if err != nil {
+		return nil, err
+	}
+	at := slices.IndexFunc(revisions, func(r *core.Record) bool { return r.Id == target.Id })
+	if at < 0 {
+		return nil, fmt.Errorf("revision %q is not in this history", target.Id)
+	}
+
+	// The state of each record at the revision: the last revision up to
+	// it, or else the state before the first one after it.
+	type restoreState struct {
+		Collection, Record string
+		Values             map[string]interface{}
+	}
+	states := map[string]*restoreState{}
+	var order []string
+	for i, revision := range revisions {
+		key := revision.GetString("collection") + "/" + revision.GetString("record")
+		if _, seen := states[key]; seen && i > at {
+			continue
+		}
+		if _, seen := states[key]; !seen {
+			order = append(order, key)
+		}
+		values := revision.Get("after")
+		if i > at {
+			values = revision.Get("before")
+		}
+		state, _ := normalizeValue(values).(map[string]interface{})
+		states[key] = &restoreState{revision.GetString("collection"), revision.GetString("record"), state}
+	}
+
+	// Owners before what they own, and parent entries before children.
+	rank := func(state *restoreState) int {
+		switch {
+		case state.Collection == "pages":
+			return 0
+		case strings.HasSuffix(state.Collection, "_sections"):
+			return 1
+		}
+		return 2
+	}
+	depth := func(state *restoreState) int {
+		d := 0
+		for s := state; s != nil && s.Values != nil && d < 16; d++ {
+			parent, _ := s.Values["parent"].(string)
+			if parent == "" {
+				break
+			}
+			s = states[s.Collection+"/"+parent]
+		}
+		return d
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := states[order[i]], states[order[j]]
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+		return depth(a) < depth(b)
+	})
+
+	result := &ContentRestore{Revision: target.Id}
+	actor := revisionActor{User: user, RestoredFrom: target.Id}
+	err = app.RunInTransaction(func(txApp core.App) error {
+		// Deletions go children first; a cascade may already have taken
+		// some of them.
+		for i := len(order) - 1; i >= 0; i-- {
+			state := states[order[i]]
+			if state.Values != nil {
+				continue
+			}
+			record, err := txApp.FindRecordById(state.Collection, state.Record)
+			if err != nil {
+				continue
+			}
+			revisionActors.Store(record, actor)
+			err = txApp.Delete(record)
+			revisionActors.Delete(record)
+			if err != nil {
+				return fmt.Errorf("failed to delete %s/%s: %w", state.Collection, state.Record, err)
+			}
+			result.Deleted++
+		}
+
+		for _, key := range order {
+			state := states[key]
+			if state.Values == nil {
+				continue
+			}
+			record, err := txApp.FindRecordById(state.Collection, state.Record)
+			created := err != nil
+			if created {
+				collection, err := txApp.FindCollectionByNameOrId(state.Collection)
+				if err != nil {
+					return err
+				}
+				record = core.NewRecord(collection)
+				record.Id = state.Record
+			} else if reflect.DeepEqual(revisionValues(record), state.Values) {
+				continue
+			}
+			for name, value := range state.Values {
+				record.Set(name, value)
+			}
+
+			revisionActors.Store(record, actor)
+			err = txApp.SaveWithContext(keepContent(), record)
+			revisionActors.Delete(record)
+			if err != nil {
+				return fmt.Errorf("failed to restore %s/%s: %w", state.Collection, state.Record, err)
+			}
+			if created {
+				result.Created++
+			} else {
+				result.Updated++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestContentHistory(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterContentHistory(app); err != nil {
+		t.Fatal(err)
+	}
+	site := createImportTestSite(t, app)
+
+	project := map[string]string{
+		"blocks/hero/config.yaml":        "name: Hero\n",
+		"blocks/hero/component.svelte":   "<h1>{heading}</h1>\n",
+		"blocks/hero/fields.yaml":        "- name: heading\n  type: text\n",
+		"blocks/hero/content.yaml":       "heading: Default\n",
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: default\nsections:\n  - block: hero\n    content:\n      heading: First\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	pages, err := sitePagesByPath(app, site.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := pages[""]
+	section, err := app.FindFirstRecordByFilter("page_sections", "page = {:page}", dbx.Params{"page": page.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := app.FindFirstRecordByFilter("page_section_entries", "section = {:section}", dbx.Params{"section": section.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry.Set("value", "Second")
+	if err := app.Save(entry); err != nil {
+		t.Fatal(err)
+	}
+	history, err := contentHistory(app, site, "section", section.Id, "page_section_entries", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Action != "update" || history[1].Action != "create" {
+		t.Fatalf("expected an update after a create, got %+v", history)
+	}
+	second := history[0]
+	if len(second.Changes) != 1 || second.Changes[0].Path != "value" || second.Changes[0].Before != "First" || second.Changes[0].After != "Second" {
+		t.Fatalf("expected value to change from First to Second, got %+v", second.Changes)
+	}
+	if second.Page != page.Id || second.Symbol != section.GetString("symbol") {
+		t.Fatalf("expected the entry's revision under its page and block, got %+v", second)
+	}
+
+	entry.Set("value", "Third")
+	if err := app.Save(entry); err != nil {
+		t.Fatal(err)
+	}
+	history, _ = contentHistory(app, site, "section", section.Id, "page_section_entries", 1, 0)
+	diff, err := diffContentRevisions(app, site, history[0].ID, second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changes) != 1 || diff.Changes[0].Before != "Third" || diff.Changes[0].After != "Second" {
+		t.Fatalf("expected the diff from Third back to Second, got %+v", diff.Changes)
+	}
+
+	// Deleting the section cascades to its entry; both deletions stay in
+	// the page's history.
+	if err := app.Delete(section); err != nil {
+		t.Fatal(err)
+	}
+	history, err = contentHistory(app, site, "page", page.Id, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := map[string]bool{}
+	for _, revision := range history {
+		if revision.Action == "delete" {
+			deleted[revision.Collection+"/"+revision.Record] = true
+		}
+	}
+	if !deleted["page_sections/"+section.Id] || !deleted["page_section_entries/"+entry.Id] {
+		t.Fatalf("expected the section and entry deletions in the page history, got %+v", history)
+	}
+
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := core.NewRecord(users)
+	user.SetEmail("editor@example.com")
+	user.SetPassword("password123")
+	if err := app.Save(user); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := app.FindRecordById("content_revisions", second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := restoreContentRevision(app, site, "page", target, user.Id)
+	if err != nil {
+		t.Fatalf("restore page: %v", err)
+	}
+	if result.Created != 2 || result.Updated != 0 || result.Deleted != 0 {
+		t.Fatalf("expected the section and entry to be recreated, got %+v", result)
+	}
+	restored, err := app.FindRecordById("page_section_entries", entry.Id)
+	if err != nil {
+		t.Fatalf("expected the entry back under its id: %v", err)
+	}
+	if value := normalizeValue(restored.Get("value")); value != "Second" {
+		t.Fatalf("expected the restored entry to say Second, got %v", value)
+	}
+	if _, err := app.FindRecordById("page_sections", section.Id); err != nil {
+		t.Fatalf("expected the section back under its id: %v", err)
+	}
+
+	history, _ = contentHistory(app, site, "page", page.Id, "", 2, 0)
+	for _, revision := range history {
+		if revision.Action != "create" || revision.User != user.Id || revision.RestoredFrom != second.ID {
+			t.Fatalf("expected the restore recorded as creates by the user, got %+v", revision)
+		}
+	}
+
+	// Restoring to where it already is changes nothing.
+	result, err = restoreContentRevision(app, site, "page", target, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Created+result.Updated+result.Deleted != 0 {
+		t.Fatalf("expected a no-op restore, got %+v", result)
+	}
+}
@@ -0,0 +1,314 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ContentPatch is the body of PATCH /api/palacms/sites/{siteId}/pages/{path...}.
+// Every key is optional, and only the fields named are written:
+//
+//	{
+//	  "site":     {"phone": "555-0100"},
+//	  "content":  {"subtitle": "Spring sale"},
+//	  "sections": {"0": {"heading": "Hi"}, "Pricing": {"plans": [...]}}
+//	}
+//
+// site and content hold site and page type fields by key. sections are
+// addressed by their index on the page or by block name, when only one
+// section on the page uses that block. A field's value replaces the field
+// entirely, repeater items and group subfields included; links may use
+// {"url": "/path"} for pages of the site, as in page files. A key that
+// matches no field or section, or a value that breaks its field's rules,
+// rejects the whole patch. Missing required values are only reported.
+type ContentPatch struct {
+	Site     map[string]interface{}            `json:"site"`
+	Content  map[string]interface{}            `json:"content"`
+	Sections map[string]map[string]interface{} `json:"sections"`
+}
+
+type ContentPatchResult struct {
+	Page     string          `json:"page"`
+	Path     string          `json:"path"`
+	Updated  []string        `json:"updated"`
+	Warnings []ImportWarning `json:"warnings"`
+}
+
+func handleContentPatch(pb *pocketbase.PocketBase, e *core.RequestEvent) error {
+	site, err := requireSiteAccess(pb, e, true, ScopeContentWrite)
+	if err != nil {
+		return err
+	}
+
+	pagePath := strings.Trim(e.Request.PathValue("path"), "/")
+	pages, err := sitePagesByPath(pb, site.Id)
+	if err != nil {
+		return e.InternalServerError("Failed to load pages", err)
+	}
+	page := pages[pagePath]
+	if page == nil {
+		return e.NotFoundError("Page not found", nil)
+	}
+
+	var patch ContentPatch
+	if err := json.NewDecoder(e.Request.Body).Decode(&patch); err != nil {
+		return e.BadRequestError("Invalid JSON body", err)
+	}
+
+	result, err := patchPageContent(pb, site, page, pagePath, patch)
+	var patchErr *contentPatchError
+	if errors.As(err, &patchErr) {
+		return e.BadRequestError("Content update failed: "+err.Error(), err)
+	}
+	if err != nil {
+		return e.InternalServerError("Content update failed: "+err.Error(), err)
+	}
+	return e.JSON(http.StatusOK, result)
+}
+
+// contentPatchError lists what in a patch can't be written: keys that
+// match no field or section on the page, and values that break their
+// field's rules.
+type contentPatchError struct {
+	problems []string
+}
+
+func (e *contentPatchError) Error() string {
+	return strings.Join(e.problems, "; ")
+}
+
+// report is the report func for checkContent: a value breaking a rule is
+// a problem, while missing required values and values hidden by a
+// condition are warnings, as the editor would save them too.
+func (e *contentPatchError) report(warnings *[]ImportWarning, sourceFile, block string) func(v *constraintViolation) {
+	warn := constraintWarnings(warnings, sourceFile, block)
+	return func(v *constraintViolation) {
+		if v.Rule == "required" || v.Rule == "condition" {
+			warn(v)
+			return
+		}
+		e.problems = append(e.problems, fmt.Sprintf("%s: %s", v.Path, v.Message))
+	}
+}
+
+// patchPageContent writes patch to site and page in one transaction.
+// Problems fail it with a *contentPatchError, so nothing is written; a
+// typo in an API call is an error, not content to keep around.
+func patchPageContent(pb *pocketbase.PocketBase, site, page *core.Record, pagePath string, patch ContentPatch) (*ContentPatchResult, error) {
+	result := &ContentPatchResult{
+		Page:     page.Id,
+		Path:     "/" + pagePath,
+		Updated:  []string{},
+		Warnings: []ImportWarning{},
+	}
+	sourceFile := result.Path
+	rejected := &contentPatchError{}
+
+	err := pb.RunInTransaction(func(txApp core.App) error {
+		pages, err := sitePagesByPath(txApp, site.Id)
+		if err != nil {
+			return err
+		}
+		pathToPageId := make(map[string]string, len(pages))
+		for p, record := range pages {
+			pathToPageId[p] = record.Id
+		}
+
+		if err := patchSiteContent(txApp, site, patch.Site, pathToPageId, result, rejected); err != nil {
+			return err
+		}
+
+		writer, err := newPageFieldWriter(txApp, page)
+		if err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(patch.Content) {
+			field := writer.field(key)
+			if field == nil {
+				rejected.problems = append(rejected.problems, fmt.Sprintf("content.%s: the page has no field %q", key, key))
+				continue
+			}
+			writer.check(field, patch.Content[key], patch.Content, "content."+key, rejected.report(&result.Warnings, sourceFile, ""))
+			if err := writer.set(field, patch.Content[key], pathToPageId); err != nil {
+				return fmt.Errorf("failed to save content.%s: %w", key, err)
+			}
+			result.Updated = append(result.Updated, "content."+key)
+		}
+
+		if err := patchSectionContent(txApp, page, patch.Sections, pathToPageId, sourceFile, result, rejected); err != nil {
+			return err
+		}
+
+		if len(rejected.problems) > 0 {
+			return rejected
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func patchSiteContent(app core.App, site *core.Record, content map[string]interface{}, pathToPageId map[string]string, result *ContentPatchResult, rejected *contentPatchError) error {
+	if len(content) == 0 {
+		return nil
+	}
+
+	fields, err := app.FindRecordsByFilter("site_fields", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return err
+	}
+	fieldByKey := make(map[string]*core.Record)
+	fieldById := recordsById(fields)
+	fieldsByParent := make(map[string][]*core.Record)
+	for _, f := range fields {
+		fieldByKey[f.GetString("key")] = f
+		if parentId := f.GetString("parent"); parentId != "" {
+			fieldsByParent[parentId] = append(fieldsByParent[parentId], f)
+		}
+	}
+	entriesColl, err := app.FindCollectionByNameOrId("site_entries")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range sortedKeys(content) {
+		field := fieldByKey[key]
+		if field == nil || field.GetString("parent") != "" {
+			rejected.problems = append(rejected.problems, fmt.Sprintf("site.%s: the site has no field %q", key, key))
+			continue
+		}
+
+		// Replace the field's entries; child entries go with them.
+		existing, err := app.FindAllRecords("site_entries", dbx.HashExp{"field": field.Id, "parent": ""})
+		if err != nil {
+			return err
+		}
+		for _, entry := range existing {
+			if err := app.Delete(entry); err != nil {
+				return err
+			}
+		}
+		checkContent(app, field, content[key], content, fieldsByParent, fieldById, "site."+key, rejected.report(&result.Warnings, result.Path, ""))
+		if err := importSiteContentField(app, entriesColl, field, convertUrlsToPageRefs(content[key], pathToPageId), "", 0, fieldsByParent, fieldByKey); err != nil {
+			return fmt.Errorf("failed to save site.%s: %w", key, err)
+		}
+		result.Updated = append(result.Updated, "site."+key)
+	}
+	return nil
+}
+
+func patchSectionContent(app core.App, page *core.Record, patches map[string]map[string]interface{}, pathToPageId map[string]string, sourceFile string, result *ContentPatchResult, rejected *contentPatchError) error {
+	if len(patches) == 0 {
+		return nil
+	}
+
+	sections, err := app.FindRecordsByFilter("page_sections", "page = {:page}", "+index", 0, 0, dbx.Params{"page": page.Id})
+	if err != nil {
+		return err
+	}
+	entriesColl, err := app.FindCollectionByNameOrId("page_section_entries")
+	if err != nil {
+		return err
+	}
+	blockNames := map[string]string{}
+	for _, section := range sections {
+		symbolId := section.GetString("symbol")
+		if _, ok := blockNames[symbolId]; ok {
+			continue
+		}
+		symbol, err := app.FindRecordById("site_symbols", symbolId)
+		if err == nil {
+			blockNames[symbolId] = symbol.GetString("name")
+		}
+	}
+
+	for _, ref := range sortedKeys(patches) {
+		matches := []int{}
+		if i, err := strconv.Atoi(ref); err == nil {
+			if i >= 0 && i < len(sections) {
+				matches = append(matches, i)
+			}
+		} else {
+			for i, section := range sections {
+				name := blockNames[section.GetString("symbol")]
+				if name == ref || sanitizeFilename(name) == ref {
+					matches = append(matches, i)
+				}
+			}
+		}
+		if len(matches) != 1 {
+			problem := fmt.Sprintf("sections.%s: the page has no section %q", ref, ref)
+			if len(matches) > 1 {
+				problem = fmt.Sprintf("sections.%s: %d sections use block %q; address the one to update by index", ref, len(matches), ref)
+			}
+			rejected.problems = append(rejected.problems, problem)
+			continue
+		}
+
+		i := matches[0]
+		section := sections[i]
+		blockName := blockNames[section.GetString("symbol")]
+		symbolFields, err := app.FindRecordsByFilter("site_symbol_fields", "symbol = {:symbol}", "", 0, 0, dbx.Params{"symbol": section.GetString("symbol")})
+		if err != nil {
+			return err
+		}
+		fieldByKey := make(map[string]*core.Record)
+		topLevel := make(map[string]*core.Record)
+		fieldById := recordsById(symbolFields)
+		fieldsByParent := make(map[string][]*core.Record)
+		for _, f := range symbolFields {
+			fieldByKey[f.GetString("key")] = f
+			if parentId := f.GetString("parent"); parentId != "" {
+				fieldsByParent[parentId] = append(fieldsByParent[parentId], f)
+			} else {
+				topLevel[f.GetString("key")] = f
+			}
+		}
+
+		content := patches[ref]
+		for _, key := range sortedKeys(content) {
+			itemPath := fmt.Sprintf("sections[%d].content.%s", i, key)
+			field := topLevel[key]
+			if field == nil {
+				rejected.problems = append(rejected.problems, fmt.Sprintf("%s: block %q has no field %q", itemPath, blockName, key))
+				continue
+			}
+
+			existing, err := app.FindAllRecords("page_section_entries", dbx.HashExp{"section": section.Id, "field": field.Id, "parent": ""})
+			if err != nil {
+				return err
+			}
+			for _, entry := range existing {
+				if err := app.Delete(entry); err != nil {
+					return err
+				}
+			}
+			checkContent(app, field, content[key], content, fieldsByParent, fieldById, itemPath, rejected.report(&result.Warnings, sourceFile, blockName))
+			if err := importPageSectionContentField(app, entriesColl, section.Id, field, content[key], "", 0, fieldsByParent, fieldByKey, pathToPageId, &result.Warnings, sourceFile, blockName, itemPath); err != nil {
+				return fmt.Errorf("failed to save %s: %w", itemPath, err)
+			}
+			result.Updated = append(result.Updated, itemPath)
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+)
+
+func TestPatchPageContent(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	project := map[string]string{
+		"blocks/pricing/config.yaml":      "name: Pricing\n",
+		"blocks/pricing/component.svelte": "<section>{heading}</section>\n",
+		"blocks/pricing/fields.yaml": "" +
+			"- name: heading\n  type: text\n" +
+			"- name: cta\n  type: link\n" +
+			"- name: plans\n  type: repeater\n  subfields:\n    - name: name\n      type: text\n    - name: price\n      type: number\n",
+		"blocks/pricing/content.yaml":    "{}\n",
+		"page-types/default/config.yaml": "name: Default\nallowed_blocks:\n  - pricing\n",
+		"page-types/default/fields.yaml": "- name: subtitle\n  type: text\n  config:\n    validation:\n      maxLength: 20\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: default\nsections: []\n",
+		"pages/pricing.yaml": "" +
+			"name: Pricing\npage_type: default\n" +
+			"sections:\n" +
+			"  - block: pricing\n" +
+			"    content:\n" +
+			"      heading: Plans\n" +
+			"      plans:\n        - name: Basic\n          price: 10\n        - name: Pro\n          price: 20\n",
+		"site/fields.yaml":  "- name: phone\n  type: text\n",
+		"site/content.yaml": "phone: 555-0100\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+	pages, err := sitePagesByPath(app, site.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var patch ContentPatch
+	if err := json.Unmarshal([]byte(`{
+		"site": {"phone": "555-0199"},
+		"content": {"subtitle": "Spring sale"},
+		"sections": {
+			"Pricing": {"plans": [{"name": "Basic", "price": 12}], "cta": {"url": "/", "label": "Home"}}
+		}
+	}`), &patch); err != nil {
+		t.Fatal(err)
+	}
+	result, err := patchPageContent(app, site, pages["pricing"], "pricing", patch)
+	if err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	if len(result.Updated) != 4 || len(result.Warnings) != 0 {
+		t.Fatalf("expected four fields updated without warnings, got %v %v", result.Updated, result.Warnings)
+	}
+
+	pageJSON := func() string {
+		t.Helper()
+		resolver, err := newContentResolver(app, site, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := resolver.pageResponse(pages["pricing"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, _ := json.Marshal(response)
+		return string(encoded)
+	}
+	encoded := pageJSON()
+	for _, want := range []string{
+		`"phone":"555-0199"`,
+		`"subtitle":"Spring sale"`,
+		`"heading":"Plans"`,
+		`"plans":[{"name":"Basic","price":12}]`,
+		`"cta":{"label":"Home","text":"Home","url":"/"}`,
+	} {
+		if !strings.Contains(encoded, want) {
+			t.Fatalf("expected %s after the patch, got %s", want, encoded)
+		}
+	}
+
+	// Keys that match nothing reject the whole patch, through the endpoint
+	// as a 400, and nothing is written or quarantined.
+	e := newTestRequestEvent(app, http.MethodPatch, "/", strings.NewReader(`{
+		"site": {"phone": "555-0000", "fax": "none"},
+		"content": {"subtitel": "Typo"},
+		"sections": {
+			"Pricing": {"heading": "Changed", "ribbon": "New"},
+			"4": {"heading": "Missing"}
+		}
+	}`))
+	e.Request.RemoteAddr = "127.0.0.1:1234"
+	e.Request.SetPathValue("siteId", site.Id)
+	e.Request.SetPathValue("path", "pricing")
+	err = handleContentPatch(app, e)
+	if got := apiErrorStatus(t, err); got != http.StatusBadRequest {
+		t.Fatalf("expected unknown keys rejected with 400, got %d (%v)", got, err)
+	}
+	for _, want := range []string{"site.fax", "content.subtitel", "sections[0].content.ribbon", "sections.4"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %s reported, got %v", want, err)
+		}
+	}
+	if after := pageJSON(); after != encoded {
+		t.Fatalf("expected nothing written by the rejected patch, got %s", after)
+	}
+	quarantined, err := app.FindAllRecords("import_quarantine", dbx.HashExp{"site": site.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 0 {
+		t.Fatalf("expected nothing quarantined, got %d rows", len(quarantined))
+	}
+
+	// So does a value breaking its field's rules.
+	_, err = patchPageContent(app, site, pages["pricing"], "pricing", ContentPatch{
+		Site:    map[string]interface{}{"phone": "555-0000"},
+		Content: map[string]interface{}{"subtitle": "A subtitle that is far too long"},
+	})
+	var patchErr *contentPatchError
+	if !errors.As(err, &patchErr) || !strings.Contains(err.Error(), "content.subtitle: must be at most 20 characters") {
+		t.Fatalf("expected the long subtitle rejected, got %v", err)
+	}
+	if after := pageJSON(); after != encoded {
+		t.Fatalf("expected nothing written by the rejected patch, got %s", after)
+	}
+}
@@ -2,17 +2,19 @@ package internal
 
 import (
 	"archive/zip"
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"mime"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
 	"gopkg.in/yaml.v3"
 )
 
@@ -122,9 +124,8 @@ func RegisterExportEndpoint(pb *pocketbase.PocketBase) error {
 			}
 
 			// Allow unauthenticated access from localhost (for primo dev)
-			isLocal := IsLocalhost(e)
-
-			if e.Auth == nil && !isLocal {
+			// and API keys with the export scope (for remote automation)
+			if e.Auth == nil && requestSiteAPIKey(e) == "" && !IsLocalhost(e) {
 				return e.UnauthorizedError("Authentication required", nil)
 			}
 
@@ -133,24 +134,23 @@ func RegisterExportEndpoint(pb *pocketbase.PocketBase) error {
 			if err != nil {
 				return e.NotFoundError("Site not found", err)
 			}
+			if err := checkSiteAccess(e, site, false, ScopeExport); err != nil {
+				return err
+			}
 
-			// Skip access check for localhost
-			if !isLocal {
-				info, err := e.RequestInfo()
-				if err != nil {
-					return e.InternalServerError("Failed to get request info", err)
-				}
-				canAccess, _ := e.App.CanAccessRecord(site, info, site.Collection().ViewRule)
-				if !canAccess {
-					return e.ForbiddenError("Access denied", nil)
+			includeFiles, _ := strconv.ParseBool(e.Request.URL.Query().Get("include_files"))
+			// Backfilling saves the uploads, so it takes write access.
+			backfill, _ := strconv.ParseBool(e.Request.URL.Query().Get("backfill_metadata"))
+			if backfill {
+				if err := checkSiteAccess(e, site, true, ScopeExport); err != nil {
+					return err
 				}
 			}
-
-			// Generate the export
-			zipData, err := exportSiteToZip(pb, site)
+			format, err := resolveExportFormat(e.Request.URL.Query().Get("format"))
 			if err != nil {
-				return e.InternalServerError("Export failed: "+err.Error(), err)
+				return e.BadRequestError(err.Error(), err)
 			}
+			opts := ExportOptions{IncludeFiles: includeFiles, Format: format, BackfillUploadMetadata: backfill}
 
 			// Return as ZIP download. mime.FormatMediaType escapes the
 			// parameter value, so a site name with quotes or backslashes
@@ -164,17 +164,66 @@ func RegisterExportEndpoint(pb *pocketbase.PocketBase) error {
 			}
 			e.Response.Header().Set("Content-Type", "application/zip")
 			e.Response.Header().Set("Content-Disposition", disposition)
-			e.Response.Write(zipData)
+
+			// The archive is streamed, so the status line is gone by the
+			// time a late failure (say, a missing blob halfway through the
+			// uploads) surfaces. Log it instead of trying to answer with an
+			// error; the client is left with a truncated zip that won't open.
+			if err := exportSiteToZip(pb, site, e.Response, opts); err != nil {
+				pb.Logger().Error("Export failed", "site", site.Id, "error", err)
+			}
 			return nil
 		})
+
+		// Pages as Markdown files with YAML frontmatter
+		serveEvent.Router.GET("/api/palacms/export/{siteId}/markdown", func(e *core.RequestEvent) error {
+			return handleMarkdownExport(pb, e)
+		})
 		return serveEvent.Next()
 	})
 	return nil
 }
 
-func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	zw := zip.NewWriter(buf)
+// ExportOptions controls what goes into a site archive beyond the editable
+// source files.
+type ExportOptions struct {
+	// IncludeFiles embeds the bytes of every upload (under uploads/) and
+	// each block's compiled JavaScript (blocks/<name>/compiled.js), so the
+	// archive can be imported on another instance or kept offline without
+	// pointing back at this server's /api/files URLs.
+	IncludeFiles bool
+
+	// Format is the export format version to write, for importing into an
+	// older instance (see exportFormatSteps). Empty means the current one.
+	Format string
+
+	// BackfillUploadMetadata saves the derived metadata (hash, size, mime,
+	// dimensions) of uploads that predate it. Without it the manifest is
+	// filled in from the stored bytes for this export only, so exporting
+	// never writes to the database unless asked to.
+	BackfillUploadMetadata bool
+
+	// sourceFilesOnly leaves out the uploads manifest, for rendering the
+	// revision-tracked files only (see renderSiteFiles).
+	sourceFilesOnly bool
+}
+
+// exportSiteToZip writes the site archive to w as it is built. Nothing is
+// buffered beyond the zip.Writer's own buffers, so embedding a site's
+// uploads costs one file at a time rather than the whole archive.
+func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record, w io.Writer, opts ExportOptions) error {
+	if opts.Format != "" && opts.Format != currentExportFormat {
+		return exportSiteToOlderFormat(pb, site, w, opts)
+	}
+
+	archive := zip.NewWriter(w)
+	zw := newRevisionWriter(archive)
+
+	fsys, err := pb.NewFilesystem()
+	if err != nil {
+		return fmt.Errorf("failed to open filesystem: %w", err)
+	}
+	defer fsys.Close()
 
 	siteId := site.Id
 
@@ -185,16 +234,16 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 		SiteID:     siteId,
 		Group:      site.GetString("group"),
 		ExportedAt: site.GetString("updated"),
-		Version:    "1.0",
+		Version:    currentExportFormat,
 	}
 	if err := writeYAMLToZip(zw, "site.yaml", siteConfig); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Pre-fetch site fields for ID -> name mapping (needed for site-field type resolution in blocks)
 	siteFields, err := pb.FindRecordsByFilter("site_fields", "site = {:site}", "+index", 0, 0, dbx.Params{"site": siteId})
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch site fields: %w", err)
+		return fmt.Errorf("failed to fetch site fields: %w", err)
 	}
 	siteFieldIdToKey := make(map[string]string)
 	for _, field := range siteFields {
@@ -211,7 +260,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 	// instead of raw record IDs.
 	pageTypesAll, err := pb.FindRecordsByFilter("page_types", "site = {:site}", "", 0, 0, dbx.Params{"site": siteId})
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch page types: %w", err)
+		return fmt.Errorf("failed to fetch page types: %w", err)
 	}
 	pageTypeFolderById := make(map[string]string)
 	for _, pt := range pageTypesAll {
@@ -225,7 +274,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 	for _, pt := range pageTypesAll {
 		ptFields, err := pb.FindRecordsByFilter("page_type_fields", "page_type = {:pt}", "+index", 0, 0, dbx.Params{"pt": pt.Id})
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch page type fields: %w", err)
+			return fmt.Errorf("failed to fetch page type fields: %w", err)
 		}
 		for _, f := range ptFields {
 			key := f.GetString("key")
@@ -239,7 +288,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 	// 2. Export blocks (site_symbols)
 	symbols, err := pb.FindRecordsByFilter("site_symbols", "site = {:site}", "", 0, 0, dbx.Params{"site": siteId})
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch symbols: %w", err)
+		return fmt.Errorf("failed to fetch symbols: %w", err)
 	}
 
 	symbolNames := make(map[string]string) // id -> name for reference
@@ -283,14 +332,20 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 
 		if len(output) > 0 {
 			if err := writeFileToZip(zw, fmt.Sprintf("blocks/%s/component.svelte", symbolName), output); err != nil {
-				return nil, err
+				return err
+			}
+		}
+
+		if compiled := symbol.GetString("compiled_js"); opts.IncludeFiles && compiled != "" {
+			if err := copyStorageFileToZip(archive, fsys, symbol.BaseFilesPath()+"/"+compiled, fmt.Sprintf("blocks/%s/compiled.js", symbolName)); err != nil {
+				return fmt.Errorf("failed to embed compiled block %s: %w", symbolName, err)
 			}
 		}
 
 		// Fetch and write fields.yaml
 		fields, err := pb.FindRecordsByFilter("site_symbol_fields", "symbol = {:symbol}", "+index", 0, 0, dbx.Params{"symbol": symbol.Id})
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch symbol fields: %w", err)
+			return fmt.Errorf("failed to fetch symbol fields: %w", err)
 		}
 
 		// Build field ID -> key map for parent resolution
@@ -313,12 +368,12 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 			Name: symbol.GetString("name"),
 		}
 		if err := writeYAMLToZip(zw, fmt.Sprintf("blocks/%s/config.yaml", symbolName), blockConfig); err != nil {
-			return nil, err
+			return err
 		}
 
 		blockFields := ExportedBlockFields(orderedFields(nestSubfields(exportedFields)))
 		if err := writeYAMLToZip(zw, fmt.Sprintf("blocks/%s/fields.yaml", symbolName), blockFields); err != nil {
-			return nil, err
+			return err
 		}
 
 		// Fetch and write content.yaml (site_symbol_entries - default values)
@@ -364,14 +419,14 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 		// dropped onto a page. Emit an empty {} when no defaults exist so
 		// scaffolders/validators can rely on the file always being present.
 		if err := writeYAMLToZip(zw, fmt.Sprintf("blocks/%s/content.yaml", symbolName), symbolContent); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	// 3. Export page types
 	pageTypes, err := pb.FindRecordsByFilter("page_types", "site = {:site}", "", 0, 0, dbx.Params{"site": siteId})
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch page types: %w", err)
+		return fmt.Errorf("failed to fetch page types: %w", err)
 	}
 
 	pageTypeNames := make(map[string]string) // id -> name
@@ -385,7 +440,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 		// Fetch allowed blocks (page_type_symbols)
 		ptSymbols, err := pb.FindRecordsByFilter("page_type_symbols", "page_type = {:pt}", "", 0, 0, dbx.Params{"pt": pt.Id})
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch page type symbols: %w", err)
+			return fmt.Errorf("failed to fetch page type symbols: %w", err)
 		}
 
 		allowedBlocks := make([]string, 0, len(ptSymbols))
@@ -399,7 +454,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 		// Fetch page type fields
 		ptFields, err := pb.FindRecordsByFilter("page_type_fields", "page_type = {:pt}", "+index", 0, 0, dbx.Params{"pt": pt.Id})
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch page type fields: %w", err)
+			return fmt.Errorf("failed to fetch page type fields: %w", err)
 		}
 
 		// Build field ID -> key map for parent resolution
@@ -427,12 +482,12 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 			AllowedBlocks: allowedBlocks,
 		}
 		if err := writeYAMLToZip(zw, fmt.Sprintf("page-types/%s/config.yaml", ptName), ptConfig); err != nil {
-			return nil, err
+			return err
 		}
 
 		ptFieldsList := ExportedPageTypeFields(orderedFields(nestSubfields(exportedPTFields)))
 		if err := writeYAMLToZip(zw, fmt.Sprintf("page-types/%s/fields.yaml", ptName), ptFieldsList); err != nil {
-			return nil, err
+			return err
 		}
 
 		// Page-type head/foot HTML. Mirrors the site-level head.svelte/foot.html
@@ -440,19 +495,19 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 		// (see Publish.svelte.ts: site.head + page_type.head).
 		if ptHead := pt.GetString("head"); ptHead != "" {
 			if err := writeFileToZip(zw, fmt.Sprintf("page-types/%s/head.svelte", ptName), []byte(ptHead)); err != nil {
-				return nil, err
+				return err
 			}
 		}
 		if ptFoot := pt.GetString("foot"); ptFoot != "" {
 			if err := writeFileToZip(zw, fmt.Sprintf("page-types/%s/foot.html", ptName), []byte(ptFoot)); err != nil {
-				return nil, err
+				return err
 			}
 		}
 
 		// Fetch header/footer slots (page_type_sections) with their content
 		ptSections, err := pb.FindRecordsByFilter("page_type_sections", "page_type = {:pt}", "+index", 0, 0, dbx.Params{"pt": pt.Id})
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch page type sections: %w", err)
+			return fmt.Errorf("failed to fetch page type sections: %w", err)
 		}
 
 		headerSections := make([]map[string]interface{}, 0)
@@ -519,11 +574,11 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 				layout["footer"] = footerSections
 			}
 			if err := writeYAMLToZip(zw, layoutPath, layout); err != nil {
-				return nil, err
+				return err
 			}
 		} else {
 			if err := writeFileToZip(zw, layoutPath, []byte(emptyLayoutTemplate)); err != nil {
-				return nil, err
+				return err
 			}
 		}
 	}
@@ -531,7 +586,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 	// 4. Export pages
 	pages, err := pb.FindRecordsByFilter("pages", "site = {:site}", "", 0, 0, dbx.Params{"site": siteId})
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch pages: %w", err)
+		return fmt.Errorf("failed to fetch pages: %w", err)
 	}
 
 	// Build page hierarchy for path generation
@@ -571,7 +626,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 		// Fetch page entries (field values)
 		pageEntries, err := pb.FindRecordsByFilter("page_entries", "page = {:page}", "", 0, 0, dbx.Params{"page": page.Id})
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch page entries: %w", err)
+			return fmt.Errorf("failed to fetch page entries: %w", err)
 		}
 
 		fieldValues := make(map[string]interface{})
@@ -588,7 +643,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 		// Fetch page sections (blocks on the page)
 		pageSections, err := pb.FindRecordsByFilter("page_sections", "page = {:page}", "+index", 0, 0, dbx.Params{"page": page.Id})
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch page sections: %w", err)
+			return fmt.Errorf("failed to fetch page sections: %w", err)
 		}
 
 		sections := make([]map[string]interface{}, 0, len(pageSections))
@@ -599,7 +654,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 			// Fetch section entries (content values)
 			sectionEntries, err := pb.FindRecordsByFilter("page_section_entries", "section = {:section}", "", 0, 0, dbx.Params{"section": section.Id})
 			if err != nil {
-				return nil, fmt.Errorf("failed to fetch section entries: %w", err)
+				return fmt.Errorf("failed to fetch section entries: %w", err)
 			}
 
 			// Fetch symbol fields to understand types and hierarchy
@@ -676,7 +731,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 		}
 
 		if err := writeYAMLToZip(zw, filename, pageData); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -697,7 +752,7 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 
 	if len(exportedSiteFields) > 0 {
 		if err := writeYAMLToZip(zw, "site/fields.yaml", orderedFields(nestSubfields(exportedSiteFields))); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -743,49 +798,58 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 		siteContent = &orderedMap{values: map[string]interface{}{}, keys: []string{}}
 	}
 	if err := writeYAMLToZip(zw, "site/content.yaml", siteContent); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Site head/foot HTML
 	headHtml := site.GetString("head")
 	if headHtml != "" {
 		if err := writeFileToZip(zw, "site/head.svelte", []byte(headHtml)); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	footHtml := site.GetString("foot")
 	if footHtml != "" {
 		if err := writeFileToZip(zw, "site/foot.html", []byte(footHtml)); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	// 6. Generate AGENTS.md
 	readme := generateReadme(site, symbols, pageTypes, symbolNames, pageTypeNames)
 	if err := writeFileToZip(zw, "AGENTS.md", []byte(readme)); err != nil {
-		return nil, err
+		return err
 	}
 
 	// 7. Export uploads manifest
-	uploads, err := pb.FindRecordsByFilter("site_uploads", "site = {:site}", "", 0, 0, dbx.Params{"site": siteId})
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch uploads: %w", err)
+	var uploads []*core.Record
+	if !opts.sourceFilesOnly {
+		uploads, err = pb.FindRecordsByFilter("site_uploads", "site = {:site}", "", 0, 0, dbx.Params{"site": siteId})
+		if err != nil {
+			return fmt.Errorf("failed to fetch uploads: %w", err)
+		}
 	}
 
 	if len(uploads) > 0 {
-		manifest := make(map[string]interface{})
+		manifest := make(map[string]UploadManifestEntry)
 		for _, upload := range uploads {
 			filename := upload.GetString("file")
 			if filename != "" {
-				manifest[filename] = map[string]interface{}{
-					"id":  upload.Id,
-					"url": fmt.Sprintf("/api/files/site_uploads/%s/%s", upload.Id, filename),
+				if err := ensureUploadMetadata(pb, fsys, upload, opts.BackfillUploadMetadata); err != nil {
+					return err
+				}
+				manifest[filename] = uploadManifestEntry(upload)
+
+				if opts.IncludeFiles {
+					if err := copyStorageFileToZip(archive, fsys, uploadStorageKey(upload), "uploads/"+filename); err != nil {
+						return fmt.Errorf("failed to embed upload %s: %w", filename, err)
+					}
 				}
 			}
 		}
 		if err := writeJSONToZip(zw, "uploads/.manifest.json", manifest); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -851,18 +915,18 @@ func exportSiteToZip(pb *pocketbase.PocketBase, site *core.Record) ([]byte, erro
 		siteFieldManifest[name] = id
 	}
 
-	if err := writeJSONToZip(zw, ".primo/manifest.json", idManifest); err != nil {
-		return nil, err
-	}
+	// Revision hashes of everything written above, checked by the next
+	// import to detect edits made in the CMS in the meantime.
+	idManifest["revisions"] = zw.revisions()
 
-	if err := zw.Close(); err != nil {
-		return nil, err
+	if err := writeJSONToZip(zw, ".primo/manifest.json", idManifest); err != nil {
+		return err
 	}
 
-	return buf.Bytes(), nil
+	return archive.Close()
 }
 
-func writeJSONToZip(zw *zip.Writer, filename string, data interface{}) error {
+func writeJSONToZip(zw zipEntryCreator, filename string, data interface{}) error {
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return err
@@ -870,7 +934,7 @@ func writeJSONToZip(zw *zip.Writer, filename string, data interface{}) error {
 	return writeFileToZip(zw, filename, jsonData)
 }
 
-func writeYAMLToZip(zw *zip.Writer, filename string, data interface{}) error {
+func writeYAMLToZip(zw zipEntryCreator, filename string, data interface{}) error {
 	yamlData, err := yaml.Marshal(data)
 	if err != nil {
 		return err
@@ -878,7 +942,25 @@ func writeYAMLToZip(zw *zip.Writer, filename string, data interface{}) error {
 	return writeFileToZip(zw, filename, yamlData)
 }
 
-func writeFileToZip(zw *zip.Writer, filename string, data []byte) error {
+// copyStorageFileToZip streams a file from app storage into the archive.
+// Images and other already-compressed uploads make up most of an embedded
+// export, so entries are stored rather than deflated a second time.
+func copyStorageFileToZip(zw *zip.Writer, fsys *filesystem.System, key, filename string) error {
+	reader, err := fsys.GetReader(key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: filename, Method: zip.Store, Modified: reader.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+func writeFileToZip(zw zipEntryCreator, filename string, data []byte) error {
 	w, err := zw.Create(filename)
 	if err != nil {
 		return err
@@ -992,6 +1074,11 @@ func fieldRecordToMap(field *core.Record, fieldIdToKey map[string]string, siteFi
 		}
 	}
 
+	// Conditions name a sibling field, by key in project files.
+	if config != nil && fieldIdToKey != nil {
+		exportFieldCondition(config, fieldIdToKey)
+	}
+
 	result := map[string]interface{}{
 		"name":  name,
 		"label": field.GetString("label"),
@@ -1151,6 +1238,9 @@ func normalizeValue(v interface{}) interface{} {
 // It groups entries by their field, handles repeaters (arrays of items with children),
 // groups (nested objects), and simple fields.
 //
+// Values of fields hidden by their condition are left out, as the editor
+// doesn't show them and sites don't render them.
+//
 // Returns a *orderedMap so the YAML emit preserves field-declaration order
 // instead of alphabetizing keys (which is yaml.v3's default for plain maps).
 // Without this, every CMS->files round-trip would reorder content keys.
@@ -1173,7 +1263,7 @@ func buildSectionContent(
 	// Collect unique fields and sort by index for consistent YAML output order
 	fieldsInContent := make([]*core.Record, 0, len(entriesByField))
 	for fieldId := range entriesByField {
-		if field, ok := fieldById[fieldId]; ok {
+		if field, ok := fieldById[fieldId]; ok && !fieldHidden(field, siblingEntryValues(field, fieldById, entriesByField)) {
 			fieldsInContent = append(fieldsInContent, field)
 		}
 	}
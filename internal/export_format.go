@@ -0,0 +1,326 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"gopkg.in/yaml.v3"
+)
+
+// currentExportFormat is the version export writes to site.yaml. A minor
+// bump adds files or keys an older importer safely ignores, so projects
+// from a newer minor version import as they are; a major bump changes the
+// layout and is refused by older instances. Either way a bump needs a step
+// in exportFormatSteps so exports can still be written for older ones.
+const currentExportFormat = "1.1"
+
+// exportFormatStep converts an archive between two consecutive formats, in
+// memory, in either direction.
+type exportFormatStep struct {
+	from, to  string
+	upgrade   func(files map[string][]byte) error
+	downgrade func(files map[string][]byte) error
+}
+
+// exportFormatSteps is ordered oldest first.
+//
+// 1.0 → 1.1: page type configs name their record id `_id` (1.0 projects
+// may still say `id`), and archives can carry revisions, upload bytes and
+// compiled blocks, which 1.0 instances don't know about.
+var exportFormatSteps = []exportFormatStep{
+	{from: "1.0", to: "1.1", upgrade: upgradeExportFormat10To11, downgrade: downgradeExportFormat11To10},
+}
+
+// exportFormatVersion parses "major.minor"; a missing minor is 0.
+func exportFormatVersion(version string) (major, minor int, err error) {
+	majorPart, minorPart, hasMinor := strings.Cut(strings.TrimSpace(version), ".")
+	major, err = strconv.Atoi(majorPart)
+	if err == nil && hasMinor {
+		minor, err = strconv.Atoi(minorPart)
+	}
+	if err != nil || major < 1 || minor < 0 {
+		return 0, 0, fmt.Errorf("unrecognised export format version %q", version)
+	}
+	return major, minor, nil
+}
+
+// compareExportFormats orders two valid versions like strings.Compare.
+func compareExportFormats(a, b string) int {
+	aMajor, aMinor, _ := exportFormatVersion(a)
+	bMajor, bMinor, _ := exportFormatVersion(b)
+	if aMajor != bMajor {
+		return cmp.Compare(aMajor, bMajor)
+	}
+	return cmp.Compare(aMinor, bMinor)
+}
+
+// supportedExportFormats lists the formats export can write, newest first.
+func supportedExportFormats() []string {
+	formats := []string{currentExportFormat}
+	for i := len(exportFormatSteps) - 1; i >= 0; i-- {
+		formats = append(formats, exportFormatSteps[i].from)
+	}
+	return formats
+}
+
+// resolveExportFormat maps a requested format to one export can write: an
+// exact version ("1.0"), the newest of a major version ("1"), or the
+// current one for "".
+func resolveExportFormat(requested string) (string, error) {
+	if requested == "" {
+		return currentExportFormat, nil
+	}
+	major, _, err := exportFormatVersion(requested)
+	if err != nil {
+		return "", err
+	}
+	exact := strings.Contains(requested, ".")
+	for _, format := range supportedExportFormats() {
+		formatMajor, _, _ := exportFormatVersion(format)
+		if (exact && compareExportFormats(format, requested) == 0) || (!exact && formatMajor == major) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("export format %q is not supported; choose one of %s", requested, strings.Join(supportedExportFormats(), ", "))
+}
+
+// upgradeImportFiles brings a project written in an older format up to the
+// current one before it is imported. Projects without a version in
+// site.yaml (hand-built archives, partial pushes) are taken as current.
+func upgradeImportFiles(files map[string][]byte) error {
+	version := siteYAMLVersion(files)
+	if version == "" {
+		return nil
+	}
+	major, _, err := exportFormatVersion(version)
+	if err != nil {
+		return fmt.Errorf("site.yaml: %w", err)
+	}
+	currentMajor, _, _ := exportFormatVersion(currentExportFormat)
+	if major > currentMajor {
+		return fmt.Errorf("site.yaml declares export format %s, but this instance reads formats up to %s; upgrade Primo to import this project", version, currentExportFormat)
+	}
+	if compareExportFormats(version, currentExportFormat) >= 0 {
+		return nil
+	}
+
+	reached := version
+	for _, step := range exportFormatSteps {
+		if compareExportFormats(step.from, reached) != 0 {
+			continue
+		}
+		if err := step.upgrade(files); err != nil {
+			return fmt.Errorf("failed to upgrade project from format %s to %s: %w", step.from, step.to, err)
+		}
+		reached = step.to
+	}
+	if compareExportFormats(reached, currentExportFormat) != 0 {
+		return fmt.Errorf("no upgrade path from export format %s to %s", version, currentExportFormat)
+	}
+
+	return setSiteYAMLVersion(files, currentExportFormat)
+}
+
+// exportSiteToOlderFormat renders the current format and steps it down.
+// Unlike a current-format export this holds the whole archive in memory,
+// which is fine for the instances old enough to need it.
+func exportSiteToOlderFormat(pb *pocketbase.PocketBase, site *core.Record, w io.Writer, opts ExportOptions) error {
+	format := opts.Format
+	var buf bytes.Buffer
+	current := opts
+	current.Format = ""
+	if err := exportSiteToZip(pb, site, &buf, current); err != nil {
+		return err
+	}
+	fsys, err := zipImportFS(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	files, err := readImportFiles(fsys)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := exportFormatVersion(format); err != nil {
+		return err
+	}
+	for i := len(exportFormatSteps) - 1; i >= 0; i-- {
+		step := exportFormatSteps[i]
+		if compareExportFormats(step.from, format) < 0 {
+			break
+		}
+		if err := step.downgrade(files); err != nil {
+			return fmt.Errorf("failed to convert export from format %s to %s: %w", step.to, step.from, err)
+		}
+	}
+	if err := setSiteYAMLVersion(files, format); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		if err := writeFileToZip(zw, name, files[name]); err != nil {
+			return err
+		}
+	}
+	// readImportFiles leaves upload bytes in the archive. No format step
+	// touches them, so they are copied across as they are.
+	err = fs.WalkDir(fsys, "uploads", func(name string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() || !isImportUploadFile(name) {
+			return err
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		entry, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entry, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func upgradeExportFormat10To11(files map[string][]byte) error {
+	for name, data := range files {
+		if !strings.HasPrefix(name, "page-types/") || path.Base(name) != "config.yaml" {
+			continue
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		mapping := yamlDocumentMapping(&doc)
+		if mapping == nil {
+			continue
+		}
+		idIndex, hasUnderscoreID := -1, false
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			switch mapping.Content[i].Value {
+			case "id":
+				idIndex = i
+			case "_id":
+				hasUnderscoreID = true
+			}
+		}
+		if idIndex < 0 {
+			continue
+		}
+		if hasUnderscoreID {
+			mapping.Content = append(mapping.Content[:idIndex], mapping.Content[idIndex+2:]...)
+		} else {
+			mapping.Content[idIndex].Value = "_id"
+		}
+		out, err := yaml.Marshal(&doc)
+		if err != nil {
+			return err
+		}
+		files[name] = out
+	}
+	return nil
+}
+
+func downgradeExportFormat11To10(files map[string][]byte) error {
+	for name := range files {
+		if (strings.HasPrefix(name, "uploads/") && name != "uploads/.manifest.json") ||
+			(strings.HasPrefix(name, "blocks/") && path.Base(name) == "compiled.js") {
+			delete(files, name)
+		}
+	}
+
+	if data, ok := files[".primo/manifest.json"]; ok {
+		var manifest map[string]interface{}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("failed to parse .primo/manifest.json: %w", err)
+		}
+		delete(manifest, "revisions")
+		out, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		files[".primo/manifest.json"] = out
+	}
+	return nil
+}
+
+func siteYAMLVersion(files map[string][]byte) string {
+	var cfg struct {
+		Version string `yaml:"version"`
+	}
+	if data, ok := files["site.yaml"]; ok {
+		yaml.Unmarshal(data, &cfg)
+	}
+	return cfg.Version
+}
+
+// setSiteYAMLVersion rewrites the version in site.yaml, leaving the rest of
+// the file (and its key order) alone.
+func setSiteYAMLVersion(files map[string][]byte, version string) error {
+	data, ok := files["site.yaml"]
+	if !ok {
+		return nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse site.yaml: %w", err)
+	}
+	mapping := yamlDocumentMapping(&doc)
+	if mapping == nil {
+		return nil
+	}
+	found := false
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == "version" {
+			mapping.Content[i+1].Value = version
+			mapping.Content[i+1].Tag = "!!str"
+			mapping.Content[i+1].Style = 0
+			found = true
+		}
+	}
+	if !found {
+		mapping.Content = append(mapping.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: version},
+		)
+	}
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return err
+	}
+	files["site.yaml"] = out
+	return nil
+}
+
+func yamlDocumentMapping(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) == 1 && doc.Content[0].Kind == yaml.MappingNode {
+		return doc.Content[0]
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestExportFormatUpgradesAndDowngrades(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	// A 1.0 project still naming the page type's record `id`.
+	legacy := map[string]string{
+		"site.yaml":                      "name: Legacy\nversion: \"1.0\"\n",
+		"page-types/default/config.yaml": "id: legacy123456789\nname: Default\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: Default\nsections: []\n",
+		"site/fields.yaml":               "[]\n",
+		"site/content.yaml":              "{}\n",
+	}
+	upgraded := readImportTestFiles(t, zipFiles(t, legacy))
+	if err := upgradeImportFiles(upgraded); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if got := string(upgraded["page-types/default/config.yaml"]); got != "_id: legacy123456789\nname: Default\n" {
+		t.Fatalf("expected the legacy id to become _id, got:\n%s", got)
+	}
+	if siteYAMLVersion(upgraded) != currentExportFormat {
+		t.Fatalf("expected site.yaml to be stamped %s, got %q", currentExportFormat, siteYAMLVersion(upgraded))
+	}
+	if _, err := processImport(app, site, zipFiles(t, legacy), false); err != nil {
+		t.Fatalf("expected a 1.0 project to be upgraded on import: %v", err)
+	}
+
+	future := map[string]string{"site.yaml": "name: Future\nversion: \"2.0\"\n"}
+	_, err := processImport(app, site, zipFiles(t, future), false)
+	var archiveErr *importArchiveError
+	if !errors.As(err, &archiveErr) || !strings.Contains(err.Error(), "export format 2.0") {
+		t.Fatalf("expected a clear error for a future format, got %v", err)
+	}
+
+	// A newer minor version only adds what this instance can ignore.
+	additive := map[string][]byte{"site.yaml": []byte("name: Later\nversion: \"1.9\"\n")}
+	if err := upgradeImportFiles(additive); err != nil || siteYAMLVersion(additive) != "1.9" {
+		t.Fatalf("expected a newer minor format imported as is, got %q (%v)", siteYAMLVersion(additive), err)
+	}
+
+	current := exportSiteBytes(t, app, site, ExportOptions{})
+	var siteConfig ExportedSite
+	if err := yaml.Unmarshal([]byte(readZipFile(t, current, "site.yaml")), &siteConfig); err != nil {
+		t.Fatalf("parse site.yaml: %v", err)
+	}
+	if siteConfig.Version != currentExportFormat {
+		t.Fatalf("expected site.yaml version %s, got %q", currentExportFormat, siteConfig.Version)
+	}
+
+	if format, err := resolveExportFormat("1"); err != nil || format != currentExportFormat {
+		t.Fatalf("expected a major version to resolve to its newest format, got %q (%v)", format, err)
+	}
+	format, err := resolveExportFormat("1.0")
+	if err != nil {
+		t.Fatalf("resolve format: %v", err)
+	}
+	older := exportSiteBytes(t, app, site, ExportOptions{Format: format})
+	if err := yaml.Unmarshal([]byte(readZipFile(t, older, "site.yaml")), &siteConfig); err != nil {
+		t.Fatalf("parse downgraded site.yaml: %v", err)
+	}
+	if siteConfig.Version != "1.0" || siteConfig.Name != site.GetString("name") {
+		t.Fatalf("expected a 1.0 site.yaml keeping the name, got %#v", siteConfig)
+	}
+	if strings.Contains(readZipFile(t, older, ".primo/manifest.json"), "revisions") {
+		t.Fatal("expected revisions to be dropped from a 1.0 export")
+	}
+
+	// The downgraded archive still round-trips through the upgrade.
+	if _, err := processImport(app, site, older, false); err != nil {
+		t.Fatalf("re-import 1.0 export: %v", err)
+	}
+
+	for _, requested := range []string{"7", "1.4", "1.x"} {
+		if _, err := resolveExportFormat(requested); err == nil {
+			t.Fatalf("expected unsupported export format %q rejected", requested)
+		}
+	}
+}
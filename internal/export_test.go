@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+func TestSelfContainedExportRestoresUploads(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterUploadBlobs(app); err != nil {
+		t.Fatalf("register upload blobs: %v", err)
+	}
+
+	site := createImportTestSite(t, app)
+
+	file, err := filesystem.NewFileFromBytes([]byte("logo bytes"), "logo.txt")
+	if err != nil {
+		t.Fatalf("new file: %v", err)
+	}
+	uploads, err := app.FindCollectionByNameOrId("site_uploads")
+	if err != nil {
+		t.Fatalf("find site_uploads: %v", err)
+	}
+	upload := core.NewRecord(uploads)
+	upload.Set("site", site.Id)
+	upload.Set("file", file)
+	if err := app.Save(upload); err != nil {
+		t.Fatalf("save upload: %v", err)
+	}
+	storedName := upload.GetString("file")
+
+	if plain := exportSiteBytes(t, app, site, ExportOptions{}); zipHasFile(t, plain, "uploads/"+storedName) {
+		t.Fatal("expected upload bytes to be left out by default")
+	}
+	exported := exportSiteBytes(t, app, site, ExportOptions{IncludeFiles: true})
+	if got := readZipFile(t, exported, "uploads/"+storedName); got != "logo bytes" {
+		t.Fatalf("expected embedded upload bytes, got %q", got)
+	}
+
+	if err := app.Delete(upload); err != nil {
+		t.Fatalf("delete upload: %v", err)
+	}
+
+	result, err := processImport(app, site, exported, false)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if !slices.Contains(result.Diff.Site.Added, "uploads/"+storedName) {
+		t.Fatalf("expected restored upload in diff, got %#v", result.Diff.Site.Added)
+	}
+
+	restored, err := app.FindRecordById("site_uploads", upload.Id)
+	if err != nil {
+		t.Fatalf("expected upload to be restored under its original id: %v", err)
+	}
+	if restored.GetString("file") != storedName || restored.GetString("blob") == "" {
+		t.Fatalf("unexpected restored upload: file=%q blob=%q", restored.GetString("file"), restored.GetString("blob"))
+	}
+
+	result, err = processImport(app, site, exported, false)
+	if err != nil {
+		t.Fatalf("reimport: %v", err)
+	}
+	if len(result.Diff.Site.Added) != 0 {
+		t.Fatalf("expected reimport to leave uploads alone, got %#v", result.Diff.Site.Added)
+	}
+}
+
+func TestSelfContainedImportGivesInvalidUploadIdsFreshOnes(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterUploadBlobs(app); err != nil {
+		t.Fatalf("register upload blobs: %v", err)
+	}
+
+	site := createImportTestSite(t, app)
+	storedName := "logo_abcdefghij.txt"
+	archive := zipFiles(t, map[string]string{
+		"uploads/" + storedName:  "logo bytes",
+		"uploads/.manifest.json": `{"` + storedName + `": {"id": "../../NOT AN ID"}}`,
+	})
+
+	result, err := processImport(app, site, archive, false)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	restored, err := app.FindFirstRecordByData("site_uploads", "file", storedName)
+	if err != nil {
+		t.Fatalf("expected the upload restored: %v", err)
+	}
+	idField := restored.Collection().Fields.GetByName("id").(*core.TextField)
+	if err := idField.ValidatePlainValue(restored.Id); err != nil {
+		t.Fatalf("expected a valid id, got %q: %v", restored.Id, err)
+	}
+	if !slices.ContainsFunc(result.Warnings, func(w ImportWarning) bool {
+		return w.Kind == "upload_id_changed" && w.Path == storedName
+	}) {
+		t.Fatalf("expected a warning about the changed id, got %#v", result.Warnings)
+	}
+}
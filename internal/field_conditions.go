@@ -0,0 +1,227 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// fieldCondition is the condition under "condition" in a field's config
+// (src/lib/common/models/Condition.ts). The field is shown only while the
+// sibling field it names compares to value. In the database field holds
+// the sibling's record id; in project files, the sibling's key.
+type fieldCondition struct {
+	Field      string      `json:"field"`
+	Comparison string      `json:"comparison"`
+	Value      interface{} `json:"value"`
+}
+
+func readFieldCondition(field *core.Record) *fieldCondition {
+	config, _ := normalizeValue(field.Get("config")).(map[string]interface{})
+	raw, ok := config["condition"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	encoded, _ := json.Marshal(raw)
+	var condition fieldCondition
+	if json.Unmarshal(encoded, &condition) != nil || condition.Field == "" {
+		return nil
+	}
+	return &condition
+}
+
+// holds compares the sibling's value as the editor does: "=" and "!="
+// on the stored value. A sibling without an entry counts as empty.
+func (c *fieldCondition) holds(value interface{}) bool {
+	equal := conditionValuesEqual(normalizeValue(value), normalizeValue(c.Value))
+	if c.Comparison == "!=" {
+		return !equal
+	}
+	return equal
+}
+
+func conditionValuesEqual(a, b interface{}) bool {
+	if a == nil {
+		a = ""
+	}
+	if b == nil {
+		b = ""
+	}
+	if x, ok := conditionNumber(a); ok {
+		y, ok := conditionNumber(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func conditionNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// fieldHidden reports whether field's condition is false. sibling looks
+// up the value of the field with an id under the same parent; ok is false
+// when there is no such field. Like the editor, a condition naming a
+// missing field leaves the field shown.
+func fieldHidden(field *core.Record, sibling func(id string) (value interface{}, ok bool)) bool {
+	condition := readFieldCondition(field)
+	if condition == nil {
+		return false
+	}
+	value, ok := sibling(condition.Field)
+	return ok && !condition.holds(value)
+}
+
+// siblingEntryValues is the sibling lookup for fieldHidden over a set of
+// entries at one level: fieldById resolves fields, entriesByField holds
+// the level's entries.
+func siblingEntryValues(field *core.Record, fieldById map[string]*core.Record, entriesByField map[string][]*core.Record) func(id string) (interface{}, bool) {
+	return func(id string) (interface{}, bool) {
+		sibling, ok := fieldById[id]
+		if !ok || sibling.GetString("parent") != field.GetString("parent") {
+			return nil, false
+		}
+		if entries := entriesByField[id]; len(entries) > 0 {
+			return entries[0].Get("value"), true
+		}
+		return nil, true
+	}
+}
+
+// siblingContentValues is the sibling lookup for fieldHidden over
+// content from a file or request, keyed by field key. fieldById resolves
+// fields.
+func siblingContentValues(field *core.Record, fieldById map[string]*core.Record, content map[string]interface{}) func(id string) (interface{}, bool) {
+	return func(id string) (interface{}, bool) {
+		sibling, ok := fieldById[id]
+		if !ok || sibling.GetString("parent") != field.GetString("parent") {
+			return nil, false
+		}
+		return content[sibling.GetString("key")], true
+	}
+}
+
+// entryOwnerFilter matches the entries next to entry: same owner (page,
+// section or page type, where the collection has one) and parent entry.
+func entryOwnerFilter(entry *core.Record) dbx.HashExp {
+	filter := dbx.HashExp{"parent": entry.GetString("parent")}
+	for _, owner := range []string{"page", "section", "page_type"} {
+		if entry.Collection().Fields.GetByName(owner) != nil {
+			filter[owner] = entry.GetString(owner)
+		}
+	}
+	return filter
+}
+
+// exportFieldCondition rewrites config.condition.field from a record id
+// to the sibling's key for fields.yaml. config is modified in place.
+func exportFieldCondition(config interface{}, fieldIdToKey map[string]string) {
+	configMap, _ := config.(map[string]interface{})
+	condition, ok := configMap["condition"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if id, ok := condition["field"].(string); ok {
+		if key, found := fieldIdToKey[id]; found {
+			copied := make(map[string]interface{}, len(condition))
+			for k, v := range condition {
+				copied[k] = v
+			}
+			copied["field"] = key
+			configMap["condition"] = copied
+		}
+	}
+}
+
+// fieldKeyPath is the keys from the top down to field joined by "/" (as
+// "items/label"), with byId resolving its parents.
+func fieldKeyPath(field *core.Record, byId map[string]*core.Record) string {
+	path := field.GetString("key")
+	for parent, depth := byId[field.GetString("parent")], 0; parent != nil && depth < 16; parent, depth = byId[parent.GetString("parent")], depth+1 {
+		path = parent.GetString("key") + "/" + path
+	}
+	return path
+}
+
+// resolveFieldConditions points the conditions of imported fields at
+// their siblings' records. fields are all the fields of one block, page
+// type or site after the import wrote them. A condition may name its
+// sibling by key (as exports do), by record id (older exports), or by a
+// key the sibling was renamed from. One naming a field that is gone or
+// was moved under another parent is dropped with a warning, leaving the
+// field always shown.
+func resolveFieldConditions(app core.App, fields []*core.Record, renames map[string]string, warnings *[]ImportWarning, sourceFile, block string) error {
+	byId := recordsById(fields)
+	fieldPath := func(field *core.Record) string {
+		return fieldKeyPath(field, byId)
+	}
+
+	for _, field := range fields {
+		config, _ := normalizeValue(field.Get("config")).(map[string]interface{})
+		condition, _ := config["condition"].(map[string]interface{})
+		ref, _ := condition["field"].(string)
+		if ref == "" {
+			continue
+		}
+
+		parentPath := strings.TrimSuffix(fieldPath(field), field.GetString("key"))
+		var target *core.Record
+		elsewhere := false
+		for _, candidate := range fields {
+			matches := candidate.Id == ref || candidate.GetString("key") == ref
+			if renamed, ok := renames[parentPath+ref]; ok && candidate.GetString("key") == renamed {
+				matches = true
+			}
+			if !matches {
+				continue
+			}
+			if candidate.Id == field.Id {
+				continue
+			}
+			if candidate.GetString("parent") == field.GetString("parent") {
+				target = candidate
+				break
+			}
+			elsewhere = true
+		}
+
+		if target != nil {
+			if ref == target.Id {
+				continue
+			}
+			condition["field"] = target.Id
+		} else {
+			reason := fmt.Sprintf("there is no field %q next to it", ref)
+			if elsewhere {
+				reason = fmt.Sprintf("%q is no longer next to it (conditions can only name fields under the same parent)", ref)
+			}
+			if warnings != nil {
+				*warnings = append(*warnings, ImportWarning{
+					Kind:    "broken_condition",
+					File:    sourceFile,
+					Path:    strings.ReplaceAll(fieldPath(field), "/", ".") + ".config.condition.field",
+					Field:   field.GetString("key"),
+					Block:   block,
+					Message: fmt.Sprintf("%s: the condition on %q was removed because %s; the field is always shown.", sourceFile, field.GetString("key"), reason),
+				})
+			}
+			delete(config, "condition")
+		}
+		field.Set("config", config)
+		if err := app.Save(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"gopkg.in/yaml.v3"
+)
+
+func TestFieldConditions(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	fields := func(kindKey, migrate string) string {
+		return "" +
+			"- name: " + kindKey + "\n  type: select\n" + migrate +
+			"  config:\n    options:\n      - value: video\n        label: Video\n      - value: image\n        label: Image\n" +
+			"- name: video\n  type: url\n  config:\n    condition:\n      field: type\n      comparison: '='\n      value: video\n" +
+			"- name: caption\n  type: text\n  config:\n    condition:\n      field: subtitle\n      comparison: '='\n      value: x\n" +
+			"- name: items\n  type: repeater\n  subfields:\n" +
+			"    - name: style\n      type: text\n" +
+			"    - name: note\n      type: text\n      config:\n        condition:\n          field: style\n          comparison: '!='\n          value: plain\n"
+	}
+	project := map[string]string{
+		"blocks/media/config.yaml":       "name: Media\n",
+		"blocks/media/component.svelte":  "<section>{type}</section>\n",
+		"blocks/media/fields.yaml":       fields("type", ""),
+		"blocks/media/content.yaml":      "{}\n",
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "- name: subtitle\n  type: text\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml": "" +
+			"name: Home\npage_type: default\n" +
+			"sections:\n" +
+			"  - block: media\n" +
+			"    content:\n" +
+			"      type: image\n" +
+			"      video: https://example.com/clip.mp4\n" +
+			"      items:\n        - style: plain\n          note: Hidden\n        - style: boxed\n          note: Shown\n",
+	}
+	result, err := processImport(app, site, zipFiles(t, project), false)
+	if err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	warnings := []string{}
+	for _, warning := range result.Warnings {
+		if warning.Kind == "broken_condition" || warning.Rule == "condition" {
+			warnings = append(warnings, warning.Kind+":"+warning.Path)
+		}
+	}
+	slices.Sort(warnings)
+	want := []string{
+		"broken_condition:caption.config.condition.field",
+		"constraint_violation:sections[0].content.items[0].note",
+		"constraint_violation:sections[0].content.video",
+	}
+	if !slices.Equal(warnings, want) {
+		t.Fatalf("expected warnings %v, got %v", want, warnings)
+	}
+
+	if condition := readFieldCondition(findBlockField(t, app, site, "Media", "video")); condition == nil || condition.Field != findBlockField(t, app, site, "Media", "type").Id {
+		t.Fatalf("expected the video condition to point at the type field, got %+v", condition)
+	}
+	if condition := readFieldCondition(findBlockField(t, app, site, "Media", "caption")); condition != nil {
+		t.Fatalf("expected the broken caption condition to be dropped, got %+v", condition)
+	}
+
+	// The headless output and the export leave hidden values out.
+	pages, err := sitePagesByPath(app, site.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := newContentResolver(app, site, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := resolver.pageResponse(pages[""])
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := json.Marshal(response)
+	if strings.Contains(string(encoded), "clip.mp4") || strings.Contains(string(encoded), "Hidden") {
+		t.Fatalf("expected hidden values left out of the content, got %s", encoded)
+	}
+	if !strings.Contains(string(encoded), `"note":"Shown"`) {
+		t.Fatalf("expected the visible note in the content, got %s", encoded)
+	}
+
+	exported := exportSiteBytes(t, app, site, ExportOptions{})
+	pageYAML := readZipFile(t, exported, "pages/index.yaml")
+	if strings.Contains(pageYAML, "clip.mp4") || strings.Contains(pageYAML, "Hidden") || !strings.Contains(pageYAML, "Shown") {
+		t.Fatalf("expected hidden values left out of the export, got:\n%s", pageYAML)
+	}
+	fieldsYAML := readZipFile(t, exported, "blocks/media/fields.yaml")
+	var exportedFields []map[string]interface{}
+	if err := yaml.Unmarshal([]byte(fieldsYAML), &exportedFields); err != nil {
+		t.Fatal(err)
+	}
+	video, _ := exportedFields[1]["config"].(map[string]interface{})
+	if condition, _ := video["condition"].(map[string]interface{}); condition["field"] != "type" {
+		t.Fatalf("expected the condition exported by key, got:\n%s", fieldsYAML)
+	}
+	items, _ := exportedFields[3]["subfields"].([]interface{})
+	note, _ := items[1].(map[string]interface{})["config"].(map[string]interface{})
+	if condition, _ := note["condition"].(map[string]interface{}); condition["field"] != "style" {
+		t.Fatalf("expected the nested condition exported by key, got:\n%s", fieldsYAML)
+	}
+
+	// A rename carries conditions that still name the old key along.
+	project["blocks/media/fields.yaml"] = fields("kind", "  migrate:\n    renamed_from: type\n")
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("reimport project: %v", err)
+	}
+	if condition := readFieldCondition(findBlockField(t, app, site, "Media", "video")); condition == nil || condition.Field != findBlockField(t, app, site, "Media", "kind").Id {
+		t.Fatalf("expected the video condition to follow the rename, got %+v", condition)
+	}
+}
+
+func TestResolveFieldConditionsSkipsFieldsWithoutKey(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+	project := map[string]string{
+		"blocks/media/config.yaml":      "name: Media\n",
+		"blocks/media/component.svelte": "<section></section>\n",
+		"blocks/media/fields.yaml":      "- name: caption\n  type: text\n",
+		"blocks/media/content.yaml":     "{}\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	// The editor adds fields with an empty key, to be named afterwards.
+	caption := findBlockField(t, app, site, "Media", "caption")
+	unnamed := core.NewRecord(caption.Collection())
+	unnamed.Set("symbol", caption.GetString("symbol"))
+	unnamed.Set("type", "text")
+	unnamed.Set("index", 1)
+	if err := app.SaveNoValidate(unnamed); err != nil {
+		t.Fatal(err)
+	}
+	caption.Set("config", map[string]interface{}{"condition": map[string]interface{}{"field": "missing", "comparison": "=", "value": "x"}})
+	if err := app.Save(caption); err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings []ImportWarning
+	if err := resolveFieldConditions(app, []*core.Record{caption, unnamed}, map[string]string{}, &warnings, "blocks/media/fields.yaml", "Media"); err != nil {
+		t.Fatal(err)
+	}
+	if condition := readFieldCondition(findBlockField(t, app, site, "Media", "caption")); condition != nil {
+		t.Fatalf("expected the condition naming a missing field dropped, got %+v", condition)
+	}
+	if len(warnings) != 1 || warnings[0].Kind != "broken_condition" {
+		t.Fatalf("expected a broken_condition warning, got %+v", warnings)
+	}
+}
@@ -0,0 +1,624 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// entryFieldCollections maps each entry collection to the collection its
+// field relation points at.
+var entryFieldCollections = map[string]string{
+	"site_entries":              "site_fields",
+	"page_entries":              "page_type_fields",
+	"page_type_entries":         "page_type_fields",
+	"page_section_entries":      "site_symbol_fields",
+	"page_type_section_entries": "site_symbol_fields",
+	"site_symbol_entries":       "site_symbol_fields",
+	"library_symbol_entries":    "library_symbol_fields",
+}
+
+// fieldConstraints are the rules a field declares for its content, under
+// "validation" in its config. For a text field in fields.yaml:
+//
+//	config:
+//	  validation:
+//	    required: true
+//	    maxLength: 70
+//
+// Text-like fields (text, markdown, url, icon, date, select) take
+// required, minLength, maxLength and pattern; number and slider take
+// required, min and max; repeaters minItems and maxItems; images
+// required, minWidth, maxWidth, minHeight, maxHeight and maxSizeMB; other
+// fields required. Independently of these, url fields must hold a URL and
+// select fields one of their options.
+type fieldConstraints struct {
+	Required  bool
+	MinLength *int
+	MaxLength *int
+	Pattern   *regexp.Regexp
+	Min       *float64
+	Max       *float64
+	MinItems  *int
+	MaxItems  *int
+	MinWidth  *int
+	MaxWidth  *int
+	MinHeight *int
+	MaxHeight *int
+	MaxSizeMB *float64
+}
+
+// constraintRuleError is a rule under "validation" that can't be
+// enforced, as a count that isn't a whole number or a pattern that
+// doesn't compile. Rule is its key.
+type constraintRuleError struct {
+	Rule    string
+	Message string
+}
+
+func (e *constraintRuleError) Error() string {
+	return "validation." + e.Rule + " " + e.Message
+}
+
+// parseFieldConstraints reads the rules in a field's config. Each rule is
+// read on its own, so one that is invalid is left out and reported
+// without affecting the others. A minimum above its maximum leaves both
+// out.
+func parseFieldConstraints(config map[string]interface{}) (fieldConstraints, []*constraintRuleError) {
+	var constraints fieldConstraints
+	var problems []*constraintRuleError
+	fail := func(rule, format string, args ...interface{}) {
+		problems = append(problems, &constraintRuleError{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	rules, ok := config["validation"].(map[string]interface{})
+	if !ok {
+		if config["validation"] != nil {
+			problems = append(problems, &constraintRuleError{Message: "must be a map of rules"})
+		}
+		return constraints, problems
+	}
+
+	counts := map[string]**int{
+		"minLength": &constraints.MinLength,
+		"maxLength": &constraints.MaxLength,
+		"minItems":  &constraints.MinItems,
+		"maxItems":  &constraints.MaxItems,
+		"minWidth":  &constraints.MinWidth,
+		"maxWidth":  &constraints.MaxWidth,
+		"minHeight": &constraints.MinHeight,
+		"maxHeight": &constraints.MaxHeight,
+	}
+	for _, rule := range sortedKeys(rules) {
+		value := rules[rule]
+		switch rule {
+		case "required":
+			required, ok := value.(bool)
+			if !ok {
+				fail(rule, "must be true or false, got %v", value)
+				continue
+			}
+			constraints.Required = required
+		case "pattern":
+			source, ok := value.(string)
+			if !ok {
+				fail(rule, "must be a regular expression, got %v", value)
+				continue
+			}
+			pattern, err := regexp.Compile(source)
+			if err != nil {
+				fail(rule, "is not a valid regular expression: %v", err)
+				continue
+			}
+			constraints.Pattern = pattern
+		case "min", "max":
+			n, ok := conditionNumber(value)
+			if !ok {
+				fail(rule, "must be a number, got %v", value)
+				continue
+			}
+			if rule == "min" {
+				constraints.Min = &n
+			} else {
+				constraints.Max = &n
+			}
+		case "maxSizeMB":
+			n, ok := conditionNumber(value)
+			if !ok || n <= 0 {
+				fail(rule, "must be a number above 0, got %v", value)
+				continue
+			}
+			constraints.MaxSizeMB = &n
+		default:
+			target, known := counts[rule]
+			if !known {
+				fail(rule, "is not a rule")
+				continue
+			}
+			n, ok := conditionNumber(value)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				fail(rule, "must be a whole number of 0 or more, got %v", value)
+				continue
+			}
+			count := int(n)
+			*target = &count
+		}
+	}
+
+	for _, pair := range [][2]string{{"minLength", "maxLength"}, {"minItems", "maxItems"}, {"minWidth", "maxWidth"}, {"minHeight", "maxHeight"}} {
+		min, max := counts[pair[0]], counts[pair[1]]
+		if *min != nil && *max != nil && **min > **max {
+			fail(pair[1], "(%d) is below %s (%d)", **max, pair[0], **min)
+			*min, *max = nil, nil
+		}
+	}
+	if constraints.Min != nil && constraints.Max != nil && *constraints.Min > *constraints.Max {
+		fail("max", "(%g) is below min (%g)", *constraints.Max, *constraints.Min)
+		constraints.Min, constraints.Max = nil, nil
+	}
+	return constraints, problems
+}
+
+// fieldConstraintCache holds the parsed rules of each field by id, with
+// the rules they were parsed from.
+var fieldConstraintCache sync.Map
+
+type cachedFieldConstraints struct {
+	source string
+	rules  fieldConstraints
+}
+
+// fieldRules returns field's rules, leaving out invalid ones. They're
+// parsed once per field (and again when its rules change), so a pattern
+// isn't compiled for every value checked.
+func fieldRules(field *core.Record) fieldConstraints {
+	config, _ := normalizeValue(field.Get("config")).(map[string]interface{})
+	source, _ := json.Marshal(config["validation"])
+	if cached, ok := fieldConstraintCache.Load(field.Id); ok && cached.(cachedFieldConstraints).source == string(source) {
+		return cached.(cachedFieldConstraints).rules
+	}
+	rules, _ := parseFieldConstraints(config)
+	if field.Id != "" {
+		fieldConstraintCache.Store(field.Id, cachedFieldConstraints{source: string(source), rules: rules})
+	}
+	return rules
+}
+
+// reportConstraintRuleErrors warns about the rules of imported fields
+// that can't be enforced. fields are all the fields of one block, page
+// type or site after the import wrote them.
+func reportConstraintRuleErrors(fields []*core.Record, warnings *[]ImportWarning, sourceFile, block string) {
+	if warnings == nil {
+		return
+	}
+	byId := recordsById(fields)
+	for _, field := range fields {
+		config, _ := normalizeValue(field.Get("config")).(map[string]interface{})
+		_, problems := parseFieldConstraints(config)
+		for _, problem := range problems {
+			path := strings.ReplaceAll(fieldKeyPath(field, byId), "/", ".") + ".config.validation"
+			if problem.Rule != "" {
+				path += "." + problem.Rule
+			}
+			*warnings = append(*warnings, ImportWarning{
+				Kind:    "invalid_constraint",
+				File:    sourceFile,
+				Path:    path,
+				Field:   field.GetString("key"),
+				Block:   block,
+				Rule:    problem.Rule,
+				Message: fmt.Sprintf("%s: %s %s; the rule is not enforced.", sourceFile, path, problem.Message),
+			})
+		}
+	}
+}
+
+// constraintViolation is content breaking one of its field's rules. It
+// renders as a PocketBase validation error, with the content path as a
+// param.
+type constraintViolation struct {
+	Rule    string
+	Path    string
+	Message string
+}
+
+func (v *constraintViolation) Error() string {
+	return v.Message
+}
+
+func (v *constraintViolation) Code() string {
+	return "validation_field_" + v.Rule
+}
+
+func (v *constraintViolation) Params() map[string]any {
+	return map[string]any{"path": v.Path}
+}
+
+// checkFieldValue checks a single value (anything but a repeater or
+// group) against field's rules. app looks up uploads for image limits.
+func checkFieldValue(app core.App, field *core.Record, value interface{}) *constraintViolation {
+	config, _ := normalizeValue(field.Get("config")).(map[string]interface{})
+	rules := fieldRules(field)
+	value = normalizeValue(value)
+	violation := func(rule, format string, args ...interface{}) *constraintViolation {
+		return &constraintViolation{Rule: rule, Path: field.GetString("key"), Message: fmt.Sprintf(format, args...)}
+	}
+
+	switch field.GetString("type") {
+	case "text", "markdown", "url", "icon", "date", "select":
+		s := ""
+		if value != nil {
+			s = fmt.Sprint(value)
+		}
+		if s == "" {
+			if rules.Required {
+				return violation("required", "a value is required")
+			}
+			return nil
+		}
+		length := utf8.RuneCountInString(s)
+		if rules.MinLength != nil && length < *rules.MinLength {
+			return violation("min_length", "must be at least %d characters, got %d", *rules.MinLength, length)
+		}
+		if rules.MaxLength != nil && length > *rules.MaxLength {
+			return violation("max_length", "must be at most %d characters, got %d", *rules.MaxLength, length)
+		}
+		if rules.Pattern != nil && !rules.Pattern.MatchString(s) {
+			return violation("pattern", "must match %s", rules.Pattern)
+		}
+		if field.GetString("type") == "url" && !isContentURL(s) {
+			return violation("url", "%q is not a valid URL", s)
+		}
+		if field.GetString("type") == "select" {
+			options, _ := config["options"].([]interface{})
+			values := make([]string, 0, len(options))
+			for _, option := range options {
+				if option, ok := option.(map[string]interface{}); ok {
+					values = append(values, getString(option, "value"))
+				}
+			}
+			if len(values) > 0 && !slices.Contains(values, s) {
+				return violation("options", "%q is not one of the options (%s)", s, strings.Join(values, ", "))
+			}
+		}
+
+	case "number", "slider":
+		if value == nil || value == "" {
+			if rules.Required {
+				return violation("required", "a value is required")
+			}
+			return nil
+		}
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case int:
+			n = float64(v)
+		case int64:
+			n = float64(v)
+		case string:
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return violation("number", "%q is not a number", v)
+			}
+			n = parsed
+		default:
+			return violation("number", "must be a number")
+		}
+		if rules.Min != nil && n < *rules.Min {
+			return violation("min", "must be at least %g, got %g", *rules.Min, n)
+		}
+		if rules.Max != nil && n > *rules.Max {
+			return violation("max", "must be at most %g, got %g", *rules.Max, n)
+		}
+
+	case "image":
+		image, _ := value.(map[string]interface{})
+		uploadId := getString(image, "upload")
+		if getString(image, "url") == "" && uploadId == "" {
+			if rules.Required {
+				return violation("required", "an image is required")
+			}
+			return nil
+		}
+		if uploadId == "" {
+			return nil
+		}
+		upload, err := app.FindRecordById("site_uploads", uploadId)
+		if err != nil {
+			return nil
+		}
+		// Dimensions are only known for uploads whose metadata was read.
+		if width, height := upload.GetInt("width"), upload.GetInt("height"); width > 0 && height > 0 {
+			if rules.MinWidth != nil && width < *rules.MinWidth {
+				return violation("min_width", "image must be at least %dpx wide, got %dpx", *rules.MinWidth, width)
+			}
+			if rules.MaxWidth != nil && width > *rules.MaxWidth {
+				return violation("max_width", "image must be at most %dpx wide, got %dpx", *rules.MaxWidth, width)
+			}
+			if rules.MinHeight != nil && height < *rules.MinHeight {
+				return violation("min_height", "image must be at least %dpx high, got %dpx", *rules.MinHeight, height)
+			}
+			if rules.MaxHeight != nil && height > *rules.MaxHeight {
+				return violation("max_height", "image must be at most %dpx high, got %dpx", *rules.MaxHeight, height)
+			}
+		}
+		if size := upload.GetInt("size"); rules.MaxSizeMB != nil && size > 0 && float64(size) > *rules.MaxSizeMB*1024*1024 {
+			return violation("max_size", "image must be at most %g MB, got %.1f MB", *rules.MaxSizeMB, float64(size)/1024/1024)
+		}
+
+	case "link":
+		link, _ := value.(map[string]interface{})
+		if rules.Required && getString(link, "url") == "" && getString(link, "page") == "" {
+			return violation("required", "a link is required")
+		}
+
+	case "rich-text":
+		if rules.Required && strings.TrimSpace(richTextToMarkdown(value)) == "" {
+			return violation("required", "a value is required")
+		}
+
+	default:
+		if rules.Required && (value == nil || value == "") {
+			return violation("required", "a value is required")
+		}
+	}
+	return nil
+}
+
+// checkRepeaterItems checks a repeater's item count.
+func checkRepeaterItems(field *core.Record, count int) *constraintViolation {
+	rules := fieldRules(field)
+	if rules.MinItems != nil && count < *rules.MinItems {
+		return &constraintViolation{Rule: "min_items", Path: field.GetString("key"), Message: fmt.Sprintf("must have at least %d items, got %d", *rules.MinItems, count)}
+	}
+	if rules.MaxItems != nil && count > *rules.MaxItems {
+		return &constraintViolation{Rule: "max_items", Path: field.GetString("key"), Message: fmt.Sprintf("must have at most %d items, got %d", *rules.MaxItems, count)}
+	}
+	return nil
+}
+
+// isContentURL accepts what url fields hold in practice: absolute http(s),
+// mailto: and tel: URLs, and site-relative paths and anchors.
+func isContentURL(s string) bool {
+	if strings.ContainsAny(s, " \t\n") {
+		return false
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto", "tel":
+		return u.Opaque != ""
+	case "":
+		return u.Host == "" && (strings.HasPrefix(s, "/") || strings.HasPrefix(s, "#") || strings.HasPrefix(s, "?") || strings.HasPrefix(s, "."))
+	}
+	return false
+}
+
+// checkContent checks a value written by an import or content patch for
+// field, with its repeater items and group subfields, and passes each
+// problem to report with the path of the value under path. The value is
+// written as is: entries written this way skip the check on save (see
+// RegisterFieldConstraints), and it is up to the caller to reject the
+// value or keep it with a warning. siblings is the content value sits in,
+// for fields with a condition: a value for a field its condition hides is
+// reported, but not checked. With nil siblings conditions are not
+// evaluated. fieldsByParent and fieldById hold the fields of the block,
+// page type or site field belongs to.
+func checkContent(app core.App, field *core.Record, value interface{}, siblings map[string]interface{}, fieldsByParent map[string][]*core.Record, fieldById map[string]*core.Record, path string, report func(v *constraintViolation)) {
+	if siblings != nil && fieldHidden(field, siblingContentValues(field, fieldById, siblings)) {
+		if value != nil && value != "" {
+			report(&constraintViolation{
+				Rule:    "condition",
+				Path:    path,
+				Message: "has a value, but its condition hides the field; the value was kept, but is not rendered or exported",
+			})
+		}
+		return
+	}
+
+	switch field.GetString("type") {
+	case "repeater":
+		items, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		if v := checkRepeaterItems(field, len(items)); v != nil {
+			v.Path = path
+			report(v)
+		}
+		for i, item := range items {
+			checkSubfields(app, field, item, fieldsByParent, fieldById, fmt.Sprintf("%s[%d]", path, i), report)
+		}
+		return
+
+	case "group":
+		checkSubfields(app, field, value, fieldsByParent, fieldById, path, report)
+		return
+	}
+
+	if v := checkFieldValue(app, field, value); v != nil {
+		v.Path = path
+		report(v)
+	}
+}
+
+func checkSubfields(app core.App, field *core.Record, value interface{}, fieldsByParent map[string][]*core.Record, fieldById map[string]*core.Record, path string, report func(v *constraintViolation)) {
+	values, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, child := range fieldsByParent[field.Id] {
+		key := child.GetString("key")
+		if v, ok := values[key]; ok {
+			checkContent(app, child, v, values, fieldsByParent, fieldById, path+"."+key, report)
+		} else if child.GetString("type") != "repeater" && child.GetString("type") != "group" {
+			checkContent(app, child, nil, values, fieldsByParent, fieldById, path+"."+key, report)
+		}
+	}
+}
+
+// constraintWarnings returns a report func for checkContent that records
+// each problem as an import warning. The value is kept either way.
+func constraintWarnings(warnings *[]ImportWarning, sourceFile, block string) func(v *constraintViolation) {
+	return func(v *constraintViolation) {
+		if warnings == nil {
+			return
+		}
+		message := v.Message
+		if v.Rule != "required" && v.Rule != "condition" {
+			message += "; the value was kept, but must be fixed before it can be saved again"
+		}
+		*warnings = append(*warnings, ImportWarning{
+			Kind:    "constraint_violation",
+			File:    sourceFile,
+			Path:    v.Path,
+			Field:   lastTargetKey(v.Path),
+			Block:   block,
+			Rule:    v.Rule,
+			Message: fmt.Sprintf("%s: %s %s.", sourceFile, v.Path, message),
+		})
+	}
+}
+
+// RegisterFieldConstraints enforces field constraints on every entry
+// saved, whether through the API (the editor included) or from Go. A
+// violation fails the save with a validation error on "value" naming the
+// rule, with the content path as a param; through the API it renders as
+//
+//	{"value": {"code": "validation_field_max_length", "message": "...", "params": {"path": "items[2].label"}}}
+//
+// Required is enforced on updates only, since the editor creates entries
+// empty and fills them in. Saves made with keepContent skip the check.
+func RegisterFieldConstraints(pb *pocketbase.PocketBase) error {
+	collections := make([]string, 0, len(entryFieldCollections))
+	fieldCollections := make([]string, 0, len(entryFieldCollections))
+	for name, fields := range entryFieldCollections {
+		collections = append(collections, name)
+		if !slices.Contains(fieldCollections, fields) {
+			fieldCollections = append(fieldCollections, fields)
+		}
+	}
+
+	pb.OnRecordValidate(collections...).BindFunc(func(e *core.RecordEvent) error {
+		if keep, _ := e.Context.Value(keepContentKey{}).(bool); !keep {
+			if v := checkEntryConstraints(e.App, e.Record); v != nil {
+				return validation.Errors{"value": v}
+			}
+		}
+		return e.Next()
+	})
+	pb.OnRecordAfterDeleteSuccess(fieldCollections...).BindFunc(func(e *core.RecordEvent) error {
+		fieldConstraintCache.Delete(e.Record.Id)
+		return e.Next()
+	})
+	return nil
+}
+
+type keepContentKey struct{}
+
+// keepContent is the context for saving entries whose content is written
+// as is: imports, which check it themselves and report problems as
+// warnings (see checkContent), and copies and restores of stored content,
+// which may predate its field's rules.
+func keepContent() context.Context {
+	return context.WithValue(context.Background(), keepContentKey{}, true)
+}
+
+// checkEntryConstraints checks an entry about to be saved against its
+// field's rules.
+func checkEntryConstraints(app core.App, entry *core.Record) *constraintViolation {
+	entries := entry.Collection()
+	field, err := app.FindRecordById(entryFieldCollections[entries.Name], entry.GetString("field"))
+	if err != nil {
+		return nil
+	}
+
+	// A field hidden by its condition is neither shown nor rendered, so
+	// there is nothing to hold its content to.
+	if condition := readFieldCondition(field); condition != nil {
+		fieldById := map[string]*core.Record{}
+		if sibling, err := app.FindRecordById(field.Collection(), condition.Field); err == nil {
+			fieldById[sibling.Id] = sibling
+		}
+		filter := entryOwnerFilter(entry)
+		filter["field"] = condition.Field
+		entriesByField := map[string][]*core.Record{}
+		if siblingEntries, err := app.FindAllRecords(entries, filter); err == nil {
+			entriesByField[condition.Field] = siblingEntries
+		}
+		if fieldHidden(field, siblingEntryValues(field, fieldById, entriesByField)) {
+			return nil
+		}
+	}
+
+	var v *constraintViolation
+	switch field.GetString("type") {
+	case "group":
+		return nil
+	case "repeater":
+		// Each item is an entry; only adding one can exceed maxItems.
+		if !entry.IsNew() {
+			return nil
+		}
+		siblings := entryOwnerFilter(entry)
+		siblings["field"] = field.Id
+		existing, err := app.CountRecords(entries, siblings)
+		if err != nil {
+			return nil
+		}
+		if v = checkRepeaterItems(field, int(existing)+1); v != nil && v.Rule != "max_items" {
+			return nil
+		}
+	default:
+		v = checkFieldValue(app, field, entry.Get("value"))
+		if v != nil && v.Rule == "required" && entry.IsNew() {
+			return nil
+		}
+	}
+	if v != nil {
+		v.Path = entryContentPath(app, entry, field)
+	}
+	return v
+}
+
+// entryContentPath is the path of entry's value in its content, as
+// "items[2].label", from the entries above it.
+func entryContentPath(app core.App, entry, field *core.Record) string {
+	path := field.GetString("key")
+	fields := entryFieldCollections[entry.Collection().Name]
+	for parentId, depth := entry.GetString("parent"), 0; parentId != "" && depth < 16; depth++ {
+		parent, err := app.FindRecordById(entry.Collection(), parentId)
+		if err != nil {
+			break
+		}
+		parentField, err := app.FindRecordById(fields, parent.GetString("field"))
+		if err != nil {
+			break
+		}
+		if parentField.GetString("type") == "repeater" {
+			path = fmt.Sprintf("%s[%d].%s", parentField.GetString("key"), parent.GetInt("index"), path)
+		} else {
+			path = parentField.GetString("key") + "." + path
+		}
+		parentId = parent.GetString("parent")
+	}
+	return path
+}
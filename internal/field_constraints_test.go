@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+)
+
+func TestFieldConstraints(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterFieldConstraints(app); err != nil {
+		t.Fatal(err)
+	}
+	site := createImportTestSite(t, app)
+
+	project := map[string]string{
+		"blocks/hero/config.yaml":      "name: Hero\n",
+		"blocks/hero/component.svelte": "<section>{heading}</section>\n",
+		"blocks/hero/fields.yaml": "" +
+			"- name: heading\n  type: text\n  config:\n    validation:\n      maxLength: 10\n" +
+			"- name: align\n  type: select\n  config:\n    options:\n      - value: left\n        label: Left\n      - value: right\n        label: Right\n" +
+			"- name: website\n  type: url\n" +
+			"- name: items\n  type: repeater\n  config:\n    validation:\n      maxItems: 2\n  subfields:\n    - name: label\n      type: text\n      config:\n        validation:\n          required: true\n          maxLength: 5\n",
+		"blocks/hero/content.yaml":       "{}\n",
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "- name: subtitle\n  type: text\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml": "" +
+			"name: Home\npage_type: default\n" +
+			"sections:\n" +
+			"  - block: hero\n" +
+			"    content:\n" +
+			"      heading: A heading that is far too long\n" +
+			"      align: center\n" +
+			"      website: javascript:alert(1)\n" +
+			"      items:\n        - label: One\n        - label: Two\n        - label: Three\n",
+		"site/fields.yaml":  "- name: phone\n  type: text\n  config:\n    validation:\n      pattern: '^[0-9-]+$'\n",
+		"site/content.yaml": "phone: call us\n",
+	}
+	result, err := processImport(app, site, zipFiles(t, project), false)
+	if err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	violations := []string{}
+	for _, warning := range result.Warnings {
+		if warning.Kind == "constraint_violation" {
+			violations = append(violations, warning.Rule+":"+warning.Path)
+		}
+	}
+	slices.Sort(violations)
+	want := []string{
+		"max_items:sections[0].content.items",
+		"max_length:sections[0].content.heading",
+		"options:sections[0].content.align",
+		"pattern:phone",
+		"url:sections[0].content.website",
+	}
+	if !slices.Equal(violations, want) {
+		t.Fatalf("expected violations %v, got %v", want, violations)
+	}
+
+	pages, err := sitePagesByPath(app, site.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := newContentResolver(app, site, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := resolver.pageResponse(pages[""])
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := json.Marshal(response)
+	for _, kept := range []string{"far too long", `"center"`, "javascript", "call us", `{"label":"Three"}`} {
+		if !strings.Contains(string(encoded), kept) {
+			t.Fatalf("expected %s kept by the import, got %s", kept, encoded)
+		}
+	}
+	for _, warning := range result.Warnings {
+		if warning.Kind == "constraint_violation" && !strings.Contains(warning.Message, "the value was kept") {
+			t.Fatalf("expected the warning to say the value was kept, got %q", warning.Message)
+		}
+	}
+
+	// Entries saved afterwards are checked the same way, with the path of
+	// the value in its content.
+	label := findBlockField(t, app, site, "Hero", "label")
+	entry, err := app.FindFirstRecordByFilter("page_section_entries", "field = {:field} && parent.index = 1", dbx.Params{"field": label.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Set("value", "Too long")
+	v := checkEntryConstraints(app, entry)
+	if v == nil || v.Rule != "max_length" || v.Path != "items[1].label" {
+		t.Fatalf("expected max_length at items[1].label, got %+v", v)
+	}
+	entry.Set("value", "")
+	if v := checkEntryConstraints(app, entry); v == nil || v.Rule != "required" {
+		t.Fatalf("expected clearing a required label to be rejected, got %+v", v)
+	}
+	entry.Set("value", "Fine")
+	if v := checkEntryConstraints(app, entry); v != nil {
+		t.Fatalf("expected a valid label to pass, got %+v", v)
+	}
+
+	// The check runs on every save, not only on API requests.
+	entry.Set("value", "Too long")
+	err = app.Save(entry)
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected the save rejected with a validation error, got %v", err)
+	}
+	if v, ok := errs["value"].(*constraintViolation); !ok || v.Code() != "validation_field_max_length" {
+		t.Fatalf("expected max_length on value, got %v", errs)
+	}
+	if err := app.SaveWithContext(keepContent(), entry); err != nil {
+		t.Fatalf("expected a save keeping content as is to pass, got %v", err)
+	}
+}
+
+func TestFieldConstraintRulesAreValidated(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterFieldConstraints(app); err != nil {
+		t.Fatal(err)
+	}
+	site := createImportTestSite(t, app)
+	project := map[string]string{
+		"blocks/hero/config.yaml":      "name: Hero\n",
+		"blocks/hero/component.svelte": "<section>{heading}</section>\n",
+		"blocks/hero/fields.yaml": "" +
+			"- name: heading\n  type: text\n  config:\n    validation:\n      required: true\n      maxLength: 2.5\n      pattern: '('\n" +
+			"- name: code\n  type: text\n  config:\n    validation:\n      minLength: 5\n      maxLength: 3\n" +
+			"- name: items\n  type: repeater\n  config:\n    validation:\n      maxItems: -1\n  subfields:\n    - name: label\n      type: text\n",
+		"blocks/hero/content.yaml":       "{}\n",
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "- name: subtitle\n  type: text\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml": "" +
+			"name: Home\npage_type: default\n" +
+			"sections:\n" +
+			"  - block: hero\n" +
+			"    content:\n" +
+			"      heading: ''\n" +
+			"      code: abcd\n" +
+			"      items:\n        - label: One\n        - label: Two\n",
+	}
+	result, err := processImport(app, site, zipFiles(t, project), false)
+	if err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	warnings := []string{}
+	for _, warning := range result.Warnings {
+		if warning.Kind == "invalid_constraint" || warning.Kind == "constraint_violation" {
+			warnings = append(warnings, warning.Kind+":"+warning.Path)
+		}
+	}
+	slices.Sort(warnings)
+	want := []string{
+		"constraint_violation:sections[0].content.heading",
+		"invalid_constraint:code.config.validation.maxLength",
+		"invalid_constraint:heading.config.validation.maxLength",
+		"invalid_constraint:heading.config.validation.pattern",
+		"invalid_constraint:items.config.validation.maxItems",
+	}
+	if !slices.Equal(warnings, want) {
+		t.Fatalf("expected warnings %v, got %v", want, warnings)
+	}
+
+	// Invalid rules are left out; the others still apply.
+	rules := fieldRules(findBlockField(t, app, site, "Hero", "heading"))
+	if !rules.Required || rules.MaxLength != nil || rules.Pattern != nil {
+		t.Fatalf("expected only required kept, got %+v", rules)
+	}
+	if rules := fieldRules(findBlockField(t, app, site, "Hero", "code")); rules.MinLength != nil || rules.MaxLength != nil {
+		t.Fatalf("expected a min above its max left out, got %+v", rules)
+	}
+	if v := checkRepeaterItems(findBlockField(t, app, site, "Hero", "items"), 2); v != nil {
+		t.Fatalf("expected a negative maxItems left out, got %+v", v)
+	}
+}
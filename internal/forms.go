@@ -0,0 +1,437 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+// formRateWindow is the window a site_forms.rate_limit applies to. Limits
+// are expressed as "submissions per IP per window" so editors can reason
+// about them without knowing anything about token buckets.
+const formRateWindow = time.Minute
+
+// formRateLimiter tracks recent submission times per form+IP. It lives in
+// memory on purpose: the limit is there to blunt form spam against a single
+// instance, not to be an exact distributed quota, and losing the counters on
+// restart is harmless.
+type formRateLimiter struct {
+	mu   sync.Mutex
+	hits map[string][]time.Time
+}
+
+func newFormRateLimiter() *formRateLimiter {
+	return &formRateLimiter{hits: map[string][]time.Time{}}
+}
+
+// allow records a hit for key and reports whether it is within limit hits
+// per window. A limit of zero or less disables limiting for the key.
+func (l *formRateLimiter) allow(key string, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-formRateWindow)
+	recent := l.hits[key][:0]
+	for _, t := range l.hits[key] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= limit {
+		l.hits[key] = recent
+		return false
+	}
+	l.hits[key] = append(recent, now)
+	return true
+}
+
+// prune drops keys with no hits inside the window so the map doesn't grow
+// with every IP that ever submitted a form.
+func (l *formRateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-formRateWindow)
+	for key, hits := range l.hits {
+		if len(hits) == 0 || !hits[len(hits)-1].After(cutoff) {
+			delete(l.hits, key)
+		}
+	}
+}
+
+// RegisterFormsEndpoint lets static sites POST forms back to the CMS.
+// Submissions are accepted for any form declared in site_forms, stored in
+// form_submissions, optionally emailed to the form's notify address, and
+// can be downloaded as CSV by site collaborators.
+func RegisterFormsEndpoint(pb *pocketbase.PocketBase) error {
+	limiter := newFormRateLimiter()
+
+	pb.OnServe().BindFunc(func(serveEvent *core.ServeEvent) error {
+		serveEvent.Router.POST("/api/palacms/forms/{host}/{formKey}", func(e *core.RequestEvent) error {
+			return handleFormSubmission(pb, e, limiter)
+		})
+
+		serveEvent.Router.GET("/api/palacms/forms/{formId}/submissions.csv", func(e *core.RequestEvent) error {
+			return handleFormSubmissionsCSV(pb, e)
+		})
+
+		return serveEvent.Next()
+	})
+
+	terminated := make(chan struct{})
+	pb.OnTerminate().BindFunc(func(terminateEvent *core.TerminateEvent) error {
+		close(terminated)
+		return terminateEvent.Next()
+	})
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-terminated:
+				return
+			case now := <-ticker.C:
+				limiter.prune(now)
+			}
+		}
+	}()
+
+	pb.OnRecordAfterCreateSuccess("form_submissions").BindFunc(func(event *core.RecordEvent) error {
+		if event.App.Settings().SMTP.Enabled {
+			if err := sendFormNotification(event.App, event.Record); err != nil {
+				event.App.Logger().Error(err.Error())
+			}
+		}
+		return event.Next()
+	})
+
+	return nil
+}
+
+func handleFormSubmission(pb *pocketbase.PocketBase, e *core.RequestEvent, limiter *formRateLimiter) error {
+	host := e.Request.PathValue("host")
+	formKey := e.Request.PathValue("formKey")
+
+	site, err := pb.FindFirstRecordByData("sites", "host", host)
+	if err != nil {
+		return e.NotFoundError("Form not found", nil)
+	}
+
+	form, err := pb.FindFirstRecordByFilter("site_forms", "site = {:site} && key = {:key}", dbx.Params{
+		"site": site.Id,
+		"key":  formKey,
+	})
+	if err != nil {
+		return e.NotFoundError("Form not found", nil)
+	}
+
+	values, err := readFormValues(e)
+	if err != nil {
+		return e.BadRequestError("Invalid form data", err)
+	}
+
+	// Bots fill every input they see; humans never see the honeypot. Answer
+	// as if the submission succeeded so the bot has no signal to adapt to.
+	if honeypot := form.GetString("honeypot"); honeypot != "" && values[honeypot] != "" {
+		return formSubmissionResponse(e, form)
+	}
+
+	ip := e.RealIP()
+	if !limiter.allow(form.Id+"|"+ip, form.GetInt("rate_limit"), time.Now()) {
+		return e.TooManyRequestsError("Too many submissions, please try again later", nil)
+	}
+
+	data, missing := filterFormValues(values, formFieldList(form, "fields"), formFieldList(form, "required_fields"))
+	if len(missing) > 0 {
+		return e.BadRequestError("Missing required fields: "+strings.Join(missing, ", "), nil)
+	}
+
+	submissionsColl, err := pb.FindCollectionByNameOrId("form_submissions")
+	if err != nil {
+		return e.InternalServerError("Failed to find form_submissions collection", err)
+	}
+
+	submission := core.NewRecord(submissionsColl)
+	submission.Set("site", site.Id)
+	submission.Set("form", form.Id)
+	submission.Set("data", data)
+	submission.Set("ip", ip)
+	submission.Set("user_agent", e.Request.UserAgent())
+	if err := pb.Save(submission); err != nil {
+		return e.InternalServerError("Failed to save submission", err)
+	}
+
+	return formSubmissionResponse(e, form)
+}
+
+// readFormValues accepts the encodings a static page can realistically
+// produce: a plain <form> post (urlencoded or multipart) or a fetch() with
+// a flat JSON object. Repeated keys are joined with ", " so checkbox groups
+// survive as a single readable column.
+func readFormValues(e *core.RequestEvent) (map[string]string, error) {
+	values := map[string]string{}
+
+	contentType := e.Request.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/json") {
+		raw := map[string]any{}
+		if err := json.NewDecoder(e.Request.Body).Decode(&raw); err != nil {
+			return nil, err
+		}
+		for key, value := range raw {
+			switch v := value.(type) {
+			case nil:
+			case string:
+				values[key] = v
+			case []any:
+				parts := make([]string, 0, len(v))
+				for _, item := range v {
+					parts = append(parts, fmt.Sprint(item))
+				}
+				values[key] = strings.Join(parts, ", ")
+			default:
+				values[key] = fmt.Sprint(v)
+			}
+		}
+		return values, nil
+	}
+
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		if err := e.Request.ParseMultipartForm(1 << 20); err != nil {
+			return nil, err
+		}
+	} else if err := e.Request.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	for key, list := range e.Request.PostForm {
+		values[key] = strings.Join(list, ", ")
+	}
+	return values, nil
+}
+
+// filterFormValues keeps only the allowed fields (all fields when the form
+// declares none) and reports required fields that are missing or blank.
+func filterFormValues(values map[string]string, allowed, required []string) (map[string]string, []string) {
+	data := map[string]string{}
+	if len(allowed) == 0 {
+		for key, value := range values {
+			data[key] = value
+		}
+	} else {
+		for _, key := range allowed {
+			if value, ok := values[key]; ok {
+				data[key] = value
+			}
+		}
+	}
+
+	missing := []string{}
+	for _, key := range required {
+		if strings.TrimSpace(data[key]) == "" {
+			missing = append(missing, key)
+		}
+	}
+	return data, missing
+}
+
+func formFieldList(form *core.Record, name string) []string {
+	list := []string{}
+	if err := form.UnmarshalJSONField(name, &list); err != nil {
+		return nil
+	}
+	return list
+}
+
+// formSubmissionResponse redirects plain HTML form posts to the form's
+// thank-you URL when one is configured, and answers JSON otherwise so
+// fetch()-based forms can render their own confirmation.
+func formSubmissionResponse(e *core.RequestEvent, form *core.Record) error {
+	redirect := form.GetString("redirect")
+	if redirect != "" && !strings.Contains(e.Request.Header.Get("Accept"), "application/json") {
+		return e.Redirect(http.StatusSeeOther, redirect)
+	}
+	return e.JSON(200, map[string]interface{}{
+		"success": true,
+	})
+}
+
+func handleFormSubmissionsCSV(pb *pocketbase.PocketBase, e *core.RequestEvent) error {
+	apiKey := requestSiteAPIKey(e)
+	if e.Auth == nil && apiKey == "" && !IsLocalhost(e) {
+		return e.UnauthorizedError("Authentication required", nil)
+	}
+
+	form, err := pb.FindRecordById("site_forms", e.Request.PathValue("formId"))
+	if err != nil {
+		return e.NotFoundError("Form not found", err)
+	}
+
+	site, err := pb.FindRecordById("sites", form.GetString("site"))
+	if err != nil {
+		return e.NotFoundError("Site not found", err)
+	}
+
+	if apiKey != "" {
+		if err := authorizeSiteAPIKey(e, apiKey, site, ScopeContentRead); err != nil {
+			return err
+		}
+	} else if e.Auth != nil {
+		info, _ := e.RequestInfo()
+		canAccess, _ := e.App.CanAccessRecord(site, info, site.Collection().ViewRule)
+		if !canAccess {
+			return e.ForbiddenError("Access denied", nil)
+		}
+	}
+
+	submissions, err := pb.FindRecordsByFilter("form_submissions", "form = {:form}", "created", 0, 0, dbx.Params{"form": form.Id})
+	if err != nil {
+		return e.InternalServerError("Failed to load submissions", err)
+	}
+
+	rows := make([]map[string]string, len(submissions))
+	for i, submission := range submissions {
+		row := map[string]string{}
+		_ = submission.UnmarshalJSONField("data", &row)
+		rows[i] = row
+	}
+	columns := formCSVColumns(formFieldList(form, "fields"), rows)
+
+	// Same escaping as the site export download: the site name is user
+	// input and must not be able to break out of the header parameter.
+	filename := asciiHeaderFilename(sanitizeFilename(site.GetString("name"))+"-"+form.GetString("key")) + ".csv"
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	e.Response.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	e.Response.Header().Set("Content-Disposition", disposition)
+	e.Response.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(e.Response)
+	header := []string{"created"}
+	for _, column := range columns {
+		header = append(header, csvSafeCell(column))
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for i, submission := range submissions {
+		record := []string{submission.GetDateTime("created").String()}
+		for _, column := range columns {
+			record = append(record, csvSafeCell(rows[i][column]))
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvSafeCell keeps a submitted value from running as a formula when the
+// CSV is opened in Excel or Sheets: cells starting with =, +, -, @, tab or
+// carriage return get a leading apostrophe, which spreadsheets show as
+// text.
+func csvSafeCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// formCSVColumns lists the declared fields first, in declaration order,
+// followed by any other keys found in the data (from before the field list
+// was narrowed, or from forms with no list) in alphabetical order.
+func formCSVColumns(declared []string, rows []map[string]string) []string {
+	columns := append([]string{}, declared...)
+	seen := map[string]bool{}
+	for _, column := range columns {
+		seen[column] = true
+	}
+
+	extra := []string{}
+	for _, row := range rows {
+		for key := range row {
+			if !seen[key] {
+				seen[key] = true
+				extra = append(extra, key)
+			}
+		}
+	}
+	sort.Strings(extra)
+	return append(columns, extra...)
+}
+
+func sendFormNotification(app core.App, submission *core.Record) error {
+	form, err := app.FindRecordById("site_forms", submission.GetString("form"))
+	if err != nil {
+		return err
+	}
+
+	to := form.GetString("notify_email")
+	if to == "" {
+		return nil
+	}
+	recipients, err := mail.ParseAddressList(to)
+	if err != nil {
+		return fmt.Errorf("invalid notify_email on form %s: %w", form.Id, err)
+	}
+
+	site, err := app.FindRecordById("sites", form.GetString("site"))
+	if err != nil {
+		return err
+	}
+
+	data := map[string]string{}
+	_ = submission.UnmarshalJSONField("data", &data)
+
+	formName := form.GetString("name")
+	if formName == "" {
+		formName = form.GetString("key")
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "<p>New submission to %s on %s.</p>\n<table>\n", html.EscapeString(formName), html.EscapeString(site.GetString("name")))
+	for _, key := range formCSVColumns(formFieldList(form, "fields"), []map[string]string{data}) {
+		value, ok := data[key]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&body, "<tr><th align=\"left\">%s</th><td>%s</td></tr>\n", html.EscapeString(key), strings.ReplaceAll(html.EscapeString(value), "\n", "<br>"))
+	}
+	body.WriteString("</table>")
+
+	meta := app.Settings().Meta
+	message := &mailer.Message{
+		From: mail.Address{
+			Address: meta.SenderAddress,
+			Name:    meta.SenderName,
+		},
+		To:      make([]mail.Address, len(recipients)),
+		Subject: fmt.Sprintf("New %s submission on %s", formName, site.GetString("name")),
+		HTML:    body.String(),
+	}
+	for i, recipient := range recipients {
+		message.To[i] = *recipient
+	}
+
+	return app.NewMailClient().Send(message)
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestFormRateLimiter(t *testing.T) {
+	limiter := newFormRateLimiter()
+	now := time.Now()
+
+	if !limiter.allow("a", 2, now) || !limiter.allow("a", 2, now.Add(time.Second)) {
+		t.Fatal("expected the first two hits allowed")
+	}
+	if limiter.allow("a", 2, now.Add(2*time.Second)) {
+		t.Fatal("expected the third hit in the window refused")
+	}
+	if !limiter.allow("b", 2, now) {
+		t.Fatal("expected other keys counted separately")
+	}
+	if !limiter.allow("a", 0, now) {
+		t.Fatal("expected a zero limit to disable limiting")
+	}
+	if !limiter.allow("a", 2, now.Add(formRateWindow+2*time.Second)) {
+		t.Fatal("expected hits allowed again once the window passed")
+	}
+
+	limiter.prune(now.Add(3 * formRateWindow))
+	if len(limiter.hits) != 0 {
+		t.Fatalf("expected idle keys pruned, got %v", limiter.hits)
+	}
+}
+
+func TestFormSubmissionsAndCSV(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+	forms, err := app.FindCollectionByNameOrId("site_forms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := core.NewRecord(forms)
+	form.Set("site", site.Id)
+	form.Set("key", "contact")
+	form.Set("fields", []string{"email", "message"})
+	form.Set("required_fields", []string{"email"})
+	form.Set("honeypot", "website")
+	form.Set("rate_limit", 2)
+	if err := app.Save(form); err != nil {
+		t.Fatal(err)
+	}
+
+	limiter := newFormRateLimiter()
+	submit := func(values url.Values) (*core.RequestEvent, error) {
+		e := newTestRequestEvent(app, http.MethodPost, "/", strings.NewReader(values.Encode()))
+		e.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		e.Request.Header.Set("Accept", "application/json")
+		e.Request.SetPathValue("host", site.GetString("host"))
+		e.Request.SetPathValue("formKey", "contact")
+		return e, handleFormSubmission(app, e, limiter)
+	}
+	submissions := func() []*core.Record {
+		records, err := app.FindAllRecords("form_submissions", dbx.HashExp{"form": form.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+
+	// A filled honeypot looks accepted but stores nothing.
+	e, err := submit(url.Values{"email": {"bot@example.com"}, "website": {"spam"}})
+	if apiErrorStatus(t, err) != http.StatusOK || e.Response.(*httptest.ResponseRecorder).Code != http.StatusOK {
+		t.Fatalf("expected the honeypot submission answered as accepted, got %v", err)
+	}
+	if got := len(submissions()); got != 0 {
+		t.Fatalf("expected no submission stored for the honeypot, got %d", got)
+	}
+
+	if _, err := submit(url.Values{"message": {"no email"}}); apiErrorStatus(t, err) != http.StatusBadRequest {
+		t.Fatalf("expected a missing required field rejected, got %v", err)
+	}
+
+	if _, err := submit(url.Values{"email": {"a@example.com"}, "message": {"=HYPERLINK(\"http://evil\")"}, "extra": {"dropped"}}); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	stored := submissions()
+	if len(stored) != 1 {
+		t.Fatalf("expected one submission stored, got %d", len(stored))
+	}
+	data := map[string]string{}
+	if err := stored[0].UnmarshalJSONField("data", &data); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := data["extra"]; ok || data["email"] != "a@example.com" {
+		t.Fatalf("expected only the declared fields stored, got %v", data)
+	}
+
+	// The rejected submission above counted too, so the limit of two is
+	// now reached.
+	if _, err := submit(url.Values{"email": {"b@example.com"}}); apiErrorStatus(t, err) != http.StatusTooManyRequests {
+		t.Fatalf("expected the third submission rate limited, got %v", err)
+	}
+
+	e = newTestRequestEvent(app, http.MethodGet, "/", nil)
+	e.Request.RemoteAddr = "127.0.0.1:1234"
+	e.Request.SetPathValue("formId", form.Id)
+	if err := handleFormSubmissionsCSV(app, e); err != nil {
+		t.Fatalf("download CSV: %v", err)
+	}
+	rows, err := csv.NewReader(e.Response.(*httptest.ResponseRecorder).Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || strings.Join(rows[0], ",") != "created,email,message" {
+		t.Fatalf("expected a header and one row, got %v", rows)
+	}
+	if rows[1][2] != "'=HYPERLINK(\"http://evil\")" {
+		t.Fatalf("expected the formula escaped, got %q", rows[1][2])
+	}
+	if csvSafeCell("-5") != "'-5" || csvSafeCell("\tx") != "'\tx" || csvSafeCell("plain") != "plain" {
+		t.Fatal("expected every formula prefix escaped and plain text left alone")
+	}
+}
@@ -53,9 +53,11 @@ func generateUploads(pb *pocketbase.PocketBase, system *filesystem.System, site
 		return nil, err
 	}
 
+	// Trashed uploads are on their way out; publishing them would keep
+	// serving files the editor has already decided to delete.
 	uploads, err := pb.FindRecordsByFilter(
 		collection.Id,
-		"site = {:site}",
+		"site = {:site} && trashed = ''",
 		"",
 		0,
 		0,
@@ -68,7 +70,7 @@ func generateUploads(pb *pocketbase.PocketBase, system *filesystem.System, site
 	newFiles := make([]string, 0, len(uploads))
 	for _, upload := range uploads {
 		name := upload.GetString("file")
-		sourceKey := collection.Id + "/" + upload.Id + "/" + name
+		sourceKey := uploadStorageKey(upload)
 		destinationKey := "sites/" + site.GetString("host") + "/_uploads/" + name
 		if err := system.Copy(sourceKey, destinationKey); err != nil {
 			return nil, err
@@ -77,6 +79,12 @@ func generateUploads(pb *pocketbase.PocketBase, system *filesystem.System, site
 		newFiles = append(newFiles, destinationKey)
 	}
 
+	variantFiles, err := generateImageVariants(pb, system, site, uploads, imageSrcsetLadder())
+	if err != nil {
+		return nil, err
+	}
+	newFiles = append(newFiles, variantFiles...)
+
 	return newFiles, nil
 }
 
@@ -236,14 +244,20 @@ func RegisterGenerateEndpoint(pb *pocketbase.PocketBase) error {
 				return err
 			}
 
-			info, err := requestEvent.RequestInfo()
-			if err != nil {
-				return err
-			}
+			if key := requestSiteAPIKey(requestEvent); key != "" {
+				if err := authorizeSiteAPIKey(requestEvent, key, site, ScopePublish); err != nil {
+					return err
+				}
+			} else {
+				info, err := requestEvent.RequestInfo()
+				if err != nil {
+					return err
+				}
 
-			canAccess, err := requestEvent.App.CanAccessRecord(site, info, site.Collection().UpdateRule)
-			if !canAccess {
-				return requestEvent.ForbiddenError("", err)
+				canAccess, err := requestEvent.App.CanAccessRecord(site, info, site.Collection().UpdateRule)
+				if !canAccess {
+					return requestEvent.ForbiddenError("", err)
+				}
 			}
 
 			system, err := pb.NewFilesystem()
@@ -0,0 +1,1120 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A small GraphQL implementation for the per-site content schema: queries
+// only (no mutations or subscriptions), with variables, aliases, fragments,
+// @include/@skip, __typename and introspection (__schema and __type).
+// Types are declared in Go with resolvers; the schema's SDL is served for
+// tooling too.
+
+// gqlMaxDepth and gqlMaxFields bound a query: how deeply its selections
+// may nest, and how many fields and fragment spreads it may select, with
+// fragments counted wherever they're spread. Both leave room for the
+// introspection query tools send, and keep a single request from asking
+// for unbounded work.
+const (
+	gqlMaxDepth  = 16
+	gqlMaxFields = 1000
+)
+
+// gqlType is a named type of the schema. Type references elsewhere are
+// strings in SDL form ("String", "[Page!]!").
+type gqlType struct {
+	name        string
+	kind        string // SCALAR, OBJECT, INTERFACE, UNION, ENUM
+	description string
+	fields      []*gqlField
+	interfaces  []string
+	members     []string // UNION members
+	enumValues  []string // ENUM values
+	// resolveType names the object type of an INTERFACE or UNION value.
+	resolveType func(value interface{}) string
+}
+
+type gqlField struct {
+	name        string
+	typ         string
+	description string
+	args        []gqlArg
+	// resolve returns the field's value from its parent's; ctx is the
+	// value passed to execute. Without one, the parent is read as a map
+	// keyed by key (or name).
+	resolve func(ctx, source interface{}, args map[string]interface{}) (interface{}, error)
+	key     string
+}
+
+type gqlArg struct {
+	name         string
+	typ          string
+	defaultValue interface{}
+}
+
+type gqlSchema struct {
+	types map[string]*gqlType
+	order []string
+	query string
+	// meta are the fields every query type has, __schema and __type.
+	meta []*gqlField
+}
+
+// newGQLSchema returns a schema with the built-in scalars and the
+// introspection types, which are left out of the SDL.
+func newGQLSchema() *gqlSchema {
+	s := &gqlSchema{types: map[string]*gqlType{}, query: "Query"}
+	for _, name := range []string{"String", "Int", "Float", "Boolean", "ID"} {
+		s.types[name] = &gqlType{name: name, kind: "SCALAR"}
+	}
+	s.addIntrospection()
+	return s
+}
+
+func (s *gqlSchema) add(t *gqlType) *gqlType {
+	if _, exists := s.types[t.name]; !exists {
+		s.order = append(s.order, t.name)
+	}
+	s.types[t.name] = t
+	return t
+}
+
+func (t *gqlType) field(name string) *gqlField {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// possibleType reports whether object type name can stand for t.
+func (s *gqlSchema) possibleType(t *gqlType, name string) bool {
+	if t.name == name {
+		return true
+	}
+	switch t.kind {
+	case "UNION":
+		for _, member := range t.members {
+			if member == name {
+				return true
+			}
+		}
+	case "INTERFACE":
+		if object := s.types[name]; object != nil {
+			for _, i := range object.interfaces {
+				if i == t.name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// SDL renders the schema in the GraphQL schema definition language.
+func (s *gqlSchema) SDL() string {
+	var b strings.Builder
+	for _, name := range s.order {
+		t := s.types[name]
+		if t.description != "" {
+			fmt.Fprintf(&b, "%q\n", t.description)
+		}
+		switch t.kind {
+		case "SCALAR":
+			fmt.Fprintf(&b, "scalar %s\n\n", t.name)
+			continue
+		case "UNION":
+			fmt.Fprintf(&b, "union %s = %s\n\n", t.name, strings.Join(t.members, " | "))
+			continue
+		case "INTERFACE":
+			fmt.Fprintf(&b, "interface %s {\n", t.name)
+		default:
+			fmt.Fprintf(&b, "type %s", t.name)
+			if len(t.interfaces) > 0 {
+				fmt.Fprintf(&b, " implements %s", strings.Join(t.interfaces, " & "))
+			}
+			b.WriteString(" {\n")
+		}
+		for _, f := range t.fields {
+			if f.description != "" {
+				fmt.Fprintf(&b, "  %q\n", f.description)
+			}
+			b.WriteString("  " + f.name)
+			if len(f.args) > 0 {
+				args := make([]string, 0, len(f.args))
+				for _, a := range f.args {
+					arg := a.name + ": " + a.typ
+					if a.defaultValue != nil {
+						value, _ := json.Marshal(a.defaultValue)
+						arg += " = " + string(value)
+					}
+					args = append(args, arg)
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + f.typ + "\n")
+		}
+		b.WriteString("}\n\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// ---- Parsing ----
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	kind       string
+	name       string
+	variables  []gqlVariableDefinition
+	selections []*gqlSelection
+}
+
+type gqlVariableDefinition struct {
+	name         string
+	typ          string
+	defaultValue interface{}
+}
+
+type gqlFragment struct {
+	typeCondition string
+	selections    []*gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread (spread set) or an inline
+// fragment (inline set).
+type gqlSelection struct {
+	alias         string
+	name          string
+	args          map[string]interface{}
+	directives    []gqlDirective
+	selections    []*gqlSelection
+	spread        string
+	inline        bool
+	typeCondition string
+}
+
+type gqlDirective struct {
+	name string
+	args map[string]interface{}
+}
+
+// gqlVariable is a $variable reference inside a literal value.
+type gqlVariable string
+
+// gqlEnum is an enum literal (an unquoted name).
+type gqlEnum string
+
+type gqlToken struct {
+	kind  byte // 'n' name, 'i' int, 'f' float, 's' string, 'p' punctuator, 0 end
+	value string
+	pos   int
+}
+
+type gqlParser struct {
+	src string
+	pos int
+	tok gqlToken
+	// depth is how deeply the current selection set nests, and fields
+	// how many selections were read so far.
+	depth  int
+	fields int
+}
+
+type gqlSyntaxError struct {
+	message string
+	pos     int
+}
+
+func (e *gqlSyntaxError) Error() string {
+	return fmt.Sprintf("Syntax Error: %s (at offset %d)", e.message, e.pos)
+}
+
+// gqlLimitError is a query beyond gqlMaxDepth or gqlMaxFields.
+type gqlLimitError struct {
+	message string
+}
+
+func (e *gqlLimitError) Error() string {
+	return e.message
+}
+
+var (
+	errGQLTooDeep       = &gqlLimitError{fmt.Sprintf("Query is nested more than %d levels deep.", gqlMaxDepth)}
+	errGQLTooManyFields = &gqlLimitError{fmt.Sprintf("Query selects more than %d fields.", gqlMaxFields)}
+)
+
+// parseGQL parses a document, rejecting one that exceeds gqlMaxDepth or
+// gqlMaxFields as written or once its fragments are expanded.
+func parseGQL(src string) (doc *gqlDocument, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			case *gqlSyntaxError:
+				doc, err = nil, r
+			case *gqlLimitError:
+				doc, err = nil, r
+			default:
+				panic(r)
+			}
+		}
+	}()
+
+	p := &gqlParser{src: src}
+	p.next()
+	doc = &gqlDocument{fragments: map[string]*gqlFragment{}}
+	for p.tok.kind != 0 {
+		switch {
+		case p.peek('p', "{"):
+			doc.operations = append(doc.operations, &gqlOperation{kind: "query", selections: p.selectionSet()})
+		case p.peek('n', "fragment"):
+			p.next()
+			name := p.name()
+			p.expectName("on")
+			fragment := &gqlFragment{typeCondition: p.name()}
+			p.directives()
+			fragment.selections = p.selectionSet()
+			doc.fragments[name] = fragment
+		case p.peek('n', "query"), p.peek('n', "mutation"), p.peek('n', "subscription"):
+			op := &gqlOperation{kind: p.tok.value}
+			p.next()
+			if p.tok.kind == 'n' {
+				op.name = p.name()
+			}
+			if p.skip("(") {
+				for !p.skip(")") {
+					p.expect("$")
+					def := gqlVariableDefinition{name: p.name()}
+					p.expect(":")
+					def.typ = p.typeRef()
+					if p.skip("=") {
+						def.defaultValue = p.value(true)
+					}
+					op.variables = append(op.variables, def)
+				}
+			}
+			p.directives()
+			op.selections = p.selectionSet()
+			doc.operations = append(doc.operations, op)
+		default:
+			p.fail("unexpected " + p.describe())
+		}
+	}
+	if err := doc.checkLimits(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// checkLimits checks the document's operations against gqlMaxDepth and
+// gqlMaxFields with every fragment spread expanded, the way they execute.
+// A fragment that spreads itself, which would never finish expanding, is
+// an error too.
+func (doc *gqlDocument) checkLimits() error {
+	fields := 0
+	var walk func(selections []*gqlSelection, depth int, spreading map[string]bool) error
+	walk = func(selections []*gqlSelection, depth int, spreading map[string]bool) error {
+		if depth > gqlMaxDepth {
+			return errGQLTooDeep
+		}
+		for _, sel := range selections {
+			if fields++; fields > gqlMaxFields {
+				return errGQLTooManyFields
+			}
+			switch {
+			case sel.spread != "":
+				fragment := doc.fragments[sel.spread]
+				if fragment == nil {
+					continue // reported when executed
+				}
+				if spreading[sel.spread] {
+					return &gqlLimitError{fmt.Sprintf("Fragment \"%s\" spreads itself.", sel.spread)}
+				}
+				spreading[sel.spread] = true
+				err := walk(fragment.selections, depth, spreading)
+				delete(spreading, sel.spread)
+				if err != nil {
+					return err
+				}
+			case sel.inline:
+				if err := walk(sel.selections, depth, spreading); err != nil {
+					return err
+				}
+			case len(sel.selections) > 0:
+				if err := walk(sel.selections, depth+1, spreading); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, op := range doc.operations {
+		if err := walk(op.selections, 1, map[string]bool{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *gqlParser) fail(message string) {
+	panic(&gqlSyntaxError{message: message, pos: p.tok.pos})
+}
+
+func (p *gqlParser) describe() string {
+	if p.tok.kind == 0 {
+		return "end of document"
+	}
+	return strconv.Quote(p.tok.value)
+}
+
+func (p *gqlParser) peek(kind byte, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+func (p *gqlParser) skip(punct string) bool {
+	if p.peek('p', punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(punct string) {
+	if !p.skip(punct) {
+		p.fail("expected " + strconv.Quote(punct) + ", found " + p.describe())
+	}
+}
+
+func (p *gqlParser) expectName(name string) {
+	if !p.peek('n', name) {
+		p.fail("expected " + strconv.Quote(name) + ", found " + p.describe())
+	}
+	p.next()
+}
+
+func (p *gqlParser) name() string {
+	if p.tok.kind != 'n' {
+		p.fail("expected a name, found " + p.describe())
+	}
+	name := p.tok.value
+	p.next()
+	return name
+}
+
+func (p *gqlParser) typeRef() string {
+	var ref string
+	if p.skip("[") {
+		ref = "[" + p.typeRef() + "]"
+		p.expect("]")
+	} else {
+		ref = p.name()
+	}
+	if p.skip("!") {
+		ref += "!"
+	}
+	return ref
+}
+
+func (p *gqlParser) selectionSet() []*gqlSelection {
+	p.expect("{")
+	if p.depth++; p.depth > gqlMaxDepth {
+		panic(errGQLTooDeep)
+	}
+	defer func() { p.depth-- }()
+
+	var selections []*gqlSelection
+	for !p.skip("}") {
+		if p.fields++; p.fields > gqlMaxFields {
+			panic(errGQLTooManyFields)
+		}
+		if p.skip("...") {
+			sel := &gqlSelection{}
+			if p.peek('n', "on") {
+				p.next()
+				sel.inline = true
+				sel.typeCondition = p.name()
+			} else if p.tok.kind == 'n' {
+				sel.spread = p.name()
+			} else {
+				sel.inline = true
+			}
+			sel.directives = p.directives()
+			if sel.inline {
+				sel.selections = p.selectionSet()
+			}
+			selections = append(selections, sel)
+			continue
+		}
+
+		sel := &gqlSelection{name: p.name()}
+		if p.skip(":") {
+			sel.alias, sel.name = sel.name, p.name()
+		}
+		sel.args = p.arguments()
+		sel.directives = p.directives()
+		if p.peek('p', "{") {
+			sel.selections = p.selectionSet()
+		}
+		selections = append(selections, sel)
+	}
+	return selections
+}
+
+func (p *gqlParser) arguments() map[string]interface{} {
+	args := map[string]interface{}{}
+	if p.skip("(") {
+		for !p.skip(")") {
+			name := p.name()
+			p.expect(":")
+			args[name] = p.value(false)
+		}
+	}
+	return args
+}
+
+func (p *gqlParser) directives() []gqlDirective {
+	var directives []gqlDirective
+	for p.skip("@") {
+		directives = append(directives, gqlDirective{name: p.name(), args: p.arguments()})
+	}
+	return directives
+}
+
+func (p *gqlParser) value(constant bool) interface{} {
+	tok := p.tok
+	switch tok.kind {
+	case 'i':
+		p.next()
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			p.fail("invalid integer " + tok.value)
+		}
+		return n
+	case 'f':
+		p.next()
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			p.fail("invalid number " + tok.value)
+		}
+		return f
+	case 's':
+		p.next()
+		return tok.value
+	case 'n':
+		p.next()
+		switch tok.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return gqlEnum(tok.value)
+	}
+	switch {
+	case p.skip("$"):
+		if constant {
+			p.fail("unexpected variable in a constant value")
+		}
+		return gqlVariable(p.name())
+	case p.skip("["):
+		list := []interface{}{}
+		for !p.skip("]") {
+			list = append(list, p.value(constant))
+		}
+		return list
+	case p.skip("{"):
+		object := map[string]interface{}{}
+		for !p.skip("}") {
+			name := p.name()
+			p.expect(":")
+			object[name] = p.value(constant)
+		}
+		return object
+	}
+	p.fail("unexpected " + p.describe())
+	return nil
+}
+
+// next advances to the next token, skipping whitespace, commas and
+// comments.
+func (p *gqlParser) next() {
+	src := p.src
+	for p.pos < len(src) {
+		c := src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' || c == 0xEF && strings.HasPrefix(src[p.pos:], "\uFEFF") {
+			if c == 0xEF {
+				p.pos += 3
+			} else {
+				p.pos++
+			}
+			continue
+		}
+		if c == '#' {
+			for p.pos < len(src) && src[p.pos] != '\n' && src[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		break
+	}
+
+	start := p.pos
+	p.tok = gqlToken{pos: start}
+	if p.pos >= len(src) {
+		return
+	}
+	c := src[p.pos]
+	switch {
+	case strings.HasPrefix(src[p.pos:], "..."):
+		p.pos += 3
+		p.tok.kind, p.tok.value = 'p', "..."
+	case strings.ContainsRune("!$&()/:=@[]{|}", rune(c)):
+		p.pos++
+		p.tok.kind, p.tok.value = 'p', string(c)
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for p.pos < len(src) && isGQLNameByte(src[p.pos]) {
+			p.pos++
+		}
+		p.tok.kind, p.tok.value = 'n', src[start:p.pos]
+	case c == '-' || c >= '0' && c <= '9':
+		p.pos++
+		kind := byte('i')
+		for p.pos < len(src) {
+			d := src[p.pos]
+			if d >= '0' && d <= '9' {
+				p.pos++
+			} else if d == '.' || d == 'e' || d == 'E' || (d == '+' || d == '-') && (src[p.pos-1] == 'e' || src[p.pos-1] == 'E') {
+				kind = 'f'
+				p.pos++
+			} else {
+				break
+			}
+		}
+		p.tok.kind, p.tok.value = kind, src[start:p.pos]
+	case strings.HasPrefix(src[p.pos:], `"""`):
+		end := strings.Index(src[p.pos+3:], `"""`)
+		if end < 0 {
+			p.fail("unterminated string")
+		}
+		p.tok.kind, p.tok.value = 's', blockStringValue(src[p.pos+3:p.pos+3+end])
+		p.pos += end + 6
+	case c == '"':
+		p.pos++
+		var b strings.Builder
+		for {
+			if p.pos >= len(src) || src[p.pos] == '\n' {
+				p.fail("unterminated string")
+			}
+			d := src[p.pos]
+			if d == '"' {
+				p.pos++
+				break
+			}
+			if d != '\\' {
+				r, size := utf8.DecodeRuneInString(src[p.pos:])
+				b.WriteRune(r)
+				p.pos += size
+				continue
+			}
+			if p.pos+1 >= len(src) {
+				p.fail("unterminated string")
+			}
+			escape := src[p.pos+1]
+			p.pos += 2
+			switch escape {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'u':
+				if p.pos+4 > len(src) {
+					p.fail("invalid unicode escape")
+				}
+				n, err := strconv.ParseUint(src[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					p.fail("invalid unicode escape")
+				}
+				b.WriteRune(rune(n))
+				p.pos += 4
+			default:
+				b.WriteByte(escape)
+			}
+		}
+		p.tok.kind, p.tok.value = 's', b.String()
+	default:
+		p.fail("unexpected character " + strconv.QuoteRune(rune(c)))
+	}
+}
+
+func isGQLNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// blockStringValue removes the common indentation and blank first and
+// last lines of a """block string""".
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, `\"""`, `"""`), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		if len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ---- Execution ----
+
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+type gqlResponse struct {
+	Data   interface{} `json:"data"`
+	Errors []gqlError  `json:"errors,omitempty"`
+}
+
+// gqlObject is a response object; its keys stay in selection order.
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		b.Write(k)
+		b.WriteByte(':')
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+type gqlExecution struct {
+	schema    *gqlSchema
+	ctx       interface{}
+	doc       *gqlDocument
+	variables map[string]interface{}
+	errors    []gqlError
+}
+
+// execute runs query against the schema. ctx is handed to every resolver.
+func (s *gqlSchema) execute(query, operationName string, variables map[string]interface{}, ctx interface{}) gqlResponse {
+	doc, err := parseGQL(query)
+	if err != nil {
+		return gqlResponse{Errors: []gqlError{{Message: err.Error()}}}
+	}
+
+	var op *gqlOperation
+	for _, candidate := range doc.operations {
+		if operationName == "" || candidate.name == operationName {
+			if op != nil && operationName == "" {
+				return gqlResponse{Errors: []gqlError{{Message: "Must provide operation name if query contains multiple operations."}}}
+			}
+			op = candidate
+		}
+	}
+	if op == nil {
+		return gqlResponse{Errors: []gqlError{{Message: "Unknown operation named \"" + operationName + "\"."}}}
+	}
+	if op.kind != "query" {
+		return gqlResponse{Errors: []gqlError{{Message: "Only queries are supported; this schema has no " + op.kind + " type."}}}
+	}
+
+	ex := &gqlExecution{schema: s, ctx: ctx, doc: doc, variables: map[string]interface{}{}}
+	for _, def := range op.variables {
+		value, provided := variables[def.name]
+		if !provided {
+			value = def.defaultValue
+		}
+		if value == nil && strings.HasSuffix(def.typ, "!") {
+			return gqlResponse{Errors: []gqlError{{Message: fmt.Sprintf("Variable \"$%s\" of required type \"%s\" was not provided.", def.name, def.typ)}}}
+		}
+		ex.variables[def.name] = value
+	}
+
+	data := ex.selectObject(s.types[s.query], nil, op.selections, nil)
+	return gqlResponse{Data: data, Errors: ex.errors}
+}
+
+func (ex *gqlExecution) fail(path []interface{}, format string, args ...interface{}) {
+	ex.errors = append(ex.errors, gqlError{Message: fmt.Sprintf(format, args...), Path: append([]interface{}{}, path...)})
+}
+
+// collectFields flattens fragments and drops skipped selections, grouping
+// fields by response key in first-seen order.
+func (ex *gqlExecution) collectFields(t *gqlType, selections []*gqlSelection, keys *[]string, fields map[string][]*gqlSelection, visited map[string]bool) {
+	for _, sel := range selections {
+		if !ex.included(sel.directives) {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			if visited[sel.spread] {
+				continue
+			}
+			visited[sel.spread] = true
+			fragment := ex.doc.fragments[sel.spread]
+			if fragment == nil {
+				ex.fail(nil, "Unknown fragment \"%s\".", sel.spread)
+				continue
+			}
+			if ex.fragmentApplies(fragment.typeCondition, t) {
+				ex.collectFields(t, fragment.selections, keys, fields, visited)
+			}
+		case sel.inline:
+			if sel.typeCondition == "" || ex.fragmentApplies(sel.typeCondition, t) {
+				ex.collectFields(t, sel.selections, keys, fields, visited)
+			}
+		default:
+			key := sel.alias
+			if key == "" {
+				key = sel.name
+			}
+			if _, seen := fields[key]; !seen {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], sel)
+		}
+	}
+}
+
+func (ex *gqlExecution) fragmentApplies(condition string, t *gqlType) bool {
+	conditionType := ex.schema.types[condition]
+	if conditionType == nil {
+		ex.fail(nil, "Unknown type \"%s\".", condition)
+		return false
+	}
+	return ex.schema.possibleType(conditionType, t.name)
+}
+
+func (ex *gqlExecution) included(directives []gqlDirective) bool {
+	for _, d := range directives {
+		value, _ := ex.resolveValue(d.args["if"]).(bool)
+		if d.name == "skip" && value || d.name == "include" && !value {
+			return false
+		}
+	}
+	return true
+}
+
+func (ex *gqlExecution) selectObject(t *gqlType, source interface{}, selections []*gqlSelection, path []interface{}) *gqlObject {
+	var keys []string
+	fields := map[string][]*gqlSelection{}
+	ex.collectFields(t, selections, &keys, fields, map[string]bool{})
+
+	out := &gqlObject{values: map[string]interface{}{}}
+	for _, key := range keys {
+		sels := fields[key]
+		sel := sels[0]
+		fieldPath := append(path, key)
+		if sel.name == "__typename" {
+			out.set(key, t.name)
+			continue
+		}
+
+		field := t.field(sel.name)
+		if field == nil && t.name == ex.schema.query {
+			for _, meta := range ex.schema.meta {
+				if meta.name == sel.name {
+					field = meta
+				}
+			}
+		}
+		if field == nil {
+			ex.fail(fieldPath, "Cannot query field \"%s\" on type \"%s\".", sel.name, t.name)
+			continue
+		}
+		args, err := ex.coerceArgs(field, sel.args)
+		if err != nil {
+			ex.fail(fieldPath, "%s", err.Error())
+			out.set(key, nil)
+			continue
+		}
+
+		var value interface{}
+		if field.resolve != nil {
+			value, err = field.resolve(ex.ctx, source, args)
+			if err != nil {
+				ex.fail(fieldPath, "%s", err.Error())
+				out.set(key, nil)
+				continue
+			}
+		} else if m, ok := source.(map[string]interface{}); ok {
+			k := field.key
+			if k == "" {
+				k = field.name
+			}
+			value = m[k]
+		}
+
+		var subselections []*gqlSelection
+		for _, s := range sels {
+			subselections = append(subselections, s.selections...)
+		}
+		out.set(key, ex.complete(field.typ, value, subselections, fieldPath))
+	}
+	return out
+}
+
+func (ex *gqlExecution) complete(typ string, value interface{}, selections []*gqlSelection, path []interface{}) interface{} {
+	if strings.HasSuffix(typ, "!") {
+		result := ex.complete(strings.TrimSuffix(typ, "!"), value, selections, path)
+		if result == nil {
+			ex.fail(path, "Cannot return null for non-nullable field.")
+		}
+		return result
+	}
+	if value == nil {
+		return nil
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		itemType := typ[1 : len(typ)-1]
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice {
+			return []interface{}{ex.complete(itemType, value, selections, append(path, 0))}
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = ex.complete(itemType, rv.Index(i).Interface(), selections, append(path, i))
+		}
+		return items
+	}
+
+	t := ex.schema.types[typ]
+	if t == nil {
+		ex.fail(path, "Unknown type \"%s\".", typ)
+		return nil
+	}
+	switch t.kind {
+	case "SCALAR", "ENUM":
+		if len(selections) > 0 {
+			ex.fail(path, "Field of type \"%s\" must not have a selection.", typ)
+		}
+		return serializeGQLScalar(typ, value)
+	case "INTERFACE", "UNION":
+		name := ""
+		if t.resolveType != nil {
+			name = t.resolveType(value)
+		}
+		object := ex.schema.types[name]
+		if object == nil || !ex.schema.possibleType(t, name) {
+			ex.fail(path, "Abstract type \"%s\" could not resolve a type for the value.", typ)
+			return nil
+		}
+		t = object
+	}
+	if len(selections) == 0 {
+		ex.fail(path, "Field of type \"%s\" must have a selection of subfields.", typ)
+		return nil
+	}
+	return ex.selectObject(t, value, selections, path)
+}
+
+func serializeGQLScalar(typ string, value interface{}) interface{} {
+	switch typ {
+	case "String", "ID":
+		switch v := value.(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case bool, int, int64:
+			return fmt.Sprint(v)
+		}
+		return nil
+	case "Int":
+		switch v := value.(type) {
+		case int:
+			return v
+		case int64:
+			return v
+		case float64:
+			return int64(v)
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n
+			}
+		}
+		return nil
+	case "Float":
+		switch v := value.(type) {
+		case float64:
+			return v
+		case int:
+			return float64(v)
+		case int64:
+			return float64(v)
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+		return nil
+	case "Boolean":
+		switch v := value.(type) {
+		case bool:
+			return v
+		case string:
+			return v == "true"
+		}
+		return nil
+	}
+	return value
+}
+
+func (ex *gqlExecution) coerceArgs(field *gqlField, given map[string]interface{}) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for name := range given {
+		known := false
+		for _, a := range field.args {
+			known = known || a.name == name
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown argument \"%s\" on field \"%s\".", name, field.name)
+		}
+	}
+	for _, a := range field.args {
+		value, ok := given[a.name]
+		if ok {
+			value = ex.resolveValue(value)
+		}
+		if value == nil {
+			value = a.defaultValue
+		}
+		if value == nil && strings.HasSuffix(a.typ, "!") {
+			return nil, fmt.Errorf("Field \"%s\" argument \"%s\" of type \"%s\" is required.", field.name, a.name, a.typ)
+		}
+		if value != nil {
+			coerced := serializeGQLScalar(strings.TrimSuffix(a.typ, "!"), value)
+			if coerced == nil {
+				return nil, fmt.Errorf("Field \"%s\" argument \"%s\" expects type \"%s\".", field.name, a.name, a.typ)
+			}
+			value = coerced
+		}
+		args[a.name] = value
+	}
+	return args, nil
+}
+
+// resolveValue substitutes variables in a literal value.
+func (ex *gqlExecution) resolveValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case gqlVariable:
+		return ex.variables[string(v)]
+	case gqlEnum:
+		return string(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = ex.resolveValue(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = ex.resolveValue(item)
+		}
+		return out
+	}
+	return value
+}
+
+// gqlName turns a label into a GraphQL name: PascalCase for types, or
+// camelCase with lower set.
+func gqlName(label string, lower bool) string {
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range label {
+		if r < utf8.RuneSelf && isGQLNameByte(byte(r)) && r != '_' {
+			word.WriteRune(r)
+		} else {
+			flush()
+		}
+	}
+	flush()
+
+	var b strings.Builder
+	for i, w := range words {
+		if i == 0 && lower {
+			b.WriteString(strings.ToLower(w[:1]) + w[1:])
+		} else {
+			b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+		}
+	}
+	name := b.String()
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// gqlFieldName is a field key as a GraphQL field name. Keys are already
+// close to names; only characters GraphQL doesn't allow are replaced.
+func gqlFieldName(key string) string {
+	b := []byte(key)
+	for i := range b {
+		if !isGQLNameByte(b[i]) {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// Introspection: the __schema and __type fields of the query type and the
+// types they return, as the GraphQL spec defines them. Tools (GraphiQL,
+// code generators) read the schema through these.
+
+// gqlTypeRef is the value of a __Type: a type reference of schema in SDL
+// form. Wrapped references ("[Page!]!") are NON_NULL and LIST types whose
+// ofType is the reference inside.
+type gqlTypeRef struct {
+	schema *gqlSchema
+	ref    string
+}
+
+func (r gqlTypeRef) kind() string {
+	switch {
+	case strings.HasSuffix(r.ref, "!"):
+		return "NON_NULL"
+	case strings.HasPrefix(r.ref, "["):
+		return "LIST"
+	}
+	if t := r.named(); t != nil {
+		return t.kind
+	}
+	return ""
+}
+
+// named is the type r names, or nil for a wrapped reference.
+func (r gqlTypeRef) named() *gqlType {
+	if strings.HasSuffix(r.ref, "!") || strings.HasPrefix(r.ref, "[") {
+		return nil
+	}
+	return r.schema.types[r.ref]
+}
+
+func (r gqlTypeRef) ofType() interface{} {
+	switch r.kind() {
+	case "NON_NULL":
+		return gqlTypeRef{schema: r.schema, ref: strings.TrimSuffix(r.ref, "!")}
+	case "LIST":
+		return gqlTypeRef{schema: r.schema, ref: r.ref[1 : len(r.ref)-1]}
+	}
+	return nil
+}
+
+// gqlDirectiveDefinition is the value of a __Directive.
+type gqlDirectiveDefinition struct {
+	name        string
+	description string
+	locations   []string
+	args        []gqlArg
+}
+
+var gqlDirectives = []gqlDirectiveDefinition{
+	{
+		name:        "include",
+		description: "Directs the executor to include this field or fragment only when the `if` argument is true.",
+		locations:   []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		args:        []gqlArg{{name: "if", typ: "Boolean!"}},
+	},
+	{
+		name:        "skip",
+		description: "Directs the executor to skip this field or fragment when the `if` argument is true.",
+		locations:   []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		args:        []gqlArg{{name: "if", typ: "Boolean!"}},
+	},
+}
+
+// addIntrospection adds the introspection types and the query type's
+// meta fields. The types aren't added to the SDL order, as they're
+// implied by every schema.
+func (s *gqlSchema) addIntrospection() {
+	field := func(name, typ string, get func(source interface{}) interface{}, args ...gqlArg) *gqlField {
+		return &gqlField{name: name, typ: typ, args: args, resolve: func(ctx, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return get(source), nil
+		}}
+	}
+	ref := func(name string) interface{} {
+		return gqlTypeRef{schema: s, ref: name}
+	}
+	refs := func(names []string) []interface{} {
+		out := make([]interface{}, len(names))
+		for i, name := range names {
+			out[i] = ref(name)
+		}
+		return out
+	}
+	description := func(text string) interface{} {
+		if text == "" {
+			return nil
+		}
+		return text
+	}
+	inputValues := func(args []gqlArg) []interface{} {
+		out := make([]interface{}, len(args))
+		for i, arg := range args {
+			out[i] = arg
+		}
+		return out
+	}
+	none := func(interface{}) interface{} { return nil }
+	notDeprecated := func(interface{}) interface{} { return false }
+	includeDeprecated := gqlArg{name: "includeDeprecated", typ: "Boolean", defaultValue: false}
+
+	s.types["__Schema"] = &gqlType{name: "__Schema", kind: "OBJECT", fields: []*gqlField{
+		field("description", "String", none),
+		field("types", "[__Type!]!", func(interface{}) interface{} { return refs(sortedKeys(s.types)) }),
+		field("queryType", "__Type!", func(interface{}) interface{} { return ref(s.query) }),
+		field("mutationType", "__Type", none),
+		field("subscriptionType", "__Type", none),
+		field("directives", "[__Directive!]!", func(interface{}) interface{} {
+			out := make([]interface{}, len(gqlDirectives))
+			for i, directive := range gqlDirectives {
+				out[i] = directive
+			}
+			return out
+		}),
+	}}
+
+	// __Type's list fields are null for kinds they don't apply to.
+	typeField := func(name, typ string, kinds []string, get func(t *gqlType) interface{}, args ...gqlArg) *gqlField {
+		return field(name, typ, func(source interface{}) interface{} {
+			t := source.(gqlTypeRef).named()
+			if t == nil || !slices.Contains(kinds, t.kind) {
+				return nil
+			}
+			return get(t)
+		}, args...)
+	}
+	s.types["__Type"] = &gqlType{name: "__Type", kind: "OBJECT", fields: []*gqlField{
+		field("kind", "__TypeKind!", func(source interface{}) interface{} { return source.(gqlTypeRef).kind() }),
+		field("name", "String", func(source interface{}) interface{} {
+			if t := source.(gqlTypeRef).named(); t != nil {
+				return t.name
+			}
+			return nil
+		}),
+		field("description", "String", func(source interface{}) interface{} {
+			if t := source.(gqlTypeRef).named(); t != nil {
+				return description(t.description)
+			}
+			return nil
+		}),
+		field("specifiedByURL", "String", none),
+		typeField("fields", "[__Field!]", []string{"OBJECT", "INTERFACE"}, func(t *gqlType) interface{} {
+			out := make([]interface{}, len(t.fields))
+			for i, f := range t.fields {
+				out[i] = f
+			}
+			return out
+		}, includeDeprecated),
+		typeField("interfaces", "[__Type!]", []string{"OBJECT", "INTERFACE"}, func(t *gqlType) interface{} { return refs(t.interfaces) }),
+		typeField("possibleTypes", "[__Type!]", []string{"INTERFACE", "UNION"}, func(t *gqlType) interface{} {
+			if t.kind == "UNION" {
+				return refs(t.members)
+			}
+			var names []string
+			for _, name := range s.order {
+				if s.types[name].kind == "OBJECT" && s.possibleType(t, name) {
+					names = append(names, name)
+				}
+			}
+			return refs(names)
+		}),
+		typeField("enumValues", "[__EnumValue!]", []string{"ENUM"}, func(t *gqlType) interface{} {
+			out := make([]interface{}, len(t.enumValues))
+			for i, value := range t.enumValues {
+				out[i] = value
+			}
+			return out
+		}, includeDeprecated),
+		typeField("inputFields", "[__InputValue!]", []string{"INPUT_OBJECT"}, func(t *gqlType) interface{} { return nil }, includeDeprecated),
+		field("ofType", "__Type", func(source interface{}) interface{} { return source.(gqlTypeRef).ofType() }),
+		field("isOneOf", "Boolean", none),
+	}}
+
+	s.types["__Field"] = &gqlType{name: "__Field", kind: "OBJECT", fields: []*gqlField{
+		field("name", "String!", func(source interface{}) interface{} { return source.(*gqlField).name }),
+		field("description", "String", func(source interface{}) interface{} { return description(source.(*gqlField).description) }),
+		field("args", "[__InputValue!]!", func(source interface{}) interface{} { return inputValues(source.(*gqlField).args) }, includeDeprecated),
+		field("type", "__Type!", func(source interface{}) interface{} { return ref(source.(*gqlField).typ) }),
+		field("isDeprecated", "Boolean!", notDeprecated),
+		field("deprecationReason", "String", none),
+	}}
+
+	s.types["__InputValue"] = &gqlType{name: "__InputValue", kind: "OBJECT", fields: []*gqlField{
+		field("name", "String!", func(source interface{}) interface{} { return source.(gqlArg).name }),
+		field("description", "String", none),
+		field("type", "__Type!", func(source interface{}) interface{} { return ref(source.(gqlArg).typ) }),
+		field("defaultValue", "String", func(source interface{}) interface{} {
+			if source.(gqlArg).defaultValue == nil {
+				return nil
+			}
+			value, _ := json.Marshal(source.(gqlArg).defaultValue)
+			return string(value)
+		}),
+		field("isDeprecated", "Boolean!", notDeprecated),
+		field("deprecationReason", "String", none),
+	}}
+
+	s.types["__EnumValue"] = &gqlType{name: "__EnumValue", kind: "OBJECT", fields: []*gqlField{
+		field("name", "String!", func(source interface{}) interface{} { return source }),
+		field("description", "String", none),
+		field("isDeprecated", "Boolean!", notDeprecated),
+		field("deprecationReason", "String", none),
+	}}
+
+	s.types["__Directive"] = &gqlType{name: "__Directive", kind: "OBJECT", fields: []*gqlField{
+		field("name", "String!", func(source interface{}) interface{} { return source.(gqlDirectiveDefinition).name }),
+		field("description", "String", func(source interface{}) interface{} {
+			return description(source.(gqlDirectiveDefinition).description)
+		}),
+		field("locations", "[__DirectiveLocation!]!", func(source interface{}) interface{} { return source.(gqlDirectiveDefinition).locations }),
+		field("args", "[__InputValue!]!", func(source interface{}) interface{} { return inputValues(source.(gqlDirectiveDefinition).args) }, includeDeprecated),
+		field("isRepeatable", "Boolean!", notDeprecated),
+	}}
+
+	s.types["__TypeKind"] = &gqlType{name: "__TypeKind", kind: "ENUM", enumValues: []string{
+		"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL",
+	}}
+	s.types["__DirectiveLocation"] = &gqlType{name: "__DirectiveLocation", kind: "ENUM", enumValues: []string{
+		"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD", "INLINE_FRAGMENT", "VARIABLE_DEFINITION",
+		"SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INTERFACE", "UNION", "ENUM", "ENUM_VALUE", "INPUT_OBJECT", "INPUT_FIELD_DEFINITION",
+	}}
+
+	s.meta = []*gqlField{
+		field("__schema", "__Schema!", func(interface{}) interface{} { return s }),
+		{
+			name: "__type",
+			typ:  "__Type",
+			args: []gqlArg{{name: "name", typ: "String!"}},
+			resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) {
+				if s.types[args["name"].(string)] == nil {
+					return nil, nil
+				}
+				return ref(args["name"].(string)), nil
+			},
+		},
+	}
+}
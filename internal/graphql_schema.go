@@ -0,0 +1,515 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// graphQLPageSize is how many pages a pages query returns without first,
+// and graphQLMaxPageSize the most it returns with it.
+const (
+	graphQLPageSize    = 20
+	graphQLMaxPageSize = 100
+)
+
+// graphQLSchemaCollections are the records a site's schema is generated
+// from; a change to any of them rebuilds it.
+var graphQLSchemaCollections = []string{"site_fields", "page_types", "page_type_fields", "site_symbols", "site_symbol_fields"}
+
+// RegisterGraphQLEndpoint serves each site's content over GraphQL, for
+// frontends that want only the fields they render:
+//
+//	POST /api/palacms/graphql/{host}                {"query": ..., "variables": ..., "operationName": ...}
+//	GET  /api/palacms/graphql/{host}?query=...
+//	GET  /api/palacms/graphql/{host}/schema.graphql the schema, as SDL
+//
+// The schema is generated from the site's fields: every page type is an
+// object type implementing Page, every block a member of the Section
+// union, and repeaters are lists of their own item types. Values are the
+// ones the content API returns. Access is the content API's too: a
+// content:read key or a collaborator's session.
+func RegisterGraphQLEndpoint(pb *pocketbase.PocketBase) error {
+	schemas := newGraphQLSchemaCache()
+	schemas.bind(pb)
+
+	pb.OnServe().BindFunc(func(serveEvent *core.ServeEvent) error {
+		serveEvent.Router.GET("/api/palacms/graphql/{host}", func(e *core.RequestEvent) error {
+			return handleGraphQL(pb, schemas, e)
+		})
+		serveEvent.Router.POST("/api/palacms/graphql/{host}", func(e *core.RequestEvent) error {
+			return handleGraphQL(pb, schemas, e)
+		})
+
+		serveEvent.Router.GET("/api/palacms/graphql/{host}/schema.graphql", func(e *core.RequestEvent) error {
+			site, err := requireContentAccess(pb, e)
+			if err != nil {
+				return err
+			}
+			schema, _, err := schemas.get(pb, site)
+			if err != nil {
+				return e.InternalServerError("Failed to load site content", err)
+			}
+			return e.String(http.StatusOK, schema.SDL())
+		})
+
+		return serveEvent.Next()
+	})
+	return nil
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+func handleGraphQL(pb *pocketbase.PocketBase, schemas *graphQLSchemaCache, e *core.RequestEvent) error {
+	site, err := requireContentAccess(pb, e)
+	if err != nil {
+		return err
+	}
+
+	var request graphQLRequest
+	if e.Request.Method == http.MethodGet {
+		query := e.Request.URL.Query()
+		request.Query = query.Get("query")
+		request.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				return e.BadRequestError("Invalid variables", err)
+			}
+		}
+	} else if err := json.NewDecoder(e.Request.Body).Decode(&request); err != nil {
+		return e.BadRequestError("Invalid JSON body", err)
+	}
+	if request.Query == "" {
+		return e.BadRequestError("Missing query", nil)
+	}
+
+	schema, structure, err := schemas.get(pb, site)
+	if err != nil {
+		return e.InternalServerError("Failed to load site content", err)
+	}
+	resolver, err := newContentResolverWithStructure(pb, site, requestBaseURL(pb, e), structure)
+	if err != nil {
+		return e.InternalServerError("Failed to load site content", err)
+	}
+	return e.JSON(http.StatusOK, schema.execute(request.Query, request.OperationName, request.Variables, resolver))
+}
+
+// graphQLSchemaCache keeps each site's generated schema, and the
+// structure it was generated from, until a field, page type or block
+// changes. Those changes are rare next to queries, so any of them drops
+// every site's schema rather than working out whose it was.
+type graphQLSchemaCache struct {
+	mu         sync.Mutex
+	generation int
+	sites      map[string]*graphQLSiteSchema
+}
+
+type graphQLSiteSchema struct {
+	schema    *gqlSchema
+	structure *contentStructure
+}
+
+func newGraphQLSchemaCache() *graphQLSchemaCache {
+	return &graphQLSchemaCache{sites: map[string]*graphQLSiteSchema{}}
+}
+
+func (c *graphQLSchemaCache) bind(app core.App) {
+	reset := func(e *core.RecordEvent) error {
+		c.reset()
+		return e.Next()
+	}
+	app.OnRecordAfterCreateSuccess(graphQLSchemaCollections...).BindFunc(reset)
+	app.OnRecordAfterUpdateSuccess(graphQLSchemaCollections...).BindFunc(reset)
+	app.OnRecordAfterDeleteSuccess(graphQLSchemaCollections...).BindFunc(reset)
+}
+
+func (c *graphQLSchemaCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.sites = map[string]*graphQLSiteSchema{}
+}
+
+// get returns the site's schema and structure, loading them when there
+// are none. Ones loaded while the cache was reset aren't kept, since they
+// may predate the change.
+func (c *graphQLSchemaCache) get(app core.App, site *core.Record) (*gqlSchema, *contentStructure, error) {
+	c.mu.Lock()
+	cached, generation := c.sites[site.Id], c.generation
+	c.mu.Unlock()
+	if cached != nil {
+		return cached.schema, cached.structure, nil
+	}
+
+	structure, err := loadContentStructure(app, site)
+	if err != nil {
+		return nil, nil, err
+	}
+	cached = &graphQLSiteSchema{schema: buildSiteGraphQLSchema(structure), structure: structure}
+	c.mu.Lock()
+	if c.generation == generation {
+		c.sites[site.Id] = cached
+	}
+	c.mu.Unlock()
+	return cached.schema, cached.structure, nil
+}
+
+// siteSchemaBuilder generates a site's schema. fieldTypes maps field ids
+// to their type references, for page-field and site-field fields, which
+// take the type of the field they point at.
+type siteSchemaBuilder struct {
+	schema     *gqlSchema
+	fieldTypes map[string]string
+}
+
+func buildSiteGraphQLSchema(r *contentStructure) *gqlSchema {
+	b := &siteSchemaBuilder{schema: newGQLSchema(), fieldTypes: map[string]string{}}
+	s := b.schema
+
+	query := s.add(&gqlType{name: "Query", kind: "OBJECT"})
+	site := s.add(&gqlType{name: "Site", kind: "OBJECT"})
+	page := s.add(&gqlType{name: "Page", kind: "INTERFACE", description: "A page of the site; each page type is an object type implementing Page."})
+	section := s.add(&gqlType{name: "Section", kind: "UNION", description: "A section on a page; each block is a member type."})
+	connection := s.add(&gqlType{name: "PageConnection", kind: "OBJECT"})
+	s.add(&gqlType{name: "PageInfo", kind: "OBJECT", fields: []*gqlField{
+		{name: "hasNextPage", typ: "Boolean!"},
+		{name: "endCursor", typ: "String"},
+	}})
+	s.add(&gqlType{name: "Image", kind: "OBJECT", fields: []*gqlField{
+		{name: "url", typ: "String"},
+		{name: "alt", typ: "String"},
+		{name: "width", typ: "Int"},
+		{name: "height", typ: "Int"},
+		{name: "focalPoint", key: "focal_point", typ: "FocalPoint", description: "Where the image should stay in view when cropped, as fractions of its width and height from the top left."},
+	}})
+	s.add(&gqlType{name: "FocalPoint", kind: "OBJECT", fields: []*gqlField{
+		{name: "x", typ: "Float!"},
+		{name: "y", typ: "Float!"},
+	}})
+	s.add(&gqlType{name: "Link", kind: "OBJECT", fields: []*gqlField{
+		{name: "url", typ: "String"},
+		{name: "label", typ: "String"},
+		{name: "text", typ: "String"},
+	}})
+	s.add(&gqlType{name: "JSON", kind: "SCALAR", description: "Any JSON value, for fields without a more specific type."})
+
+	// Site fields come first and page type fields before blocks, so the
+	// fields page-field and site-field point at already have their types.
+	site.fields = []*gqlField{
+		{name: "id", typ: "ID!"},
+		{name: "name", typ: "String!"},
+		{name: "host", typ: "String!"},
+		{name: "content", typ: b.contentType("SiteContent", r.siteFields, "") + "!"},
+	}
+
+	pageTypeNames := map[string]string{}
+	for _, pageType := range sortedByName(r.pageTypes) {
+		typeName := b.uniqueName(gqlName(pageType.GetString("name"), false) + "Page")
+		object := s.add(&gqlType{name: typeName, kind: "OBJECT", interfaces: []string{"Page"}, description: "Pages of the " + pageType.GetString("name") + " page type."})
+		contentType := b.contentType(typeName+"Content", r.pageTypeFields[pageType.Id], "")
+		object.fields = append(graphQLPageFields(), &gqlField{
+			name: "content",
+			typ:  contentType + "!",
+			resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return ctx.(*contentResolver).resolvePageContent(source.(*core.Record)), nil
+			},
+		})
+		pageTypeNames[pageType.Id] = typeName
+	}
+	page.fields = graphQLPageFields()
+	page.resolveType = func(value interface{}) string {
+		record, _ := value.(*core.Record)
+		if record == nil {
+			return ""
+		}
+		return pageTypeNames[record.GetString("page_type")]
+	}
+
+	symbolIds := make([]string, 0, len(r.symbolNames))
+	for symbolId := range r.symbolNames {
+		symbolIds = append(symbolIds, symbolId)
+	}
+	sort.Slice(symbolIds, func(i, j int) bool {
+		if r.symbolNames[symbolIds[i]] != r.symbolNames[symbolIds[j]] {
+			return r.symbolNames[symbolIds[i]] < r.symbolNames[symbolIds[j]]
+		}
+		return symbolIds[i] < symbolIds[j]
+	})
+	sectionTypeNames := map[string]string{}
+	for _, symbolId := range symbolIds {
+		name := r.symbolNames[symbolId]
+		typeName := b.uniqueName(gqlName(name, false) + "Section")
+		object := s.add(&gqlType{name: typeName, kind: "OBJECT", description: "Sections using the " + name + " block."})
+		object.fields = []*gqlField{
+			graphQLSectionField("id", "ID!"),
+			graphQLSectionField("block", "String!"),
+			graphQLSectionField("zone", "String!"),
+			graphQLSectionField("content", b.contentType(typeName+"Content", r.symbolFields[symbolId], "")+"!"),
+		}
+		section.members = append(section.members, typeName)
+		sectionTypeNames[symbolId] = typeName
+	}
+	section.resolveType = func(value interface{}) string {
+		return sectionTypeNames[value.(contentSection).symbol]
+	}
+
+	sections := &gqlField{
+		name: "sections",
+		typ:  "[Section!]!",
+		resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return ctx.(*contentResolver).pageSections(source.(*core.Record))
+		},
+	}
+	page.fields = append(page.fields, sections)
+	for _, typeName := range pageTypeNames {
+		s.types[typeName].fields = append(s.types[typeName].fields, sections)
+	}
+
+	connection.fields = []*gqlField{
+		{name: "totalCount", typ: "Int!"},
+		{name: "nodes", typ: "[Page!]!"},
+		{name: "pageInfo", typ: "PageInfo!"},
+	}
+
+	query.fields = []*gqlField{
+		{
+			name:        "site",
+			typ:         "Site!",
+			description: "The site, with its site-wide fields.",
+			resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return ctx.(*contentResolver).siteMeta(), nil
+			},
+		},
+		{
+			name:        "page",
+			typ:         "Page",
+			description: "The page at path (\"/\" for the home page), if there is one.",
+			args:        []gqlArg{{name: "path", typ: "String!"}},
+			resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) {
+				if page := ctx.(*contentResolver).pageByPath[strings.Trim(args["path"].(string), "/")]; page != nil {
+					return page, nil
+				}
+				return nil, nil
+			},
+		},
+		{
+			name:        "pages",
+			typ:         "PageConnection!",
+			description: "Pages in path order, optionally of one page type. after takes a previous endCursor.",
+			args: []gqlArg{
+				{name: "pageType", typ: "String"},
+				{name: "first", typ: "Int", defaultValue: int64(graphQLPageSize)},
+				{name: "after", typ: "String"},
+			},
+			resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return graphQLPages(ctx.(*contentResolver), pageTypeNames, args), nil
+			},
+		},
+	}
+	return s
+}
+
+// graphQLPageFields are the fields every page has, read from the page
+// record.
+func graphQLPageFields() []*gqlField {
+	field := func(name, typ string, get func(r *contentResolver, page *core.Record) interface{}) *gqlField {
+		return &gqlField{name: name, typ: typ, resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return get(ctx.(*contentResolver), source.(*core.Record)), nil
+		}}
+	}
+	return []*gqlField{
+		field("id", "ID!", func(r *contentResolver, page *core.Record) interface{} { return page.Id }),
+		field("name", "String!", func(r *contentResolver, page *core.Record) interface{} { return page.GetString("name") }),
+		field("slug", "String!", func(r *contentResolver, page *core.Record) interface{} { return page.GetString("slug") }),
+		field("url", "String!", func(r *contentResolver, page *core.Record) interface{} { return "/" + r.pagePaths[page.Id] }),
+		field("pageType", "String!", func(r *contentResolver, page *core.Record) interface{} {
+			if pageType := r.pageTypes[page.GetString("page_type")]; pageType != nil {
+				return pageType.GetString("name")
+			}
+			return ""
+		}),
+		field("parent", "Page", func(r *contentResolver, page *core.Record) interface{} {
+			if parent := r.pageById[page.GetString("parent")]; parent != nil {
+				return parent
+			}
+			return nil
+		}),
+		field("created", "String!", func(r *contentResolver, page *core.Record) interface{} { return page.GetString("created") }),
+		field("updated", "String!", func(r *contentResolver, page *core.Record) interface{} { return page.GetString("updated") }),
+	}
+}
+
+func graphQLSectionField(name, typ string) *gqlField {
+	return &gqlField{name: name, typ: typ, resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return source.(contentSection).fields[name], nil
+	}}
+}
+
+// graphQLPages answers a pages query. Cursors are page URLs, which stay
+// valid as pages are added before them.
+func graphQLPages(r *contentResolver, pageTypeNames map[string]string, args map[string]interface{}) map[string]interface{} {
+	pageType, _ := args["pageType"].(string)
+	after, _ := args["after"].(string)
+	first := graphQLPageSize
+	switch n := args["first"].(type) {
+	case int64:
+		first = int(n)
+	case int:
+		first = n
+	}
+	first = max(0, min(first, graphQLMaxPageSize))
+
+	urls := make([]string, 0, len(r.pageByPath))
+	for pagePath, page := range r.pageByPath {
+		if pageType != "" {
+			record := r.pageTypes[page.GetString("page_type")]
+			if record == nil || record.GetString("name") != pageType && pageTypeNames[record.Id] != pageType {
+				continue
+			}
+		}
+		urls = append(urls, "/"+pagePath)
+	}
+	sort.Strings(urls)
+
+	start := sort.SearchStrings(urls, after)
+	if after != "" && start < len(urls) && urls[start] == after {
+		start++
+	}
+	end := min(start+first, len(urls))
+
+	nodes := make([]interface{}, 0, end-start)
+	for _, url := range urls[start:end] {
+		nodes = append(nodes, r.pageByPath[strings.TrimPrefix(url, "/")])
+	}
+	var endCursor interface{}
+	if end > start {
+		endCursor = urls[end-1]
+	}
+	return map[string]interface{}{
+		"totalCount": len(urls),
+		"nodes":      nodes,
+		"pageInfo": map[string]interface{}{
+			"hasNextPage": end < len(urls),
+			"endCursor":   endCursor,
+		},
+	}
+}
+
+// contentType adds an object type for the fields under parent and returns
+// its name.
+func (b *siteSchemaBuilder) contentType(name string, fields []*core.Record, parent string) string {
+	t := b.schema.add(&gqlType{name: b.uniqueName(name), kind: "OBJECT"})
+	used := map[string]bool{}
+	for _, field := range fields {
+		key := field.GetString("key")
+		if key == "" || field.GetString("parent") != parent || field.GetString("type") == "info" {
+			continue
+		}
+		fieldName := gqlFieldName(key)
+		for i := 2; used[fieldName]; i++ {
+			fieldName = fmt.Sprintf("%s%d", gqlFieldName(key), i)
+		}
+		used[fieldName] = true
+
+		f := &gqlField{name: fieldName, key: key, typ: b.fieldType(strings.TrimSuffix(t.name, "Content"), field, fields), description: field.GetString("label")}
+		if strings.Trim(f.typ, "[]!") == "Page" {
+			f.resolve = func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return graphQLPageReferences(ctx.(*contentResolver), source.(map[string]interface{})[key]), nil
+			}
+		}
+		t.fields = append(t.fields, f)
+	}
+	if len(t.fields) == 0 {
+		// GraphQL has no empty object types.
+		t.fields = append(t.fields, &gqlField{name: "_empty", typ: "Boolean", description: "Placeholder, as there are no fields yet."})
+	}
+	return t.name
+}
+
+func (b *siteSchemaBuilder) fieldType(owner string, field *core.Record, fields []*core.Record) string {
+	config, _ := normalizeValue(field.Get("config")).(map[string]interface{})
+	typ := "JSON"
+	switch field.GetString("type") {
+	case "text", "markdown", "rich-text", "url", "icon", "date", "select":
+		typ = "String"
+	case "number", "slider":
+		typ = "Float"
+	case "switch":
+		typ = "Boolean"
+	case "image":
+		typ = "Image"
+	case "link":
+		typ = "Link"
+	case "repeater":
+		typ = "[" + b.contentType(owner+gqlName(field.GetString("key"), false)+"Item", fields, field.Id) + "!]!"
+	case "group":
+		typ = b.contentType(owner+gqlName(field.GetString("key"), false), fields, field.Id) + "!"
+	case "page":
+		typ = "Page"
+	case "page-list":
+		typ = "[Page!]!"
+	case "page-field", "site-field":
+		// Nullable, as the field pointed at may be missing.
+		if target, ok := b.fieldTypes[getString(config, "field")]; ok {
+			typ = strings.TrimSuffix(target, "!")
+		}
+	}
+	b.fieldTypes[field.Id] = typ
+	return typ
+}
+
+// uniqueName returns name, numbered when another type already has it.
+func (b *siteSchemaBuilder) uniqueName(name string) string {
+	candidate := name
+	for i := 2; b.schema.types[candidate] != nil; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	return candidate
+}
+
+// graphQLPageReferences turns the page references in resolved content
+// (maps with a _meta.url) back into page records for the Page type.
+func graphQLPageReferences(r *contentResolver, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		meta, _ := v["_meta"].(map[string]interface{})
+		if page := r.pageByPath[strings.TrimPrefix(getString(meta, "url"), "/")]; page != nil {
+			return page
+		}
+	case []interface{}:
+		pages := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if page := graphQLPageReferences(r, item); page != nil {
+				pages = append(pages, page)
+			}
+		}
+		return pages
+	}
+	return nil
+}
+
+// sortedByName orders records by name, then id, so generated type names
+// are stable.
+func sortedByName(records map[string]*core.Record) []*core.Record {
+	sorted := make([]*core.Record, 0, len(records))
+	for _, record := range records {
+		sorted = append(sorted, record)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].GetString("name") != sorted[j].GetString("name") {
+			return sorted[i].GetString("name") < sorted[j].GetString("name")
+		}
+		return sorted[i].Id < sorted[j].Id
+	})
+	return sorted
+}
@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestGraphQLQueriesSiteContent(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+	schemas := newGraphQLSchemaCache()
+	schemas.bind(app)
+
+	project := map[string]string{
+		"blocks/hero/config.yaml":      "name: Hero\n",
+		"blocks/hero/component.svelte": "<section>{heading}</section>\n",
+		"blocks/hero/fields.yaml": "" +
+			"- name: heading\n  type: text\n" +
+			"- name: items\n  type: repeater\n  subfields:\n    - name: label\n      type: text\n",
+		"blocks/hero/content.yaml":       "{}\n",
+		"blocks/quote/config.yaml":       "name: Quote\n",
+		"blocks/quote/component.svelte":  "<blockquote>{text}</blockquote>\n",
+		"blocks/quote/fields.yaml":       "- name: text\n  type: text\n",
+		"blocks/quote/content.yaml":      "{}\n",
+		"page-types/default/config.yaml": "name: Default\nallowed_blocks:\n  - hero\n  - quote\n",
+		"page-types/default/fields.yaml": "- name: subtitle\n  type: text\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"page-types/post/config.yaml":    "name: Post\n",
+		"page-types/post/fields.yaml":    "- name: summary\n  type: text\n- name: rating\n  type: number\n",
+		"page-types/post/layout.yaml":    "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: default\nsections: []\n",
+		"pages/blog.yaml":                "name: Blog\npage_type: default\nsections: []\n",
+		"pages/blog/first.yaml":          "name: First\npage_type: post\ncontent:\n  summary: One\n  rating: 4\nsections: []\n",
+		"pages/blog/second.yaml":         "name: Second\npage_type: post\ncontent:\n  summary: Two\nsections: []\n",
+		"pages/blog/third.yaml":          "name: Third\npage_type: post\ncontent:\n  summary: Three\nsections: []\n",
+		"site/fields.yaml":               "- name: brand\n  type: text\n",
+		"site/content.yaml":              "brand: Acme\n",
+		"pages/about.yaml": "" +
+			"name: About\npage_type: default\n" +
+			"content:\n  subtitle: Who we are\n" +
+			"sections:\n" +
+			"  - block: hero\n" +
+			"    content:\n" +
+			"      heading: Hello\n" +
+			"      items:\n        - label: one\n        - label: two\n" +
+			"  - block: quote\n" +
+			"    content:\n" +
+			"      text: Quoted\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	query := func(source string, variables map[string]interface{}) string {
+		t.Helper()
+		schema, structure, err := schemas.get(app, site)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resolver, err := newContentResolverWithStructure(app, site, "https://cms.example.com", structure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := json.Marshal(schema.execute(source, "", variables, resolver))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(encoded)
+	}
+
+	got := query(`query About($path: String!) {
+		site { name content { brand } }
+		page(path: $path) {
+			__typename
+			url
+			... on DefaultPage { content { subtitle } }
+			sections {
+				__typename
+				... on HeroSection { content { heading items { label } } }
+				... on QuoteSection { block content { text } }
+			}
+		}
+	}`, map[string]interface{}{"path": "/about"})
+	want := `{"data":{"site":{"name":"Import Test","content":{"brand":"Acme"}},` +
+		`"page":{"__typename":"DefaultPage","url":"/about","content":{"subtitle":"Who we are"},` +
+		`"sections":[{"__typename":"HeroSection","content":{"heading":"Hello","items":[{"label":"one"},{"label":"two"}]}},` +
+		`{"__typename":"QuoteSection","block":"Quote","content":{"text":"Quoted"}}]}}}`
+	if got != want {
+		t.Fatalf("unexpected page query result:\n got %s\nwant %s", got, want)
+	}
+
+	got = query(`{ pages(pageType: "Post", first: 2) { totalCount nodes { url ...post } pageInfo { hasNextPage endCursor } } }
+		fragment post on PostPage { content { summary rating } }`, nil)
+	want = `{"data":{"pages":{"totalCount":3,"nodes":[` +
+		`{"url":"/blog/first","content":{"summary":"One","rating":4}},` +
+		`{"url":"/blog/second","content":{"summary":"Two","rating":null}}],` +
+		`"pageInfo":{"hasNextPage":true,"endCursor":"/blog/second"}}}}`
+	if got != want {
+		t.Fatalf("unexpected pages query result:\n got %s\nwant %s", got, want)
+	}
+	got = query(`{ pages(pageType: "Post", after: "/blog/second") { nodes { name } pageInfo { hasNextPage } } }`, nil)
+	if want := `{"data":{"pages":{"nodes":[{"name":"Third"}],"pageInfo":{"hasNextPage":false}}}}`; got != want {
+		t.Fatalf("unexpected second page of results: %s", got)
+	}
+
+	if got := query(`{ site { content { phone } } }`, nil); !strings.Contains(got, `Cannot query field \"phone\" on type \"SiteContent\"`) {
+		t.Fatalf("expected an error for an unknown field, got %s", got)
+	}
+
+	// The schema can be introspected, with wrapped types unwrapped through
+	// ofType.
+	got = query(`{ __type(name: "PostPage") { kind name interfaces { name } fields { name type { kind ofType { kind name } } } } }`, nil)
+	if !strings.Contains(got, `"kind":"OBJECT","name":"PostPage","interfaces":[{"name":"Page"}]`) ||
+		!strings.Contains(got, `{"name":"content","type":{"kind":"NON_NULL","ofType":{"kind":"OBJECT","name":"PostPageContent"}}}`) {
+		t.Fatalf("unexpected type introspection result: %s", got)
+	}
+	if got := query(`{ __type(name: "Missing") { name } }`, nil); got != `{"data":{"__type":null}}` {
+		t.Fatalf("expected no type for an unknown name, got %s", got)
+	}
+	got = query(`{ __schema { queryType { name } types { name } directives { name locations args { name defaultValue } } } }`, nil)
+	if !strings.Contains(got, `"queryType":{"name":"Query"}`) || !strings.Contains(got, `{"name":"HeroSection"}`) ||
+		!strings.Contains(got, `{"name":"__TypeKind"}`) || !strings.Contains(got, `{"name":"skip","locations":["FIELD","FRAGMENT_SPREAD","INLINE_FRAGMENT"],"args":[{"name":"if","defaultValue":null}]}`) {
+		t.Fatalf("unexpected schema introspection result: %s", got)
+	}
+	got = query(`{ __type(name: "Section") { kind possibleTypes { name } } page: __type(name: "Page") { possibleTypes { name } } }`, nil)
+	if want := `{"data":{"__type":{"kind":"UNION","possibleTypes":[{"name":"HeroSection"},{"name":"QuoteSection"}]},` +
+		`"page":{"possibleTypes":[{"name":"DefaultPage"},{"name":"PostPage"}]}}}`; got != want {
+		t.Fatalf("unexpected possible types:\n got %s\nwant %s", got, want)
+	}
+	if got := query(graphQLIntrospectionQuery, nil); strings.Contains(got, `"errors"`) {
+		t.Fatalf("expected the standard introspection query to succeed, got %s", got)
+	}
+
+	// Adding a site field rebuilds the schema.
+	siteFields, err := app.FindCollectionByNameOrId("site_fields")
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone := core.NewRecord(siteFields)
+	phone.Set("site", site.Id)
+	phone.Set("key", "phone")
+	phone.Set("label", "Phone")
+	phone.Set("type", "text")
+	phone.Set("index", 1)
+	if err := app.Save(phone); err != nil {
+		t.Fatal(err)
+	}
+	if got := query(`{ site { content { phone } } }`, nil); got != `{"data":{"site":{"content":{"phone":null}}}}` {
+		t.Fatalf("expected the new field in the schema, got %s", got)
+	}
+}
+
+// graphQLIntrospectionQuery is the query GraphiQL and most client tooling
+// send to read a schema.
+const graphQLIntrospectionQuery = `
+query IntrospectionQuery {
+	__schema {
+		queryType { name }
+		mutationType { name }
+		subscriptionType { name }
+		types { ...FullType }
+		directives { name description locations args { ...InputValue } }
+	}
+}
+fragment FullType on __Type {
+	kind name description
+	fields(includeDeprecated: true) { name description args { ...InputValue } type { ...TypeRef } isDeprecated deprecationReason }
+	inputFields { ...InputValue }
+	interfaces { ...TypeRef }
+	enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+	possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue { name description type { ...TypeRef } defaultValue }
+fragment TypeRef on __Type {
+	kind name
+	ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } } }
+}`
+
+func TestGraphQLQueryLimits(t *testing.T) {
+	s := newGQLSchema()
+	node := s.add(&gqlType{name: "Node", kind: "OBJECT"})
+	node.fields = []*gqlField{
+		{name: "name", typ: "String", resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) { return "node", nil }},
+		{name: "child", typ: "Node", resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) { return source, nil }},
+	}
+	s.add(&gqlType{name: "Query", kind: "OBJECT", fields: []*gqlField{
+		{name: "node", typ: "Node", resolve: func(ctx, source interface{}, args map[string]interface{}) (interface{}, error) { return true, nil }},
+	}})
+
+	nested := func(depth int) string {
+		return "{ node " + strings.Repeat("{ child ", depth-1) + "{ name }" + strings.Repeat(" }", depth-1) + " }"
+	}
+	errorOf := func(query string) string {
+		t.Helper()
+		response := s.execute(query, "", nil, nil)
+		if len(response.Errors) == 0 {
+			return ""
+		}
+		return response.Errors[0].Message
+	}
+
+	if got := errorOf(nested(gqlMaxDepth - 1)); got != "" {
+		t.Fatalf("expected a query within the depth limit to run, got %q", got)
+	}
+	if got := errorOf(nested(gqlMaxDepth)); got != errGQLTooDeep.Error() {
+		t.Fatalf("expected a query beyond the depth limit rejected, got %q", got)
+	}
+
+	if got := errorOf("{ node { " + strings.Repeat("name ", gqlMaxFields) + "} }"); got != errGQLTooManyFields.Error() {
+		t.Fatalf("expected a query with too many fields rejected, got %q", got)
+	}
+	// Fragments are counted as they expand: ten spreads of ten spreads of
+	// a fragment selecting twenty fields is 2000 fields.
+	expanding := "{ node { ...a } }" +
+		" fragment a on Node { " + strings.Repeat("...b ", 10) + "}" +
+		" fragment b on Node { " + strings.Repeat("...c ", 10) + "}" +
+		" fragment c on Node { " + strings.Repeat("name ", 20) + "}"
+	if got := errorOf(expanding); got != errGQLTooManyFields.Error() {
+		t.Fatalf("expected expanded fragments counted against the field limit, got %q", got)
+	}
+	deep := "{ node { ...a } } fragment a on Node { child { child { child { ...b } } } }" +
+		" fragment b on Node { " + strings.Repeat("child { ", gqlMaxDepth-4) + "name" + strings.Repeat(" }", gqlMaxDepth-4) + " }"
+	if got := errorOf(deep); got != errGQLTooDeep.Error() {
+		t.Fatalf("expected expanded fragments counted against the depth limit, got %q", got)
+	}
+	if got := errorOf("{ node { ...a } } fragment a on Node { child { ...b } } fragment b on Node { ...a }"); got != `Fragment "a" spreads itself.` {
+		t.Fatalf("expected a self-spreading fragment rejected, got %q", got)
+	}
+}
@@ -0,0 +1,647 @@
+package internal
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTML from other systems (a WordPress post body, say) converted into the
+// values Primo's text fields store: a TipTap document for rich-text, the
+// markdown source for markdown. Both walk the same parsed tree and handle
+// the elements the rich-text editor's StarterKit, Image and Youtube
+// extensions know; anything else is unwrapped to its text. rewriteURL, if
+// given, is applied to every href and src on the way through.
+
+func parseHTMLFragment(source string) []*html.Node {
+	nodes, err := html.ParseFragment(strings.NewReader(source), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return nil
+	}
+	return nodes
+}
+
+func htmlAttr(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func htmlChildren(n *html.Node) []*html.Node {
+	var children []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		children = append(children, c)
+	}
+	return children
+}
+
+// htmlText is the text content of a node, whitespace left alone.
+func htmlText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(htmlText(c))
+	}
+	return b.String()
+}
+
+var (
+	htmlWhitespace = regexp.MustCompile(`[ \t\r\n\f]+`)
+	htmlBlankLines = regexp.MustCompile(`\n{2,}`)
+)
+
+// htmlBlockAtoms start a new block; everything else flows inline.
+var htmlBlockAtoms = map[atom.Atom]bool{
+	atom.P: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Blockquote: true, atom.Pre: true, atom.Hr: true,
+	atom.Div: true, atom.Section: true, atom.Article: true, atom.Figure: true, atom.Figcaption: true,
+	atom.Header: true, atom.Footer: true, atom.Main: true, atom.Aside: true, atom.Nav: true,
+	atom.Table: true, atom.Thead: true, atom.Tbody: true, atom.Tr: true, atom.Td: true, atom.Th: true,
+	atom.Iframe: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+}
+
+var htmlSkippedAtoms = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+}
+
+func isHTMLBlock(n *html.Node) bool {
+	return n.Type == html.ElementNode && (htmlBlockAtoms[n.DataAtom] || (n.DataAtom == atom.Img))
+}
+
+func headingLevel(a atom.Atom) int {
+	switch a {
+	case atom.H1:
+		return 1
+	case atom.H2:
+		return 2
+	case atom.H3:
+		return 3
+	case atom.H4:
+		return 4
+	case atom.H5:
+		return 5
+	case atom.H6:
+		return 6
+	}
+	return 0
+}
+
+// youtubeEmbed returns the iframe's src when it is a YouTube embed.
+func youtubeEmbed(n *html.Node) string {
+	src := htmlAttr(n, "src")
+	if strings.Contains(src, "youtube.com/") || strings.Contains(src, "youtu.be/") || strings.Contains(src, "youtube-nocookie.com/") {
+		return src
+	}
+	return ""
+}
+
+func rewriteHTMLURL(rewriteURL func(string) string, u string) string {
+	if rewriteURL == nil || u == "" {
+		return u
+	}
+	return rewriteURL(u)
+}
+
+// htmlToRichText converts HTML into the TipTap document the rich-text
+// editor stores.
+func htmlToRichText(source string, rewriteURL func(string) string) map[string]interface{} {
+	c := richTextConverter{rewriteURL: rewriteURL}
+	content := c.blocks(parseHTMLFragment(source))
+	if len(content) == 0 {
+		content = []interface{}{map[string]interface{}{"type": "paragraph"}}
+	}
+	return map[string]interface{}{"type": "doc", "content": content}
+}
+
+type richTextConverter struct {
+	rewriteURL func(string) string
+}
+
+// blocks converts a run of sibling nodes, gathering loose inline content
+// into paragraphs.
+func (c richTextConverter) blocks(nodes []*html.Node) []interface{} {
+	var out, inline []interface{}
+	flush := func() {
+		out = append(out, c.paragraphs(inline)...)
+		inline = nil
+	}
+	for _, n := range nodes {
+		if n.Type == html.ElementNode && htmlSkippedAtoms[n.DataAtom] {
+			continue
+		}
+		if !isHTMLBlock(n) {
+			inline = append(inline, c.inline(n, nil)...)
+			continue
+		}
+		flush()
+		out = append(out, c.block(n)...)
+	}
+	flush()
+	return out
+}
+
+func (c richTextConverter) block(n *html.Node) []interface{} {
+	switch n.DataAtom {
+	case atom.P, atom.Dt, atom.Dd, atom.Figcaption:
+		return c.paragraphs(c.inlineChildren(n, nil))
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		content := trimInlineText(c.inlineChildren(n, nil))
+		if len(content) == 0 {
+			return nil
+		}
+		return []interface{}{map[string]interface{}{
+			"type":    "heading",
+			"attrs":   map[string]interface{}{"level": headingLevel(n.DataAtom)},
+			"content": content,
+		}}
+	case atom.Ul, atom.Ol:
+		var items []interface{}
+		for _, child := range htmlChildren(n) {
+			if child.Type != html.ElementNode || child.DataAtom != atom.Li {
+				continue
+			}
+			content := c.blocks(htmlChildren(child))
+			if len(content) == 0 {
+				content = []interface{}{map[string]interface{}{"type": "paragraph"}}
+			}
+			items = append(items, map[string]interface{}{"type": "listItem", "content": content})
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		list := map[string]interface{}{"type": "bulletList", "content": items}
+		if n.DataAtom == atom.Ol {
+			list["type"] = "orderedList"
+			start := 1
+			if s, err := strconv.Atoi(htmlAttr(n, "start")); err == nil {
+				start = s
+			}
+			list["attrs"] = map[string]interface{}{"start": start}
+		}
+		return []interface{}{list}
+	case atom.Blockquote:
+		content := c.blocks(htmlChildren(n))
+		if len(content) == 0 {
+			return nil
+		}
+		return []interface{}{map[string]interface{}{"type": "blockquote", "content": content}}
+	case atom.Pre:
+		code := strings.TrimRight(htmlText(n), "\n")
+		block := map[string]interface{}{"type": "codeBlock"}
+		if code != "" {
+			block["content"] = []interface{}{map[string]interface{}{"type": "text", "text": code}}
+		}
+		return []interface{}{block}
+	case atom.Hr:
+		return []interface{}{map[string]interface{}{"type": "horizontalRule"}}
+	case atom.Img:
+		return []interface{}{c.image(n)}
+	case atom.Iframe:
+		if src := youtubeEmbed(n); src != "" {
+			return []interface{}{map[string]interface{}{"type": "youtube", "attrs": map[string]interface{}{"src": src}}}
+		}
+		return nil
+	}
+	// Containers (div, figure, table, ...) are unwrapped.
+	return c.blocks(htmlChildren(n))
+}
+
+func (c richTextConverter) image(n *html.Node) map[string]interface{} {
+	attrs := map[string]interface{}{"src": rewriteHTMLURL(c.rewriteURL, htmlAttr(n, "src"))}
+	if alt := htmlAttr(n, "alt"); alt != "" {
+		attrs["alt"] = alt
+	}
+	if title := htmlAttr(n, "title"); title != "" {
+		attrs["title"] = title
+	}
+	return map[string]interface{}{"type": "image", "attrs": attrs}
+}
+
+// paragraphs wraps inline nodes in a paragraph. Images are block nodes in
+// the editor's schema, so an image inside a paragraph splits it.
+func (c richTextConverter) paragraphs(inline []interface{}) []interface{} {
+	var out, run []interface{}
+	flush := func() {
+		if content := trimInlineText(run); len(content) > 0 {
+			out = append(out, map[string]interface{}{"type": "paragraph", "content": content})
+		}
+		run = nil
+	}
+	for _, node := range inline {
+		if node.(map[string]interface{})["type"] == "image" {
+			flush()
+			out = append(out, node)
+			continue
+		}
+		run = append(run, node)
+	}
+	flush()
+	return out
+}
+
+func (c richTextConverter) inlineChildren(n *html.Node, marks []interface{}) []interface{} {
+	var out []interface{}
+	for _, child := range htmlChildren(n) {
+		out = append(out, c.inline(child, marks)...)
+	}
+	return out
+}
+
+func (c richTextConverter) inline(n *html.Node, marks []interface{}) []interface{} {
+	switch n.Type {
+	case html.TextNode:
+		text := htmlWhitespace.ReplaceAllString(n.Data, " ")
+		if text == "" {
+			return nil
+		}
+		node := map[string]interface{}{"type": "text", "text": text}
+		if len(marks) > 0 {
+			node["marks"] = append([]interface{}{}, marks...)
+		}
+		return []interface{}{node}
+	case html.ElementNode:
+	default:
+		return nil
+	}
+	if htmlSkippedAtoms[n.DataAtom] {
+		return nil
+	}
+
+	var mark map[string]interface{}
+	switch n.DataAtom {
+	case atom.Br:
+		return []interface{}{map[string]interface{}{"type": "hardBreak"}}
+	case atom.Img:
+		return []interface{}{c.image(n)}
+	case atom.Strong, atom.B:
+		mark = map[string]interface{}{"type": "bold"}
+	case atom.Em, atom.I:
+		mark = map[string]interface{}{"type": "italic"}
+	case atom.S, atom.Del, atom.Strike:
+		mark = map[string]interface{}{"type": "strike"}
+	case atom.U:
+		mark = map[string]interface{}{"type": "underline"}
+	case atom.Code:
+		mark = map[string]interface{}{"type": "code"}
+	case atom.Mark:
+		mark = map[string]interface{}{"type": "highlight"}
+	case atom.A:
+		if href := htmlAttr(n, "href"); href != "" {
+			attrs := map[string]interface{}{"href": rewriteHTMLURL(c.rewriteURL, href)}
+			if target := htmlAttr(n, "target"); target != "" {
+				attrs["target"] = target
+			}
+			mark = map[string]interface{}{"type": "link", "attrs": attrs}
+		}
+	}
+	if mark != nil {
+		marks = append(append([]interface{}{}, marks...), mark)
+	}
+	return c.inlineChildren(n, marks)
+}
+
+// trimInlineText drops whitespace at the edges of a paragraph (and around
+// hard breaks), which HTML ignores but TipTap would keep.
+func trimInlineText(nodes []interface{}) []interface{} {
+	var out []interface{}
+	for i, node := range nodes {
+		m := node.(map[string]interface{})
+		if m["type"] != "text" {
+			out = append(out, m)
+			continue
+		}
+		text := m["text"].(string)
+		if i == 0 || nodes[i-1].(map[string]interface{})["type"] == "hardBreak" {
+			text = strings.TrimLeft(text, " ")
+		}
+		if i == len(nodes)-1 || nodes[i+1].(map[string]interface{})["type"] == "hardBreak" {
+			text = strings.TrimRight(text, " ")
+		}
+		if text == "" {
+			continue
+		}
+		trimmed := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			trimmed[k] = v
+		}
+		trimmed["text"] = text
+		out = append(out, trimmed)
+	}
+	for len(out) > 0 && out[len(out)-1].(map[string]interface{})["type"] == "hardBreak" {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// htmlToMarkdown converts HTML into markdown source for a markdown field.
+func htmlToMarkdown(source string, rewriteURL func(string) string) string {
+	c := markdownConverter{rewriteURL: rewriteURL}
+	return strings.TrimSpace(strings.Join(c.blocks(parseHTMLFragment(source)), "\n\n")) + "\n"
+}
+
+type markdownConverter struct {
+	rewriteURL func(string) string
+}
+
+func (c markdownConverter) blocks(nodes []*html.Node) []string {
+	var out []string
+	var inline strings.Builder
+	flush := func() {
+		if text := cleanMarkdownInline(inline.String()); text != "" {
+			out = append(out, text)
+		}
+		inline.Reset()
+	}
+	for _, n := range nodes {
+		if n.Type == html.ElementNode && htmlSkippedAtoms[n.DataAtom] {
+			continue
+		}
+		if !isHTMLBlock(n) || n.DataAtom == atom.Img {
+			inline.WriteString(c.inline(n))
+			continue
+		}
+		flush()
+		if block := c.block(n); block != "" {
+			out = append(out, block)
+		}
+	}
+	flush()
+	return out
+}
+
+func (c markdownConverter) block(n *html.Node) string {
+	switch n.DataAtom {
+	case atom.P, atom.Dt, atom.Dd, atom.Figcaption:
+		return cleanMarkdownInline(c.inlineChildren(n))
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text := cleanMarkdownInline(c.inlineChildren(n))
+		if text == "" {
+			return ""
+		}
+		return strings.Repeat("#", headingLevel(n.DataAtom)) + " " + strings.ReplaceAll(text, "\n", " ")
+	case atom.Ul, atom.Ol:
+		var items []string
+		number := 1
+		if s, err := strconv.Atoi(htmlAttr(n, "start")); err == nil {
+			number = s
+		}
+		for _, child := range htmlChildren(n) {
+			if child.Type != html.ElementNode || child.DataAtom != atom.Li {
+				continue
+			}
+			marker := "- "
+			if n.DataAtom == atom.Ol {
+				marker = strconv.Itoa(number) + ". "
+				number++
+			}
+			body := strings.Join(c.blocks(htmlChildren(child)), "\n\n")
+			items = append(items, marker+indentMarkdown(body, strings.Repeat(" ", len(marker))))
+		}
+		return strings.Join(items, "\n")
+	case atom.Blockquote:
+		body := strings.Join(c.blocks(htmlChildren(n)), "\n\n")
+		if body == "" {
+			return ""
+		}
+		lines := strings.Split(body, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return strings.Join(lines, "\n")
+	case atom.Pre:
+		code := strings.TrimRight(htmlText(n), "\n")
+		return "```\n" + code + "\n```"
+	case atom.Hr:
+		return "---"
+	case atom.Iframe:
+		if src := youtubeEmbed(n); src != "" {
+			return "<" + src + ">"
+		}
+		return ""
+	}
+	return strings.Join(c.blocks(htmlChildren(n)), "\n\n")
+}
+
+func (c markdownConverter) inlineChildren(n *html.Node) string {
+	var b strings.Builder
+	for _, child := range htmlChildren(n) {
+		b.WriteString(c.inline(child))
+	}
+	return b.String()
+}
+
+func (c markdownConverter) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return htmlWhitespace.ReplaceAllString(n.Data, " ")
+	case html.ElementNode:
+	default:
+		return ""
+	}
+	if htmlSkippedAtoms[n.DataAtom] {
+		return ""
+	}
+
+	wrap := func(marker string) string {
+		inner := c.inlineChildren(n)
+		trimmed := strings.TrimSpace(inner)
+		if trimmed == "" {
+			return inner
+		}
+		// Markers can't sit next to the whitespace they enclose.
+		lead := inner[:len(inner)-len(strings.TrimLeft(inner, " "))]
+		trail := inner[len(strings.TrimRight(inner, " ")):]
+		return lead + marker + trimmed + marker + trail
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		return "\\\n"
+	case atom.Img:
+		src := rewriteHTMLURL(c.rewriteURL, htmlAttr(n, "src"))
+		return "![" + htmlAttr(n, "alt") + "](" + src + ")"
+	case atom.Strong, atom.B:
+		return wrap("**")
+	case atom.Em, atom.I:
+		return wrap("_")
+	case atom.S, atom.Del, atom.Strike:
+		return wrap("~~")
+	case atom.Code:
+		return "`" + htmlText(n) + "`"
+	case atom.A:
+		text := c.inlineChildren(n)
+		href := htmlAttr(n, "href")
+		if href == "" {
+			return text
+		}
+		return "[" + strings.TrimSpace(text) + "](" + rewriteHTMLURL(c.rewriteURL, href) + ")"
+	}
+	return c.inlineChildren(n)
+}
+
+// cleanMarkdownInline trims a paragraph's edges and the spaces HTML
+// whitespace collapsing leaves around line breaks.
+func cleanMarkdownInline(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.TrimSpace(strings.Join(lines, "\n"))
+	return strings.TrimSuffix(text, "\\")
+}
+
+func indentMarkdown(text, indent string) string {
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// htmlToPlainText flattens HTML to text, one line per block.
+func htmlToPlainText(source string) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(htmlWhitespace.ReplaceAllString(n.Data, " "))
+			return
+		case n.Type == html.ElementNode && htmlSkippedAtoms[n.DataAtom]:
+			return
+		case n.Type == html.ElementNode && n.DataAtom == atom.Br:
+			b.WriteString("\n")
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if isHTMLBlock(n) {
+			b.WriteString("\n")
+		}
+	}
+	for _, n := range parseHTMLFragment(source) {
+		walk(n)
+	}
+	return cleanMarkdownInline(htmlBlankLines.ReplaceAllString(b.String(), "\n"))
+}
+
+// richTextToHTML renders a stored TipTap document, the inverse of
+// htmlToRichText for the nodes and marks it produces.
+func richTextToHTML(value interface{}) string {
+	var b strings.Builder
+	renderRichTextNode(&b, value)
+	return b.String()
+}
+
+func renderRichTextNode(b *strings.Builder, value interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, child := range v {
+			renderRichTextNode(b, child)
+		}
+		return
+	case string:
+		// Older values hold markdown rather than a document.
+		b.WriteString(markdownToHTML(v))
+		return
+	case map[string]interface{}:
+	default:
+		return
+	}
+	node := value.(map[string]interface{})
+	attrs, _ := node["attrs"].(map[string]interface{})
+	attr := func(key string) string {
+		if s, ok := attrs[key].(string); ok {
+			return html.EscapeString(s)
+		}
+		if n, ok := attrs[key].(float64); ok {
+			return strconv.FormatFloat(n, 'f', -1, 64)
+		}
+		return ""
+	}
+	wrap := func(open, close string) {
+		b.WriteString(open)
+		renderRichTextNode(b, node["content"])
+		b.WriteString(close)
+	}
+
+	switch node["type"] {
+	case "listItem":
+		wrap("<li>", "</li>")
+	case "paragraph":
+		wrap("<p>", "</p>")
+	case "heading":
+		level := attr("level")
+		if level == "" {
+			level = "1"
+		}
+		wrap("<h"+level+">", "</h"+level+">")
+	case "bulletList":
+		wrap("<ul>", "</ul>")
+	case "orderedList":
+		if start := attr("start"); start != "" && start != "1" {
+			wrap(`<ol start="`+start+`">`, "</ol>")
+		} else {
+			wrap("<ol>", "</ol>")
+		}
+	case "blockquote":
+		wrap("<blockquote>", "</blockquote>")
+	case "codeBlock":
+		b.WriteString("<pre><code>")
+		for _, child := range asSlice(node["content"]) {
+			if text, ok := child.(map[string]interface{})["text"].(string); ok {
+				b.WriteString(html.EscapeString(text))
+			}
+		}
+		b.WriteString("</code></pre>")
+	case "horizontalRule":
+		b.WriteString("<hr>")
+	case "hardBreak":
+		b.WriteString("<br>")
+	case "image":
+		b.WriteString(`<img src="` + attr("src") + `"`)
+		if alt := attr("alt"); alt != "" {
+			b.WriteString(` alt="` + alt + `"`)
+		}
+		if title := attr("title"); title != "" {
+			b.WriteString(` title="` + title + `"`)
+		}
+		b.WriteString(">")
+	case "youtube":
+		b.WriteString(`<iframe src="` + attr("src") + `"></iframe>`)
+	case "text":
+		text, _ := node["text"].(string)
+		open, close := "", ""
+		for _, m := range asSlice(node["marks"]) {
+			mark, _ := m.(map[string]interface{})
+			markAttrs, _ := mark["attrs"].(map[string]interface{})
+			tag := map[string]string{"bold": "strong", "italic": "em", "strike": "s", "underline": "u", "code": "code", "highlight": "mark"}[getString(mark, "type")]
+			if getString(mark, "type") == "link" {
+				open += `<a href="` + html.EscapeString(getString(markAttrs, "href")) + `">`
+				close = "</a>" + close
+			} else if tag != "" {
+				open += "<" + tag + ">"
+				close = "</" + tag + ">" + close
+			}
+		}
+		b.WriteString(open + html.EscapeString(text) + close)
+	default:
+		renderRichTextNode(b, node["content"])
+	}
+}
+
+func asSlice(value interface{}) []interface{} {
+	items, _ := value.([]interface{})
+	return items
+}
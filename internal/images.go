@@ -0,0 +1,415 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// defaultImageSizes is the allowlist used when PRIMO_IMAGE_SIZES is unset.
+// It doubles as the srcset ladder when PRIMO_IMAGE_SRCSET is "true".
+const defaultImageSizes = "320,640,960,1280,1920"
+
+// imageVariantsDir is where resized uploads are cached, next to the
+// originals so generate's cleanup pass treats them like any other
+// published file and drops stale variants when a site is regenerated.
+const imageVariantsDir = "_uploads/_variants/"
+
+// imageFormats maps the accepted ?format= values to what the imaging
+// package can encode. WebP/AVIF are decode-only in the Go stack, so they
+// are deliberately absent.
+var imageFormats = map[string]imaging.Format{
+	"jpeg": imaging.JPEG,
+	"jpg":  imaging.JPEG,
+	"png":  imaging.PNG,
+	"gif":  imaging.GIF,
+}
+
+// maxImagePixels caps the width × height of a source image. Sizes are read
+// from the header before decoding, so a small file claiming a huge canvas
+// (a decompression bomb) is refused instead of allocated.
+var maxImagePixels = 50_000_000
+
+var imageFormatExtensions = map[imaging.Format]string{
+	imaging.JPEG: ".jpg",
+	imaging.PNG:  ".png",
+	imaging.GIF:  ".gif",
+}
+
+type imageVariantOptions struct {
+	Width  int
+	Height int
+	Fit    string
+	Format string
+}
+
+// imageSizeAllowlist returns the pixel sizes a width or height may take.
+// Only allowing a fixed set keeps a crawler (or an attacker) from filling
+// storage with one variant per integer.
+func imageSizeAllowlist() map[int]bool {
+	raw := getenvCompat("PRIMO_IMAGE_SIZES", "PALA_IMAGE_SIZES")
+	if strings.TrimSpace(raw) == "" {
+		raw = defaultImageSizes
+	}
+
+	allowed := map[int]bool{}
+	for _, size := range parseImageSizeList(raw) {
+		allowed[size] = true
+	}
+	return allowed
+}
+
+// imageSrcsetLadder returns the widths generate should pre-render for
+// uploads referenced by image fields. Empty (the default) disables
+// pre-rendering; "true" uses the whole allowlist; otherwise it's a comma
+// separated width list, filtered to the allowlist so pre-rendered files are
+// always ones ServeSites would have produced on demand.
+func imageSrcsetLadder() []int {
+	raw := strings.TrimSpace(getenvCompat("PRIMO_IMAGE_SRCSET", "PALA_IMAGE_SRCSET"))
+	if raw == "" || raw == "false" || raw == "0" {
+		return nil
+	}
+
+	allowed := imageSizeAllowlist()
+	if raw == "true" || raw == "1" {
+		ladder := make([]int, 0, len(allowed))
+		for size := range allowed {
+			ladder = append(ladder, size)
+		}
+		sort.Ints(ladder)
+		return ladder
+	}
+
+	ladder := []int{}
+	for _, size := range parseImageSizeList(raw) {
+		if allowed[size] {
+			ladder = append(ladder, size)
+		}
+	}
+	return ladder
+}
+
+func parseImageSizeList(raw string) []int {
+	sizes := []int{}
+	for _, part := range strings.Split(raw, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(part))
+		if err == nil && size > 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// parseImageVariantOptions reads w, h, fit and format from the query. The
+// bool result is false when none of them are present, i.e. the request is
+// for the original file.
+func parseImageVariantOptions(query url.Values, allowed map[int]bool) (imageVariantOptions, bool, error) {
+	opts := imageVariantOptions{
+		Fit:    strings.ToLower(query.Get("fit")),
+		Format: strings.ToLower(query.Get("format")),
+	}
+	if query.Get("w") == "" && query.Get("h") == "" && opts.Fit == "" && opts.Format == "" {
+		return opts, false, nil
+	}
+
+	for _, dim := range []struct {
+		name   string
+		target *int
+	}{{"w", &opts.Width}, {"h", &opts.Height}} {
+		raw := query.Get(dim.name)
+		if raw == "" {
+			continue
+		}
+		size, err := strconv.Atoi(raw)
+		if err != nil || size <= 0 {
+			return opts, true, fmt.Errorf("invalid %s %q", dim.name, raw)
+		}
+		if !allowed[size] {
+			return opts, true, fmt.Errorf("%s=%d is not an allowed image size", dim.name, size)
+		}
+		*dim.target = size
+	}
+
+	switch opts.Fit {
+	case "":
+		opts.Fit = "cover"
+	case "cover", "contain", "fill":
+	default:
+		return opts, true, fmt.Errorf("invalid fit %q (expected cover, contain or fill)", opts.Fit)
+	}
+
+	if opts.Format != "" {
+		if _, ok := imageFormats[opts.Format]; !ok {
+			return opts, true, fmt.Errorf("unsupported format %q", opts.Format)
+		}
+	}
+
+	return opts, true, nil
+}
+
+// imageVariantKey is the storage key a variant of uploadName is cached
+// under. Every option is part of the key so two requests only share a file
+// when they would have rendered identical bytes, and the extension always
+// matches the encoded format so ServeContent sniffs the right Content-Type.
+func imageVariantKey(host, uploadName string, opts imageVariantOptions) string {
+	format := imageVariantFormat(uploadName, opts)
+	return fmt.Sprintf("%s%dx%d-%s%s", imageVariantDir(host, uploadName), opts.Width, opts.Height, opts.Fit, imageFormatExtensions[format])
+}
+
+// imageVariantDir holds every cached variant of uploadName. It's named
+// after the whole filename, extension included, so uploads that only
+// share a stem (hero.png and hero.jpg) never share variants.
+func imageVariantDir(host, uploadName string) string {
+	return fmt.Sprintf("sites/%s/%s%s/", host, imageVariantsDir, uploadName)
+}
+
+// isImageVariantOf reports whether key is one of the variants
+// imageVariantKey caches for uploadName.
+func isImageVariantOf(host, uploadName, key string) bool {
+	if path.Dir(key)+"/" != imageVariantDir(host, uploadName) {
+		return false
+	}
+	var opts imageVariantOptions
+	base := path.Base(key)
+	if _, err := fmt.Sscanf(strings.TrimSuffix(base, path.Ext(base)), "%dx%d-%s", &opts.Width, &opts.Height, &opts.Fit); err != nil {
+		return false
+	}
+	if imageVariantKey(host, uploadName, opts) == key {
+		return true
+	}
+	for format := range imageFormats {
+		opts.Format = format
+		if imageVariantKey(host, uploadName, opts) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// imageVariantFormat picks the output encoding: the requested format, else
+// the original's, else JPEG for sources Go can decode but not encode (webp).
+func imageVariantFormat(sourceName string, opts imageVariantOptions) imaging.Format {
+	if opts.Format != "" {
+		return imageFormats[opts.Format]
+	}
+	format, err := imaging.FormatFromFilename(sourceName)
+	if _, ok := imageFormatExtensions[format]; err != nil || !ok {
+		return imaging.JPEG
+	}
+	return format
+}
+
+// renderImageVariant decodes src, resizes it per opts and re-encodes it.
+// Images are never upscaled: a width or height larger than the original is
+// clamped, which keeps a srcset ladder from inflating small uploads.
+func renderImageVariant(src io.Reader, sourceName string, opts imageVariantOptions) ([]byte, error) {
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(src, &header))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if err := checkImagePixels(config); err != nil {
+		return nil, err
+	}
+
+	img, err := imaging.Decode(io.MultiReader(&header, src), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	img = resizeImage(img, opts)
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imageVariantFormat(sourceName, opts), imaging.JPEGQuality(82)); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func checkImagePixels(config image.Config) error {
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return fmt.Errorf("image is %dx%d, larger than the %d pixels that can be resized", config.Width, config.Height, maxImagePixels)
+	}
+	return nil
+}
+
+func resizeImage(img image.Image, opts imageVariantOptions) image.Image {
+	bounds := img.Bounds()
+	width := min(opts.Width, bounds.Dx())
+	height := min(opts.Height, bounds.Dy())
+
+	switch {
+	case width == 0 && height == 0:
+		return img
+	case width == 0 || height == 0:
+		return imaging.Resize(img, width, height, imaging.Lanczos)
+	case opts.Fit == "contain":
+		return imaging.Fit(img, width, height, imaging.Lanczos)
+	case opts.Fit == "fill":
+		return imaging.Resize(img, width, height, imaging.Lanczos)
+	default:
+		return imaging.Fill(img, width, height, imaging.Center, imaging.Lanczos)
+	}
+}
+
+// serveImageVariant answers an _uploads request carrying resize parameters.
+// The variant is rendered from the published original on first request and
+// cached in storage; later requests stream the cached file.
+func serveImageVariant(e *core.RequestEvent, fs *filesystem.System, host, originalKey string, opts imageVariantOptions) error {
+	variantKey := imageVariantKey(host, path.Base(originalKey), opts)
+
+	exists, err := fs.Exists(variantKey)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		reader, err := fs.GetReader(originalKey)
+		if err != nil {
+			return e.NotFoundError("", err)
+		}
+		data, err := renderImageVariant(reader, originalKey, opts)
+		reader.Close()
+		if err != nil {
+			return e.BadRequestError(err.Error(), err)
+		}
+		if err := fs.Upload(data, variantKey); err != nil {
+			return err
+		}
+	}
+
+	reader, err := fs.GetReader(variantKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	e.Response.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	e.Response.Header().Set("Content-Security-Policy", "frame-ancestors *")
+	http.ServeContent(e.Response, e.Request, path.Base(variantKey), reader.ModTime(), reader)
+	return nil
+}
+
+// generateImageVariants pre-renders the srcset ladder for every upload an
+// image field points at, so the first visitor on a slow connection doesn't
+// pay for the resize. Uploads nobody references are left to on-demand
+// rendering.
+func generateImageVariants(pb *pocketbase.PocketBase, system *filesystem.System, site *core.Record, uploads []*core.Record, ladder []int) ([]string, error) {
+	if len(ladder) == 0 || len(uploads) == 0 {
+		return nil, nil
+	}
+
+	referenced, err := referencedImageUploads(pb, site.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	host := site.GetString("host")
+	newFiles := []string{}
+	for _, upload := range uploads {
+		if !referenced[upload.Id] {
+			continue
+		}
+
+		name := upload.GetString("file")
+
+		sourceKey := uploadStorageKey(upload)
+		reader, err := system.GetReader(sourceKey)
+		if err != nil {
+			return nil, err
+		}
+		config, _, err := image.DecodeConfig(reader)
+		reader.Close()
+		if err != nil || checkImagePixels(config) != nil {
+			// Not an image we can decode, or too large to; leave it to
+			// the original.
+			continue
+		}
+
+		for _, width := range ladder {
+			if width >= config.Width {
+				break
+			}
+			opts := imageVariantOptions{Width: width, Fit: "cover"}
+			variantKey := imageVariantKey(host, name, opts)
+
+			reader, err := system.GetReader(sourceKey)
+			if err != nil {
+				return nil, err
+			}
+			data, err := renderImageVariant(reader, name, opts)
+			reader.Close()
+			if err != nil {
+				return nil, err
+			}
+			if err := system.Upload(data, variantKey); err != nil {
+				return nil, err
+			}
+			newFiles = append(newFiles, variantKey)
+		}
+	}
+
+	return newFiles, nil
+}
+
+// referencedImageUploads collects the site_uploads ids stored in the
+// `upload` key of image field values anywhere in the site.
+func referencedImageUploads(pb *pocketbase.PocketBase, siteId string) (map[string]bool, error) {
+	sources := []struct {
+		fields  string
+		filter  string
+		entries []string
+	}{
+		{"site_fields", "site = {:site}", []string{"site_entries"}},
+		{"site_symbol_fields", "symbol.site = {:site}", []string{"site_symbol_entries", "page_section_entries", "page_type_section_entries"}},
+		{"page_type_fields", "page_type.site = {:site}", []string{"page_type_entries", "page_entries"}},
+	}
+
+	referenced := map[string]bool{}
+	for _, source := range sources {
+		fields, err := pb.FindRecordsByFilter(source.fields, source.filter+" && type = 'image'", "", 0, 0, dbx.Params{"site": siteId})
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		fieldIds := make([]any, len(fields))
+		for i, field := range fields {
+			fieldIds[i] = field.Id
+		}
+
+		for _, collection := range source.entries {
+			entries, err := pb.FindAllRecords(collection, dbx.In("field", fieldIds...))
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				value := struct {
+					Upload string `json:"upload"`
+				}{}
+				if err := json.Unmarshal([]byte(entry.GetString("value")), &value); err == nil && value.Upload != "" {
+					referenced[value.Upload] = true
+				}
+			}
+		}
+	}
+
+	return referenced, nil
+}
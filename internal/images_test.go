@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	imagepng "image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func testPNG(t testing.TB, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := imagepng.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageVariantOptionsAllowlist(t *testing.T) {
+	t.Setenv("PRIMO_IMAGE_SIZES", "320, 640")
+	allowed := imageSizeAllowlist()
+
+	if _, ok, err := parseImageVariantOptions(url.Values{}, allowed); ok || err != nil {
+		t.Fatalf("expected a plain request left to the original, got %v %v", ok, err)
+	}
+	opts, ok, err := parseImageVariantOptions(url.Values{"w": {"320"}, "format": {"PNG"}}, allowed)
+	if !ok || err != nil || opts.Width != 320 || opts.Fit != "cover" || opts.Format != "png" {
+		t.Fatalf("expected an allowed width accepted, got %+v %v", opts, err)
+	}
+	for _, query := range []url.Values{
+		{"w": {"321"}},
+		{"h": {"1920"}},
+		{"w": {"-320"}},
+		{"w": {"320"}, "fit": {"stretch"}},
+		{"w": {"320"}, "format": {"webp"}},
+	} {
+		if _, _, err := parseImageVariantOptions(query, allowed); err == nil {
+			t.Fatalf("expected %v rejected", query)
+		}
+	}
+}
+
+func TestServeImageVariantCachesAndRefusesBombs(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	original := "sites/example.com/_uploads/photo.png"
+	if err := fsys.Upload(testPNG(t, 800, 600), original); err != nil {
+		t.Fatal(err)
+	}
+	opts := imageVariantOptions{Width: 320, Fit: "cover"}
+	serve := func() int {
+		e := newTestRequestEvent(app, http.MethodGet, "/", nil)
+		if err := serveImageVariant(e, fsys, "example.com", original, opts); err != nil {
+			return apiErrorStatus(t, err)
+		}
+		return e.Response.(*httptest.ResponseRecorder).Code
+	}
+
+	if got := serve(); got != http.StatusOK {
+		t.Fatalf("expected the variant served, got %d", got)
+	}
+	variantKey := imageVariantKey("example.com", "photo.png", opts)
+	if exists, _ := fsys.Exists(variantKey); !exists {
+		t.Fatalf("expected the variant cached at %s", variantKey)
+	}
+	// Uploads that only share a stem never share a variant, even once
+	// the format gives them the same extension.
+	jpeg := imageVariantOptions{Width: 320, Fit: "cover", Format: "jpg"}
+	if a, b := imageVariantKey("example.com", "hero.png", jpeg), imageVariantKey("example.com", "hero.jpg", jpeg); a == b {
+		t.Fatalf("expected hero.png and hero.jpg cached apart, both got %s", a)
+	}
+
+	// With the original gone, only the cache can answer.
+	if err := fsys.Delete(original); err != nil {
+		t.Fatal(err)
+	}
+	if got := serve(); got != http.StatusOK {
+		t.Fatalf("expected the cached variant served, got %d", got)
+	}
+
+	// A source over the pixel limit is refused before it is decoded.
+	defer func(limit int) { maxImagePixels = limit }(maxImagePixels)
+	maxImagePixels = 1000
+	if _, err := renderImageVariant(bytes.NewReader(testPNG(t, 100, 100)), "big.png", opts); err == nil {
+		t.Fatal("expected an image over the pixel limit refused")
+	}
+	if _, err := renderImageVariant(bytes.NewReader(testPNG(t, 20, 20)), "small.png", opts); err != nil {
+		t.Fatalf("expected an image under the limit rendered: %v", err)
+	}
+}
+
+func TestGenerateImageVariantsPreRendersSrcset(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+	project := map[string]string{
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "- name: cover\n  type: image\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: default\nsections: []\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	cover, err := createSiteUpload(app, fsys, site, "cover.png", testPNG(t, 700, 100), siteUploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused, err := createSiteUpload(app, fsys, site, "unused.png", testPNG(t, 900, 100), siteUploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := sitePagesByPath(app, site.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := newPageFieldWriter(app, pages[""])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.set(writer.field("cover"), map[string]interface{}{"url": "", "alt": "", "upload": cover.Id}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := generateImageVariants(app, fsys, site, []*core.Record{cover, unused}, []int{320, 640, 960})
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := site.GetString("host")
+	want := []string{
+		imageVariantKey(host, cover.GetString("file"), imageVariantOptions{Width: 320, Fit: "cover"}),
+		imageVariantKey(host, cover.GetString("file"), imageVariantOptions{Width: 640, Fit: "cover"}),
+	}
+	if !slices.Equal(files, want) {
+		t.Fatalf("expected the referenced upload rendered below its width only, got %v", files)
+	}
+	for _, key := range want {
+		if exists, _ := fsys.Exists(key); !exists {
+			t.Fatalf("expected %s written", key)
+		}
+	}
+}
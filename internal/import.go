@@ -1,17 +1,22 @@
 package internal
 
 import (
-	"archive/zip"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
 	"golang.org/x/net/html"
 	"gopkg.in/yaml.v3"
 )
@@ -148,6 +153,11 @@ type ImportDiff struct {
 	PageTypes ImportDiffSection `json:"page_types"`
 	Pages     ImportDiffSection `json:"pages"`
 	Site      ImportDiffSection `json:"site"`
+
+	// Items and Orphaned are only filled in for previews; see
+	// buildImportItemDiffs.
+	Items    []ImportItemDiff `json:"items,omitempty"`
+	Orphaned []ImportOrphan   `json:"orphaned,omitempty"`
 }
 
 type ImportDiffSection struct {
@@ -167,6 +177,14 @@ type ImportWarning struct {
 	Field   string `json:"field"`   // the unknown field key
 	Block   string `json:"block"`   // block/symbol name when relevant
 	Message string `json:"message"` // human-readable explanation
+
+	// Rule names the field constraint broken, for constraint_violation
+	// warnings (e.g. "max_length").
+	Rule string `json:"rule,omitempty"`
+
+	// orphan carries content the import could not place, so it can be
+	// kept in import_quarantine rather than lost.
+	orphan *orphanedContent
 }
 
 // ImportResult contains the diff and created IDs for writing back to files
@@ -174,6 +192,9 @@ type ImportResult struct {
 	Diff       *ImportDiff                       `json:"diff"`
 	CreatedIDs map[string]map[string]interface{} `json:"created_ids"`
 	Warnings   []ImportWarning                   `json:"warnings,omitempty"`
+	// Conflicts lists files changed in the CMS since the export, when a
+	// preview was asked to check.
+	Conflicts []ImportConflict `json:"conflicts,omitempty"`
 }
 
 func RegisterImportEndpoint(pb *pocketbase.PocketBase) error {
@@ -188,6 +209,25 @@ func RegisterImportEndpoint(pb *pocketbase.PocketBase) error {
 			return handleImport(pb, e, false)
 		})
 
+		// WordPress WXR export, with an optional uploads archive
+		serveEvent.Router.POST("/api/palacms/import/{siteId}/wordpress", func(e *core.RequestEvent) error {
+			return handleWordPressImport(pb, e)
+		})
+
+		// A folder of Markdown files with YAML frontmatter
+		serveEvent.Router.POST("/api/palacms/import/{siteId}/markdown", func(e *core.RequestEvent) error {
+			return handleMarkdownImport(pb, e)
+		})
+
+		// Content earlier imports couldn't place, kept until it is
+		// restored by a later push or purged
+		serveEvent.Router.GET("/api/palacms/sites/{siteId}/quarantine", func(e *core.RequestEvent) error {
+			return handleListQuarantine(pb, e)
+		})
+		serveEvent.Router.POST("/api/palacms/sites/{siteId}/quarantine/purge", func(e *core.RequestEvent) error {
+			return handlePurgeQuarantine(pb, e)
+		})
+
 		return serveEvent.Next()
 	})
 	return nil
@@ -199,37 +239,37 @@ func handleImport(pb *pocketbase.PocketBase, e *core.RequestEvent, previewOnly b
 		return e.BadRequestError("Missing site ID", nil)
 	}
 
-	// Skip auth check for localhost (local dev mode)
-	if e.Auth == nil && !IsLocalhost(e) {
+	// Skip auth check for localhost (local dev mode). API keys are checked
+	// against the site once it is found.
+	apiKey := requestSiteAPIKey(e)
+	if e.Auth == nil && apiKey == "" && !IsLocalhost(e) {
 		return e.UnauthorizedError("Authentication required", nil)
 	}
 
-	// Parse multipart form (must read body before any access checks so we can
+	// Read the project (must read body before any access checks so we can
 	// peek at site.yaml when creating a new site).
-	if err := e.Request.ParseMultipartForm(32 << 20); err != nil { // 32MB max
-		return e.BadRequestError("Failed to parse form", err)
-	}
-
-	file, _, err := e.Request.FormFile("file")
+	source, closeSource, err := readImportSource(e)
 	if err != nil {
-		return e.BadRequestError("No file uploaded", err)
+		return err
 	}
-	defer file.Close()
-
-	// Read ZIP data
-	zipData, err := io.ReadAll(file)
+	defer closeSource()
+	files, err := readImportFiles(source)
 	if err != nil {
-		return e.InternalServerError("Failed to read file", err)
+		return e.BadRequestError(err.Error(), err)
 	}
 
-	// Pull name/host/group from site.yaml in the zip. These are used to
-	// populate required fields on create, and to keep the server-side site
-	// record in sync with the canonical config on subsequent pushes.
-	siteName, siteHost, siteGroup := readSiteConfigFromZip(zipData)
+	// Pull name/host/group from site.yaml. These are used to populate
+	// required fields on create, and to keep the server-side site record in
+	// sync with the canonical config on subsequent pushes.
+	siteName, siteHost, siteGroup := readSiteConfig(files)
 
 	// Find the site or create it if it doesn't exist
 	siteCreated := false
 	site, err := pb.FindRecordById("sites", siteId)
+	if err != nil && apiKey != "" {
+		// A key belongs to an existing site; it can't create one.
+		return e.NotFoundError("Site not found", err)
+	}
 	if err != nil {
 		createName := siteName
 		if createName == "" {
@@ -270,18 +310,34 @@ func handleImport(pb *pocketbase.PocketBase, e *core.RequestEvent, previewOnly b
 		siteCreated = true
 	}
 
-	// Only check access if site already existed (skip for newly created sites and localhost)
-	if !siteCreated && !IsLocalhost(e) {
-		info, err := e.RequestInfo()
-		if err != nil {
-			return e.InternalServerError("Failed to get request info", err)
-		}
-		canAccess, _ := e.App.CanAccessRecord(site, info, site.Collection().UpdateRule)
-		if !canAccess {
-			return e.ForbiddenError("Access denied", nil)
+	// Only check access if site already existed (skip for newly created sites)
+	if !siteCreated {
+		if err := checkSiteAccess(e, site, true, ScopeImport); err != nil {
+			return err
 		}
 	}
 
+	// Edits made in the CMS since the archive was exported are not
+	// overwritten unless the caller explicitly forces the push. Preview
+	// reports the conflicts alongside the diff instead of refusing, so the
+	// CLI can show both before asking.
+	force, _ := strconv.ParseBool(e.Request.FormValue("force"))
+	result, err := processImportFiles(pb, site, source, files, previewOnly, !siteCreated && !force)
+	var conflictErr *importConflictError
+	if errors.As(err, &conflictErr) {
+		return e.JSON(409, map[string]interface{}{
+			"message":   "The site was changed in the CMS since this export; pull first or push with force=true",
+			"conflicts": conflictErr.conflicts,
+		})
+	}
+	var archiveErr *importArchiveError
+	if errors.As(err, &archiveErr) {
+		return e.BadRequestError(err.Error(), err)
+	}
+	if err != nil {
+		return e.InternalServerError("Import failed: "+err.Error(), err)
+	}
+
 	// Sync name/host/group from site.yaml onto an existing site. Skipped on
 	// create (the values were just written above) and during preview (no
 	// writes). Empty fields in site.yaml leave the existing record untouched
@@ -310,16 +366,11 @@ func handleImport(pb *pocketbase.PocketBase, e *core.RequestEvent, previewOnly b
 		}
 	}
 
-	// Parse and process the import
-	result, err := processImport(pb, site, zipData, previewOnly)
-	if err != nil {
-		return e.InternalServerError("Import failed: "+err.Error(), err)
-	}
-
 	if previewOnly {
 		return e.JSON(200, map[string]interface{}{
-			"preview": true,
-			"diff":    result.Diff,
+			"preview":   true,
+			"diff":      result.Diff,
+			"conflicts": result.Conflicts,
 		})
 	}
 
@@ -352,73 +403,60 @@ func handleImport(pb *pocketbase.PocketBase, e *core.RequestEvent, previewOnly b
 		BroadcastStatus("connected", "")
 	}
 
+	// The pushed files are now the site's state; handing back their
+	// revisions lets the CLI push again without pulling first.
+	revisions, err := siteRevisions(pb, site)
+	if err != nil {
+		return e.InternalServerError("Failed to render revisions: "+err.Error(), err)
+	}
+
 	return e.JSON(200, map[string]interface{}{
 		"success":     true,
 		"diff":        result.Diff,
 		"created_ids": result.CreatedIDs,
 		"warnings":    result.Warnings,
+		"revisions":   revisions,
 	})
 }
 
-// readSiteConfigFromZip extracts site.yaml from the import zip and returns
-// (name, host, group). Missing or unparseable site.yaml yields empty strings;
-// callers fall back to safe defaults so a malformed config never blocks the
-// site auto-create path.
-func readSiteConfigFromZip(zipData []byte) (string, string, string) {
-	reader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+func processImport(pb *pocketbase.PocketBase, site *core.Record, zipData []byte, previewOnly bool) (*ImportResult, error) {
+	fsys, err := zipImportFS(zipData)
 	if err != nil {
-		return "", "", ""
+		return nil, err
 	}
-	for _, f := range reader.File {
-		if f.Name != "site.yaml" || f.FileInfo().IsDir() {
-			continue
-		}
-		rc, err := f.Open()
-		if err != nil {
-			return "", "", ""
-		}
-		data, err := io.ReadAll(rc)
-		rc.Close()
-		if err != nil {
-			return "", "", ""
-		}
-		var cfg struct {
-			Name  string `yaml:"name"`
-			Host  string `yaml:"host"`
-			Group string `yaml:"group"`
-		}
-		if err := yaml.Unmarshal(data, &cfg); err != nil {
-			return "", "", ""
-		}
-		return cfg.Name, cfg.Host, cfg.Group
+	files, err := readImportFiles(fsys)
+	if err != nil {
+		return nil, err
 	}
-	return "", "", ""
+	return processImportFiles(pb, site, fsys, files, previewOnly, false)
 }
 
-func processImport(pb *pocketbase.PocketBase, site *core.Record, zipData []byte, previewOnly bool) (*ImportResult, error) {
-	reader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
-	if err != nil {
-		return nil, fmt.Errorf("invalid ZIP file: %w", err)
+// processImportFiles applies a project already read from source by
+// readImportFiles. Upload bytes are read from source as they are restored.
+// With checkConflicts, files the CMS changed since the project's export
+// refuse the import with an *importConflictError, or are listed in the
+// result when previewing.
+func processImportFiles(pb *pocketbase.PocketBase, site *core.Record, source fs.FS, files map[string][]byte, previewOnly, checkConflicts bool) (*ImportResult, error) {
+	// Upgrade projects exported in an older format (and refuse newer ones)
+	// before anything is compared against or written to the site.
+	if err := upgradeImportFiles(files); err != nil {
+		return nil, &importArchiveError{err}
 	}
 
-	// Parse all files from ZIP
-	files := make(map[string][]byte)
-	for _, f := range reader.File {
-		if f.FileInfo().IsDir() {
-			continue
-		}
-		rc, err := f.Open()
+	var conflicts []ImportConflict
+	if checkConflicts {
+		var err error
+		conflicts, err = findImportConflicts(pb, site, files)
 		if err != nil {
-			return nil, err
+			return nil, &importArchiveError{fmt.Errorf("conflict check failed: %w", err)}
 		}
-		data, err := io.ReadAll(rc)
-		rc.Close()
-		if err != nil {
-			return nil, err
+		if len(conflicts) > 0 && !previewOnly {
+			return nil, &importConflictError{conflicts}
 		}
-		files[f.Name] = data
 	}
 
+	var err error
+
 	// Collects non-fatal import problems (orphaned fields, etc.) to surface
 	// back to the CLI so they're impossible to miss instead of silently dropped.
 	var warnings []ImportWarning
@@ -430,6 +468,17 @@ func processImport(pb *pocketbase.PocketBase, site *core.Record, zipData []byte,
 		Site:      ImportDiffSection{Added: []string{}, Modified: []string{}, Deleted: []string{}},
 	}
 
+	if err := applyFieldRenamesToFiles(files); err != nil {
+		return nil, err
+	}
+
+	if previewOnly {
+		diff.Items, diff.Orphaned, err = buildImportItemDiffs(pb, site, files)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build preview diff: %w", err)
+		}
+	}
+
 	// Track created IDs for writing back to files
 	createdIDs := make(map[string]map[string]interface{})
 
@@ -608,7 +657,7 @@ func processImport(pb *pocketbase.PocketBase, site *core.Record, zipData []byte,
 			if plan.existing == nil {
 				continue
 			}
-			fieldKeyToId, err := importPageTypeFieldsOnly(pb, plan.existing, plan.ptFields, pageTypeNameToId)
+			fieldKeyToId, err := importPageTypeFieldsOnly(pb, plan.existing, plan.ptFields, pageTypeNameToId, &warnings, fmt.Sprintf("page-types/%s/fields.yaml", plan.ptName))
 			if err != nil {
 				return nil, fmt.Errorf("failed to pre-import page type fields for %s: %w", plan.ptData.Name, err)
 			}
@@ -724,6 +773,15 @@ func processImport(pb *pocketbase.PocketBase, site *core.Record, zipData []byte,
 			createdIDs[configPath] = map[string]interface{}{
 				"_id": blockId,
 			}
+
+			// A self-contained export carries the compiled block next to
+			// its source. It is only trusted when the source came along
+			// unchanged; otherwise the editor recompiles as usual.
+			if compiled := files[fmt.Sprintf("blocks/%s/compiled.js", blockName)]; compiled != nil && !slices.Contains(diff.Blocks.Modified, displayName) {
+				if err := restoreCompiledBlock(pb, blockId, compiled); err != nil {
+					return nil, fmt.Errorf("failed to restore compiled block %s: %w", blockName, err)
+				}
+			}
 		}
 	}
 
@@ -732,7 +790,7 @@ func processImport(pb *pocketbase.PocketBase, site *core.Record, zipData []byte,
 	// and layout.yaml, and lets layout-mounted blocks import content/defaults.
 	for _, plan := range plans {
 		if !previewOnly {
-			if err := importPageType(pb, site, plan.ptData, plan.ptFields, plan.existing, folderToDisplayName, plan.layoutData, plan.ptHead, plan.ptFoot, pageTypeNameToId, blockDefaultContent); err != nil {
+			if err := importPageType(pb, site, plan.ptData, plan.ptFields, plan.existing, folderToDisplayName, plan.layoutData, plan.ptHead, plan.ptFoot, pageTypeNameToId, blockDefaultContent, &warnings, fmt.Sprintf("page-types/%s/fields.yaml", plan.ptName)); err != nil {
 				return nil, fmt.Errorf("failed to import page type %s: %w", plan.ptData.Name, err)
 			}
 		}
@@ -950,7 +1008,7 @@ func processImport(pb *pocketbase.PocketBase, site *core.Record, zipData []byte,
 	if siteFieldsData, ok := files["site/fields.yaml"]; ok {
 		diff.Site.Modified = append(diff.Site.Modified, "fields")
 		if !previewOnly {
-			if err := importSiteFields(pb, site, siteFieldsData); err != nil {
+			if err := importSiteFields(pb, site, siteFieldsData, &warnings); err != nil {
 				return nil, fmt.Errorf("failed to import site fields: %w", err)
 			}
 		}
@@ -970,7 +1028,7 @@ func processImport(pb *pocketbase.PocketBase, site *core.Record, zipData []byte,
 			if err != nil {
 				return nil, fmt.Errorf("failed to build page slug map: %w", err)
 			}
-			if err := importSiteContent(pb, site, siteContentData, siteContentIsYaml, pathToPageId); err != nil {
+			if err := importSiteContent(pb, site, siteContentData, siteContentIsYaml, pathToPageId, &warnings); err != nil {
 				return nil, fmt.Errorf("failed to import site content: %w", err)
 			}
 		}
@@ -1003,13 +1061,76 @@ func processImport(pb *pocketbase.PocketBase, site *core.Record, zipData []byte,
 		}
 	}
 
+	if err := importSiteUploadFiles(pb, site, source, files["uploads/.manifest.json"], previewOnly, diff, &warnings); err != nil {
+		return nil, err
+	}
+
+	if manifestData, ok := files["uploads/.manifest.json"]; ok {
+		if err := applyUploadManifest(pb, site, manifestData, previewOnly, &warnings); err != nil {
+			return nil, err
+		}
+	}
+
+	if !previewOnly {
+		// Restore earlier orphans the schema now has room for before
+		// quarantining this push's, so a value only moves out once.
+		if err := reapplyQuarantine(pb, site, &warnings); err != nil {
+			return nil, err
+		}
+		if err := quarantineOrphans(pb, site, warnings); err != nil {
+			return nil, err
+		}
+	}
+
 	return &ImportResult{
 		Diff:       diff,
 		CreatedIDs: createdIDs,
 		Warnings:   warnings,
+		Conflicts:  conflicts,
 	}, nil
 }
 
+// importArchiveError is an archive that can't be imported as it is, such
+// as one in an export format this instance doesn't read.
+type importArchiveError struct {
+	err error
+}
+
+func (e *importArchiveError) Error() string {
+	return e.err.Error()
+}
+
+func (e *importArchiveError) Unwrap() error {
+	return e.err
+}
+
+// importConflictError refuses an import that would overwrite edits made in
+// the CMS since the project was exported.
+type importConflictError struct {
+	conflicts []ImportConflict
+}
+
+func (e *importConflictError) Error() string {
+	return fmt.Sprintf("%d files were changed in the CMS since this export", len(e.conflicts))
+}
+
+func restoreCompiledBlock(pb *pocketbase.PocketBase, symbolId string, data []byte) error {
+	symbol, err := pb.FindRecordById("site_symbols", symbolId)
+	if err != nil {
+		return err
+	}
+	if symbol.GetString("compiled_js") != "" {
+		return nil
+	}
+
+	file, err := filesystem.NewFileFromBytes(data, "compiled.js")
+	if err != nil {
+		return err
+	}
+	symbol.Set("compiled_js", file)
+	return pb.Save(symbol)
+}
+
 func validateHeadSvelte(data []byte, sourcePath string) error {
 	tokenizer := html.NewTokenizer(bytes.NewReader(data))
 
@@ -1177,13 +1298,17 @@ func importBlock(pb *pocketbase.PocketBase, site *core.Record, folderName, displ
 				continue
 			}
 
+			migration := readFieldMigration(fieldData)
 			var field *core.Record
 			if existing, ok := existingFieldsByKey[fieldKey]; ok {
 				field = existing
+			} else if renamed := findRenamedField(existingFieldsByKey, migration.RenamedFrom, matchedFieldIds); renamed != nil {
+				field = renamed
 			} else {
 				field = core.NewRecord(fieldsColl)
 				field.Set("symbol", symbol.Id)
 			}
+			previousType := field.GetString("type")
 
 			field.Set("key", fieldKey)
 			field.Set("label", getString(fieldData, "label"))
@@ -1247,6 +1372,9 @@ func importBlock(pb *pocketbase.PocketBase, site *core.Record, folderName, displ
 				return "", err
 			}
 			matchedFieldIds[field.Id] = true
+			if err := migrateFieldEntries(pb, site.Id, field, previousType, migration, warnings, sourceFile); err != nil {
+				return "", err
+			}
 
 			fieldKeyToRecord[fieldKey] = field
 
@@ -1273,13 +1401,21 @@ func importBlock(pb *pocketbase.PocketBase, site *core.Record, folderName, displ
 
 					// Check if this nested field already exists using composite key
 					compositeKey := parentKey + "/" + nestedKey
+					nestedMigration := readFieldMigration(nestedFieldMap)
+					renamedKey := ""
+					if nestedMigration.RenamedFrom != "" {
+						renamedKey = parentKey + "/" + nestedMigration.RenamedFrom
+					}
 					var nestedField *core.Record
 					if existing, ok := existingFieldsByKey[compositeKey]; ok {
 						nestedField = existing
+					} else if renamed := findRenamedField(existingFieldsByKey, renamedKey, matchedFieldIds); renamed != nil {
+						nestedField = renamed
 					} else {
 						nestedField = core.NewRecord(fieldsColl)
 						nestedField.Set("symbol", symbol.Id)
 					}
+					previousNestedType := nestedField.GetString("type")
 
 					nestedField.Set("key", nestedKey)
 					nestedField.Set("label", getString(nestedFieldMap, "label"))
@@ -1301,6 +1437,9 @@ func importBlock(pb *pocketbase.PocketBase, site *core.Record, folderName, displ
 						return err
 					}
 					matchedFieldIds[nestedField.Id] = true
+					if err := migrateFieldEntries(pb, site.Id, nestedField, previousNestedType, nestedMigration, warnings, sourceFile); err != nil {
+						return err
+					}
 
 					fieldKeyToRecord[compositeKey] = nestedField
 					fieldKeyToRecord[nestedKey] = nestedField
@@ -1351,6 +1490,15 @@ func importBlock(pb *pocketbase.PocketBase, site *core.Record, folderName, displ
 				return "", err
 			}
 		}
+
+		fields, err := pb.FindRecordsByFilter("site_symbol_fields", "symbol = {:symbol}", "", 0, 0, dbx.Params{"symbol": symbol.Id})
+		if err != nil {
+			return "", err
+		}
+		if err := resolveFieldConditions(pb, fields, fieldRenames(blockFields), warnings, sourceFile, displayName); err != nil {
+			return "", err
+		}
+		reportConstraintRuleErrors(fields, warnings, sourceFile, displayName)
 	}
 
 	// Import content entries (default values) if provided
@@ -1371,6 +1519,7 @@ func importBlock(pb *pocketbase.PocketBase, site *core.Record, folderName, displ
 
 		// Build lookup maps
 		fieldByKey := make(map[string]*core.Record)
+		fieldById := recordsById(fields)
 		fieldsByParent := make(map[string][]*core.Record)
 		for _, f := range fields {
 			fieldByKey[f.GetString("key")] = f
@@ -1406,6 +1555,7 @@ func importBlock(pb *pocketbase.PocketBase, site *core.Record, folderName, displ
 					continue // This field's parent is in this symbol, will be processed recursively
 				}
 			}
+			checkContent(pb, field, value, contentMap, fieldsByParent, fieldById, fieldKey, constraintWarnings(warnings, fmt.Sprintf("blocks/%s/content.yaml", folderName), displayName))
 			if err := importSymbolContentField(pb, entriesColl, field, value, "", 0, fieldsByParent, fieldByKey, pathToPageId); err != nil {
 				return "", err
 			}
@@ -1450,7 +1600,7 @@ func importSymbolContentField(pb *pocketbase.PocketBase, entriesColl *core.Colle
 			if parentEntryId != "" {
 				itemEntry.Set("parent", parentEntryId)
 			}
-			if err := pb.Save(itemEntry); err != nil {
+			if err := pb.SaveWithContext(keepContent(), itemEntry); err != nil {
 				return err
 			}
 
@@ -1479,8 +1629,8 @@ func importSymbolContentField(pb *pocketbase.PocketBase, entriesColl *core.Colle
 			groupEntry.Set("parent", parentEntryId)
 		}
 		// Store the whole group value
-		groupEntry.Set("value", convertUrlsToPageRefs(value, pathToPageId))
-		if err := pb.Save(groupEntry); err != nil {
+		groupEntry.Set("value", storedEntryValue(convertUrlsToPageRefs(value, pathToPageId)))
+		if err := pb.SaveWithContext(keepContent(), groupEntry); err != nil {
 			return err
 		}
 
@@ -1503,11 +1653,11 @@ func importSymbolContentField(pb *pocketbase.PocketBase, entriesColl *core.Colle
 		entry.Set("field", fieldId)
 		entry.Set("locale", "en")
 		entry.Set("index", index)
-		entry.Set("value", convertUrlsToPageRefs(value, pathToPageId))
+		entry.Set("value", storedEntryValue(convertUrlsToPageRefs(value, pathToPageId)))
 		if parentEntryId != "" {
 			entry.Set("parent", parentEntryId)
 		}
-		if err := pb.Save(entry); err != nil {
+		if err := pb.SaveWithContext(keepContent(), entry); err != nil {
 			return err
 		}
 	}
@@ -1516,7 +1666,7 @@ func importSymbolContentField(pb *pocketbase.PocketBase, entriesColl *core.Colle
 }
 
 // importPageSectionContentField recursively imports a page section field's value, handling repeaters and groups
-func importPageSectionContentField(pb *pocketbase.PocketBase, entriesColl *core.Collection, sectionId string, field *core.Record, value interface{}, parentEntryId string, index int, fieldsByParent map[string][]*core.Record, fieldByKey map[string]*core.Record, pathToPageId map[string]string, warnings *[]ImportWarning, sourceFile string, blockName string, pathPrefix string) error {
+func importPageSectionContentField(pb core.App, entriesColl *core.Collection, sectionId string, field *core.Record, value interface{}, parentEntryId string, index int, fieldsByParent map[string][]*core.Record, fieldByKey map[string]*core.Record, pathToPageId map[string]string, warnings *[]ImportWarning, sourceFile string, blockName string, pathPrefix string) error {
 	fieldType := field.GetString("type")
 	fieldId := field.Id
 
@@ -1546,7 +1696,7 @@ func importPageSectionContentField(pb *pocketbase.PocketBase, entriesColl *core.
 			if parentEntryId != "" {
 				itemEntry.Set("parent", parentEntryId)
 			}
-			if err := pb.Save(itemEntry); err != nil {
+			if err := pb.SaveWithContext(keepContent(), itemEntry); err != nil {
 				return err
 			}
 
@@ -1566,9 +1716,10 @@ func importPageSectionContentField(pb *pocketbase.PocketBase, entriesColl *core.
 					Field: field.GetString("key"),
 					Block: blockName,
 					Message: fmt.Sprintf(
-						"%s: repeater %q in block %q imported item %d as an empty object because the block had no subfields for that repeater at import time. Re-import this page after fixing blocks/%s/fields.yaml.",
+						"%s: repeater %q in block %q imported item %d as an empty object because the block had no subfields for that repeater at import time. The item's content was kept in the import quarantine and is restored once blocks/%s/fields.yaml declares the subfields.",
 						sourceFile, pathPrefix, blockName, i, sanitizeFilename(blockName),
 					),
+					orphan: &orphanedContent{section: sectionId, target: sectionContentTarget(fmt.Sprintf("%s[%d]", pathPrefix, i)), value: itemMap},
 				})
 			} else if warnings != nil && len(knownChildKeys) > 0 {
 				for key := range itemMap {
@@ -1580,9 +1731,10 @@ func importPageSectionContentField(pb *pocketbase.PocketBase, entriesColl *core.
 							Field: key,
 							Block: blockName,
 							Message: fmt.Sprintf(
-								"%s: repeater item %d under %q has key %q which is not defined as a subfield. Content for this key was not imported.",
+								"%s: repeater item %d under %q has key %q which is not defined as a subfield. Content for this key was kept in the import quarantine.",
 								sourceFile, i, pathPrefix, key,
 							),
+							orphan: &orphanedContent{section: sectionId, target: sectionContentTarget(fmt.Sprintf("%s[%d].%s", pathPrefix, i, key)), value: itemMap[key]},
 						})
 					}
 				}
@@ -1609,8 +1761,8 @@ func importPageSectionContentField(pb *pocketbase.PocketBase, entriesColl *core.
 			groupEntry.Set("parent", parentEntryId)
 		}
 		// Store the whole group value
-		groupEntry.Set("value", convertUrlsToPageRefs(value, pathToPageId))
-		if err := pb.Save(groupEntry); err != nil {
+		groupEntry.Set("value", storedEntryValue(convertUrlsToPageRefs(value, pathToPageId)))
+		if err := pb.SaveWithContext(keepContent(), groupEntry); err != nil {
 			return err
 		}
 
@@ -1625,9 +1777,10 @@ func importPageSectionContentField(pb *pocketbase.PocketBase, entriesColl *core.
 					Field: field.GetString("key"),
 					Block: blockName,
 					Message: fmt.Sprintf(
-						"%s: group %q in block %q imported as an empty object because the block had no subfields for that group at import time. Re-import this page after fixing blocks/%s/fields.yaml.",
+						"%s: group %q in block %q imported as an empty object because the block had no subfields for that group at import time. The group's content was kept in the import quarantine and is restored once blocks/%s/fields.yaml declares the subfields.",
 						sourceFile, pathPrefix, blockName, sanitizeFilename(blockName),
 					),
+					orphan: &orphanedContent{section: sectionId, target: sectionContentTarget(pathPrefix), value: groupMap},
 				})
 			} else if warnings != nil && len(knownChildKeys) > 0 {
 				for key := range groupMap {
@@ -1639,9 +1792,10 @@ func importPageSectionContentField(pb *pocketbase.PocketBase, entriesColl *core.
 							Field: key,
 							Block: blockName,
 							Message: fmt.Sprintf(
-								"%s: group at %q has key %q which is not defined as a subfield. Content for this key was not imported.",
+								"%s: group at %q has key %q which is not defined as a subfield. Content for this key was kept in the import quarantine.",
 								sourceFile, pathPrefix, key,
 							),
+							orphan: &orphanedContent{section: sectionId, target: sectionContentTarget(fmt.Sprintf("%s.%s", pathPrefix, key)), value: groupMap[key]},
 						})
 					}
 				}
@@ -1664,11 +1818,11 @@ func importPageSectionContentField(pb *pocketbase.PocketBase, entriesColl *core.
 		entry.Set("field", fieldId)
 		entry.Set("locale", "en")
 		entry.Set("index", index)
-		entry.Set("value", convertUrlsToPageRefs(value, pathToPageId))
+		entry.Set("value", storedEntryValue(convertUrlsToPageRefs(value, pathToPageId)))
 		if parentEntryId != "" {
 			entry.Set("parent", parentEntryId)
 		}
-		if err := pb.Save(entry); err != nil {
+		if err := pb.SaveWithContext(keepContent(), entry); err != nil {
 			return err
 		}
 	}
@@ -1769,12 +1923,18 @@ func importPage(pb *pocketbase.PocketBase, site *core.Record, pageData ExportedP
 		// Get page type fields - map field key/name -> field id
 		ptFields, _ := pb.FindRecordsByFilter("page_type_fields", "page_type = {:pt}", "", 0, 0, dbx.Params{"pt": pageTypeId})
 		fieldByKey := make(map[string]string)
+		fieldById := make(map[string]*core.Record, len(ptFields))
+		fieldsByParent := make(map[string][]*core.Record)
 		for _, f := range ptFields {
 			key := f.GetString("key")
 			if key == "" {
 				key = f.GetString("name")
 			}
 			fieldByKey[key] = f.Id
+			fieldById[f.Id] = f
+			if parentId := f.GetString("parent"); parentId != "" {
+				fieldsByParent[parentId] = append(fieldsByParent[parentId], f)
+			}
 		}
 
 		// Get existing page entries
@@ -1803,9 +1963,10 @@ func importPage(pb *pocketbase.PocketBase, site *core.Record, pageData ExportedP
 						Path:  fmt.Sprintf("%s.%s", pageContentPathPrefix, fieldKey),
 						Field: fieldKey,
 						Message: fmt.Sprintf(
-							"%s has page content for field %q, but page type %q has no such field. Content for this field was not imported. Add the field to page-types/%s/fields.yaml or remove it from the page.",
+							"%s has page content for field %q, but page type %q has no such field. Content for this field was kept in the import quarantine. Add the field to page-types/%s/fields.yaml or remove it from the page.",
 							sourceFile, fieldKey, pageTypeName, sanitizeFilename(pageTypeName),
 						),
+						orphan: &orphanedContent{page: page.Id, target: fieldKey, value: value},
 					})
 				}
 				continue
@@ -1821,9 +1982,10 @@ func importPage(pb *pocketbase.PocketBase, site *core.Record, pageData ExportedP
 				entry.Set("locale", "en")
 			}
 
-			entry.Set("value", normalizeValueForStorage(value))
+			checkContent(pb, fieldById[fieldId], value, pageContent, fieldsByParent, fieldById, fmt.Sprintf("%s.%s", pageContentPathPrefix, fieldKey), constraintWarnings(warnings, sourceFile, ""))
+			entry.Set("value", storedEntryValue(normalizeValueForStorage(value)))
 
-			if err := pb.Save(entry); err != nil {
+			if err := pb.SaveWithContext(keepContent(), entry); err != nil {
 				return "", nil, err
 			}
 		}
@@ -1929,6 +2091,7 @@ func importPage(pb *pocketbase.PocketBase, site *core.Record, pageData ExportedP
 
 				// Build lookup maps
 				fieldByKey := make(map[string]*core.Record)
+				fieldById := recordsById(symbolFields)
 				fieldsByParent := make(map[string][]*core.Record)
 				for _, f := range symbolFields {
 					fieldByKey[f.GetString("key")] = f
@@ -1961,9 +2124,10 @@ func importPage(pb *pocketbase.PocketBase, site *core.Record, pageData ExportedP
 								Field: fieldKey,
 								Block: blockName,
 								Message: fmt.Sprintf(
-									"%s section %d (block %q) has content for field %q, but block %q has no such field. Content for this field was not imported. Add the field to blocks/%s/fields.yaml or remove it from the page.",
+									"%s section %d (block %q) has content for field %q, but block %q has no such field. Content for this field was kept in the import quarantine. Add the field to blocks/%s/fields.yaml or remove it from the page.",
 									sourceFile, i, blockName, fieldKey, blockName, sanitizeFilename(blockName),
 								),
+								orphan: &orphanedContent{section: section.Id, target: fieldKey, value: value},
 							})
 						}
 						continue
@@ -1976,6 +2140,7 @@ func importPage(pb *pocketbase.PocketBase, site *core.Record, pageData ExportedP
 						}
 					}
 					itemPath := fmt.Sprintf("sections[%d].content.%s", i, fieldKey)
+					checkContent(pb, field, value, content, fieldsByParent, fieldById, itemPath, constraintWarnings(warnings, sourceFile, blockName))
 					if err := importPageSectionContentField(pb, entriesColl, section.Id, field, value, "", 0, fieldsByParent, fieldByKey, nil, warnings, sourceFile, blockName, itemPath); err != nil {
 						return "", nil, err
 					}
@@ -1998,12 +2163,13 @@ func importPage(pb *pocketbase.PocketBase, site *core.Record, pageData ExportedP
 	return page.Id, sectionIds, nil
 }
 
-func importSiteFields(pb *pocketbase.PocketBase, site *core.Record, data []byte) error {
+func importSiteFields(pb *pocketbase.PocketBase, site *core.Record, data []byte, warnings *[]ImportWarning) error {
 	fieldEntries, err := parseBareFieldList(data, "site/fields.yaml")
 	if err != nil {
 		return err
 	}
 	fields := fieldListToMaps(fieldEntries)
+	renames := fieldRenames(fieldEntries)
 
 	// Flatten nested subfields to flat format with parent keys
 	fields = flattenSubfields(fields, "")
@@ -2103,14 +2269,19 @@ func importSiteFields(pb *pocketbase.PocketBase, site *core.Record, data []byte)
 		}
 	}
 
-	return nil
+	siteFields, err := pb.FindRecordsByFilter("site_fields", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return err
+	}
+	reportConstraintRuleErrors(siteFields, warnings, "site/fields.yaml", "")
+	return resolveFieldConditions(pb, siteFields, renames, warnings, "site/fields.yaml", "")
 }
 
 // importPageTypeFieldsOnly creates/updates page_type_fields rows for a page
 // type ahead of block import, returning a key -> ID map for page-field
 // resolution in blocks. The full importPageType still runs in pass 2 and
 // re-processes these rows idempotently.
-func importPageTypeFieldsOnly(pb *pocketbase.PocketBase, pageType *core.Record, ptFields []interface{}, pageTypeNameToId map[string]string) (map[string]string, error) {
+func importPageTypeFieldsOnly(pb *pocketbase.PocketBase, pageType *core.Record, ptFields []interface{}, pageTypeNameToId map[string]string, warnings *[]ImportWarning, sourceFile string) (map[string]string, error) {
 	keyToId := make(map[string]string)
 
 	fieldsColl, err := pb.FindCollectionByNameOrId("page_type_fields")
@@ -2123,6 +2294,7 @@ func importPageTypeFieldsOnly(pb *pocketbase.PocketBase, pageType *core.Record,
 	for _, f := range existingFields {
 		existingByKey[f.GetString("key")] = f
 	}
+	matchedFieldIds := make(map[string]bool)
 
 	for i, fieldEntry := range ptFields {
 		fieldData, ok := fieldEntry.(map[string]interface{})
@@ -2134,13 +2306,17 @@ func importPageTypeFieldsOnly(pb *pocketbase.PocketBase, pageType *core.Record,
 			continue
 		}
 
+		migration := readFieldMigration(fieldData)
 		var field *core.Record
 		if existing, ok := existingByKey[fieldKey]; ok {
 			field = existing
+		} else if renamed := findRenamedField(existingByKey, migration.RenamedFrom, matchedFieldIds); renamed != nil {
+			field = renamed
 		} else {
 			field = core.NewRecord(fieldsColl)
 			field.Set("page_type", pageType.Id)
 		}
+		previousType := field.GetString("type")
 
 		field.Set("key", fieldKey)
 		field.Set("label", getString(fieldData, "label"))
@@ -2168,6 +2344,10 @@ func importPageTypeFieldsOnly(pb *pocketbase.PocketBase, pageType *core.Record,
 		if err := pb.Save(field); err != nil {
 			return keyToId, err
 		}
+		matchedFieldIds[field.Id] = true
+		if err := migrateFieldEntries(pb, pageType.GetString("site"), field, previousType, migration, warnings, sourceFile); err != nil {
+			return keyToId, err
+		}
 		keyToId[fieldKey] = field.Id
 	}
 
@@ -2355,7 +2535,7 @@ func importSiteFieldsWithMap(pb *pocketbase.PocketBase, site *core.Record, data
 	return keyToId, nil
 }
 
-func importSiteContent(pb *pocketbase.PocketBase, site *core.Record, data []byte, isYaml bool, pathToPageId map[string]string) error {
+func importSiteContent(pb *pocketbase.PocketBase, site *core.Record, data []byte, isYaml bool, pathToPageId map[string]string, warnings *[]ImportWarning) error {
 	var content map[string]interface{}
 	var err error
 	if isYaml {
@@ -2375,6 +2555,7 @@ func importSiteContent(pb *pocketbase.PocketBase, site *core.Record, data []byte
 
 	// Build field maps
 	fieldByKey := make(map[string]*core.Record)
+	fieldById := recordsById(fields)
 	fieldsByParent := make(map[string][]*core.Record) // parent field ID -> child fields
 	for _, f := range fields {
 		fieldByKey[f.GetString("key")] = f
@@ -2406,6 +2587,7 @@ func importSiteContent(pb *pocketbase.PocketBase, site *core.Record, data []byte
 			continue
 		}
 
+		checkContent(pb, field, value, content, fieldsByParent, fieldById, fieldKey, constraintWarnings(warnings, "site/content.yaml", ""))
 		if err := importSiteContentField(pb, entriesColl, field, value, "", 0, fieldsByParent, fieldByKey); err != nil {
 			return err
 		}
@@ -2415,7 +2597,7 @@ func importSiteContent(pb *pocketbase.PocketBase, site *core.Record, data []byte
 }
 
 // importSiteContentField recursively imports a field's value, handling repeaters and groups
-func importSiteContentField(pb *pocketbase.PocketBase, entriesColl *core.Collection, field *core.Record, value interface{}, parentEntryId string, index int, fieldsByParent map[string][]*core.Record, fieldByKey map[string]*core.Record) error {
+func importSiteContentField(pb core.App, entriesColl *core.Collection, field *core.Record, value interface{}, parentEntryId string, index int, fieldsByParent map[string][]*core.Record, fieldByKey map[string]*core.Record) error {
 	fieldType := field.GetString("type")
 	fieldId := field.Id
 
@@ -2439,7 +2621,7 @@ func importSiteContentField(pb *pocketbase.PocketBase, entriesColl *core.Collect
 			if parentEntryId != "" {
 				itemEntry.Set("parent", parentEntryId)
 			}
-			if err := pb.Save(itemEntry); err != nil {
+			if err := pb.SaveWithContext(keepContent(), itemEntry); err != nil {
 				return err
 			}
 
@@ -2468,8 +2650,8 @@ func importSiteContentField(pb *pocketbase.PocketBase, entriesColl *core.Collect
 			groupEntry.Set("parent", parentEntryId)
 		}
 		// Store the whole group value (normalized to prevent byte array issues)
-		groupEntry.Set("value", normalizeValueForStorage(value))
-		if err := pb.Save(groupEntry); err != nil {
+		groupEntry.Set("value", storedEntryValue(normalizeValueForStorage(value)))
+		if err := pb.SaveWithContext(keepContent(), groupEntry); err != nil {
 			return err
 		}
 
@@ -2492,11 +2674,11 @@ func importSiteContentField(pb *pocketbase.PocketBase, entriesColl *core.Collect
 		entry.Set("field", fieldId)
 		entry.Set("locale", "en")
 		entry.Set("index", index)
-		entry.Set("value", normalizeValueForStorage(value))
+		entry.Set("value", storedEntryValue(normalizeValueForStorage(value)))
 		if parentEntryId != "" {
 			entry.Set("parent", parentEntryId)
 		}
-		if err := pb.Save(entry); err != nil {
+		if err := pb.SaveWithContext(keepContent(), entry); err != nil {
 			return err
 		}
 	}
@@ -2527,7 +2709,7 @@ func parseComponent(code string) (html, css, js string) {
 	return html, css, js
 }
 
-func importPageType(pb *pocketbase.PocketBase, site *core.Record, ptData ExportedPageType, ptFields []interface{}, existing *core.Record, folderToDisplayName map[string]string, layoutData *ExportedLayout, ptHead *string, ptFoot *string, pageTypeNameToId map[string]string, blockDefaultContent map[string]map[string]interface{}) error {
+func importPageType(pb *pocketbase.PocketBase, site *core.Record, ptData ExportedPageType, ptFields []interface{}, existing *core.Record, folderToDisplayName map[string]string, layoutData *ExportedLayout, ptHead *string, ptFoot *string, pageTypeNameToId map[string]string, blockDefaultContent map[string]map[string]interface{}, warnings *[]ImportWarning, sourceFile string) error {
 	ptColl, err := pb.FindCollectionByNameOrId("page_types")
 	if err != nil {
 		return err
@@ -2598,13 +2780,17 @@ func importPageType(pb *pocketbase.PocketBase, site *core.Record, ptData Exporte
 				continue
 			}
 
+			migration := readFieldMigration(fieldData)
 			var field *core.Record
 			if existing, ok := existingByKey[fieldKey]; ok {
 				field = existing
+			} else if renamed := findRenamedField(existingByKey, migration.RenamedFrom, matchedFieldIds); renamed != nil {
+				field = renamed
 			} else {
 				field = core.NewRecord(fieldsColl)
 				field.Set("page_type", pageType.Id)
 			}
+			previousType := field.GetString("type")
 
 			field.Set("key", fieldKey)
 			field.Set("label", getString(fieldData, "label"))
@@ -2635,6 +2821,9 @@ func importPageType(pb *pocketbase.PocketBase, site *core.Record, ptData Exporte
 				return err
 			}
 			matchedFieldIds[field.Id] = true
+			if err := migrateFieldEntries(pb, site.Id, field, previousType, migration, warnings, sourceFile); err != nil {
+				return err
+			}
 
 			fieldKeyToRecord[fieldKey] = field
 
@@ -2672,6 +2861,15 @@ func importPageType(pb *pocketbase.PocketBase, site *core.Record, ptData Exporte
 				return err
 			}
 		}
+
+		fields, err := pb.FindRecordsByFilter("page_type_fields", "page_type = {:pt}", "", 0, 0, dbx.Params{"pt": pageType.Id})
+		if err != nil {
+			return err
+		}
+		if err := resolveFieldConditions(pb, fields, fieldRenames(ptFields), warnings, sourceFile, ""); err != nil {
+			return err
+		}
+		reportConstraintRuleErrors(fields, warnings, sourceFile, "")
 	}
 
 	// Import page_type_symbols (allowed blocks on this page type).
@@ -2823,7 +3021,7 @@ func importPageType(pb *pocketbase.PocketBase, site *core.Record, ptData Exporte
 			// content, seed it from the block's content.yaml defaults so
 			// layout-mounted blocks behave like newly-added page sections.
 			if content := resolveLayoutContent(sectionData); content != nil {
-				if err := importPageTypeSectionContent(pb, section, symbolFields[symbolId], content); err != nil {
+				if err := importPageTypeSectionContent(pb, section, symbolFields[symbolId], content, warnings, sourceFile, fmt.Sprintf("%s[%d].content", section.GetString("zone"), i)); err != nil {
 					return err
 				}
 			}
@@ -2853,7 +3051,7 @@ func importPageType(pb *pocketbase.PocketBase, site *core.Record, ptData Exporte
 			// content, seed it from the block's content.yaml defaults so
 			// layout-mounted blocks behave like newly-added page sections.
 			if content := resolveLayoutContent(sectionData); content != nil {
-				if err := importPageTypeSectionContent(pb, section, symbolFields[symbolId], content); err != nil {
+				if err := importPageTypeSectionContent(pb, section, symbolFields[symbolId], content, warnings, sourceFile, fmt.Sprintf("%s[%d].content", section.GetString("zone"), i)); err != nil {
 					return err
 				}
 			}
@@ -2864,7 +3062,7 @@ func importPageType(pb *pocketbase.PocketBase, site *core.Record, ptData Exporte
 }
 
 // importPageTypeSectionContent imports content entries for a page type section from layout.yaml
-func importPageTypeSectionContent(pb *pocketbase.PocketBase, section *core.Record, fields []*core.Record, content map[string]interface{}) error {
+func importPageTypeSectionContent(pb *pocketbase.PocketBase, section *core.Record, fields []*core.Record, content map[string]interface{}, warnings *[]ImportWarning, sourceFile string, pathPrefix string) error {
 	entriesColl, err := pb.FindCollectionByNameOrId("page_type_section_entries")
 	if err != nil {
 		return err
@@ -2878,6 +3076,7 @@ func importPageTypeSectionContent(pb *pocketbase.PocketBase, section *core.Recor
 
 	// Build field lookup maps
 	fieldByKey := make(map[string]*core.Record)
+	fieldById := recordsById(fields)
 	fieldsByParent := make(map[string][]*core.Record)
 	for _, f := range fields {
 		fieldByKey[f.GetString("key")] = f
@@ -2891,6 +3090,7 @@ func importPageTypeSectionContent(pb *pocketbase.PocketBase, section *core.Recor
 		if !ok {
 			continue
 		}
+		checkContent(pb, field, value, content, fieldsByParent, fieldById, pathPrefix+"."+key, constraintWarnings(warnings, sourceFile, ""))
 		if err := importPageTypeSectionContentField(pb, entriesColl, section.Id, field, value, "", 0, fieldsByParent, fieldByKey); err != nil {
 			return err
 		}
@@ -2922,7 +3122,7 @@ func importPageTypeSectionContentField(pb *pocketbase.PocketBase, entriesColl *c
 			if parentEntryId != "" {
 				itemEntry.Set("parent", parentEntryId)
 			}
-			if err := pb.Save(itemEntry); err != nil {
+			if err := pb.SaveWithContext(keepContent(), itemEntry); err != nil {
 				return err
 			}
 
@@ -2949,8 +3149,8 @@ func importPageTypeSectionContentField(pb *pocketbase.PocketBase, entriesColl *c
 		if parentEntryId != "" {
 			groupEntry.Set("parent", parentEntryId)
 		}
-		groupEntry.Set("value", value)
-		if err := pb.Save(groupEntry); err != nil {
+		groupEntry.Set("value", storedEntryValue(value))
+		if err := pb.SaveWithContext(keepContent(), groupEntry); err != nil {
 			return err
 		}
 
@@ -2972,11 +3172,11 @@ func importPageTypeSectionContentField(pb *pocketbase.PocketBase, entriesColl *c
 		entry.Set("field", fieldId)
 		entry.Set("locale", "en")
 		entry.Set("index", index)
-		entry.Set("value", value)
+		entry.Set("value", storedEntryValue(value))
 		if parentEntryId != "" {
 			entry.Set("parent", parentEntryId)
 		}
-		if err := pb.Save(entry); err != nil {
+		if err := pb.SaveWithContext(keepContent(), entry); err != nil {
 			return err
 		}
 	}
@@ -2988,6 +3188,18 @@ func importPageTypeSectionContentField(pb *pocketbase.PocketBase, entriesColl *c
 
 // normalizeValueForStorage ensures values are properly typed before storing in PocketBase.
 // This prevents []byte values from being stored as arrays of numbers in JSON fields.
+// storedEntryValue prepares a value for an entry's JSON value column.
+// PocketBase parses plain strings that happen to be valid JSON ("3",
+// "true", "null"), so a text field holding such a string would come back
+// as a number or bool; strings are handed over pre-encoded instead.
+func storedEntryValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		raw, _ := json.Marshal(s)
+		return types.JSONRaw(raw)
+	}
+	return v
+}
+
 func normalizeValueForStorage(v interface{}) interface{} {
 	switch val := v.(type) {
 	case []byte:
@@ -3095,6 +3307,126 @@ func buildPagePathMap(pb *pocketbase.PocketBase, siteId string) (map[string]stri
 	return pathToId, nil
 }
 
+// sitePagesByPath is buildPagePathMap with the records, read through app so
+// it can run inside a transaction.
+func sitePagesByPath(app core.App, siteId string) (map[string]*core.Record, error) {
+	pages, err := app.FindRecordsByFilter("pages", "site = {:site}", "", 0, 0, dbx.Params{"site": siteId})
+	if err != nil {
+		return nil, err
+	}
+	parentMap := make(map[string]string, len(pages))
+	slugMap := make(map[string]string, len(pages))
+	for _, page := range pages {
+		parentMap[page.Id] = page.GetString("parent")
+		slugMap[page.Id] = page.GetString("slug")
+	}
+	byPath := make(map[string]*core.Record, len(pages))
+	for _, page := range pages {
+		byPath[buildFullPagePath(page.Id, parentMap, slugMap)] = page
+	}
+	return byPath, nil
+}
+
+// resolvePageTypeByName finds a page type by name, folder name or id,
+// falling back to fallbackId when none was asked for.
+func resolvePageTypeByName(pageTypes []*core.Record, name, fallbackId string) (*core.Record, error) {
+	for _, pageType := range pageTypes {
+		if name == "" && pageType.Id == fallbackId {
+			return pageType, nil
+		}
+		if name != "" && (pageType.GetString("name") == name || sanitizeFilename(pageType.GetString("name")) == name || pageType.Id == name) {
+			return pageType, nil
+		}
+	}
+	if name == "" && len(pageTypes) > 0 {
+		return pageTypes[0], nil
+	}
+	if name == "" {
+		return nil, fmt.Errorf("the site has no page types")
+	}
+	return nil, fmt.Errorf("page type %q does not exist", name)
+}
+
+// pageFieldWriter sets a page's top-level page type field values for the
+// importers that build pages from other formats (WordPress, Markdown),
+// updating existing page_entries in place.
+type pageFieldWriter struct {
+	app          core.App
+	page         *core.Record
+	fields       []*core.Record // top-level, in declaration order
+	fieldByKey   map[string]*core.Record
+	fieldById    map[string]*core.Record
+	children     map[string][]*core.Record // parent field id -> subfields
+	entryByField map[string]*core.Record
+	entries      *core.Collection
+}
+
+func newPageFieldWriter(app core.App, page *core.Record) (*pageFieldWriter, error) {
+	fields, err := app.FindRecordsByFilter("page_type_fields", "page_type = {:pt}", "+index", 0, 0, dbx.Params{"pt": page.GetString("page_type")})
+	if err != nil {
+		return nil, err
+	}
+	existing, err := app.FindRecordsByFilter("page_entries", "page = {:page}", "", 0, 0, dbx.Params{"page": page.Id})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := app.FindCollectionByNameOrId("page_entries")
+	if err != nil {
+		return nil, err
+	}
+
+	w := &pageFieldWriter{
+		app:          app,
+		page:         page,
+		fieldByKey:   make(map[string]*core.Record, len(fields)),
+		fieldById:    recordsById(fields),
+		children:     make(map[string][]*core.Record),
+		entryByField: make(map[string]*core.Record, len(existing)),
+		entries:      entries,
+	}
+	for _, field := range fields {
+		if parentId := field.GetString("parent"); parentId != "" {
+			w.children[parentId] = append(w.children[parentId], field)
+			continue
+		}
+		key := field.GetString("key")
+		if key == "" {
+			key = field.GetString("name")
+		}
+		w.fields = append(w.fields, field)
+		w.fieldByKey[key] = field
+	}
+	for _, entry := range existing {
+		w.entryByField[entry.GetString("field")] = entry
+	}
+	return w, nil
+}
+
+// field returns the top-level field with key, or nil.
+func (w *pageFieldWriter) field(key string) *core.Record {
+	return w.fieldByKey[key]
+}
+
+// check reports the parts of value that break field's constraints, each
+// at path. siblings is the page content value is set with, or nil to
+// leave field conditions unchecked.
+func (w *pageFieldWriter) check(field *core.Record, value interface{}, siblings map[string]interface{}, path string, report func(v *constraintViolation)) {
+	checkContent(w.app, field, value, siblings, w.children, w.fieldById, path, report)
+}
+
+func (w *pageFieldWriter) set(field *core.Record, value interface{}, pathToPageId map[string]string) error {
+	entry := w.entryByField[field.Id]
+	if entry == nil {
+		entry = core.NewRecord(w.entries)
+		entry.Set("page", w.page.Id)
+		entry.Set("field", field.Id)
+		entry.Set("locale", "en")
+		w.entryByField[field.Id] = entry
+	}
+	entry.Set("value", storedEntryValue(convertUrlsToPageRefs(value, pathToPageId)))
+	return w.app.SaveWithContext(keepContent(), entry)
+}
+
 // buildFullPagePath constructs the full URL path for a page by walking its parent hierarchy.
 // Returns path without leading/trailing slashes (e.g., "company/about", "" for homepage).
 func buildFullPagePath(pageId string, parentMap, slugMap map[string]string) string {
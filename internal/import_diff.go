@@ -0,0 +1,575 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"gopkg.in/yaml.v3"
+)
+
+// ImportItemDiff is the detailed change to one file of the archive, so a
+// preview can be reviewed like a pull request rather than as a list of
+// names. Which of the detail fields is set depends on the file: field
+// definitions for fields.yaml, content values (and section order) for
+// content and page files, a unified diff for component and head/foot
+// sources.
+type ImportItemDiff struct {
+	File     string              `json:"file"`
+	Status   string              `json:"status"` // "added" or "modified"
+	Fields   []ImportFieldChange `json:"fields,omitempty"`
+	Content  []ImportValueChange `json:"content,omitempty"`
+	Sections *ImportSectionOrder `json:"sections,omitempty"`
+	Source   string              `json:"source,omitempty"`
+}
+
+// ImportFieldChange is one field definition added, removed or modified.
+// Path is the dotted key path, with subfields under their parent
+// ("items.title"); Attributes lists what changed on a modified field.
+type ImportFieldChange struct {
+	Path       string              `json:"path"`
+	Change     string              `json:"change"`
+	Attributes []ImportValueChange `json:"attributes,omitempty"`
+}
+
+// ImportValueChange is one value that differs, with nil standing for "not
+// set" on either side.
+type ImportValueChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// ImportSectionOrder is reported when sections present both before and
+// after the import change position. Sections are labelled "block#_id".
+type ImportSectionOrder struct {
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+}
+
+// ImportOrphan is content that the import would delete because its field
+// definition is removed from fields.yaml (entries cascade with the field).
+type ImportOrphan struct {
+	File    string `json:"file"`
+	Field   string `json:"field"`
+	FieldID string `json:"field_id"`
+	Entries int    `json:"entries"`
+}
+
+// fieldEntryCollections maps each fields.yaml location, and the collection
+// its definitions are stored in, to the entry collections whose rows
+// reference those field records.
+var fieldEntryCollections = []struct {
+	Prefix  string
+	Fields  string
+	Entries []string
+}{
+	{"blocks/", "site_symbol_fields", []string{"site_symbol_entries", "page_section_entries", "page_type_section_entries"}},
+	{"page-types/", "page_type_fields", []string{"page_type_entries", "page_entries"}},
+	{"site/", "site_fields", []string{"site_entries"}},
+}
+
+func entryCollectionsForField(fieldsCollection string) []string {
+	for _, source := range fieldEntryCollections {
+		if source.Fields == fieldsCollection {
+			return source.Entries
+		}
+	}
+	return nil
+}
+
+// buildImportItemDiffs compares every tracked file in the archive with what
+// export renders for the site right now. renderSiteFiles leaves out the
+// uploads, the only part of export that can write, so a preview stays
+// read-only. It is only run for previews.
+func buildImportItemDiffs(pb *pocketbase.PocketBase, site *core.Record, files map[string][]byte) ([]ImportItemDiff, []ImportOrphan, error) {
+	current, err := renderSiteFiles(pb, site)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if revisionTracked(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	items := []ImportItemDiff{}
+	orphans := []ImportOrphan{}
+	for _, name := range names {
+		pushed := files[name]
+		existing, exists := current[name]
+		if exists && string(existing) == string(pushed) {
+			continue
+		}
+
+		item := ImportItemDiff{File: name, Status: "modified"}
+		if !exists {
+			item.Status = "added"
+		}
+
+		switch ext := path.Ext(name); {
+		case ext == ".yaml" || ext == ".yml" || ext == ".json":
+			var before, after interface{}
+			if err := yaml.Unmarshal(existing, &before); err != nil {
+				before = nil
+			}
+			if err := yaml.Unmarshal(pushed, &after); err != nil {
+				// The import itself reports the parse error; show the raw
+				// change so the preview still says something useful.
+				item.Source = unifiedDiff(name, string(existing), string(pushed))
+				break
+			}
+
+			switch {
+			case path.Base(name) == "fields.yaml":
+				item.Fields = diffFieldDefinitions(before, after)
+				for _, change := range item.Fields {
+					if change.Change != "removed" {
+						continue
+					}
+					fieldOrphans, err := countOrphanedEntries(pb, name, before, change.Path)
+					if err != nil {
+						return nil, nil, err
+					}
+					orphans = append(orphans, fieldOrphans...)
+				}
+			case strings.HasPrefix(name, "pages/"):
+				item.Content, item.Sections = diffPageFile(before, after)
+			default:
+				item.Content = diffValues("", before, after)
+			}
+		default:
+			item.Source = unifiedDiff(name, string(existing), string(pushed))
+		}
+
+		if item.Fields == nil && item.Content == nil && item.Sections == nil && item.Source == "" && exists {
+			// Only formatting differs (key order, quoting); nothing the
+			// import would change.
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return items, orphans, nil
+}
+
+// diffValues lists the leaf paths where two decoded YAML documents differ.
+// Lists of equal length are compared item by item; anything else that
+// differs is reported whole.
+func diffValues(prefix string, a, b interface{}) []ImportValueChange {
+	var changes []ImportValueChange
+
+	mapA, okA := a.(map[string]interface{})
+	mapB, okB := b.(map[string]interface{})
+	if okA && okB {
+		for _, key := range unionKeys(mapA, mapB) {
+			child := key
+			if prefix != "" {
+				child = prefix + "." + key
+			}
+			changes = append(changes, diffValues(child, mapA[key], mapB[key])...)
+		}
+		return changes
+	}
+
+	listA, okA := a.([]interface{})
+	listB, okB := b.([]interface{})
+	if okA && okB && len(listA) == len(listB) {
+		for i := range listA {
+			changes = append(changes, diffValues(fmt.Sprintf("%s[%d]", prefix, i), listA[i], listB[i])...)
+		}
+		return changes
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		changes = append(changes, ImportValueChange{Path: prefix, Before: a, After: b})
+	}
+	return changes
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	seen := map[string]bool{}
+	for _, m := range []map[string]interface{}{a, b} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// flattenFieldDefinitions indexes a fields.yaml list by dotted name path,
+// descending into subfields.
+func flattenFieldDefinitions(prefix string, value interface{}, out map[string]map[string]interface{}, order *[]string) {
+	list, _ := value.([]interface{})
+	for _, item := range list {
+		field, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := field["name"].(string)
+		if name == "" {
+			name, _ = field["key"].(string)
+		}
+		if name == "" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		definition := make(map[string]interface{}, len(field))
+		for k, v := range field {
+			if k != "subfields" {
+				definition[k] = v
+			}
+		}
+		out[key] = definition
+		*order = append(*order, key)
+		flattenFieldDefinitions(key, field["subfields"], out, order)
+	}
+}
+
+// diffFieldDefinitions compares two fields.yaml documents. A field that
+// keeps its _id under a new name is reported as modified (its name
+// attribute changes) rather than as a removal plus an addition.
+func diffFieldDefinitions(before, after interface{}) []ImportFieldChange {
+	oldDefs, newDefs := map[string]map[string]interface{}{}, map[string]map[string]interface{}{}
+	var oldOrder, newOrder []string
+	flattenFieldDefinitions("", before, oldDefs, &oldOrder)
+	flattenFieldDefinitions("", after, newDefs, &newOrder)
+
+	newById := map[string]string{}
+	newByRenamed := map[string]string{}
+	for key, def := range newDefs {
+		if id, _ := def["_id"].(string); id != "" {
+			newById[id] = key
+		}
+		if old := readFieldMigration(def).RenamedFrom; old != "" {
+			newByRenamed[path.Join(path.Dir(strings.ReplaceAll(key, ".", "/")), old)] = key
+		}
+	}
+
+	changes := []ImportFieldChange{}
+	matched := map[string]bool{}
+	for _, key := range oldOrder {
+		oldDef := oldDefs[key]
+		newKey := key
+		if id, _ := oldDef["_id"].(string); id != "" && newById[id] != "" {
+			newKey = newById[id]
+		} else if renamed, ok := newByRenamed[strings.ReplaceAll(key, ".", "/")]; ok && newDefs[key] == nil {
+			newKey = renamed
+		}
+		newDef, ok := newDefs[newKey]
+		if !ok {
+			changes = append(changes, ImportFieldChange{Path: key, Change: "removed"})
+			continue
+		}
+		matched[newKey] = true
+
+		// _id is bookkeeping; an archive written by hand may simply omit it.
+		oldCmp, newCmp := withoutKey(oldDef, "_id"), withoutKey(newDef, "_id")
+		if attributes := diffValues("", oldCmp, newCmp); len(attributes) > 0 {
+			changes = append(changes, ImportFieldChange{Path: newKey, Change: "modified", Attributes: attributes})
+		}
+	}
+	for _, key := range newOrder {
+		if !matched[key] {
+			changes = append(changes, ImportFieldChange{Path: key, Change: "added"})
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func withoutKey(m map[string]interface{}, key string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// diffPageFile compares two page files. Sections are matched by _id (then
+// by position for sections without one), so moving a section shows up as a
+// reorder instead of every section's content changing.
+func diffPageFile(before, after interface{}) ([]ImportValueChange, *ImportSectionOrder) {
+	oldPage, _ := before.(map[string]interface{})
+	newPage, _ := after.(map[string]interface{})
+	oldSections, _ := oldPage["sections"].([]interface{})
+	newSections, _ := newPage["sections"].([]interface{})
+
+	changes := diffValues("", withoutKey(oldPage, "sections"), withoutKey(newPage, "sections"))
+
+	oldByLabel := map[string]interface{}{}
+	var oldLabels, newLabels []string
+	for i, section := range oldSections {
+		label := sectionLabel(section, i)
+		oldByLabel[label] = section
+		oldLabels = append(oldLabels, label)
+	}
+	newSet := map[string]bool{}
+	for i, section := range newSections {
+		label := sectionLabel(section, i)
+		newSet[label] = true
+		newLabels = append(newLabels, label)
+
+		previous, existed := oldByLabel[label]
+		if !existed {
+			changes = append(changes, ImportValueChange{Path: fmt.Sprintf("sections[%d]", i), After: section})
+			continue
+		}
+		changes = append(changes, diffValues(fmt.Sprintf("sections[%d]", i), previous, section)...)
+	}
+	for _, label := range oldLabels {
+		if !newSet[label] {
+			changes = append(changes, ImportValueChange{Path: "sections[" + label + "]", Before: oldByLabel[label]})
+		}
+	}
+
+	var order *ImportSectionOrder
+	var keptOld, keptNew []string
+	for _, label := range oldLabels {
+		if newSet[label] {
+			keptOld = append(keptOld, label)
+		}
+	}
+	for _, label := range newLabels {
+		if _, ok := oldByLabel[label]; ok {
+			keptNew = append(keptNew, label)
+		}
+	}
+	if !reflect.DeepEqual(keptOld, keptNew) {
+		order = &ImportSectionOrder{Before: oldLabels, After: newLabels}
+	}
+
+	if len(changes) == 0 {
+		changes = nil
+	}
+	return changes, order
+}
+
+func sectionLabel(section interface{}, index int) string {
+	m, _ := section.(map[string]interface{})
+	block, _ := m["block"].(string)
+	if id, _ := m["_id"].(string); id != "" {
+		return block + "#" + id
+	}
+	return fmt.Sprintf("%s@%d", block, index)
+}
+
+// countOrphanedEntries finds the field records behind a removed fields.yaml
+// path (and its subfields, which cascade with it) and counts the entries
+// the import would delete along with them.
+func countOrphanedEntries(pb *pocketbase.PocketBase, file string, before interface{}, fieldPath string) ([]ImportOrphan, error) {
+	defs := map[string]map[string]interface{}{}
+	var order []string
+	flattenFieldDefinitions("", before, defs, &order)
+
+	var collections []string
+	for _, source := range fieldEntryCollections {
+		if strings.HasPrefix(file, source.Prefix) {
+			collections = source.Entries
+		}
+	}
+
+	var orphans []ImportOrphan
+	for _, key := range order {
+		if key != fieldPath && !strings.HasPrefix(key, fieldPath+".") {
+			continue
+		}
+		id, _ := defs[key]["_id"].(string)
+		if id == "" {
+			continue
+		}
+
+		count := 0
+		for _, collection := range collections {
+			n, err := pb.CountRecords(collection, dbx.HashExp{"field": id})
+			if err != nil {
+				return nil, fmt.Errorf("failed to count %s: %w", collection, err)
+			}
+			count += int(n)
+		}
+		if count > 0 {
+			orphans = append(orphans, ImportOrphan{File: file, Field: key, FieldID: id, Entries: count})
+		}
+	}
+	return orphans, nil
+}
+
+// unifiedDiff renders a line-based unified diff with three lines of
+// context, the format reviewers already read in pull requests.
+func unifiedDiff(name, before, after string) string {
+	a := splitDiffLines(before)
+	b := splitDiffLines(after)
+
+	// Longest common subsequence table. Components and head/foot files
+	// are small; anything pathological is shown as a full replacement.
+	if len(a)*len(b) > 4_000_000 {
+		return formatDiffHunks(name, a, b, []diffOp{{kind: '-', a: 0, b: 0, n: len(a)}, {kind: '+', a: len(a), b: 0, n: len(b)}})
+	}
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	push := func(kind byte, i, j int) {
+		if n := len(ops); n > 0 && ops[n-1].kind == kind {
+			ops[n-1].n++
+			return
+		}
+		ops = append(ops, diffOp{kind: kind, a: i, b: j, n: 1})
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			push(' ', i, j)
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			push('-', i, j)
+			i++
+		default:
+			push('+', i, j)
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		push('-', i, j)
+	}
+	for ; j < len(b); j++ {
+		push('+', i, j)
+	}
+
+	return formatDiffHunks(name, a, b, ops)
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	a, b int  // start line in before / after
+	n    int
+}
+
+func splitDiffLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func formatDiffHunks(name string, a, b []string, ops []diffOp) string {
+	const context = 3
+
+	// Expand ops into individual lines, then cut hunks around changes.
+	type line struct {
+		kind byte
+		text string
+		a, b int
+	}
+	var lines []line
+	for _, op := range ops {
+		for k := 0; k < op.n; k++ {
+			switch op.kind {
+			case ' ':
+				lines = append(lines, line{' ', a[op.a+k], op.a + k, op.b + k})
+			case '-':
+				lines = append(lines, line{'-', a[op.a+k], op.a + k, op.b})
+			case '+':
+				lines = append(lines, line{'+', b[op.b+k], op.a, op.b + k})
+			}
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+
+	for start := 0; start < len(lines); {
+		// Find the next change.
+		first := start
+		for first < len(lines) && lines[first].kind == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+
+		// Extend the hunk while changes are within 2*context lines.
+		last := first
+		for k := first; k < len(lines); k++ {
+			if lines[k].kind != ' ' {
+				last = k
+			} else if k-last > 2*context {
+				break
+			}
+		}
+
+		from := max(first-context, start)
+		to := min(last+context+1, len(lines))
+
+		oldStart, newStart, oldCount, newCount := lines[from].a, lines[from].b, 0, 0
+		for _, l := range lines[from:to] {
+			if l.kind != '+' {
+				oldCount++
+			}
+			if l.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, l := range lines[from:to] {
+			out.WriteByte(l.kind)
+			out.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = to
+	}
+
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"gopkg.in/yaml.v3"
+)
+
+func TestImportPreviewNeverWrites(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+	project := map[string]string{
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "- name: title\n  type: text\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: Default\ncontent:\n  title: Before\nsections: []\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	upload, err := createSiteUpload(app, fsys, site, "legacy.txt", []byte("legacy bytes"), siteUploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.DB().Update("site_uploads", dbx.Params{"hash": ""}, dbx.HashExp{"id": upload.Id}).Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := func() map[string]string {
+		t.Helper()
+		state := map[string]string{}
+		for _, collection := range []string{"sites", "pages", "page_entries", "page_type_fields", "site_uploads"} {
+			var rows []struct {
+				ID      string `db:"id"`
+				Updated string `db:"updated"`
+			}
+			if err := app.DB().Select("id", "updated").From(collection).All(&rows); err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range rows {
+				state[collection+"/"+row.ID] = row.Updated
+			}
+		}
+		record, err := app.FindRecordById("site_uploads", upload.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state["hash"] = record.GetString("hash")
+		return state
+	}
+	before := snapshot()
+
+	// A pulled archive (revisions and uploads manifest included) with an
+	// edited page, previewed only.
+	pushed := readImportTestFiles(t, exportSiteBytes(t, app, site, ExportOptions{}))
+	pushed["pages/index.yaml"] = []byte(strings.Replace(string(pushed["pages/index.yaml"]), "Before", "After", 1))
+	archive := map[string]string{}
+	for name, data := range pushed {
+		archive[name] = string(data)
+	}
+	result, err := processImport(app, site, zipFiles(t, archive), true)
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if len(result.Diff.Items) != 1 || result.Diff.Items[0].File != "pages/index.yaml" {
+		t.Fatalf("expected the page edit previewed, got %#v", result.Diff.Items)
+	}
+	if after := snapshot(); !reflect.DeepEqual(before, after) {
+		t.Fatalf("expected the preview to write nothing\nbefore: %v\nafter:  %v", before, after)
+	}
+}
+
+func TestImportPreviewReportsFieldLevelDiff(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	files := map[string]string{
+		"blocks/hero/config.yaml":      "name: hero\n",
+		"blocks/hero/component.svelte": "<section>\n  <h1>{heading}</h1>\n</section>\n",
+		"blocks/hero/fields.yaml": "" +
+			"- name: heading\n" +
+			"  label: Heading\n" +
+			"  type: text\n" +
+			"- name: tagline\n" +
+			"  label: Tagline\n" +
+			"  type: text\n",
+		"blocks/hero/content.yaml":       "{}\n",
+		"page-types/default/config.yaml": "name: Default\nallowed_blocks:\n  - hero\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml": "" +
+			"name: Home\n" +
+			"page_type: Default\n" +
+			"sections:\n" +
+			"  - block: hero\n" +
+			"    content:\n" +
+			"      heading: First\n" +
+			"      tagline: One\n" +
+			"  - block: hero\n" +
+			"    content:\n" +
+			"      heading: Second\n",
+		"site/fields.yaml":  "[]\n",
+		"site/content.yaml": "{}\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, files), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	exported := exportSiteBytes(t, app, site, ExportOptions{})
+	pageYAML := readZipFile(t, exported, "pages/index.yaml")
+	var page map[string]interface{}
+	if err := yaml.Unmarshal([]byte(pageYAML), &page); err != nil {
+		t.Fatalf("parse page: %v", err)
+	}
+	sections := page["sections"].([]interface{})
+	sections[0], sections[1] = sections[1], sections[0]
+	sections[0].(map[string]interface{})["content"].(map[string]interface{})["heading"] = "Second, edited"
+	editedPage, err := yaml.Marshal(page)
+	if err != nil {
+		t.Fatalf("marshal page: %v", err)
+	}
+
+	pushed := map[string]string{
+		"blocks/hero/config.yaml":      readZipFile(t, exported, "blocks/hero/config.yaml"),
+		"blocks/hero/component.svelte": "<section>\n  <h2>{heading}</h2>\n</section>\n",
+		"blocks/hero/fields.yaml":      "- name: heading\n  label: Title\n  type: text\n",
+		"pages/index.yaml":             string(editedPage),
+	}
+	result, err := processImport(app, site, zipFiles(t, pushed), true)
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+
+	items := map[string]ImportItemDiff{}
+	for _, item := range result.Diff.Items {
+		items[item.File] = item
+	}
+	if _, ok := items["blocks/hero/config.yaml"]; ok {
+		t.Fatalf("unchanged file reported: %#v", items["blocks/hero/config.yaml"])
+	}
+
+	source := items["blocks/hero/component.svelte"].Source
+	if !strings.Contains(source, "-  <h1>{heading}</h1>\n+  <h2>{heading}</h2>\n") {
+		t.Fatalf("expected unified diff of the component, got:\n%s", source)
+	}
+
+	fields := items["blocks/hero/fields.yaml"].Fields
+	if len(fields) != 2 || fields[0].Path != "heading" || fields[0].Change != "modified" || fields[1].Path != "tagline" || fields[1].Change != "removed" {
+		t.Fatalf("unexpected field changes: %#v", fields)
+	}
+	if attrs := fields[0].Attributes; len(attrs) != 1 || attrs[0].Path != "label" || attrs[0].After != "Title" {
+		t.Fatalf("unexpected heading attribute changes: %#v", attrs)
+	}
+
+	pageDiff := items["pages/index.yaml"]
+	if pageDiff.Sections == nil {
+		t.Fatal("expected the section reorder to be reported")
+	}
+	if len(pageDiff.Content) != 1 || pageDiff.Content[0].Path != "sections[0].content.heading" || pageDiff.Content[0].After != "Second, edited" {
+		t.Fatalf("unexpected content changes: %#v", pageDiff.Content)
+	}
+
+	if len(result.Diff.Orphaned) != 1 || result.Diff.Orphaned[0].Field != "tagline" || result.Diff.Orphaned[0].Entries != 1 {
+		t.Fatalf("expected the tagline entry to be reported as orphaned, got %#v", result.Diff.Orphaned)
+	}
+}
@@ -619,7 +619,11 @@ func importLibraryBlockFields(pb *pocketbase.PocketBase, symbol *core.Record, ne
 		}
 	}
 
-	return nil
+	fieldsAfter, err := pb.FindRecordsByFilter("library_symbol_fields", "symbol = {:symbol}", "", 0, 0, dbx.Params{"symbol": symbol.Id})
+	if err != nil {
+		return err
+	}
+	return resolveFieldConditions(pb, fieldsAfter, fieldRenames(nestedFields), nil, "", "")
 }
 
 func importLibraryBlockContent(pb *pocketbase.PocketBase, symbol *core.Record, data []byte) error {
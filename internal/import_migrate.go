@@ -0,0 +1,459 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"gopkg.in/yaml.v3"
+)
+
+// Fields in fields.yaml may carry a migrate section telling the import how
+// the field relates to what is already stored, so a schema refactor keeps
+// editor content instead of deleting the old field and adding a new one:
+//
+//   - name: summary
+//     label: Summary
+//     type: markdown
+//     migrate:
+//     renamed_from: intro    # reuse the field (and its entries) stored as "intro"
+//     from_type: text        # convert existing values from text to markdown
+//
+// Both hints are no-ops once applied (the field is then stored under the new
+// key and type), so they can stay in the file until every environment has
+// been pushed.
+type fieldMigration struct {
+	RenamedFrom string
+	FromType    string
+}
+
+func readFieldMigration(fieldData map[string]interface{}) fieldMigration {
+	migrate, _ := fieldData["migrate"].(map[string]interface{})
+	return fieldMigration{
+		RenamedFrom: getString(migrate, "renamed_from"),
+		FromType:    getString(migrate, "from_type"),
+	}
+}
+
+// findRenamedField returns the stored field a renamed_from hint points at,
+// or nil when there is no hint, the old key is gone, or the record has
+// already been claimed by another entry in the file.
+func findRenamedField(existingByKey map[string]*core.Record, oldKey string, matched map[string]bool) *core.Record {
+	if oldKey == "" {
+		return nil
+	}
+	field, ok := existingByKey[oldKey]
+	if !ok || matched[field.Id] {
+		return nil
+	}
+	return field
+}
+
+// fieldValueConverters rewrite stored entry values when a field's type
+// changes, keyed by "<from>><to>". Conversions are lossless in the
+// direction offered; anything else has to be migrated by hand.
+var fieldValueConverters = map[string]func(value interface{}, pagePaths map[string]string) (interface{}, bool){
+	// Plain text is already valid markdown. Escaping it would litter
+	// ordinary punctuation with backslashes for little gain.
+	"text>markdown": func(value interface{}, _ map[string]string) (interface{}, bool) {
+		s, ok := value.(string)
+		return s, ok
+	},
+	"text>rich-text": func(value interface{}, _ map[string]string) (interface{}, bool) {
+		s, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		return textToRichText(s), true
+	},
+	"number>text": func(value interface{}, _ map[string]string) (interface{}, bool) {
+		switch v := value.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case int:
+			return strconv.Itoa(v), true
+		case string:
+			return v, true
+		case nil:
+			return "", true
+		}
+		return nil, false
+	},
+	// A link that points at a page keeps working as a url by resolving the
+	// page's current path; the label has nowhere to go and is dropped.
+	"link>url": func(value interface{}, pagePaths map[string]string) (interface{}, bool) {
+		link, ok := value.(map[string]interface{})
+		if !ok {
+			s, isString := value.(string)
+			return s, isString
+		}
+		if url := getString(link, "url"); url != "" {
+			return url, true
+		}
+		if page := getString(link, "page"); page != "" {
+			if pagePath, found := pagePaths[page]; found {
+				return "/" + pagePath, true
+			}
+		}
+		return "", true
+	},
+}
+
+// textToRichText builds the TipTap document the rich-text editor stores:
+// one paragraph per blank-line separated block, with single newlines kept
+// as hard breaks.
+func textToRichText(text string) map[string]interface{} {
+	paragraphs := []interface{}{}
+	for _, block := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		block = strings.Trim(block, "\n")
+		if block == "" {
+			continue
+		}
+		content := []interface{}{}
+		for i, line := range strings.Split(block, "\n") {
+			if i > 0 {
+				content = append(content, map[string]interface{}{"type": "hardBreak"})
+			}
+			if line != "" {
+				content = append(content, map[string]interface{}{"type": "text", "text": line})
+			}
+		}
+		paragraphs = append(paragraphs, map[string]interface{}{"type": "paragraph", "content": content})
+	}
+	if len(paragraphs) == 0 {
+		paragraphs = append(paragraphs, map[string]interface{}{"type": "paragraph"})
+	}
+	return map[string]interface{}{"type": "doc", "content": paragraphs}
+}
+
+// migrateFieldEntries converts the stored values of field after its type
+// changed from previousType, if the fields.yaml entry asked for it with
+// migrate.from_type. A type change without the hint leaves the values as
+// they were, same as before hints existed.
+func migrateFieldEntries(pb *pocketbase.PocketBase, siteId string, field *core.Record, previousType string, migration fieldMigration, warnings *[]ImportWarning, sourceFile string) error {
+	newType := field.GetString("type")
+	if migration.FromType == "" || previousType != migration.FromType || previousType == newType {
+		return nil
+	}
+
+	key := field.GetString("key")
+	convert, ok := fieldValueConverters[previousType+">"+newType]
+	if !ok {
+		if warnings != nil {
+			*warnings = append(*warnings, ImportWarning{
+				Kind:    "unsupported_field_migration",
+				File:    sourceFile,
+				Path:    key + ".migrate.from_type",
+				Field:   key,
+				Message: fmt.Sprintf("field %q changed type from %s to %s, which has no converter; existing content was left as is", key, previousType, newType),
+			})
+		}
+		return nil
+	}
+
+	pagePaths := map[string]string{}
+	if pathToPageId, err := buildPagePathMap(pb, siteId); err == nil {
+		for pagePath, pageId := range pathToPageId {
+			pagePaths[pageId] = pagePath
+		}
+	}
+
+	for _, collection := range entryCollectionsForField(field.Collection().Name) {
+		entries, err := pb.FindRecordsByFilter(collection, "field = {:field}", "", 0, 0, dbx.Params{"field": field.Id})
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", collection, err)
+		}
+		for _, entry := range entries {
+			var value interface{}
+			if err := entry.UnmarshalJSONField("value", &value); err != nil {
+				value = nil
+			}
+			converted, ok := convert(value, pagePaths)
+			if !ok {
+				if warnings != nil {
+					*warnings = append(*warnings, ImportWarning{
+						Kind:    "unsupported_field_migration",
+						File:    sourceFile,
+						Path:    key,
+						Field:   key,
+						Message: fmt.Sprintf("a %s value of field %q could not be converted to %s and was left as is (%s %s)", previousType, key, newType, collection, entry.Id),
+					})
+				}
+				continue
+			}
+			entry.Set("value", storedEntryValue(converted))
+			if err := pb.SaveWithContext(keepContent(), entry); err != nil {
+				return fmt.Errorf("failed to migrate %s %s: %w", collection, entry.Id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fieldRename is a field's migrate section located within a field tree.
+// parent is the chain of (new) keys leading to the field's parent, empty at
+// the top. from equals to when the field only changes type.
+type fieldRename struct {
+	parent   []string
+	from     string
+	to       string
+	fromType string
+	toType   string
+}
+
+// collectFieldRenames finds the migrate hints in a fields.yaml list.
+// Subfields may be nested (block fields) or flat with a parent key (page
+// type fields); both are handled. Renames are ordered parents first, so by
+// the time a child is renamed its parent is already under its new key.
+func collectFieldRenames(fields []interface{}) []fieldRename {
+	var renames []fieldRename
+	parentOf := map[string]string{}
+
+	var walk func(list []interface{}, parent []string)
+	walk = func(list []interface{}, parent []string) {
+		for _, entry := range list {
+			fieldData, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			key := getString(fieldData, "name")
+			if key == "" {
+				continue
+			}
+			if p := getString(fieldData, "parent"); p != "" && len(parent) == 0 {
+				parentOf[key] = p
+			}
+			migration := readFieldMigration(fieldData)
+			if migration.RenamedFrom != "" || migration.FromType != "" {
+				from := migration.RenamedFrom
+				if from == "" {
+					from = key
+				}
+				renames = append(renames, fieldRename{
+					parent:   parent,
+					from:     from,
+					to:       key,
+					fromType: migration.FromType,
+					toType:   getString(fieldData, "type"),
+				})
+			}
+			if subfields, ok := fieldData["subfields"].([]interface{}); ok {
+				walk(subfields, append(append([]string{}, parent...), key))
+			}
+		}
+	}
+	walk(fields, nil)
+
+	// Resolve flat parent keys into chains.
+	for i, rename := range renames {
+		if len(rename.parent) > 0 {
+			continue
+		}
+		var chain []string
+		seen := map[string]bool{}
+		for p := parentOf[rename.to]; p != "" && !seen[p]; p = parentOf[p] {
+			seen[p] = true
+			chain = append([]string{p}, chain...)
+		}
+		renames[i].parent = chain
+	}
+
+	sort.SliceStable(renames, func(i, j int) bool { return len(renames[i].parent) < len(renames[j].parent) })
+	return renames
+}
+
+// fieldRenames maps the old path of each field renamed in a fields.yaml
+// list to its new key. A path is the keys from the top joined by "/" (as
+// "items/label"), with parents under their new keys.
+func fieldRenames(fields []interface{}) map[string]string {
+	renames := map[string]string{}
+	for _, rename := range collectFieldRenames(fields) {
+		if rename.from != rename.to {
+			renames[strings.Join(append(append([]string{}, rename.parent...), rename.from), "/")] = rename.to
+		}
+	}
+	return renames
+}
+
+// renameContentKeys applies renames to a content map as found in
+// content.yaml and the content of page sections. Group values are maps and
+// repeater values lists of maps; both are followed. A key is only moved
+// when the new key isn't already present, so content that was written for
+// the new schema is never overwritten. Values still in the shape of the old
+// type are converted the same way stored entries are; the converters leave
+// values already in the new shape alone.
+func renameContentKeys(content map[string]interface{}, renames []fieldRename) bool {
+	changed := false
+	for _, rename := range renames {
+		for _, target := range contentMapsAt(content, rename.parent) {
+			if value, ok := target[rename.from]; ok && rename.from != rename.to {
+				if _, exists := target[rename.to]; !exists {
+					target[rename.to] = value
+					delete(target, rename.from)
+					changed = true
+				}
+			}
+
+			convert, ok := fieldValueConverters[rename.fromType+">"+rename.toType]
+			if !ok || rename.fromType == rename.toType {
+				continue
+			}
+			if value, exists := target[rename.to]; exists {
+				if converted, ok := convert(value, nil); ok && !reflect.DeepEqual(converted, value) {
+					target[rename.to] = converted
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+func contentMapsAt(content map[string]interface{}, parent []string) []map[string]interface{} {
+	if len(parent) == 0 {
+		return []map[string]interface{}{content}
+	}
+	var maps []map[string]interface{}
+	switch child := content[parent[0]].(type) {
+	case map[string]interface{}:
+		maps = append(maps, contentMapsAt(child, parent[1:])...)
+	case []interface{}:
+		for _, item := range child {
+			if m, ok := item.(map[string]interface{}); ok {
+				maps = append(maps, contentMapsAt(m, parent[1:])...)
+			}
+		}
+	}
+	return maps
+}
+
+// applyFieldRenamesToFiles moves content written against the old keys onto
+// the renamed fields (and converts it, for type changes) before anything is
+// imported. Page files and block
+// defaults pushed alongside a renamed fields.yaml are usually still the
+// ones pulled before the rename; without this their content would land on
+// keys the schema no longer has and be dropped as orphaned.
+func applyFieldRenamesToFiles(files map[string][]byte) error {
+	blockRenames := map[string][]fieldRename{}
+	pageTypeRenames := map[string][]fieldRename{}
+
+	collect := func(dir string, target map[string][]fieldRename) error {
+		for _, folder := range findDirectories(files, dir+"/") {
+			fieldsPath := fmt.Sprintf("%s/%s/fields.yaml", dir, folder)
+			data, ok := files[fieldsPath]
+			if !ok {
+				continue
+			}
+			fields, err := parseBareFieldList(data, fieldsPath)
+			if err != nil {
+				return err
+			}
+			renames := collectFieldRenames(fields)
+			if len(renames) == 0 {
+				continue
+			}
+			names := []string{folder}
+			var config struct {
+				Name string `yaml:"name"`
+			}
+			if yaml.Unmarshal(files[fmt.Sprintf("%s/%s/config.yaml", dir, folder)], &config) == nil && config.Name != "" {
+				names = append(names, config.Name, sanitizeFilename(config.Name))
+			}
+			for _, name := range names {
+				target[name] = renames
+				target[strings.ToLower(name)] = renames
+			}
+		}
+		return nil
+	}
+	if err := collect("blocks", blockRenames); err != nil {
+		return err
+	}
+	if err := collect("page-types", pageTypeRenames); err != nil {
+		return err
+	}
+	if len(blockRenames) == 0 && len(pageTypeRenames) == 0 {
+		return nil
+	}
+
+	lookup := func(renames map[string][]fieldRename, name string) []fieldRename {
+		if r, ok := renames[name]; ok {
+			return r
+		}
+		return renames[strings.ToLower(name)]
+	}
+	renameSections := func(sections []interface{}) bool {
+		changed := false
+		for _, section := range sections {
+			sectionMap, ok := section.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			content, ok := sectionMap["content"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if renameContentKeys(content, lookup(blockRenames, getString(sectionMap, "block"))) {
+				changed = true
+			}
+		}
+		return changed
+	}
+
+	for name, data := range files {
+		var doc map[string]interface{}
+		changed := false
+
+		switch {
+		case strings.HasPrefix(name, "blocks/") && path.Base(name) == "content.yaml":
+			folder := strings.Split(name, "/")[1]
+			renames := lookup(blockRenames, folder)
+			if len(renames) == 0 || yaml.Unmarshal(data, &doc) != nil || doc == nil {
+				continue
+			}
+			changed = renameContentKeys(doc, renames)
+
+		case strings.HasPrefix(name, "page-types/") && path.Base(name) == "layout.yaml":
+			if yaml.Unmarshal(data, &doc) != nil || doc == nil {
+				continue
+			}
+			for _, region := range []string{"header", "footer"} {
+				if sections, ok := doc[region].([]interface{}); ok && renameSections(sections) {
+					changed = true
+				}
+			}
+
+		case strings.HasPrefix(name, "pages/") && (strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")):
+			if yaml.Unmarshal(data, &doc) != nil || doc == nil {
+				continue
+			}
+			if sections, ok := doc["sections"].([]interface{}); ok && renameSections(sections) {
+				changed = true
+			}
+			renames := lookup(pageTypeRenames, getString(doc, "page_type"))
+			for _, key := range []string{"content", "fields"} {
+				if content, ok := doc[key].(map[string]interface{}); ok && renameContentKeys(content, renames) {
+					changed = true
+				}
+			}
+		}
+
+		if !changed {
+			continue
+		}
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite %s: %w", name, err)
+		}
+		files[name] = out
+	}
+
+	return nil
+}
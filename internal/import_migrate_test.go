@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImportFieldMigrationHintsKeepContent(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	files := map[string]string{
+		"blocks/hero/config.yaml":      "name: hero\n",
+		"blocks/hero/component.svelte": "<section>{intro}</section>\n",
+		"blocks/hero/fields.yaml": "" +
+			"- name: intro\n" +
+			"  label: Intro\n" +
+			"  type: text\n" +
+			"- name: count\n" +
+			"  label: Count\n" +
+			"  type: number\n",
+		"blocks/hero/content.yaml":       "{}\n",
+		"page-types/default/config.yaml": "name: Default\nallowed_blocks:\n  - hero\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml": "" +
+			"name: Home\n" +
+			"page_type: Default\n" +
+			"sections:\n" +
+			"  - block: hero\n" +
+			"    content:\n" +
+			"      intro: |-\n" +
+			"        Hello\n" +
+			"\n" +
+			"        World\n" +
+			"      count: 3\n",
+		"site/fields.yaml":  "[]\n",
+		"site/content.yaml": "{}\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, files), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	intro, err := app.FindFirstRecordByData("site_symbol_fields", "key", "intro")
+	if err != nil {
+		t.Fatalf("find intro field: %v", err)
+	}
+
+	// Rename and retype, pushing the page file as it was pulled before the
+	// schema change.
+	files["blocks/hero/fields.yaml"] = "" +
+		"- name: summary\n" +
+		"  label: Summary\n" +
+		"  type: rich-text\n" +
+		"  migrate:\n" +
+		"    renamed_from: intro\n" +
+		"    from_type: text\n" +
+		"- name: count\n" +
+		"  label: Count\n" +
+		"  type: text\n" +
+		"  migrate:\n" +
+		"    from_type: number\n"
+	result, err := processImport(app, site, zipFiles(t, files), false)
+	if err != nil {
+		t.Fatalf("migrating import: %v", err)
+	}
+	for _, warning := range result.Warnings {
+		if warning.Kind == "orphaned_field" {
+			t.Fatalf("content was orphaned: %#v", warning)
+		}
+	}
+
+	summary, err := app.FindRecordById("site_symbol_fields", intro.Id)
+	if err != nil {
+		t.Fatalf("expected the intro field record to be reused: %v", err)
+	}
+	if summary.GetString("key") != "summary" || summary.GetString("type") != "rich-text" {
+		t.Fatalf("field not migrated: key=%q type=%q", summary.GetString("key"), summary.GetString("type"))
+	}
+
+	entry, err := app.FindFirstRecordByData("page_section_entries", "field", summary.Id)
+	if err != nil {
+		t.Fatalf("expected section content to survive the rename: %v", err)
+	}
+	var doc map[string]interface{}
+	if err := entry.UnmarshalJSONField("value", &doc); err != nil {
+		t.Fatalf("decode rich text: %v", err)
+	}
+	if want := textToRichText("Hello\n\nWorld"); !reflect.DeepEqual(normalizeValue(doc), normalizeValue(want)) || len(doc["content"].([]interface{})) != 2 {
+		t.Fatalf("unexpected rich text value: %#v", doc)
+	}
+
+	count, err := app.FindFirstRecordByData("site_symbol_fields", "key", "count")
+	if err != nil {
+		t.Fatalf("find count field: %v", err)
+	}
+	countEntry, err := app.FindFirstRecordByData("page_section_entries", "field", count.Id)
+	if err != nil {
+		t.Fatalf("find count entry: %v", err)
+	}
+	var countValue interface{}
+	if err := countEntry.UnmarshalJSONField("value", &countValue); err != nil {
+		t.Fatalf("decode count: %v", err)
+	}
+	if countValue != "3" {
+		t.Fatalf("expected number to be converted to text, got %#v", countValue)
+	}
+}
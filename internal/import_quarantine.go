@@ -0,0 +1,486 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// orphanedContent is a value the import could not place because the
+// schema had no field for it. target is the dotted path from the section's
+// (or page's) content, e.g. "items[1].subtitle".
+type orphanedContent struct {
+	page    string
+	section string
+	target  string
+	value   interface{}
+}
+
+// QuarantinedContent is an import_quarantine row as the API returns it.
+type QuarantinedContent struct {
+	ID      string      `json:"id"`
+	Kind    string      `json:"kind"`
+	File    string      `json:"file"`
+	Path    string      `json:"path"`
+	Block   string      `json:"block,omitempty"`
+	Page    string      `json:"page,omitempty"`
+	Section string      `json:"section,omitempty"`
+	Value   interface{} `json:"value"`
+	Created string      `json:"created"`
+	Updated string      `json:"updated"`
+}
+
+func handleListQuarantine(pb *pocketbase.PocketBase, e *core.RequestEvent) error {
+	site, err := requireSiteAccess(pb, e, false, ScopeImport)
+	if err != nil {
+		return err
+	}
+
+	rows, err := pb.FindRecordsByFilter("import_quarantine", "site = {:site}", "created", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return e.InternalServerError("Failed to load quarantine", err)
+	}
+
+	items := make([]QuarantinedContent, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, QuarantinedContent{
+			ID:      row.Id,
+			Kind:    row.GetString("kind"),
+			File:    row.GetString("file"),
+			Path:    row.GetString("path"),
+			Block:   row.GetString("block"),
+			Page:    row.GetString("page"),
+			Section: row.GetString("section"),
+			Value:   quarantinedValue(row),
+			Created: row.GetString("created"),
+			Updated: row.GetString("updated"),
+		})
+	}
+
+	return e.JSON(200, map[string]interface{}{
+		"items": items,
+	})
+}
+
+func handlePurgeQuarantine(pb *pocketbase.PocketBase, e *core.RequestEvent) error {
+	site, err := requireSiteAccess(pb, e, true, ScopeImport)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		IDs []string `json:"ids"`
+		All bool     `json:"all"`
+	}{}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if len(body.IDs) == 0 && !body.All {
+		return e.BadRequestError("Pass ids, or all: true to purge the whole quarantine", nil)
+	}
+
+	ids := body.IDs
+	if body.All {
+		ids = nil
+	}
+	deleted, err := purgeQuarantine(pb, site.Id, ids)
+	if err != nil {
+		return e.InternalServerError("Purge failed: "+err.Error(), err)
+	}
+
+	return e.JSON(200, map[string]interface{}{
+		"deleted": deleted,
+	})
+}
+
+// purgeQuarantine deletes the given quarantined values, or all of the
+// site's when ids is nil.
+func purgeQuarantine(app core.App, siteId string, ids []string) ([]string, error) {
+	var rows []*core.Record
+	var err error
+	if ids == nil {
+		rows, err = app.FindRecordsByFilter("import_quarantine", "site = {:site}", "", 0, 0, dbx.Params{"site": siteId})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch quarantine: %w", err)
+		}
+	} else {
+		for _, id := range ids {
+			row, err := app.FindFirstRecordByFilter("import_quarantine", "id = {:id} && site = {:site}", dbx.Params{"id": id, "site": siteId})
+			if err != nil {
+				continue
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	deleted := []string{}
+	for _, row := range rows {
+		if err := app.Delete(row); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s: %w", row.Id, err)
+		}
+		deleted = append(deleted, row.Id)
+	}
+	return deleted, nil
+}
+
+// quarantineOrphans stores the content behind this import's orphan
+// warnings. A value already quarantined at the same place is replaced, so
+// pushing the same file twice doesn't pile up copies. Repeater items and
+// groups imported without subfields are split per key, which lets each key
+// come back on its own as subfields are added.
+func quarantineOrphans(pb core.App, site *core.Record, warnings []ImportWarning) error {
+	collection, err := pb.FindCollectionByNameOrId("import_quarantine")
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range warnings {
+		if warning.orphan == nil {
+			continue
+		}
+		orphan := *warning.orphan
+
+		parts := map[string]interface{}{orphan.target: orphan.value}
+		paths := map[string]string{orphan.target: warning.Path}
+		if warning.Kind == "missing_subfields" {
+			values, ok := orphan.value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			parts = make(map[string]interface{}, len(values))
+			paths = make(map[string]string, len(values))
+			for key, value := range values {
+				parts[orphan.target+"."+key] = value
+				paths[orphan.target+"."+key] = warning.Path + "." + key
+			}
+		}
+
+		for target, value := range parts {
+			if value == nil {
+				continue
+			}
+			// HashExp rather than a filter: filter params don't bind
+			// empty strings, and one of page/section is always empty.
+			existing, err := pb.FindAllRecords("import_quarantine", dbx.HashExp{
+				"site":    site.Id,
+				"page":    orphan.page,
+				"section": orphan.section,
+				"target":  target,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to fetch quarantine: %w", err)
+			}
+			var row *core.Record
+			if len(existing) > 0 {
+				row = existing[0]
+			} else {
+				row = core.NewRecord(collection)
+				row.Set("site", site.Id)
+				row.Set("page", orphan.page)
+				row.Set("section", orphan.section)
+				row.Set("target", target)
+			}
+			row.Set("kind", warning.Kind)
+			row.Set("file", warning.File)
+			row.Set("path", paths[target])
+			row.Set("block", warning.Block)
+			row.Set("value", storedEntryValue(value))
+			if err := pb.Save(row); err != nil {
+				return fmt.Errorf("failed to quarantine %s %s: %w", warning.File, paths[target], err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// reapplyQuarantine moves quarantined values back into their page or
+// section once the schema has a field for them again. If the pushed content
+// already fills that field, the push wins and the quarantined copy is
+// dropped. Values whose page or section no longer exists stay put until
+// they are purged.
+func reapplyQuarantine(pb *pocketbase.PocketBase, site *core.Record, warnings *[]ImportWarning) error {
+	rows, err := pb.FindRecordsByFilter("import_quarantine", "site = {:site}", "created", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return fmt.Errorf("failed to fetch quarantine: %w", err)
+	}
+
+	for _, row := range rows {
+		var remaining interface{}
+		var restored bool
+		var err error
+		switch {
+		case row.GetString("section") != "":
+			remaining, restored, err = reapplySectionContent(pb, row, warnings)
+		case row.GetString("page") != "":
+			remaining, restored, err = reapplyPageContent(pb, row)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s %s: %w", row.GetString("file"), row.GetString("path"), err)
+		}
+
+		if remaining == nil {
+			if err := pb.Delete(row); err != nil {
+				return err
+			}
+		} else if restored {
+			row.Set("value", storedEntryValue(remaining))
+			if err := pb.Save(row); err != nil {
+				return err
+			}
+		}
+
+		if restored && warnings != nil {
+			*warnings = append(*warnings, ImportWarning{
+				Kind:  "quarantine_restored",
+				File:  row.GetString("file"),
+				Path:  row.GetString("path"),
+				Field: lastTargetKey(row.GetString("target")),
+				Block: row.GetString("block"),
+				Message: fmt.Sprintf(
+					"%s: content at %q was restored from the import quarantine now that the field exists again. Pull to get it back into your files.",
+					row.GetString("file"), row.GetString("path"),
+				),
+			})
+		}
+	}
+
+	return nil
+}
+
+// reapplySectionContent walks the row's target through the section's
+// entries. It returns what is still unplaced (nil once resolved) and
+// whether anything was written back. Keys inside the value that still have
+// no subfield are reported as new orphans and quarantined again.
+func reapplySectionContent(pb *pocketbase.PocketBase, row *core.Record, warnings *[]ImportWarning) (interface{}, bool, error) {
+	value := quarantinedValue(row)
+	section, err := pb.FindRecordById("page_sections", row.GetString("section"))
+	if err != nil {
+		return value, false, nil
+	}
+
+	symbolFields, err := pb.FindRecordsByFilter("site_symbol_fields", "symbol = {:symbol}", "", 0, 0, dbx.Params{"symbol": section.GetString("symbol")})
+	if err != nil {
+		return nil, false, err
+	}
+	fieldByKey := make(map[string]*core.Record, len(symbolFields))
+	fieldsByParent := make(map[string][]*core.Record)
+	for _, f := range symbolFields {
+		fieldByKey[f.GetString("key")] = f
+		fieldsByParent[f.GetString("parent")] = append(fieldsByParent[f.GetString("parent")], f)
+	}
+
+	entriesColl, err := pb.FindCollectionByNameOrId("page_section_entries")
+	if err != nil {
+		return nil, false, err
+	}
+
+	r := sectionReapplier{
+		pb:             pb,
+		entriesColl:    entriesColl,
+		sectionId:      section.Id,
+		fieldByKey:     fieldByKey,
+		fieldsByParent: fieldsByParent,
+		file:           row.GetString("file"),
+		block:          row.GetString("block"),
+		warnings:       warnings,
+	}
+	return r.place("", "", parseContentTarget(row.GetString("target")), value, row.GetString("path"))
+}
+
+type sectionReapplier struct {
+	pb             *pocketbase.PocketBase
+	entriesColl    *core.Collection
+	sectionId      string
+	fieldByKey     map[string]*core.Record
+	fieldsByParent map[string][]*core.Record
+	file           string
+	block          string
+	warnings       *[]ImportWarning
+}
+
+func (r sectionReapplier) place(parentField, parentEntry string, segments []contentTargetSegment, value interface{}, path string) (interface{}, bool, error) {
+	if len(segments) == 0 {
+		return value, false, nil
+	}
+
+	var field *core.Record
+	for _, candidate := range r.fieldsByParent[parentField] {
+		if candidate.GetString("key") == segments[0].key {
+			field = candidate
+			break
+		}
+	}
+	if field == nil {
+		return value, false, nil
+	}
+
+	entries, err := r.pb.FindAllRecords("page_section_entries", dbx.HashExp{
+		"section": r.sectionId,
+		"field":   field.Id,
+		"parent":  parentEntry,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].GetInt("index") < entries[j].GetInt("index") })
+
+	if len(segments) > 1 {
+		index := 0
+		if segments[0].index >= 0 {
+			index = segments[0].index
+		}
+		for _, entry := range entries {
+			if entry.GetInt("index") == index {
+				return r.place(field.Id, entry.Id, segments[1:], value, path)
+			}
+		}
+		return value, false, nil
+	}
+
+	if len(entries) == 0 {
+		if err := importPageSectionContentField(r.pb, r.entriesColl, r.sectionId, field, value, parentEntry, 0, r.fieldsByParent, r.fieldByKey, nil, r.warnings, r.file, r.block, path); err != nil {
+			return nil, false, err
+		}
+		return nil, true, nil
+	}
+
+	entry := entries[0]
+	if field.GetString("type") == "group" {
+		// The group entry exists (possibly empty); place each key under it
+		// and keep whatever still has no subfield.
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false, nil
+		}
+		remaining := map[string]interface{}{}
+		restored := false
+		for key, childValue := range values {
+			left, placed, err := r.place(field.Id, entry.Id, []contentTargetSegment{{key: key, index: -1}}, childValue, path+"."+key)
+			if err != nil {
+				return nil, false, err
+			}
+			restored = restored || placed
+			if left != nil {
+				remaining[key] = left
+			}
+		}
+		if len(remaining) == 0 {
+			return nil, restored, nil
+		}
+		return remaining, restored, nil
+	}
+
+	if field.GetString("type") == "repeater" || entryHasContent(entry) {
+		// The pushed content already fills this field.
+		return nil, false, nil
+	}
+
+	entry.Set("value", storedEntryValue(value))
+	if err := r.pb.SaveWithContext(keepContent(), entry); err != nil {
+		return nil, false, err
+	}
+	return nil, true, nil
+}
+
+func reapplyPageContent(pb *pocketbase.PocketBase, row *core.Record) (interface{}, bool, error) {
+	value := quarantinedValue(row)
+	page, err := pb.FindRecordById("pages", row.GetString("page"))
+	if err != nil || page.GetString("page_type") == "" {
+		return value, false, nil
+	}
+
+	key := row.GetString("target")
+	field, err := pb.FindFirstRecordByFilter(
+		"page_type_fields",
+		"page_type = {:pt} && (key = {:key} || (key = '' && name = {:key}))",
+		dbx.Params{"pt": page.GetString("page_type"), "key": key},
+	)
+	if err != nil {
+		return value, false, nil
+	}
+
+	entry, err := pb.FindFirstRecordByFilter("page_entries", "page = {:page} && field = {:field}", dbx.Params{"page": page.Id, "field": field.Id})
+	if err == nil && entryHasContent(entry) {
+		return nil, false, nil
+	}
+	if err != nil {
+		entriesColl, err := pb.FindCollectionByNameOrId("page_entries")
+		if err != nil {
+			return nil, false, err
+		}
+		entry = core.NewRecord(entriesColl)
+		entry.Set("page", page.Id)
+		entry.Set("field", field.Id)
+		entry.Set("locale", "en")
+	}
+
+	entry.Set("value", storedEntryValue(normalizeValueForStorage(value)))
+	if err := pb.SaveWithContext(keepContent(), entry); err != nil {
+		return nil, false, err
+	}
+	return nil, true, nil
+}
+
+func quarantinedValue(row *core.Record) interface{} {
+	var value interface{}
+	if raw := row.GetString("value"); raw != "" {
+		json.Unmarshal([]byte(raw), &value)
+	}
+	return value
+}
+
+func entryHasContent(entry *core.Record) bool {
+	switch strings.TrimSpace(entry.GetString("value")) {
+	case "", "null", `""`, "{}", "[]":
+		return false
+	}
+	return true
+}
+
+type contentTargetSegment struct {
+	key   string
+	index int // -1 when the segment isn't indexed
+}
+
+var contentTargetIndex = regexp.MustCompile(`^(.*)\[(\d+)\]$`)
+
+// parseContentTarget splits "items[1].subtitle" into its keys and indexes.
+func parseContentTarget(target string) []contentTargetSegment {
+	var segments []contentTargetSegment
+	for _, part := range strings.Split(target, ".") {
+		segment := contentTargetSegment{key: part, index: -1}
+		if match := contentTargetIndex.FindStringSubmatch(part); match != nil {
+			segment.key = match[1]
+			segment.index, _ = strconv.Atoi(match[2])
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+// sectionContentTarget strips the "sections[N].content." prefix from a
+// warning path.
+func sectionContentTarget(path string) string {
+	if _, after, ok := strings.Cut(path, ".content."); ok {
+		return after
+	}
+	return path
+}
+
+func lastTargetKey(target string) string {
+	segments := parseContentTarget(target)
+	if len(segments) == 0 {
+		return ""
+	}
+	return segments[len(segments)-1].key
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+)
+
+func TestImportQuarantinesOrphanedContent(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	blockFields := func(extra string, itemExtra string) string {
+		return "" +
+			"- name: heading\n" +
+			"  label: Heading\n" +
+			"  type: text\n" +
+			extra +
+			"- name: items\n" +
+			"  label: Items\n" +
+			"  type: repeater\n" +
+			"  subfields:\n" +
+			"    - name: title\n" +
+			"      label: Title\n" +
+			"      type: text\n" +
+			itemExtra
+	}
+	files := map[string]string{
+		"blocks/hero/config.yaml":        "name: hero\n",
+		"blocks/hero/component.svelte":   "<section>{heading}</section>\n",
+		"blocks/hero/fields.yaml":        blockFields("", ""),
+		"blocks/hero/content.yaml":       "{}\n",
+		"page-types/default/config.yaml": "name: Default\nallowed_blocks:\n  - hero\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml": "" +
+			"name: Home\n" +
+			"page_type: Default\n" +
+			"sections:\n" +
+			"  - block: hero\n" +
+			"    content:\n" +
+			"      heading: Welcome\n" +
+			"      subtitle: Written in the CMS\n" +
+			"      items:\n" +
+			"        - title: First\n" +
+			"          note: Keep me\n",
+		"site/fields.yaml":  "[]\n",
+		"site/content.yaml": "{}\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, files), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	quarantined, err := app.FindRecordsByFilter("import_quarantine", "site = {:site}", "target", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		t.Fatalf("list quarantine: %v", err)
+	}
+	if len(quarantined) != 2 || quarantined[0].GetString("target") != "items[0].note" || quarantined[1].GetString("target") != "subtitle" {
+		t.Fatalf("expected subtitle and items[0].note to be quarantined, got %d rows", len(quarantined))
+	}
+	if got := quarantinedValue(quarantined[1]); got != "Written in the CMS" {
+		t.Fatalf("unexpected quarantined value %#v", got)
+	}
+
+	// Pushing the same files again (with the _id the CLI writes back) must
+	// not duplicate the quarantine.
+	sectionId := quarantined[1].GetString("section")
+	files["pages/index.yaml"] = strings.Replace(files["pages/index.yaml"], "  - block: hero\n", "  - block: hero\n    _id: "+sectionId+"\n", 1)
+	if _, err := processImport(app, site, zipFiles(t, files), false); err != nil {
+		t.Fatalf("repeat import: %v", err)
+	}
+	if count, _ := app.CountRecords("import_quarantine", dbx.HashExp{"site": site.Id}); count != 2 {
+		t.Fatalf("expected 2 quarantined values after a repeat push, got %d", count)
+	}
+
+	// The fields come back, and the page no longer carries the content.
+	files["blocks/hero/fields.yaml"] = blockFields(
+		"- name: subtitle\n  label: Subtitle\n  type: text\n",
+		"    - name: note\n      label: Note\n      type: text\n",
+	)
+	files["pages/index.yaml"] = "" +
+		"name: Home\n" +
+		"page_type: Default\n" +
+		"sections:\n" +
+		"  - block: hero\n" +
+		"    _id: " + sectionId + "\n" +
+		"    content:\n" +
+		"      heading: Welcome\n" +
+		"      items:\n" +
+		"        - title: First\n"
+	result, err := processImport(app, site, zipFiles(t, files), false)
+	if err != nil {
+		t.Fatalf("import with fields restored: %v", err)
+	}
+	restored := 0
+	for _, warning := range result.Warnings {
+		if warning.Kind == "quarantine_restored" {
+			restored++
+		}
+	}
+	if restored != 2 {
+		t.Fatalf("expected 2 quarantine_restored warnings, got %#v", result.Warnings)
+	}
+	if count, _ := app.CountRecords("import_quarantine", dbx.HashExp{"site": site.Id}); count != 0 {
+		t.Fatalf("expected the quarantine to be empty, got %d", count)
+	}
+
+	for key, want := range map[string]string{"subtitle": "Written in the CMS", "note": "Keep me"} {
+		field, err := app.FindFirstRecordByData("site_symbol_fields", "key", key)
+		if err != nil {
+			t.Fatalf("find %s field: %v", key, err)
+		}
+		entry, err := app.FindFirstRecordByData("page_section_entries", "field", field.Id)
+		if err != nil {
+			t.Fatalf("expected %s to be restored: %v", key, err)
+		}
+		var value interface{}
+		if err := entry.UnmarshalJSONField("value", &value); err != nil {
+			t.Fatalf("decode %s: %v", key, err)
+		}
+		if value != want {
+			t.Fatalf("%s: got %#v, want %q", key, value, want)
+		}
+	}
+
+	// Orphan the subtitle again and purge it.
+	files["blocks/hero/fields.yaml"] = blockFields("", "")
+	files["pages/index.yaml"] += "      subtitle: Gone for good\n"
+	if _, err := processImport(app, site, zipFiles(t, files), false); err != nil {
+		t.Fatalf("orphaning import: %v", err)
+	}
+	deleted, err := purgeQuarantine(app, site.Id, nil)
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if len(deleted) != 1 {
+		t.Fatalf("expected 1 purged value, got %v", deleted)
+	}
+}
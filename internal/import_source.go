@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	"gopkg.in/yaml.v3"
+)
+
+// An import reads the project layout (site.yaml, blocks/, page-types/,
+// pages/, site/, uploads/, .primo/) from an fs.FS, so an uploaded zip, a
+// directory on the server and a commit in a local git checkout all go
+// through readImportFiles and the same import code.
+
+// zipImportFS exposes an archive held in memory as a filesystem.
+func zipImportFS(data []byte) (fs.FS, error) {
+	return zipReaderImportFS(bytes.NewReader(data), int64(len(data)))
+}
+
+// zipReaderImportFS exposes an archive read in place, such as an uploaded
+// multipart file, as a filesystem.
+func zipReaderImportFS(r io.ReaderAt, size int64) (fs.FS, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ZIP file: %w", err)
+	}
+	return reader, nil
+}
+
+// dirImportFS reads a project directory as it is on disk.
+func dirImportFS(dir string) (fs.FS, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return os.DirFS(dir), nil
+}
+
+// gitImportFS reads the project as committed at ref in the git checkout
+// containing dir, ignoring uncommitted changes. When dir is a subdirectory
+// of the checkout, only that subdirectory is read. The archive git writes
+// is spooled to a temp file rather than held in memory; close removes it.
+func gitImportFS(dir, ref string) (fs.FS, func(), error) {
+	if ref == "" || strings.HasPrefix(ref, "-") || strings.ContainsAny(ref, ": \t\n") {
+		return nil, nil, fmt.Errorf("invalid git ref %q", ref)
+	}
+	if _, err := dirImportFS(dir); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := runGit(dir, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, nil, fmt.Errorf("%s is not inside a git checkout: %w", dir, err)
+	}
+
+	archive, err := os.CreateTemp("", "palacms-import-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+	closeArchive := func() {
+		archive.Close()
+		os.Remove(archive.Name())
+	}
+
+	// Run from dir, git archive only includes that subdirectory, with
+	// paths relative to it.
+	cmd := exec.Command("git", "-C", dir, "archive", "--format=zip", ref)
+	var stderr bytes.Buffer
+	cmd.Stdout = &cappedWriter{w: archive, remaining: maxImportArchiveSize}
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		closeArchive()
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, nil, fmt.Errorf("failed to read %s at %s: %w", dir, ref, err)
+	}
+
+	info, err := archive.Stat()
+	if err != nil {
+		closeArchive()
+		return nil, nil, err
+	}
+	fsys, err := zipReaderImportFS(archive, info.Size())
+	if err != nil {
+		closeArchive()
+		return nil, nil, err
+	}
+	return fsys, closeArchive, nil
+}
+
+// cappedWriter passes writes through until remaining bytes have been
+// written, then fails them.
+type cappedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > c.remaining {
+		return 0, fmt.Errorf("archive is larger than %d bytes", maxImportArchiveSize)
+	}
+	c.remaining -= int64(len(p))
+	return c.w.Write(p)
+}
+
+func runGit(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// Limits on what an import loads into memory. The project files are read
+// whole to be parsed, so an archive that inflates to far more than it
+// weighs (a zip bomb) is refused once it passes these. Upload bytes don't
+// count: they stay in the source and are read one file at a time when
+// they are restored.
+const (
+	maxImportFileSize  = 16 << 20  // 16MB per file
+	maxImportTotalSize = 128 << 20 // 128MB for the whole project
+)
+
+// maxImportArchiveSize caps the archive a git import spools to disk,
+// upload bytes included.
+const maxImportArchiveSize = 1 << 30 // 1GB
+
+// isImportUploadFile reports whether name holds upload bytes, which
+// readImportFiles leaves in the source. The uploads manifest is project
+// metadata and is loaded with the rest.
+func isImportUploadFile(name string) bool {
+	return strings.HasPrefix(name, "uploads/") && name != "uploads/.manifest.json"
+}
+
+// readImportFiles loads the project's files into memory, keyed by
+// slash-separated path, leaving upload bytes where they are. A directory
+// source is usually a checkout, so .git and node_modules are skipped.
+func readImportFiles(fsys fs.FS) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	var total int64
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || d.Name() == "node_modules" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || isImportUploadFile(name) {
+			return nil
+		}
+		data, err := readImportFile(fsys, name, maxImportFileSize)
+		if err != nil {
+			return err
+		}
+		total += int64(len(data))
+		if total > maxImportTotalSize {
+			return fmt.Errorf("project is larger than %d bytes", maxImportTotalSize)
+		}
+		files[name] = data
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import files: %w", err)
+	}
+	return files, nil
+}
+
+// readImportFile reads one file of at most maxSize bytes. The size an
+// archive claims can't be trusted, so the read itself is capped.
+func readImportFile(fsys fs.FS, name string, maxSize int64) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxSize)
+	}
+	return data, nil
+}
+
+// readImportSource resolves where an import request reads from: an
+// uploaded "file" zip, or a server-side "path" (optionally read at git
+// "ref"). Server paths expose the host filesystem, so they are limited to
+// localhost (primo dev) and superusers. The caller closes the source once
+// the import is done with it.
+func readImportSource(e *core.RequestEvent) (fs.FS, func(), error) {
+	if err := e.Request.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) { // 32MB max in memory
+		return nil, nil, e.BadRequestError("Failed to parse form", err)
+	}
+
+	if dir := e.Request.FormValue("path"); dir != "" {
+		if !IsLocalhost(e) && (e.Auth == nil || !e.Auth.IsSuperuser()) {
+			return nil, nil, e.ForbiddenError("Importing from a server path requires a superuser", nil)
+		}
+		dir = filepath.Clean(dir)
+
+		if ref := e.Request.FormValue("ref"); ref != "" {
+			fsys, closeArchive, err := gitImportFS(dir, ref)
+			if err != nil {
+				return nil, nil, e.BadRequestError(err.Error(), err)
+			}
+			return fsys, closeArchive, nil
+		}
+		fsys, err := dirImportFS(dir)
+		if err != nil {
+			return nil, nil, e.BadRequestError(err.Error(), err)
+		}
+		return fsys, func() {}, nil
+	}
+
+	file, header, err := e.Request.FormFile("file")
+	if err != nil {
+		return nil, nil, e.BadRequestError("No file uploaded", err)
+	}
+
+	// Uploads over the in-memory limit are spooled to a temp file by the
+	// multipart parser, and multipart.File is an io.ReaderAt, so the
+	// archive is read in place rather than buffered whole.
+	fsys, err := zipReaderImportFS(file, header.Size)
+	if err != nil {
+		file.Close()
+		return nil, nil, e.BadRequestError(err.Error(), err)
+	}
+	return fsys, func() { file.Close() }, nil
+}
+
+// readImportSourceFiles is readImportSource for callers that only need the
+// project files.
+func readImportSourceFiles(e *core.RequestEvent) (map[string][]byte, error) {
+	fsys, closeSource, err := readImportSource(e)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSource()
+
+	files, err := readImportFiles(fsys)
+	if err != nil {
+		return nil, e.BadRequestError(err.Error(), err)
+	}
+	return files, nil
+}
+
+// readSiteConfig extracts (name, host, group) from site.yaml. Missing or
+// unparseable site.yaml yields empty strings; callers fall back to safe
+// defaults so a malformed config never blocks the site auto-create path.
+func readSiteConfig(files map[string][]byte) (string, string, string) {
+	data, ok := files["site.yaml"]
+	if !ok {
+		return "", "", ""
+	}
+	var cfg struct {
+		Name  string `yaml:"name"`
+		Host  string `yaml:"host"`
+		Group string `yaml:"group"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return "", "", ""
+	}
+	return cfg.Name, cfg.Host, cfg.Group
+}
@@ -0,0 +1,252 @@
+package internal
+
+import (
+	"bytes"
+	"io/fs"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestImportFromDirectoryAndGitRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	files := map[string]string{
+		"site.yaml":                      "name: Disk Site\n",
+		"blocks/hero/config.yaml":        "name: hero\n",
+		"blocks/hero/component.svelte":   "<h1>{heading}</h1>\n",
+		"blocks/hero/fields.yaml":        "- name: heading\n  label: Heading\n  type: text\n",
+		"blocks/hero/content.yaml":       "{}\n",
+		"page-types/default/config.yaml": "name: Default\nallowed_blocks:\n  - hero\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: Default\nsections:\n  - block: hero\n    content:\n      heading: Committed\n",
+		"site/fields.yaml":               "[]\n",
+		"site/content.yaml":              "{}\n",
+	}
+
+	// The project lives in a subdirectory of the checkout, as in a repo
+	// that also holds other code.
+	repo := t.TempDir()
+	project := filepath.Join(repo, "site")
+	for name, content := range files {
+		target := filepath.Join(project, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+
+	// Uncommitted edit: visible to a directory import, not at HEAD.
+	if err := os.WriteFile(filepath.Join(project, "pages", "index.yaml"), []byte(strings.Replace(files["pages/index.yaml"], "Committed", "Working tree", 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	headingOf := func(source fs.FS) string {
+		t.Helper()
+		imported, err := readImportFiles(source)
+		if err != nil {
+			t.Fatalf("read source: %v", err)
+		}
+		if name, _, _ := readSiteConfig(imported); name != "Disk Site" {
+			t.Fatalf("expected site.yaml at the root of the source, got name %q", name)
+		}
+		if _, err := processImportFiles(app, site, source, imported, false, false); err != nil {
+			t.Fatalf("import: %v", err)
+		}
+		field, err := app.FindFirstRecordByData("site_symbol_fields", "key", "heading")
+		if err != nil {
+			t.Fatalf("find heading field: %v", err)
+		}
+		entry, err := app.FindFirstRecordByData("page_section_entries", "field", field.Id)
+		if err != nil {
+			t.Fatalf("find heading entry: %v", err)
+		}
+		var value string
+		if err := entry.UnmarshalJSONField("value", &value); err != nil {
+			t.Fatalf("decode heading: %v", err)
+		}
+		return value
+	}
+
+	dirSource, err := dirImportFS(project)
+	if err != nil {
+		t.Fatalf("open directory: %v", err)
+	}
+	if got := headingOf(dirSource); got != "Working tree" {
+		t.Fatalf("directory import: got heading %q", got)
+	}
+
+	// The archive is spooled to a temp file that close removes.
+	spool := t.TempDir()
+	t.Setenv("TMPDIR", spool)
+	gitSource, closeArchive, err := gitImportFS(project, "HEAD")
+	if err != nil {
+		t.Fatalf("open git ref: %v", err)
+	}
+	if got := headingOf(gitSource); got != "Committed" {
+		t.Fatalf("git import: got heading %q", got)
+	}
+	if spooled, _ := os.ReadDir(spool); len(spooled) != 1 {
+		t.Fatalf("expected the archive spooled to disk, got %v", spooled)
+	}
+	closeArchive()
+	if spooled, _ := os.ReadDir(spool); len(spooled) != 0 {
+		t.Fatalf("expected the spooled archive removed, got %v", spooled)
+	}
+
+	if _, _, err := gitImportFS(project, "--output=/tmp/x"); err == nil {
+		t.Fatal("expected option-like refs to be rejected")
+	}
+}
+
+// Regression test for reading a git ref from a project nested in the
+// checkout: git archive must be given the ref alone, run from the project
+// directory, or it looks for the subdirectory inside itself.
+func TestGitImportFSReadsNestedProjectAtRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	repo := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		target := filepath.Join(repo, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write("README.md", "# monorepo\n")
+	write("apps/web/site/site.yaml", "name: First\n")
+	write("apps/web/site/pages/index.yaml", "name: Home\n")
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	write("apps/web/site/site.yaml", "name: Second\n")
+	git("commit", "-q", "-am", "second")
+
+	read := func(dir, ref string) map[string][]byte {
+		t.Helper()
+		source, closeArchive, err := gitImportFS(dir, ref)
+		if err != nil {
+			t.Fatalf("open %s at %s: %v", dir, ref, err)
+		}
+		defer closeArchive()
+		files, err := readImportFiles(source)
+		if err != nil {
+			t.Fatalf("read %s at %s: %v", dir, ref, err)
+		}
+		return files
+	}
+
+	project := filepath.Join(repo, "apps", "web", "site")
+	files := read(project, "HEAD~1")
+	if len(files) != 2 || string(files["site.yaml"]) != "name: First\n" || files["pages/index.yaml"] == nil {
+		t.Fatalf("expected only the project's files at the earlier ref, got %v", slices.Sorted(maps.Keys(files)))
+	}
+	if got := string(read(project, "HEAD")["site.yaml"]); got != "name: Second\n" {
+		t.Fatalf("expected site.yaml at HEAD, got %q", got)
+	}
+	if files := read(repo, "HEAD"); files["README.md"] == nil || files["apps/web/site/site.yaml"] == nil {
+		t.Fatalf("expected the whole checkout from its root, got %v", slices.Sorted(maps.Keys(files)))
+	}
+}
+
+func TestReadImportSourceReadsUploadedZip(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	upload := func(content []byte) (map[string][]byte, error) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", "site.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(content); err != nil {
+			t.Fatal(err)
+		}
+		if err := form.Close(); err != nil {
+			t.Fatal(err)
+		}
+		e := newTestRequestEvent(app, http.MethodPost, "/", &body)
+		e.Request.Header.Set("Content-Type", form.FormDataContentType())
+		return readImportSourceFiles(e)
+	}
+
+	files, err := upload(zipFiles(t, map[string]string{"site.yaml": "name: Uploaded\n", "pages/index.yaml": "name: Home\n"}))
+	if err != nil {
+		t.Fatalf("read upload: %v", err)
+	}
+	if string(files["site.yaml"]) != "name: Uploaded\n" || string(files["pages/index.yaml"]) != "name: Home\n" || len(files) != 2 {
+		t.Fatalf("expected the archive's files, got %v", files)
+	}
+	if _, err := upload([]byte("not a zip")); apiErrorStatus(t, err) != http.StatusBadRequest {
+		t.Fatalf("expected an invalid archive rejected, got %v", err)
+	}
+}
+
+func TestReadImportFilesCapsSizeAndLeavesUploads(t *testing.T) {
+	source, err := zipImportFS(zipFiles(t, map[string]string{
+		"site.yaml":              "name: Site\n",
+		"uploads/.manifest.json": "{}",
+		"uploads/logo.png":       "logo bytes",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := readImportFiles(source)
+	if err != nil {
+		t.Fatalf("read source: %v", err)
+	}
+	if got := slices.Sorted(maps.Keys(files)); !slices.Equal(got, []string{"site.yaml", "uploads/.manifest.json"}) {
+		t.Fatalf("expected upload bytes left in the source, got %v", got)
+	}
+
+	// Zeros compress to almost nothing, the way a zip bomb does.
+	bomb, err := zipImportFS(zipFiles(t, map[string]string{
+		"site.yaml":       "name: Site\n",
+		"pages/huge.yaml": strings.Repeat("\x00", maxImportFileSize+1),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readImportFiles(bomb); err == nil {
+		t.Fatal("expected a file over the size limit refused")
+	}
+}
@@ -3,15 +3,20 @@ package internal
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	_ "github.com/palacms/palacms/migrations"
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
 	"gopkg.in/yaml.v3"
 )
 
@@ -76,10 +81,7 @@ func TestImportReimportsBlockFieldsBeforePageContent(t *testing.T) {
 		t.Fatalf("expected heading field to be imported, got %#v", fields)
 	}
 
-	exportedZip, err := exportSiteToZip(app, site)
-	if err != nil {
-		t.Fatalf("export site: %v", err)
-	}
+	exportedZip := exportSiteBytes(t, app, site, ExportOptions{})
 	pageYAML := readZipFile(t, exportedZip, "pages/index.yaml")
 	var page struct {
 		Sections []struct {
@@ -157,10 +159,7 @@ func TestImportReimportsPageTypeFieldsBeforePageContent(t *testing.T) {
 		t.Fatalf("expected SEO page type fields to be imported, got %#v", fieldKeys)
 	}
 
-	exportedZip, err := exportSiteToZip(app, site)
-	if err != nil {
-		t.Fatalf("export site: %v", err)
-	}
+	exportedZip := exportSiteBytes(t, app, site, ExportOptions{})
 	pageYAML := readZipFile(t, exportedZip, "pages/index.yaml")
 	var page struct {
 		Content map[string]any `yaml:"content"`
@@ -204,10 +203,7 @@ func TestImportExportPageTypeConfigUsesUnderscoreID(t *testing.T) {
 		t.Fatalf("import page type with _id key: %v", err)
 	}
 
-	exportedZip, err := exportSiteToZip(app, site)
-	if err != nil {
-		t.Fatalf("export site: %v", err)
-	}
+	exportedZip := exportSiteBytes(t, app, site, ExportOptions{})
 
 	configYAML := readZipFile(t, exportedZip, "page-types/default/config.yaml")
 	if !strings.HasPrefix(configYAML, "_id: ") {
@@ -300,10 +296,7 @@ func TestImportExportScaffoldBlockFieldsRoundTrip(t *testing.T) {
 		t.Fatalf("expected no import warnings, got %#v", result.Warnings)
 	}
 
-	exportedZip, err := exportSiteToZip(app, site)
-	if err != nil {
-		t.Fatalf("export site: %v", err)
-	}
+	exportedZip := exportSiteBytes(t, app, site, ExportOptions{})
 
 	exportedFieldsYAML := readZipFile(t, exportedZip, "blocks/pricing-table/fields.yaml")
 	expected := normalizeFieldsYAMLForComparison(t, scaffoldedFieldsYAML)
@@ -568,7 +561,7 @@ func TestUpdatedTimestampStableOnNoOpReimport(t *testing.T) {
 	t.Logf("Native `updated` is safe to use as the sync version primitive.")
 }
 
-func newImportTestApp(t *testing.T) *pocketbase.PocketBase {
+func newImportTestApp(t testing.TB) *pocketbase.PocketBase {
 	t.Helper()
 
 	app := pocketbase.NewWithConfig(pocketbase.Config{
@@ -584,7 +577,7 @@ func newImportTestApp(t *testing.T) *pocketbase.PocketBase {
 	return app
 }
 
-func createImportTestSite(t *testing.T, app *pocketbase.PocketBase) *core.Record {
+func createImportTestSite(t testing.TB, app *pocketbase.PocketBase) *core.Record {
 	t.Helper()
 
 	groupID, err := ensureDefaultGroup(app)
@@ -606,7 +599,17 @@ func createImportTestSite(t *testing.T, app *pocketbase.PocketBase) *core.Record
 	return site
 }
 
-func zipFiles(t *testing.T, files map[string]string) []byte {
+func exportSiteBytes(t *testing.T, app *pocketbase.PocketBase, site *core.Record, opts ExportOptions) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := exportSiteToZip(app, site, &buf, opts); err != nil {
+		t.Fatalf("export site: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func zipFiles(t testing.TB, files map[string]string) []byte {
 	t.Helper()
 
 	var buf bytes.Buffer
@@ -626,6 +629,35 @@ func zipFiles(t *testing.T, files map[string]string) []byte {
 	return buf.Bytes()
 }
 
+func readImportTestFiles(t *testing.T, zipData []byte) map[string][]byte {
+	t.Helper()
+
+	fsys, err := zipImportFS(zipData)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	files, err := readImportFiles(fsys)
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	return files
+}
+
+func zipHasFile(t *testing.T, zipData []byte, name string) bool {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	for _, f := range zr.File {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func readZipFile(t *testing.T, zipData []byte, name string) string {
 	t.Helper()
 
@@ -704,3 +736,38 @@ func asInterfaceSlice(t *testing.T, value interface{}) []interface{} {
 	}
 	return items
 }
+
+// findBlockField finds the field with key on the site's block named block.
+func findBlockField(t testing.TB, app core.App, site *core.Record, block, key string) *core.Record {
+	t.Helper()
+
+	field, err := app.FindFirstRecordByFilter("site_symbol_fields", "symbol.name = {:block} && symbol.site = {:site} && key = {:key}", dbx.Params{"block": block, "site": site.Id, "key": key})
+	if err != nil {
+		t.Fatalf("find field %s.%s: %v", block, key, err)
+	}
+	return field
+}
+
+// newTestRequestEvent builds a request event for calling a handler
+// directly; the response is recorded in e.Response.
+func newTestRequestEvent(app core.App, method, target string, body io.Reader) *core.RequestEvent {
+	e := &core.RequestEvent{App: app}
+	e.Request = httptest.NewRequest(method, target, body)
+	e.Response = httptest.NewRecorder()
+	return e
+}
+
+// apiErrorStatus is the status a handler error answers with, or 200 for
+// no error.
+func apiErrorStatus(t testing.TB, err error) int {
+	t.Helper()
+
+	if err == nil {
+		return http.StatusOK
+	}
+	var apiErr *router.ApiError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an API error, got %v", err)
+	}
+	return apiErr.Status
+}
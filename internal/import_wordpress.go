@@ -0,0 +1,743 @@
+package internal
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// WordPress sites are brought over from a WXR export (Tools → Export in
+// wp-admin) plus, optionally, a zip of wp-content/uploads. Pages keep their
+// parent/child hierarchy and slugs; posts become pages of a chosen page
+// type, under a chosen parent page. Attachments found in the uploads
+// archive become site_uploads. Post fields are written to page type fields
+// through a mapping, converted to whatever the target field stores.
+//
+// Links inside post bodies that point at the WordPress site are rewritten
+// to the imported page's path (rich text and markdown hold links as
+// hrefs), or to the upload's file URL for attachments. Link-typed values
+// then go through convertUrlsToPageRefs like any other import, so they
+// end up as page references.
+
+// wordpressSources are the post fields a mapping can draw from.
+var wordpressSources = []string{"title", "content", "excerpt", "date", "featured_image"}
+
+type wxrDocument struct {
+	Channel wxrChannel `xml:"channel"`
+}
+
+type wxrChannel struct {
+	Link        string    `xml:"link"`
+	BaseSiteURL string    `xml:"base_site_url"`
+	BaseBlogURL string    `xml:"base_blog_url"`
+	Items       []wxrItem `xml:"item"`
+}
+
+type wxrItem struct {
+	Title         string        `xml:"title"`
+	Link          string        `xml:"link"`
+	GUID          string        `xml:"guid"`
+	Encoded       []wxrEncoded  `xml:"encoded"`
+	PostID        int           `xml:"post_id"`
+	PostDate      string        `xml:"post_date"`
+	PostName      string        `xml:"post_name"`
+	Status        string        `xml:"status"`
+	PostParent    int           `xml:"post_parent"`
+	MenuOrder     int           `xml:"menu_order"`
+	PostType      string        `xml:"post_type"`
+	AttachmentURL string        `xml:"attachment_url"`
+	Meta          []wxrPostMeta `xml:"postmeta"`
+}
+
+// wxrEncoded is content:encoded or excerpt:encoded; the excerpt namespace
+// carries the WXR version, so the two are told apart by namespace path.
+type wxrEncoded struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+type wxrPostMeta struct {
+	Key   string `xml:"meta_key"`
+	Value string `xml:"meta_value"`
+}
+
+func (item wxrItem) content() string {
+	for _, encoded := range item.Encoded {
+		if !strings.Contains(encoded.XMLName.Space, "/excerpt/") {
+			return encoded.Value
+		}
+	}
+	return ""
+}
+
+func (item wxrItem) excerpt() string {
+	for _, encoded := range item.Encoded {
+		if strings.Contains(encoded.XMLName.Space, "/excerpt/") {
+			return encoded.Value
+		}
+	}
+	return ""
+}
+
+func (item wxrItem) meta(key string) string {
+	for _, meta := range item.Meta {
+		if meta.Key == key {
+			return meta.Value
+		}
+	}
+	return ""
+}
+
+func parseWXR(r io.Reader) (*wxrDocument, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	var doc wxrDocument
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid WXR file: %w", err)
+	}
+	if len(doc.Channel.Items) == 0 && doc.Channel.BaseSiteURL == "" {
+		return nil, fmt.Errorf("invalid WXR file: no channel or items found")
+	}
+	return &doc, nil
+}
+
+// WordPressImportOptions says where imported content goes.
+type WordPressImportOptions struct {
+	// PageType and PostPageType name page types (by name or folder name).
+	// Pages default to the home page's type, posts to PageType.
+	PageType     string
+	PostPageType string
+	// PostsParent is the path of the page posts are created under, e.g.
+	// "blog". Empty puts them at the top level.
+	PostsParent string
+	// Mapping maps a post field (see wordpressSources) to a page type field
+	// key. Sources without an entry map to a field of the same key, if the
+	// page type has one.
+	Mapping map[string]string
+	// Statuses lists the post statuses to import; publish only by default.
+	Statuses []string
+}
+
+// WordPressImportResult reports what was created or updated per WordPress
+// item.
+type WordPressImportResult struct {
+	Pages    []WordPressImportItem `json:"pages"`
+	Uploads  []WordPressImportItem `json:"uploads"`
+	Skipped  []WordPressImportItem `json:"skipped"`
+	Warnings []ImportWarning       `json:"warnings"`
+}
+
+type WordPressImportItem struct {
+	WordPressID int    `json:"wp_id"`
+	Type        string `json:"type"`
+	Title       string `json:"title"`
+	ID          string `json:"id,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+func handleWordPressImport(pb *pocketbase.PocketBase, e *core.RequestEvent) error {
+	site, err := requireSiteAccess(pb, e, true, ScopeImport)
+	if err != nil {
+		return err
+	}
+
+	if err := e.Request.ParseMultipartForm(32 << 20); err != nil { // 32MB max in memory
+		return e.BadRequestError("Failed to parse form", err)
+	}
+
+	file, header, err := e.Request.FormFile("file")
+	if err != nil {
+		return e.BadRequestError("No WXR file uploaded", err)
+	}
+	defer file.Close()
+	doc, err := parseWXR(file)
+	if err != nil {
+		return e.BadRequestError(err.Error(), err)
+	}
+
+	var uploads *zip.Reader
+	if uploadsFile, uploadsHeader, err := e.Request.FormFile("uploads"); err == nil {
+		defer uploadsFile.Close()
+		uploads, err = zip.NewReader(uploadsFile, uploadsHeader.Size)
+		if err != nil {
+			return e.BadRequestError("Invalid uploads ZIP file", err)
+		}
+	}
+
+	opts := WordPressImportOptions{
+		PageType:     e.Request.FormValue("page_type"),
+		PostPageType: e.Request.FormValue("post_page_type"),
+		PostsParent:  strings.Trim(e.Request.FormValue("posts_parent"), "/"),
+	}
+	if raw := e.Request.FormValue("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts.Mapping); err != nil {
+			return e.BadRequestError("Invalid mapping (expected a JSON object of post field to page field)", err)
+		}
+		for source := range opts.Mapping {
+			if !slices.Contains(wordpressSources, source) {
+				return e.BadRequestError(fmt.Sprintf("Unknown mapping source %q (expected one of %s)", source, strings.Join(wordpressSources, ", ")), nil)
+			}
+		}
+	}
+	if raw := e.Request.FormValue("statuses"); raw != "" {
+		for _, status := range strings.Split(raw, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				opts.Statuses = append(opts.Statuses, status)
+			}
+		}
+	}
+
+	result, err := importWordPress(pb, site, doc, uploads, header.Filename, opts)
+	if err != nil {
+		return e.BadRequestError("WordPress import failed: "+err.Error(), err)
+	}
+	return e.JSON(200, result)
+}
+
+// wordpressPage is a page or post on its way in.
+type wordpressPage struct {
+	item     wxrItem
+	pageType *core.Record
+	parent   *wordpressPage
+	slug     string
+	path     string
+	record   *core.Record
+}
+
+// importWordPress runs the import in one transaction, so a failure leaves
+// the site as it was. Pages already at an imported path are updated in
+// place and uploads already in the target folder are reused, so the same
+// export can be imported again after fixing the mapping.
+func importWordPress(pb *pocketbase.PocketBase, site *core.Record, doc *wxrDocument, uploads *zip.Reader, sourceFile string, opts WordPressImportOptions) (*WordPressImportResult, error) {
+	if sourceFile == "" {
+		sourceFile = "wordpress.xml"
+	}
+	statuses := opts.Statuses
+	if len(statuses) == 0 {
+		statuses = []string{"publish"}
+	}
+
+	result := &WordPressImportResult{
+		Pages:    []WordPressImportItem{},
+		Uploads:  []WordPressImportItem{},
+		Skipped:  []WordPressImportItem{},
+		Warnings: []ImportWarning{},
+	}
+
+	fsys, err := pb.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+
+	archive := map[string]*zip.File{}
+	if uploads != nil {
+		for _, f := range uploads.File {
+			if f.FileInfo().IsDir() || isHiddenArchivePath(f.Name) {
+				continue
+			}
+			archive[wordpressUploadPath(f.Name)] = f
+		}
+	}
+
+	// Upload bytes are written as attachments are created; a rolled back
+	// import removes them again through writes.
+	writes := newBlobWrites(fsys)
+	err = pb.RunInTransaction(func(txApp core.App) error {
+		pageTypes, err := txApp.FindRecordsByFilter("page_types", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+		if err != nil {
+			return err
+		}
+		pageByPath, err := sitePagesByPath(txApp, site.Id)
+		if err != nil {
+			return err
+		}
+		homepage := pageByPath[""]
+		if homepage == nil {
+			return fmt.Errorf("the site has no home page to import under")
+		}
+
+		pageType, err := resolvePageTypeByName(pageTypes, opts.PageType, homepage.GetString("page_type"))
+		if err != nil {
+			return err
+		}
+		postPageType, err := resolvePageTypeByName(pageTypes, opts.PostPageType, pageType.Id)
+		if err != nil {
+			return err
+		}
+
+		// Attachments first, so post bodies and featured images can point
+		// at the uploads.
+		attachments := map[int]*core.Record{}
+		urlRewrites := map[string]string{}
+		taken := map[string]*core.Record{}
+		existingUploads, err := txApp.FindRecordsByFilter("site_uploads", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+		if err != nil {
+			return err
+		}
+		for _, upload := range existingUploads {
+			taken[uploadFolderKey(upload.GetString("folder"), uploadOriginalName(upload))] = upload
+		}
+		for _, item := range doc.Channel.Items {
+			if item.PostType != "attachment" {
+				continue
+			}
+			relative := item.meta("_wp_attached_file")
+			if relative == "" {
+				relative = wordpressUploadPath(urlPath(item.AttachmentURL))
+			}
+			f := archive[relative]
+			if f == nil {
+				result.Skipped = append(result.Skipped, WordPressImportItem{WordPressID: item.PostID, Type: item.PostType, Title: item.Title, Reason: "file not in the uploads archive"})
+				continue
+			}
+
+			folder := path.Join("wordpress", path.Dir(relative))
+			name := path.Base(relative)
+			upload := taken[uploadFolderKey(folder, name)]
+			if upload == nil {
+				data, err := readZipEntry(f, uploadMaxSize(txApp))
+				if err != nil {
+					return err
+				}
+				upload, err = createSiteUpload(txApp, fsys, site, name, data, siteUploadOptions{
+					Folder: folder,
+					Alt:    item.meta("_wp_attachment_image_alt"),
+					Writes: writes,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to import attachment %s: %w", relative, err)
+				}
+				taken[uploadFolderKey(folder, name)] = upload
+			}
+			attachments[item.PostID] = upload
+			fileURL := uploadManifestEntry(upload).URL
+			urlRewrites["upload:"+relative] = fileURL
+			for _, link := range []string{item.AttachmentURL, item.Link, item.GUID} {
+				if key := wordpressURLKey(link); key != "" {
+					urlRewrites[key] = fileURL
+				}
+			}
+			result.Uploads = append(result.Uploads, WordPressImportItem{WordPressID: item.PostID, Type: item.PostType, Title: item.Title, ID: upload.Id, Path: path.Join(folder, name)})
+		}
+
+		// Work out every page's path before writing any, so links between
+		// posts resolve regardless of order.
+		byID := map[int]*wordpressPage{}
+		var imported []*wordpressPage
+		for _, item := range doc.Channel.Items {
+			if item.PostType != "page" && item.PostType != "post" {
+				if item.PostType != "attachment" {
+					result.Skipped = append(result.Skipped, WordPressImportItem{WordPressID: item.PostID, Type: item.PostType, Title: item.Title, Reason: "unsupported post type"})
+				}
+				continue
+			}
+			if !slices.Contains(statuses, item.Status) {
+				result.Skipped = append(result.Skipped, WordPressImportItem{WordPressID: item.PostID, Type: item.PostType, Title: item.Title, Reason: "status " + item.Status})
+				continue
+			}
+			page := &wordpressPage{item: item, pageType: pageType}
+			if item.PostType == "post" {
+				page.pageType = postPageType
+			}
+			byID[item.PostID] = page
+			imported = append(imported, page)
+		}
+		sort.SliceStable(imported, func(i, j int) bool {
+			a, b := imported[i].item, imported[j].item
+			if a.PostType != b.PostType {
+				return a.PostType == "page"
+			}
+			if a.MenuOrder != b.MenuOrder {
+				return a.MenuOrder < b.MenuOrder
+			}
+			return a.PostDate < b.PostDate
+		})
+
+		for _, page := range imported {
+			if page.item.PostType == "page" && page.item.PostParent != 0 {
+				if parent, ok := byID[page.item.PostParent]; ok && parent.item.PostType == "page" {
+					page.parent = parent
+				} else {
+					result.Warnings = append(result.Warnings, ImportWarning{
+						Kind:    "missing_parent_page",
+						File:    sourceFile,
+						Path:    fmt.Sprintf("item %d", page.item.PostID),
+						Message: fmt.Sprintf("Page %q has parent %d, which is not part of this import; it was placed at the top level.", page.item.Title, page.item.PostParent),
+					})
+				}
+			}
+		}
+
+		claimed := map[string]bool{}
+		var assignPath func(page *wordpressPage, seen map[*wordpressPage]bool) string
+		assignPath = func(page *wordpressPage, seen map[*wordpressPage]bool) string {
+			if page.path != "" {
+				return page.path
+			}
+			parentPath := ""
+			if page.parent != nil && !seen[page.parent] {
+				seen[page] = true
+				parentPath = assignPath(page.parent, seen)
+			} else if page.item.PostType == "post" {
+				parentPath = opts.PostsParent
+			}
+			slug := wordpressSlug(page.item)
+			candidate := path.Join(parentPath, slug)
+			for n := 2; claimed[candidate]; n++ {
+				candidate = path.Join(parentPath, slug+"-"+strconv.Itoa(n))
+			}
+			claimed[candidate] = true
+			page.slug = path.Base(candidate)
+			page.path = candidate
+			return candidate
+		}
+		for _, page := range imported {
+			assignPath(page, map[*wordpressPage]bool{})
+			for _, link := range []string{page.item.Link, page.item.GUID} {
+				if key := wordpressURLKey(link); key != "" {
+					urlRewrites[key] = "/" + page.path
+				}
+			}
+			// Hierarchical permalinks usually match the new path already;
+			// keep them pointing there if the export's <link> differs.
+			if _, ok := urlRewrites["/"+page.path]; !ok {
+				urlRewrites["/"+page.path] = "/" + page.path
+			}
+			urlRewrites[fmt.Sprintf("?p=%d", page.item.PostID)] = "/" + page.path
+			urlRewrites[fmt.Sprintf("?page_id=%d", page.item.PostID)] = "/" + page.path
+		}
+
+		// The posts parent may be one of the imported pages ("blog").
+		if opts.PostsParent != "" && pageByPath[opts.PostsParent] == nil && !claimed[opts.PostsParent] {
+			return fmt.Errorf("posts parent page %q does not exist", opts.PostsParent)
+		}
+
+		hosts := wordpressHosts(doc.Channel)
+		rewrite := func(raw string) string {
+			return rewriteWordPressURL(raw, hosts, urlRewrites)
+		}
+
+		// Parents are saved before their children, and posts after every
+		// page, since their parent may be one.
+		depth := func(page *wordpressPage) int {
+			if page.item.PostType == "post" {
+				return len(imported) + 1
+			}
+			d := 0
+			for p := page.parent; p != nil && d <= len(imported); p = p.parent {
+				d++
+			}
+			return d
+		}
+		sort.SliceStable(imported, func(i, j int) bool { return depth(imported[i]) < depth(imported[j]) })
+
+		pagesColl, err := txApp.FindCollectionByNameOrId("pages")
+		if err != nil {
+			return err
+		}
+		for i, page := range imported {
+			parent := homepage
+			if page.parent != nil {
+				parent = page.parent.record
+			} else if page.item.PostType == "post" && opts.PostsParent != "" {
+				parent = pageByPath[opts.PostsParent]
+			}
+
+			record := pageByPath[page.path]
+			if record == nil {
+				record = core.NewRecord(pagesColl)
+				record.Set("site", site.Id)
+			}
+			record.Set("name", page.item.Title)
+			record.Set("slug", page.slug)
+			record.Set("parent", parent.Id)
+			record.Set("page_type", page.pageType.Id)
+			record.Set("index", i)
+			if err := txApp.Save(record); err != nil {
+				return fmt.Errorf("failed to save page %s: %w", page.path, err)
+			}
+			page.record = record
+			pageByPath[page.path] = record
+		}
+
+		// Page references need the ids, so values are written once every
+		// page exists.
+		pathToPageId := make(map[string]string, len(pageByPath))
+		for pagePath, record := range pageByPath {
+			pathToPageId[pagePath] = record.Id
+		}
+		for _, page := range imported {
+			if err := writeWordPressPageEntries(txApp, page, attachments, pathToPageId, rewrite, opts.Mapping, sourceFile, &result.Warnings); err != nil {
+				return err
+			}
+			result.Pages = append(result.Pages, WordPressImportItem{WordPressID: page.item.PostID, Type: page.item.PostType, Title: page.item.Title, ID: page.record.Id, Path: page.path})
+		}
+
+		return nil
+	})
+	writes.finish(pb, err)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// writeWordPressPageEntries sets the page's page type fields from the post
+// through the mapping.
+func writeWordPressPageEntries(app core.App, page *wordpressPage, attachments map[int]*core.Record, pathToPageId map[string]string, rewrite func(string) string, mapping map[string]string, sourceFile string, warnings *[]ImportWarning) error {
+	writer, err := newPageFieldWriter(app, page.record)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range wordpressSources {
+		key, explicit := mapping[source]
+		if !explicit {
+			key = source
+		}
+		if key == "" {
+			continue
+		}
+		field := writer.field(key)
+		if field == nil {
+			if explicit {
+				*warnings = append(*warnings, ImportWarning{
+					Kind:    "unmapped_field",
+					File:    sourceFile,
+					Path:    fmt.Sprintf("item %d", page.item.PostID),
+					Field:   key,
+					Message: fmt.Sprintf("Page type %q has no field %q to receive the post %s; it was not imported.", page.pageType.GetString("name"), key, source),
+				})
+			}
+			continue
+		}
+
+		value, ok, reason := wordpressFieldValue(page.item, source, field.GetString("type"), attachments, rewrite)
+		if !ok {
+			if reason != "" {
+				*warnings = append(*warnings, ImportWarning{
+					Kind:    "unmapped_field",
+					File:    sourceFile,
+					Path:    fmt.Sprintf("item %d", page.item.PostID),
+					Field:   key,
+					Message: fmt.Sprintf("Post %q: %s", page.item.Title, reason),
+				})
+			}
+			continue
+		}
+
+		writer.check(field, value, nil, fmt.Sprintf("item %d.%s", page.item.PostID, key), constraintWarnings(warnings, sourceFile, ""))
+		if err := writer.set(field, value, pathToPageId); err != nil {
+			return fmt.Errorf("failed to save %s for page %s: %w", key, page.path, err)
+		}
+	}
+	return nil
+}
+
+// wordpressFieldValue converts one post field into the value a field of
+// fieldType stores. ok is false when there is nothing to write; reason
+// says why when it is worth a warning.
+func wordpressFieldValue(item wxrItem, source, fieldType string, attachments map[int]*core.Record, rewrite func(string) string) (value interface{}, ok bool, reason string) {
+	switch source {
+	case "title", "content", "excerpt":
+		body := item.Title
+		if source == "content" {
+			body = wordpressAutoParagraphs(item.content())
+		} else if source == "excerpt" {
+			body = wordpressAutoParagraphs(item.excerpt())
+		}
+		if strings.TrimSpace(body) == "" {
+			return nil, false, ""
+		}
+		switch fieldType {
+		case "rich-text":
+			if source == "title" {
+				return textToRichText(body), true, ""
+			}
+			return htmlToRichText(body, rewrite), true, ""
+		case "markdown":
+			if source == "title" {
+				return body, true, ""
+			}
+			return htmlToMarkdown(body, rewrite), true, ""
+		case "text":
+			if source == "title" {
+				return body, true, ""
+			}
+			return htmlToPlainText(body), true, ""
+		}
+	case "date":
+		date, err := time.Parse("2006-01-02 15:04:05", item.PostDate)
+		if err != nil {
+			return nil, false, ""
+		}
+		switch fieldType {
+		case "date", "text":
+			return date.Format("2006-01-02"), true, ""
+		}
+	case "featured_image":
+		id, _ := strconv.Atoi(item.meta("_thumbnail_id"))
+		if id == 0 {
+			return nil, false, ""
+		}
+		upload := attachments[id]
+		if upload == nil {
+			return nil, false, fmt.Sprintf("featured image %d was not imported", id)
+		}
+		switch fieldType {
+		case "image":
+			return map[string]interface{}{
+				"url":    "",
+				"src":    "",
+				"alt":    upload.GetString("alt"),
+				"size":   nil,
+				"width":  nullableInt(upload.GetInt("width")),
+				"height": nullableInt(upload.GetInt("height")),
+				"upload": upload.Id,
+			}, true, ""
+		case "url", "text":
+			return uploadManifestEntry(upload).URL, true, ""
+		}
+	}
+	return nil, false, fmt.Sprintf("the %s can't be stored in a %s field", source, fieldType)
+}
+
+func nullableInt(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// wordpressSlug is the post's slug, or one made from its title for drafts
+// WordPress hasn't given a slug yet.
+func wordpressSlug(item wxrItem) string {
+	if slug, err := url.PathUnescape(item.PostName); err == nil && slug != "" {
+		return slug
+	}
+	slug := strings.Trim(wordpressSlugInvalid.ReplaceAllString(strings.ToLower(item.Title), "-"), "-")
+	if slug == "" {
+		slug = fmt.Sprintf("%s-%d", item.PostType, item.PostID)
+	}
+	return slug
+}
+
+var wordpressSlugInvalid = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// wordpressBlockTag is a tag that makes wpautop leave content alone.
+var wordpressBlockTag = regexp.MustCompile(`(?i)<(p|div|h[1-6]|ul|ol|blockquote|pre|figure|table)[\s>]`)
+
+// wordpressAutoParagraphs mirrors WordPress's wpautop for classic-editor
+// content, which is stored without <p> tags: blank lines separate
+// paragraphs and single newlines are line breaks.
+func wordpressAutoParagraphs(content string) string {
+	if strings.TrimSpace(content) == "" || wordpressBlockTag.MatchString(content) {
+		return content
+	}
+	var b strings.Builder
+	for _, block := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n\n") {
+		if block = strings.Trim(block, "\n"); block != "" {
+			b.WriteString("<p>" + strings.ReplaceAll(block, "\n", "<br>\n") + "</p>\n")
+		}
+	}
+	return b.String()
+}
+
+// wordpressUploadPath is a file's path below wp-content/uploads, which is
+// how _wp_attached_file names it, whether the archive was made from
+// wp-content, from uploads or from inside it.
+func wordpressUploadPath(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if i := strings.LastIndex(name, "uploads/"); i >= 0 {
+		return name[i+len("uploads/"):]
+	}
+	return name
+}
+
+func urlPath(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Path
+}
+
+func wordpressHosts(channel wxrChannel) map[string]bool {
+	hosts := map[string]bool{}
+	for _, raw := range []string{channel.Link, channel.BaseSiteURL, channel.BaseBlogURL} {
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			hosts[strings.TrimPrefix(strings.ToLower(u.Host), "www.")] = true
+		}
+	}
+	return hosts
+}
+
+// wordpressURLKey normalises a URL on the WordPress site to the form
+// urlRewrites is keyed by: path without a trailing slash, plus the query.
+func wordpressURLKey(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Path == "" && u.RawQuery == "") {
+		return ""
+	}
+	key := strings.TrimSuffix(u.Path, "/")
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+	return key
+}
+
+// wordpressImageSize matches the -300x200 WordPress adds to resized copies
+// of an upload.
+var wordpressImageSize = regexp.MustCompile(`-\d+x\d+(\.[^./]+)$`)
+
+// rewriteWordPressURL points a link or image source at the imported page
+// or upload. URLs on other hosts, and ones nothing was imported for, are
+// left alone.
+func rewriteWordPressURL(raw string, hosts map[string]bool, rewrites map[string]string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Host == "" && !strings.HasPrefix(u.Path, "/") && u.RawQuery == "") {
+		return raw
+	}
+	if u.Host != "" && !hosts[strings.TrimPrefix(strings.ToLower(u.Host), "www.")] {
+		return raw
+	}
+
+	if strings.Contains(u.Path, "/uploads/") {
+		relative := wordpressUploadPath(u.Path)
+		if target, ok := rewrites["upload:"+relative]; ok {
+			return target
+		}
+		if target, ok := rewrites["upload:"+wordpressImageSize.ReplaceAllString(relative, "$1")]; ok {
+			return target
+		}
+	}
+
+	key := wordpressURLKey(raw)
+	if u.Path == "" || u.Path == "/" {
+		key = "?" + u.RawQuery
+	}
+	if target, ok := rewrites[key]; ok {
+		if u.Fragment != "" {
+			target += "#" + u.Fragment
+		}
+		return target
+	}
+	return raw
+}
@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	imagepng "image/png"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestImportWordPressExport(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	project := map[string]string{
+		"site.yaml":                      "name: Migrated\n",
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "- name: content\n  type: rich-text\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"page-types/post/config.yaml":    "name: Post\n",
+		"page-types/post/fields.yaml": "- name: body\n  type: rich-text\n" +
+			"- name: summary\n  type: markdown\n" +
+			"- name: published\n  type: date\n" +
+			"- name: cover\n  type: image\n",
+		"page-types/post/layout.yaml": "{}\n",
+		"pages/index.yaml":            "name: Home\npage_type: default\nsections: []\n",
+		"site/fields.yaml":            "[]\n",
+		"site/content.yaml":           "{}\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	item := func(id int, postType, status, slug string, parent int, extra string) string {
+		return fmt.Sprintf(`<item><title>%s</title><link>https://old.example.com/%s/</link><guid>https://old.example.com/?p=%d</guid>
+<wp:post_id>%d</wp:post_id><wp:post_date>2023-05-04 09:30:00</wp:post_date><wp:post_name>%s</wp:post_name><wp:status>%s</wp:status>
+<wp:post_parent>%d</wp:post_parent><wp:menu_order>0</wp:menu_order><wp:post_type>%s</wp:post_type>%s</item>`,
+			strings.ToUpper(slug[:1])+slug[1:], slug, id, id, slug, status, parent, postType, extra)
+	}
+	wxr := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:excerpt="http://wordpress.org/export/1.2/excerpt/" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:wp="http://wordpress.org/export/1.2/">
+<channel><title>Old</title><link>https://old.example.com</link><wp:base_site_url>https://old.example.com</wp:base_site_url>
+` + item(2, "page", "publish", "about", 0, `<content:encoded><![CDATA[<p>About us</p>]]></content:encoded>`) +
+		item(3, "page", "publish", "team", 2, "") +
+		item(4, "page", "publish", "blog", 0, "") +
+		item(10, "post", "publish", "hello", 0, `<content:encoded><![CDATA[Meet <a href="https://old.example.com/about/team/">the team</a>.
+
+<img src="https://old.example.com/wp-content/uploads/2023/05/photo-300x200.png" alt="Photo">]]></content:encoded>
+<excerpt:encoded><![CDATA[A <strong>short</strong> hello]]></excerpt:encoded>
+<wp:postmeta><wp:meta_key>_thumbnail_id</wp:meta_key><wp:meta_value>20</wp:meta_value></wp:postmeta>`) +
+		item(11, "post", "draft", "unfinished", 0, "") +
+		item(20, "attachment", "inherit", "photo", 10, `<wp:attachment_url>https://old.example.com/wp-content/uploads/2023/05/photo.png</wp:attachment_url>
+<wp:postmeta><wp:meta_key>_wp_attached_file</wp:meta_key><wp:meta_value>2023/05/photo.png</wp:meta_value></wp:postmeta>
+<wp:postmeta><wp:meta_key>_wp_attachment_image_alt</wp:meta_key><wp:meta_value>A photo</wp:meta_value></wp:postmeta>`) +
+		`</channel></rss>`
+
+	doc, err := parseWXR(strings.NewReader(wxr))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	var png bytes.Buffer
+	if err := imagepng.Encode(&png, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	uploadsZip := zipFiles(t, map[string]string{"wp-content/uploads/2023/05/photo.png": png.String()})
+	uploads, err := zip.NewReader(bytes.NewReader(uploadsZip), int64(len(uploadsZip)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := WordPressImportOptions{
+		PostPageType: "post",
+		PostsParent:  "blog",
+		Mapping:      map[string]string{"content": "body", "excerpt": "summary", "date": "published", "featured_image": "cover"},
+	}
+	result, err := importWordPress(app, site, doc, uploads, "export.xml", opts)
+	if err != nil {
+		t.Fatalf("wordpress import: %v", err)
+	}
+
+	paths := map[string]string{}
+	for _, page := range result.Pages {
+		paths[page.Path] = page.ID
+	}
+	for _, want := range []string{"about", "about/team", "blog", "blog/hello"} {
+		if paths[want] == "" {
+			t.Fatalf("expected a page at %s, got %+v", want, result.Pages)
+		}
+	}
+	if len(result.Uploads) != 1 || len(result.Skipped) != 1 || result.Skipped[0].WordPressID != 11 {
+		t.Fatalf("expected the attachment imported and the draft skipped, got uploads %+v skipped %+v", result.Uploads, result.Skipped)
+	}
+	upload, err := app.FindRecordById("site_uploads", result.Uploads[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.GetString("folder") != "wordpress/2023/05" || upload.GetString("alt") != "A photo" {
+		t.Fatalf("unexpected upload folder %q alt %q", upload.GetString("folder"), upload.GetString("alt"))
+	}
+
+	values := map[string]string{}
+	fields, _ := app.FindAllRecords("page_type_fields")
+	fieldKeys := map[string]string{}
+	for _, field := range fields {
+		fieldKeys[field.Id] = field.GetString("key")
+	}
+	entries, _ := app.FindAllRecords("page_entries", dbx.HashExp{"page": paths["blog/hello"]})
+	for _, entry := range entries {
+		values[fieldKeys[entry.GetString("field")]] = entry.GetString("value")
+	}
+
+	fileURL := uploadManifestEntry(upload).URL
+	for _, want := range []string{`"href":"/about/team"`, `"type":"image"`, `"src":"` + fileURL + `"`} {
+		if !strings.Contains(values["body"], want) {
+			t.Fatalf("expected body to contain %s, got %s", want, values["body"])
+		}
+	}
+	if values["summary"] != `"A **short** hello\n"` {
+		t.Fatalf("expected the excerpt as markdown, got %s", values["summary"])
+	}
+	if values["published"] != `"2023-05-04"` {
+		t.Fatalf("expected the post date, got %s", values["published"])
+	}
+	if !strings.Contains(values["cover"], `"upload":"`+upload.Id+`"`) {
+		t.Fatalf("expected the featured image to reference the upload, got %s", values["cover"])
+	}
+
+	// Importing again updates the same pages and reuses the upload.
+	again, err := importWordPress(app, site, doc, uploads, "export.xml", opts)
+	if err != nil {
+		t.Fatalf("re-import: %v", err)
+	}
+	if again.Pages[0].ID != result.Pages[0].ID || again.Uploads[0].ID != upload.Id {
+		t.Fatalf("expected a re-import to update in place, got %+v", again)
+	}
+}
+
+func TestImportWordPressRollbackRemovesUploadBlobs(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+	project := map[string]string{
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: default\nsections: []\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	uploadsColl, err := app.FindCollectionByNameOrId("site_uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadsColl.Fields.GetByName("file").(*core.FileField).MaxSize = 100
+	if err := app.Save(uploadsColl); err != nil {
+		t.Fatal(err)
+	}
+
+	attachment := func(id int, file string) string {
+		return fmt.Sprintf(`<item><title>%s</title><wp:post_id>%d</wp:post_id><wp:status>inherit</wp:status><wp:post_type>attachment</wp:post_type>
+<wp:postmeta><wp:meta_key>_wp_attached_file</wp:meta_key><wp:meta_value>%s</wp:meta_value></wp:postmeta></item>`, file, id, file)
+	}
+	doc, err := parseWXR(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:wp="http://wordpress.org/export/1.2/"><channel>` +
+		attachment(1, "small.txt") + attachment(2, "large.txt") + `</channel></rss>`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	uploadsZip := zipFiles(t, map[string]string{
+		"wp-content/uploads/small.txt": "small file",
+		"wp-content/uploads/large.txt": strings.Repeat("x", 101),
+	})
+	uploads, err := zip.NewReader(bytes.NewReader(uploadsZip), int64(len(uploadsZip)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := importWordPress(app, site, doc, uploads, "export.xml", WordPressImportOptions{}); err == nil {
+		t.Fatal("expected the oversized attachment to fail the import")
+	}
+	if records, _ := app.FindAllRecords("site_uploads"); len(records) != 0 {
+		t.Fatalf("expected no uploads after the rollback, got %d", len(records))
+	}
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	if exists, _ := fsys.Exists(blobKey(blobHash([]byte("small file")))); exists {
+		t.Fatal("expected the blob written before the failure removed")
+	}
+}
@@ -0,0 +1,647 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+)
+
+// The entry and field tables hang together by ids the database doesn't
+// fully guard: an entry's field must belong to the same block or page
+// type as its section or page, an entry's parent must be an entry of the
+// parent field, and ids inside config and value JSON (site-field and
+// page-field targets, page types, conditions, linked pages, uploads) are
+// not relations at all. Clone, import and older versions have each left
+// some of these dangling. The integrity check finds them per site, and
+// repair fixes what it finds in one transaction.
+
+// IntegrityIssue is one problem found in a site.
+type IntegrityIssue struct {
+	// Kind is orphaned_entry, missing_parent, parent_cycle,
+	// duplicate_index or dangling_reference.
+	Kind       string `json:"kind"`
+	Collection string `json:"collection"`
+	Record     string `json:"record"`
+	Message    string `json:"message"`
+	// Repair says what repairing does about the issue.
+	Repair string `json:"repair"`
+
+	fix     func(app core.App) error
+	deletes bool
+}
+
+// IntegrityReport is the result of checking, or repairing, a site.
+type IntegrityReport struct {
+	Site     string           `json:"site"`
+	Issues   []IntegrityIssue `json:"issues"`
+	Repaired bool             `json:"repaired"`
+}
+
+// integrityFieldCollections are the field tables, with the column naming
+// their owner and the filter scoping them to a site.
+var integrityFieldCollections = []struct {
+	Name   string
+	Owner  string
+	Filter string
+}{
+	{"site_fields", "site", "site = {:site}"},
+	{"site_symbol_fields", "symbol", "symbol.site = {:site}"},
+	{"page_type_fields", "page_type", "page_type.site = {:site}"},
+}
+
+// integrityEntryCollections are the entry tables. Entries of a page or
+// section are owned by it, and their field must belong to its page type
+// or block; the others are owned through their field.
+var integrityEntryCollections = []struct {
+	Name   string
+	Fields string
+	Owner  string // owner column, or "" for entries owned by their field
+}{
+	{"site_entries", "site_fields", ""},
+	{"site_symbol_entries", "site_symbol_fields", ""},
+	{"page_type_entries", "page_type_fields", ""},
+	{"page_entries", "page_type_fields", "page"},
+	{"page_section_entries", "site_symbol_fields", "section"},
+	{"page_type_section_entries", "site_symbol_fields", "section"},
+}
+
+// RegisterIntegrityCheck serves the integrity report and repair for a
+// site, and adds an `integrity` command doing the same from the shell.
+func RegisterIntegrityCheck(pb *pocketbase.PocketBase) error {
+	pb.OnServe().BindFunc(func(serveEvent *core.ServeEvent) error {
+		serveEvent.Router.GET("/api/palacms/sites/{siteId}/integrity", func(e *core.RequestEvent) error {
+			site, err := requireSiteAccess(pb, e, false, ScopeContentRead)
+			if err != nil {
+				return err
+			}
+			report, err := checkSiteIntegrity(pb, site)
+			if err != nil {
+				return e.InternalServerError("Integrity check failed: "+err.Error(), err)
+			}
+			return e.JSON(200, report)
+		})
+
+		serveEvent.Router.POST("/api/palacms/sites/{siteId}/integrity/repair", func(e *core.RequestEvent) error {
+			site, err := requireSiteAccess(pb, e, true, ScopeContentWrite)
+			if err != nil {
+				return err
+			}
+			report, err := repairSiteIntegrity(pb, site)
+			if err != nil {
+				return e.InternalServerError("Repair failed: "+err.Error(), err)
+			}
+			return e.JSON(200, report)
+		})
+
+		return serveEvent.Next()
+	})
+
+	var siteId string
+	var repair, asJSON bool
+	command := &cobra.Command{
+		Use:   "integrity",
+		Short: "Check sites for dangling references between fields and entries, and optionally repair them",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var sites []*core.Record
+			if siteId != "" {
+				site, err := pb.FindRecordById("sites", siteId)
+				if err != nil {
+					return fmt.Errorf("site %q not found: %w", siteId, err)
+				}
+				sites = append(sites, site)
+			} else {
+				all, err := pb.FindAllRecords("sites")
+				if err != nil {
+					return err
+				}
+				sites = all
+			}
+
+			reports := make([]*IntegrityReport, 0, len(sites))
+			for _, site := range sites {
+				check := checkSiteIntegrity
+				if repair {
+					check = repairSiteIntegrity
+				}
+				report, err := check(pb, site)
+				if err != nil {
+					return fmt.Errorf("site %s: %w", site.Id, err)
+				}
+				reports = append(reports, report)
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(reports)
+			}
+			for i, report := range reports {
+				fmt.Fprintf(os.Stdout, "%s (%s): %d issues\n", sites[i].GetString("name"), report.Site, len(report.Issues))
+				for _, issue := range report.Issues {
+					fmt.Fprintf(os.Stdout, "  %s %s/%s: %s\n", issue.Kind, issue.Collection, issue.Record, issue.Message)
+					verb := "repair would"
+					if report.Repaired {
+						verb = "repaired:"
+					}
+					fmt.Fprintf(os.Stdout, "    %s %s\n", verb, issue.Repair)
+				}
+			}
+			return nil
+		},
+	}
+	command.Flags().StringVar(&siteId, "site", "", "check only this site")
+	command.Flags().BoolVar(&repair, "repair", false, "repair the issues found, in one transaction per site")
+	command.Flags().BoolVar(&asJSON, "json", false, "print the reports as JSON")
+	pb.RootCmd.AddCommand(command)
+
+	return nil
+}
+
+// repairSiteIntegrity checks site and fixes every issue found, all or
+// nothing. Deletions run first, so later fixes don't touch records a
+// cascade already removed.
+func repairSiteIntegrity(app core.App, site *core.Record) (*IntegrityReport, error) {
+	report, err := checkSiteIntegrity(app, site)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Issues) == 0 {
+		return report, nil
+	}
+
+	ordered := make([]IntegrityIssue, len(report.Issues))
+	copy(ordered, report.Issues)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].deletes && !ordered[j].deletes
+	})
+	err = app.RunInTransaction(func(txApp core.App) error {
+		for _, issue := range ordered {
+			if err := issue.fix(txApp); err != nil {
+				return fmt.Errorf("%s %s/%s: %w", issue.Kind, issue.Collection, issue.Record, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Repaired = true
+	return report, nil
+}
+
+// siteIntegrity holds a site's records while it is checked.
+type siteIntegrity struct {
+	site    *core.Record
+	issues  []IntegrityIssue
+	fields  map[string]map[string]*core.Record // collection -> id -> field
+	owners  map[string]map[string]*core.Record // "<entries>.<owner column>" -> id -> page or section
+	pages   map[string]*core.Record
+	uploads map[string]bool
+	types   map[string]bool // page type ids
+}
+
+// checkSiteIntegrity scans site and reports what is wrong, without
+// changing anything. Entries whose field is gone entirely can only be
+// told apart for pages and sections, which own their entries; the
+// relations cascade, so the others only lose theirs through raw SQL.
+func checkSiteIntegrity(app core.App, site *core.Record) (*IntegrityReport, error) {
+	c := &siteIntegrity{
+		site:    site,
+		fields:  map[string]map[string]*core.Record{},
+		owners:  map[string]map[string]*core.Record{},
+		pages:   map[string]*core.Record{},
+		uploads: map[string]bool{},
+		types:   map[string]bool{},
+	}
+	params := dbx.Params{"site": site.Id}
+
+	pages, err := app.FindRecordsByFilter("pages", "site = {:site}", "", 0, 0, params)
+	if err != nil {
+		return nil, err
+	}
+	for _, page := range pages {
+		c.pages[page.Id] = page
+	}
+	pageTypes, err := app.FindRecordsByFilter("page_types", "site = {:site}", "", 0, 0, params)
+	if err != nil {
+		return nil, err
+	}
+	for _, pageType := range pageTypes {
+		c.types[pageType.Id] = true
+	}
+	uploads, err := app.FindRecordsByFilter("site_uploads", "site = {:site}", "", 0, 0, params)
+	if err != nil {
+		return nil, err
+	}
+	for _, upload := range uploads {
+		c.uploads[upload.Id] = true
+	}
+	pageSections, err := app.FindRecordsByFilter("page_sections", "page.site = {:site}", "", 0, 0, params)
+	if err != nil {
+		return nil, err
+	}
+	pageTypeSections, err := app.FindRecordsByFilter("page_type_sections", "page_type.site = {:site}", "", 0, 0, params)
+	if err != nil {
+		return nil, err
+	}
+	c.owners["page_entries.page"] = c.pages
+	c.owners["page_section_entries.section"] = recordsById(pageSections)
+	c.owners["page_type_section_entries.section"] = recordsById(pageTypeSections)
+
+	c.checkIndexes("pages", pages, "parent")
+	c.checkIndexes("page_sections", pageSections, "page")
+	c.checkIndexes("page_type_sections", pageTypeSections, "page_type", "zone")
+
+	for _, coll := range integrityFieldCollections {
+		fields, err := app.FindRecordsByFilter(coll.Name, coll.Filter, "", 0, 0, params)
+		if err != nil {
+			return nil, err
+		}
+		c.fields[coll.Name] = recordsById(fields)
+	}
+	for _, coll := range integrityFieldCollections {
+		c.checkFields(coll.Name, coll.Owner)
+	}
+
+	for _, coll := range siteEntryCollections {
+		entries, err := app.FindRecordsByFilter(coll.Name, coll.Filter, "", 0, 0, params)
+		if err != nil {
+			return nil, err
+		}
+		for _, layout := range integrityEntryCollections {
+			if layout.Name == coll.Name {
+				c.checkEntries(layout.Name, layout.Fields, layout.Owner, entries)
+			}
+		}
+	}
+
+	if c.issues == nil {
+		c.issues = []IntegrityIssue{}
+	}
+	return &IntegrityReport{Site: site.Id, Issues: c.issues}, nil
+}
+
+func recordsById(records []*core.Record) map[string]*core.Record {
+	byId := make(map[string]*core.Record, len(records))
+	for _, record := range records {
+		byId[record.Id] = record
+	}
+	return byId
+}
+
+func (c *siteIntegrity) report(kind string, record *core.Record, message, repair string, fix func(app core.App) error) {
+	c.issues = append(c.issues, IntegrityIssue{
+		Kind:       kind,
+		Collection: record.Collection().Name,
+		Record:     record.Id,
+		Message:    message,
+		Repair:     repair,
+		fix:        fix,
+	})
+}
+
+// reportDeletion reports an entry that can only be repaired by deleting
+// it (and, by cascade, the entries under it).
+func (c *siteIntegrity) reportDeletion(kind string, entry *core.Record, message string) {
+	c.report(kind, entry, message, "delete the entry", deleteRecord(entry.Collection().Name, entry.Id))
+	c.issues[len(c.issues)-1].deletes = true
+}
+
+// checkFields checks one field table: parents, cycles, indexes, and the
+// ids in each config.
+func (c *siteIntegrity) checkFields(collection, owner string) {
+	fields := c.fields[collection]
+	records := sortedRecords(fields)
+
+	for _, field := range records {
+		parentId := field.GetString("parent")
+		if parentId == "" {
+			continue
+		}
+		parent := fields[parentId]
+		switch {
+		case parent == nil:
+			c.report("missing_parent", field, fmt.Sprintf("field %q has parent %s, which does not exist", field.GetString("key"), parentId),
+				"move the field to the top level", setRecordValue(collection, field.Id, "parent", ""))
+		case parent.GetString(owner) != field.GetString(owner):
+			c.report("missing_parent", field, fmt.Sprintf("field %q has parent %q, which belongs to another %s", field.GetString("key"), parent.GetString("key"), strings.ReplaceAll(owner, "_", " ")),
+				"move the field to the top level", setRecordValue(collection, field.Id, "parent", ""))
+		}
+	}
+
+	for _, cycle := range parentCycles(records, fields) {
+		// Breaking the cycle at one field keeps it and everything under it.
+		field := cycle[0]
+		c.report("parent_cycle", field, fmt.Sprintf("fields %s are each other's parents", recordKeys(cycle)),
+			fmt.Sprintf("move field %q to the top level", field.GetString("key")), setRecordValue(collection, field.Id, "parent", ""))
+	}
+
+	c.checkIndexes(collection, records, owner, "parent")
+
+	for _, field := range records {
+		config, _ := normalizeValue(field.Get("config")).(map[string]interface{})
+		if config == nil {
+			continue
+		}
+		key := field.GetString("key")
+		switch field.GetString("type") {
+		case "site-field":
+			if ref := getString(config, "field"); ref != "" && c.fields["site_fields"][ref] == nil {
+				c.report("dangling_reference", field, fmt.Sprintf("site-field %q points at site field %s, which is not in this site", key, ref),
+					"clear config.field", removeConfigKey(collection, field.Id, "field"))
+			}
+		case "page-field":
+			if ref := getString(config, "field"); ref != "" && c.fields["page_type_fields"][ref] == nil {
+				c.report("dangling_reference", field, fmt.Sprintf("page-field %q points at page type field %s, which is not in this site", key, ref),
+					"clear config.field", removeConfigKey(collection, field.Id, "field"))
+			}
+		case "page", "page-list":
+			if ref := getString(config, "page_type"); ref != "" && !c.types[ref] {
+				c.report("dangling_reference", field, fmt.Sprintf("%s field %q points at page type %s, which is not in this site", field.GetString("type"), key, ref),
+					"clear config.page_type", removeConfigKey(collection, field.Id, "page_type"))
+			}
+		}
+		if condition, ok := config["condition"].(map[string]interface{}); ok {
+			if ref := getString(condition, "field"); ref != "" {
+				sibling := fields[ref]
+				if sibling == nil || sibling.Id == field.Id || sibling.GetString(owner) != field.GetString(owner) || sibling.GetString("parent") != field.GetString("parent") {
+					c.report("dangling_reference", field, fmt.Sprintf("the condition on field %q names %s, which is not a field next to it", key, ref),
+						"remove the condition", removeConfigKey(collection, field.Id, "condition"))
+				}
+			}
+		}
+	}
+}
+
+// checkEntries checks one entry table: that each entry's field exists and
+// belongs to its owner, parents, cycles, repeater item indexes, and the
+// ids in each value.
+func (c *siteIntegrity) checkEntries(collection, fieldsCollection, owner string, entries []*core.Record) {
+	fields := c.fields[fieldsCollection]
+	byId := recordsById(entries)
+	records := sortedRecords(byId)
+
+	valid := make([]*core.Record, 0, len(records))
+	for _, entry := range records {
+		field := fields[entry.GetString("field")]
+		if field == nil {
+			c.reportDeletion("orphaned_entry", entry, fmt.Sprintf("the entry's field %q does not exist in this site", entry.GetString("field")))
+			continue
+		}
+		if owner != "" {
+			ownerRecord := c.owners[collection+"."+owner][entry.GetString(owner)]
+			if ownerRecord != nil && !entryFieldMatchesOwner(field, ownerRecord) {
+				c.reportDeletion("orphaned_entry", entry, fmt.Sprintf("the entry's field %q belongs to another %s than its %s", field.GetString("key"), entryFieldOwnerName(field), owner))
+				continue
+			}
+		}
+
+		parentId := entry.GetString("parent")
+		parent := byId[parentId]
+		switch {
+		case parentId == "" && field.GetString("parent") != "":
+			c.reportDeletion("missing_parent", entry, fmt.Sprintf("the entry is for subfield %q but has no parent entry", field.GetString("key")))
+			continue
+		case parentId != "" && parent == nil:
+			c.reportDeletion("missing_parent", entry, fmt.Sprintf("the entry's parent %s does not exist", parentId))
+			continue
+		case parentId != "" && (parent.GetString("field") != field.GetString("parent") || (owner != "" && parent.GetString(owner) != entry.GetString(owner))):
+			c.reportDeletion("missing_parent", entry, fmt.Sprintf("the entry's parent %s is not an entry of the field above %q", parentId, field.GetString("key")))
+			continue
+		}
+		valid = append(valid, entry)
+	}
+
+	for _, cycle := range parentCycles(records, byId) {
+		for _, entry := range cycle {
+			c.reportDeletion("parent_cycle", entry, fmt.Sprintf("entries %s are each other's parents", recordIds(cycle)))
+		}
+	}
+
+	repeaterItems := make([]*core.Record, 0)
+	for _, entry := range valid {
+		if fields[entry.GetString("field")].GetString("type") == "repeater" {
+			repeaterItems = append(repeaterItems, entry)
+		}
+	}
+	groupColumns := []string{"parent", "field"}
+	if owner != "" {
+		groupColumns = append(groupColumns, owner)
+	}
+	c.checkIndexes(collection, repeaterItems, groupColumns...)
+
+	for _, entry := range valid {
+		field := fields[entry.GetString("field")]
+		value := normalizeValue(entry.Get("value"))
+		switch field.GetString("type") {
+		case "page":
+			if ref, ok := value.(string); ok && ref != "" && c.pages[ref] == nil {
+				c.report("dangling_reference", entry, fmt.Sprintf("page field %q points at page %s, which is not in this site", field.GetString("key"), ref),
+					"clear the value", setRecordValue(collection, entry.Id, "value", ""))
+			}
+		case "link":
+			link, _ := value.(map[string]interface{})
+			if ref := getString(link, "page"); ref != "" && c.pages[ref] == nil {
+				c.report("dangling_reference", entry, fmt.Sprintf("link %q points at page %s, which is not in this site", field.GetString("key"), ref),
+					"clear the link's page", removeValueKey(collection, entry.Id, "page"))
+			}
+		case "image":
+			image, _ := value.(map[string]interface{})
+			if ref := getString(image, "upload"); ref != "" && !c.uploads[ref] {
+				c.report("dangling_reference", entry, fmt.Sprintf("image %q points at upload %s, which is not in this site", field.GetString("key"), ref),
+					"clear the image's upload", removeValueKey(collection, entry.Id, "upload"))
+			}
+		}
+	}
+}
+
+// entryFieldMatchesOwner reports whether field belongs to the page type
+// of a page, or the block of a section.
+func entryFieldMatchesOwner(field, owner *core.Record) bool {
+	if symbol := field.GetString("symbol"); symbol != "" {
+		return owner.GetString("symbol") == symbol
+	}
+	return owner.GetString("page_type") == field.GetString("page_type")
+}
+
+func entryFieldOwnerName(field *core.Record) string {
+	if field.GetString("symbol") != "" {
+		return "block"
+	}
+	return "page type"
+}
+
+// checkIndexes reports records that share an index with a sibling, the
+// siblings being those alike in every column of group. Repair numbers
+// each such group 0, 1, 2... in its current order.
+func (c *siteIntegrity) checkIndexes(collection string, records []*core.Record, group ...string) {
+	groups := map[string][]*core.Record{}
+	keys := []string{}
+	for _, record := range records {
+		parts := make([]string, len(group))
+		for i, column := range group {
+			parts[i] = record.GetString(column)
+		}
+		key := strings.Join(parts, "\x00")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], record)
+	}
+
+	for _, key := range keys {
+		siblings := groups[key]
+		sort.SliceStable(siblings, func(i, j int) bool {
+			if siblings[i].GetInt("index") != siblings[j].GetInt("index") {
+				return siblings[i].GetInt("index") < siblings[j].GetInt("index")
+			}
+			return siblings[i].Id < siblings[j].Id
+		})
+		var duplicated []*core.Record
+		for i := 1; i < len(siblings); i++ {
+			if siblings[i].GetInt("index") == siblings[i-1].GetInt("index") {
+				duplicated = append(duplicated, siblings[i])
+			}
+		}
+		if len(duplicated) == 0 {
+			continue
+		}
+		ids := make([]string, len(siblings))
+		for i, sibling := range siblings {
+			ids[i] = sibling.Id
+		}
+		for _, record := range duplicated {
+			c.report("duplicate_index", record, fmt.Sprintf("index %d is shared with a sibling", record.GetInt("index")),
+				"renumber the siblings in order", renumberRecords(collection, ids))
+		}
+	}
+}
+
+// parentCycles finds the records whose parent chain loops back on
+// itself, one slice per loop.
+func parentCycles(records []*core.Record, byId map[string]*core.Record) [][]*core.Record {
+	var cycles [][]*core.Record
+	done := map[string]bool{}
+	for _, record := range records {
+		onPath := map[string]int{}
+		path := []*core.Record{}
+		for current := record; current != nil && !done[current.Id]; current = byId[current.GetString("parent")] {
+			if start, ok := onPath[current.Id]; ok {
+				cycles = append(cycles, path[start:])
+				break
+			}
+			onPath[current.Id] = len(path)
+			path = append(path, current)
+		}
+		for _, visited := range path {
+			done[visited.Id] = true
+		}
+	}
+	return cycles
+}
+
+func sortedRecords(byId map[string]*core.Record) []*core.Record {
+	records := make([]*core.Record, 0, len(byId))
+	for _, record := range byId {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Id < records[j].Id })
+	return records
+}
+
+func recordKeys(records []*core.Record) string {
+	keys := make([]string, len(records))
+	for i, record := range records {
+		keys[i] = fmt.Sprintf("%q", record.GetString("key"))
+	}
+	return strings.Join(keys, ", ")
+}
+
+func recordIds(records []*core.Record) string {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.Id
+	}
+	return strings.Join(ids, ", ")
+}
+
+// The fixes reload their record inside the repair transaction, and skip
+// it when an earlier fix (or a cascade) already deleted it. Records are
+// saved with keepContent: a repair doesn't make an entry's content break
+// its field's rules, so content that already did mustn't block it.
+
+func deleteRecord(collection, id string) func(app core.App) error {
+	return func(app core.App) error {
+		record, err := app.FindRecordById(collection, id)
+		if err != nil {
+			return nil
+		}
+		return app.Delete(record)
+	}
+}
+
+func setRecordValue(collection, id, column string, value interface{}) func(app core.App) error {
+	return func(app core.App) error {
+		record, err := app.FindRecordById(collection, id)
+		if err != nil {
+			return nil
+		}
+		record.Set(column, value)
+		return app.SaveWithContext(keepContent(), record)
+	}
+}
+
+// removeConfigKey removes key from a field's config.
+func removeConfigKey(collection, id, key string) func(app core.App) error {
+	return func(app core.App) error {
+		record, err := app.FindRecordById(collection, id)
+		if err != nil {
+			return nil
+		}
+		config, _ := normalizeValue(record.Get("config")).(map[string]interface{})
+		delete(config, key)
+		record.Set("config", config)
+		return app.Save(record)
+	}
+}
+
+// removeValueKey removes key from an entry's object value.
+func removeValueKey(collection, id, key string) func(app core.App) error {
+	return func(app core.App) error {
+		record, err := app.FindRecordById(collection, id)
+		if err != nil {
+			return nil
+		}
+		value, _ := normalizeValue(record.Get("value")).(map[string]interface{})
+		delete(value, key)
+		record.Set("value", value)
+		return app.SaveWithContext(keepContent(), record)
+	}
+}
+
+func renumberRecords(collection string, ids []string) func(app core.App) error {
+	return func(app core.App) error {
+		index := 0
+		for _, id := range ids {
+			record, err := app.FindRecordById(collection, id)
+			if err != nil {
+				continue
+			}
+			if record.GetInt("index") != index {
+				record.Set("index", index)
+				if err := app.SaveWithContext(keepContent(), record); err != nil {
+					return err
+				}
+			}
+			index++
+		}
+		return nil
+	}
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestSiteIntegrityRepair(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	project := map[string]string{
+		"blocks/hero/config.yaml":      "name: Hero\n",
+		"blocks/hero/component.svelte": "<section>{heading}</section>\n",
+		"blocks/hero/fields.yaml": "" +
+			"- name: heading\n  type: text\n" +
+			"- name: cta\n  type: link\n" +
+			"- name: items\n  type: repeater\n  subfields:\n    - name: label\n      type: text\n",
+		"blocks/hero/content.yaml":       "{}\n",
+		"blocks/quote/config.yaml":       "name: Quote\n",
+		"blocks/quote/component.svelte":  "<blockquote>{text}</blockquote>\n",
+		"blocks/quote/fields.yaml":       "- name: text\n  type: text\n",
+		"blocks/quote/content.yaml":      "{}\n",
+		"blocks/box/config.yaml":         "name: Box\n",
+		"blocks/box/component.svelte":    "<div>{box.inner}</div>\n",
+		"blocks/box/fields.yaml":         "- name: box\n  type: group\n  subfields:\n    - name: inner\n      type: text\n",
+		"blocks/box/content.yaml":        "{}\n",
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "- name: subtitle\n  type: text\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: default\nsections: []\n",
+		"pages/about.yaml": "" +
+			"name: About\npage_type: default\n" +
+			"sections:\n" +
+			"  - block: hero\n" +
+			"    content:\n" +
+			"      heading: Hello\n" +
+			"      cta:\n        url: /\n        label: Home\n" +
+			"      items:\n        - label: One\n        - label: Two\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	report, err := checkSiteIntegrity(app, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 0 {
+		t.Fatalf("expected a fresh import to be consistent, got %+v", report.Issues)
+	}
+
+	entry := func(fieldId string) []*core.Record {
+		t.Helper()
+		records, err := app.FindRecordsByFilter("page_section_entries", "field = {:field}", "+index", 0, 0, dbx.Params{"field": fieldId})
+		if err != nil || len(records) == 0 {
+			t.Fatalf("find entries of %s: %v", fieldId, err)
+		}
+		return records
+	}
+	save := func(record *core.Record) {
+		t.Helper()
+		if err := app.SaveNoValidate(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An entry moved onto another block's field, a repeater index used
+	// twice, a link to a deleted page, a condition on a missing field and
+	// a loop of subfields.
+	heading := entry(findBlockField(t, app, site, "Hero", "heading").Id)[0]
+	heading.Set("field", findBlockField(t, app, site, "Quote", "text").Id)
+	save(heading)
+	items := entry(findBlockField(t, app, site, "Hero", "items").Id)
+	items[1].Set("index", items[0].GetInt("index"))
+	save(items[1])
+	cta := entry(findBlockField(t, app, site, "Hero", "cta").Id)[0]
+	cta.Set("value", map[string]interface{}{"url": "", "label": "Gone", "page": "missingpage0001"})
+	save(cta)
+	text := findBlockField(t, app, site, "Quote", "text")
+	text.Set("config", map[string]interface{}{"condition": map[string]interface{}{"field": "missingfield001", "comparison": "=", "value": "x"}})
+	save(text)
+	box, inner := findBlockField(t, app, site, "Box", "box"), findBlockField(t, app, site, "Box", "inner")
+	box.Set("parent", inner.Id)
+	save(box)
+
+	report, err = checkSiteIntegrity(app, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []string{}
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind+":"+issue.Collection)
+	}
+	slices.Sort(kinds)
+	want := []string{
+		"dangling_reference:page_section_entries",
+		"dangling_reference:site_symbol_fields",
+		"duplicate_index:page_section_entries",
+		"orphaned_entry:page_section_entries",
+		"parent_cycle:site_symbol_fields",
+	}
+	if !slices.Equal(kinds, want) {
+		t.Fatalf("expected issues %v, got %v", want, kinds)
+	}
+
+	repaired, err := repairSiteIntegrity(app, site)
+	if err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if !repaired.Repaired || len(repaired.Issues) != len(want) {
+		t.Fatalf("expected the issues repaired, got %+v", repaired)
+	}
+	if _, err := app.FindRecordById("page_section_entries", heading.Id); err == nil {
+		t.Fatal("expected the orphaned entry to be deleted")
+	}
+	cta, _ = app.FindRecordById("page_section_entries", cta.Id)
+	if link, _ := normalizeValue(cta.Get("value")).(map[string]interface{}); link["page"] != nil || link["label"] != "Gone" {
+		t.Fatalf("expected only the link's page cleared, got %v", link)
+	}
+
+	report, err = checkSiteIntegrity(app, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 0 {
+		t.Fatalf("expected no issues after repair, got %+v", report.Issues)
+	}
+}
@@ -41,6 +41,56 @@ func IsLocalhost(e *core.RequestEvent) bool {
 	return ip != nil && ip.IsLoopback()
 }
 
+// requireSiteAccess resolves {siteId} and checks the caller may view the
+// site (or update it, for modifying endpoints), or holds an API key with
+// scope. Localhost is allowed through unauthenticated for primo dev, as
+// with export/import.
+func requireSiteAccess(pb *pocketbase.PocketBase, e *core.RequestEvent, modify bool, scope string) (*core.Record, error) {
+	if e.Auth == nil && requestSiteAPIKey(e) == "" && !IsLocalhost(e) {
+		return nil, e.UnauthorizedError("Authentication required", nil)
+	}
+
+	site, err := pb.FindRecordById("sites", e.Request.PathValue("siteId"))
+	if err != nil {
+		return nil, e.NotFoundError("Site not found", err)
+	}
+
+	if err := checkSiteAccess(e, site, modify, scope); err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// checkSiteAccess checks the caller may use site: with an API key granted
+// scope, as a user the site's view (or update, when modify) rule lets in,
+// or from localhost. The returned error is ready to hand back from a
+// handler.
+func checkSiteAccess(e *core.RequestEvent, site *core.Record, modify bool, scope string) error {
+	if key := requestSiteAPIKey(e); key != "" {
+		return authorizeSiteAPIKey(e, key, site, scope)
+	}
+	if IsLocalhost(e) {
+		return nil
+	}
+	if e.Auth == nil {
+		return e.UnauthorizedError("Authentication required", nil)
+	}
+
+	info, err := e.RequestInfo()
+	if err != nil {
+		return e.InternalServerError("Failed to get request info", err)
+	}
+	rule := site.Collection().ViewRule
+	if modify {
+		rule = site.Collection().UpdateRule
+	}
+	canAccess, _ := e.App.CanAccessRecord(site, info, rule)
+	if !canAccess {
+		return e.ForbiddenError("Access denied", nil)
+	}
+	return nil
+}
+
 // RegisterDevAuthEndpoint registers an endpoint for localhost dev
 // authentication. The endpoint creates a fixed-password developer account on
 // demand, so it must never exist in production binaries; we only register
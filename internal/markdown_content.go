@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// markdownToHTML renders the markdown content teams write by hand:
+// headings (ATX and setext), paragraphs, lists, blockquotes, fenced and
+// indented code, rules, raw HTML blocks, and the usual inline syntax. It
+// covers what htmlToRichText can represent, so a markdown body can become
+// a rich-text value; markdown fields keep their source and are rendered by
+// the frontend's markdown-it as before.
+func markdownToHTML(source string) string {
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+	return renderMarkdownBlocks(lines)
+}
+
+// markdownToRichText converts markdown into a TipTap document.
+func markdownToRichText(source string) map[string]interface{} {
+	return htmlToRichText(markdownToHTML(source), nil)
+}
+
+// richTextToMarkdown converts a stored rich-text value into markdown.
+func richTextToMarkdown(value interface{}) string {
+	return htmlToMarkdown(richTextToHTML(value), nil)
+}
+
+var (
+	markdownATXHeading   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	markdownRule         = regexp.MustCompile(`^ {0,3}((\*[ \t]*){3,}|(-[ \t]*){3,}|(_[ \t]*){3,})$`)
+	markdownFence        = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+	markdownListItem     = regexp.MustCompile(`^( {0,3})([-*+]|(\d{1,9})[.)])([ \t]+|$)`)
+	markdownSetext       = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	markdownHTMLBlock    = regexp.MustCompile(`(?i)^ {0,3}<(/?)(p|div|h[1-6]|ul|ol|li|blockquote|pre|figure|table|section|article|iframe|hr|img|details|summary)[\s/>]`)
+	markdownBlankLine    = regexp.MustCompile(`^[ \t]*$`)
+	markdownBlockquote   = regexp.MustCompile(`^ {0,3}> ?`)
+	markdownIndentedCode = regexp.MustCompile(`^( {4}|\t)`)
+)
+
+func renderMarkdownBlocks(lines []string) string {
+	var b strings.Builder
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>" + renderMarkdownInline(strings.Join(paragraph, "\n")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case markdownBlankLine.MatchString(line):
+			flush()
+
+		case markdownFence.MatchString(line):
+			flush()
+			fence := strings.TrimLeft(markdownFence.FindStringSubmatch(line)[1], " ")
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimLeft(lines[i], " "), fence); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+
+		case len(paragraph) == 0 && markdownIndentedCode.MatchString(line):
+			var code []string
+			for ; i < len(lines) && (markdownIndentedCode.MatchString(lines[i]) || markdownBlankLine.MatchString(lines[i])); i++ {
+				code = append(code, markdownIndentedCode.ReplaceAllString(lines[i], ""))
+			}
+			i--
+			b.WriteString("<pre><code>" + html.EscapeString(strings.TrimRight(strings.Join(code, "\n"), "\n")) + "</code></pre>\n")
+
+		case markdownATXHeading.MatchString(line):
+			flush()
+			m := markdownATXHeading.FindStringSubmatch(line)
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">" + renderMarkdownInline(m[2]) + "</h" + level + ">\n")
+
+		case len(paragraph) > 0 && markdownSetext.MatchString(line):
+			level := "2"
+			if strings.HasPrefix(strings.TrimSpace(line), "=") {
+				level = "1"
+			}
+			b.WriteString("<h" + level + ">" + renderMarkdownInline(strings.Join(paragraph, "\n")) + "</h" + level + ">\n")
+			paragraph = nil
+
+		case markdownRule.MatchString(line):
+			flush()
+			b.WriteString("<hr>\n")
+
+		case markdownBlockquote.MatchString(line):
+			flush()
+			var quoted []string
+			for ; i < len(lines) && !markdownBlankLine.MatchString(lines[i]); i++ {
+				quoted = append(quoted, markdownBlockquote.ReplaceAllString(lines[i], ""))
+			}
+			i--
+			b.WriteString("<blockquote>\n" + renderMarkdownBlocks(quoted) + "</blockquote>\n")
+
+		case markdownListItem.MatchString(line) && (len(paragraph) == 0 || !markdownIndentedCode.MatchString(line)):
+			flush()
+			i = renderMarkdownList(&b, lines, i) - 1
+
+		case len(paragraph) == 0 && markdownHTMLBlock.MatchString(line):
+			for ; i < len(lines) && !markdownBlankLine.MatchString(lines[i]); i++ {
+				b.WriteString(lines[i] + "\n")
+			}
+			i--
+
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// renderMarkdownList renders the list starting at lines[start] and returns
+// the index of the first line after it. An item's continuation lines are
+// those indented past its marker; a blank line followed by an unindented
+// line that isn't another item ends the list.
+func renderMarkdownList(b *strings.Builder, lines []string, start int) int {
+	first := markdownListItem.FindStringSubmatch(lines[start])
+	ordered := first[3] != ""
+	bullet := first[2][len(first[2])-1:]
+	if ordered {
+		if n, _ := strconv.Atoi(first[3]); n != 1 {
+			b.WriteString(`<ol start="` + strconv.Itoa(n) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	i := start
+	for i < len(lines) {
+		m := markdownListItem.FindStringSubmatch(lines[i])
+		if m == nil || (m[3] != "") != ordered || m[2][len(m[2])-1:] != bullet {
+			break
+		}
+		indent := len(m[0])
+		if strings.TrimSpace(m[4]) == "" && len(m[4]) > 1 {
+			indent = len(m[1]) + len(m[2]) + 1
+		}
+
+		item := []string{lines[i][len(m[0]):]}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if markdownBlankLine.MatchString(line) {
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) >= indent {
+					item = append(item, "")
+					continue
+				}
+				break
+			}
+			if leadingSpaces(line) >= indent {
+				item = append(item, line[indent:])
+				continue
+			}
+			if markdownListItem.MatchString(line) || markdownBlankLine.MatchString(lines[i-1]) {
+				break
+			}
+			// Lazy continuation of the item's paragraph.
+			item = append(item, strings.TrimLeft(line, " \t"))
+		}
+
+		body := renderMarkdownBlocks(item)
+		// Tight items hold a single paragraph, rendered without <p>.
+		if strings.Count(body, "<p>") == 1 && strings.HasPrefix(body, "<p>") && !strings.Contains(strings.Join(item, "\n"), "\n\n") {
+			body = strings.Replace(strings.Replace(body, "<p>", "", 1), "</p>\n", "\n", 1)
+		}
+		b.WriteString("<li>" + strings.TrimSuffix(body, "\n") + "</li>\n")
+
+		if i < len(lines) && markdownBlankLine.MatchString(lines[i]) && i+1 < len(lines) && markdownListItem.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+
+	if ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+	return i
+}
+
+func leadingSpaces(line string) int {
+	n := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+var (
+	markdownCodeSpan  = regexp.MustCompile("(`+)(.+?)(`+)")
+	markdownRawTag    = regexp.MustCompile(`</?[a-zA-Z][a-zA-Z0-9-]*(?:\s[^<>]*)?/?>`)
+	markdownEscaped   = regexp.MustCompile(`\\([\\` + "`" + `*_{}\[\]()#+\-.!~|>])`)
+	markdownImage     = regexp.MustCompile(`!\[([^\]]*)\]\(\s*<?([^)\s>]*)>?(?:\s+&quot;([^&]*)&quot;)?\s*\)`)
+	markdownLink      = regexp.MustCompile(`\[([^\]]+)\]\(\s*<?([^)\s>]*)>?(?:\s+&quot;([^&]*)&quot;)?\s*\)`)
+	markdownAutolink  = regexp.MustCompile(`&lt;((?:https?|mailto):[^\s&]+)&gt;`)
+	markdownStrong    = regexp.MustCompile(`\*\*([^\s*](?:.*?[^\s*])?)\*\*|__([^\s_](?:.*?[^\s_])?)__`)
+	markdownEmphasis  = regexp.MustCompile(`\*([^\s*](?:[^*]*?[^\s*])?)\*|(^|[^\w])_([^\s_](?:[^_]*?[^\s_])?)_($|[^\w])`)
+	markdownStrike    = regexp.MustCompile(`~~([^\s~](?:.*?[^\s~])?)~~`)
+	markdownHardBreak = regexp.MustCompile(`( {2,}|\\)\n`)
+	markdownHolder    = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+// renderMarkdownInline renders inline markdown. Code spans, escapes, raw
+// tags and link targets are set aside as placeholders first, so emphasis
+// markers inside them (an underscore in a URL) are left alone.
+func renderMarkdownInline(text string) string {
+	var held []string
+	hold := func(s string) string {
+		held = append(held, s)
+		return "\x00" + strconv.Itoa(len(held)-1) + "\x00"
+	}
+
+	text = markdownCodeSpan.ReplaceAllStringFunc(text, func(m string) string {
+		parts := markdownCodeSpan.FindStringSubmatch(m)
+		if len(parts[1]) != len(parts[3]) {
+			return m
+		}
+		return hold("<code>" + html.EscapeString(strings.TrimSpace(parts[2])) + "</code>")
+	})
+	text = markdownEscaped.ReplaceAllStringFunc(text, func(m string) string {
+		return hold(html.EscapeString(m[1:]))
+	})
+	text = markdownRawTag.ReplaceAllStringFunc(text, hold)
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, "&#34;", "&quot;")
+
+	text = markdownImage.ReplaceAllStringFunc(text, func(m string) string {
+		parts := markdownImage.FindStringSubmatch(m)
+		img := `<img src="` + parts[2] + `" alt="` + parts[1] + `"`
+		if parts[3] != "" {
+			img += ` title="` + parts[3] + `"`
+		}
+		return hold(img + ">")
+	})
+	text = markdownLink.ReplaceAllStringFunc(text, func(m string) string {
+		parts := markdownLink.FindStringSubmatch(m)
+		return hold(`<a href="`+parts[2]+`">`) + parts[1] + hold("</a>")
+	})
+	text = markdownAutolink.ReplaceAllStringFunc(text, func(m string) string {
+		target := markdownAutolink.FindStringSubmatch(m)[1]
+		return hold(`<a href="`+target+`">`) + target + hold("</a>")
+	})
+
+	text = markdownStrong.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = markdownEmphasis.ReplaceAllStringFunc(text, func(m string) string {
+		parts := markdownEmphasis.FindStringSubmatch(m)
+		if parts[1] != "" {
+			return "<em>" + parts[1] + "</em>"
+		}
+		return parts[2] + "<em>" + parts[3] + "</em>" + parts[4]
+	})
+	text = markdownStrike.ReplaceAllString(text, "<s>$1</s>")
+	text = markdownHardBreak.ReplaceAllString(text, "<br>\n")
+
+	for markdownHolder.MatchString(text) {
+		text = markdownHolder.ReplaceAllStringFunc(text, func(m string) string {
+			n, _ := strconv.Atoi(markdownHolder.FindStringSubmatch(m)[1])
+			return held[n]
+		})
+	}
+	return text
+}
@@ -0,0 +1,597 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"gopkg.in/yaml.v3"
+)
+
+// Pages can round-trip through a folder of Markdown files with YAML
+// frontmatter, the layout static site generators and docs tooling use:
+//
+//	index.md            the page imported under (the home page by default)
+//	about.md            about
+//	blog/index.md       blog (also _index.md or README.md)
+//	blog/first-post.md  blog/first-post
+//
+// Frontmatter keys set page type fields of the same key; title, slug,
+// page_type and draft are the page's own. The body goes to one markdown or
+// rich-text field: the one asked for, or else the page type's first. A
+// folder without an index file still becomes a page, named after the
+// folder, so the hierarchy below it is kept.
+
+// markdownPageKeys are frontmatter keys that describe the page itself
+// rather than a field.
+var markdownPageKeys = map[string]bool{"title": true, "slug": true, "page_type": true, "draft": true}
+
+type MarkdownImportOptions struct {
+	// PageType names the page type for new pages without page_type in
+	// their frontmatter; the home page's type by default.
+	PageType string
+	// BodyField is the key of the field that receives the Markdown body.
+	BodyField string
+	// Parent is the path of the page the folder is imported under.
+	Parent string
+}
+
+type MarkdownImportResult struct {
+	Pages    []MarkdownImportItem `json:"pages"`
+	Skipped  []MarkdownImportItem `json:"skipped"`
+	Warnings []ImportWarning      `json:"warnings"`
+}
+
+type MarkdownImportItem struct {
+	File    string `json:"file,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Path    string `json:"path"`
+	Created bool   `json:"created,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func handleMarkdownImport(pb *pocketbase.PocketBase, e *core.RequestEvent) error {
+	site, err := requireSiteAccess(pb, e, true, ScopeImport)
+	if err != nil {
+		return err
+	}
+	files, err := readImportSourceFiles(e)
+	if err != nil {
+		return err
+	}
+
+	result, err := importMarkdownPages(pb, site, files, MarkdownImportOptions{
+		PageType:  e.Request.FormValue("page_type"),
+		BodyField: e.Request.FormValue("body_field"),
+		Parent:    strings.Trim(e.Request.FormValue("parent"), "/"),
+	})
+	if err != nil {
+		return e.BadRequestError("Markdown import failed: "+err.Error(), err)
+	}
+	return e.JSON(200, result)
+}
+
+// markdownPage is one page of the folder on its way in. dir is the
+// folder-relative path it stands for ("" for the root index), which
+// children use to find it whatever slug it ends up with.
+type markdownPage struct {
+	file        string
+	dir         string
+	frontmatter map[string]interface{}
+	body        string
+	pagePath    string
+	record      *core.Record
+}
+
+// importMarkdownPages creates or updates a page per Markdown file, in one
+// transaction. Pages are matched by path, so importing the folder again
+// updates what the last import created.
+func importMarkdownPages(pb *pocketbase.PocketBase, site *core.Record, files map[string][]byte, opts MarkdownImportOptions) (*MarkdownImportResult, error) {
+	result := &MarkdownImportResult{
+		Pages:    []MarkdownImportItem{},
+		Skipped:  []MarkdownImportItem{},
+		Warnings: []ImportWarning{},
+	}
+
+	byDir := map[string]*markdownPage{}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ext := path.Ext(name)
+		if !strings.EqualFold(ext, ".md") && !strings.EqualFold(ext, ".markdown") {
+			continue
+		}
+		frontmatter, body, err := splitFrontmatter(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if draft, _ := frontmatter["draft"].(bool); draft {
+			result.Skipped = append(result.Skipped, MarkdownImportItem{File: name, Reason: "draft"})
+			continue
+		}
+
+		dir := strings.TrimSuffix(name, ext)
+		switch path.Base(dir) {
+		case "index", "_index", "README", "readme":
+			dir = strings.TrimSuffix(strings.TrimSuffix(dir, path.Base(dir)), "/")
+		}
+		if existing := byDir[dir]; existing != nil {
+			result.Skipped = append(result.Skipped, MarkdownImportItem{File: name, Reason: "also imported from " + existing.file})
+			continue
+		}
+		byDir[dir] = &markdownPage{file: name, dir: dir, frontmatter: frontmatter, body: body}
+	}
+
+	// Folders without an index file get a page of their own.
+	for dir := range byDir {
+		for parent := path.Dir(dir); parent != "." && parent != "/"; parent = path.Dir(parent) {
+			if byDir[parent] == nil {
+				byDir[parent] = &markdownPage{dir: parent, frontmatter: map[string]interface{}{}}
+			}
+		}
+	}
+	pages := make([]*markdownPage, 0, len(byDir))
+	for _, page := range byDir {
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		di, dj := markdownDirDepth(pages[i].dir), markdownDirDepth(pages[j].dir)
+		if di != dj {
+			return di < dj
+		}
+		return pages[i].dir < pages[j].dir
+	})
+
+	err := pb.RunInTransaction(func(txApp core.App) error {
+		pageTypes, err := txApp.FindRecordsByFilter("page_types", "site = {:site}", "", 0, 0, map[string]any{"site": site.Id})
+		if err != nil {
+			return err
+		}
+		pageByPath, err := sitePagesByPath(txApp, site.Id)
+		if err != nil {
+			return err
+		}
+		base := pageByPath[opts.Parent]
+		if base == nil {
+			if opts.Parent == "" {
+				return fmt.Errorf("the site has no home page to import under")
+			}
+			return fmt.Errorf("parent page %q does not exist", opts.Parent)
+		}
+		defaultType, err := resolvePageTypeByName(pageTypes, opts.PageType, base.GetString("page_type"))
+		if err != nil {
+			return err
+		}
+
+		// Paths first, so links between files resolve whatever the order.
+		fileToPath := map[string]string{}
+		for _, page := range pages {
+			if page.dir == "" {
+				page.pagePath = opts.Parent
+			} else {
+				slug := path.Base(page.dir)
+				if s := getString(page.frontmatter, "slug"); s != "" {
+					slug = s
+				}
+				page.pagePath = path.Join(byDir[markdownParentDir(page.dir)].pathOr(opts.Parent), slug)
+			}
+			if page.file != "" {
+				fileToPath[page.file] = page.pagePath
+			}
+		}
+
+		pagesColl, err := txApp.FindCollectionByNameOrId("pages")
+		if err != nil {
+			return err
+		}
+		for _, page := range pages {
+			record := pageByPath[page.pagePath]
+			created := record == nil
+			if created {
+				record = core.NewRecord(pagesColl)
+				record.Set("site", site.Id)
+			}
+
+			pageType := defaultType
+			if name := getString(page.frontmatter, "page_type"); name != "" {
+				if pageType, err = resolvePageTypeByName(pageTypes, name, ""); err != nil {
+					return fmt.Errorf("%s: %w", page.file, err)
+				}
+			} else if !created && record.GetString("page_type") != "" {
+				pageType = nil
+			}
+			if pageType != nil {
+				record.Set("page_type", pageType.Id)
+			}
+
+			if title := getString(page.frontmatter, "title"); title != "" {
+				record.Set("name", title)
+			} else if created || page.file != "" {
+				record.Set("name", markdownTitle(page))
+			}
+			if page.dir != "" {
+				record.Set("slug", path.Base(page.pagePath))
+				parent := base
+				if p := byDir[markdownParentDir(page.dir)]; p != nil {
+					parent = p.record
+				}
+				record.Set("parent", parent.Id)
+			}
+
+			if err := txApp.Save(record); err != nil {
+				return fmt.Errorf("failed to save page %s: %w", page.pagePath, err)
+			}
+			page.record = record
+			pageByPath[page.pagePath] = record
+			result.Pages = append(result.Pages, MarkdownImportItem{File: page.file, ID: record.Id, Path: page.pagePath, Created: created})
+		}
+
+		pathToPageId := make(map[string]string, len(pageByPath))
+		for pagePath, record := range pageByPath {
+			pathToPageId[pagePath] = record.Id
+		}
+		for _, page := range pages {
+			if page.file == "" {
+				continue
+			}
+			if err := writeMarkdownPageFields(txApp, page, opts.BodyField, fileToPath, pathToPageId, &result.Warnings); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (page *markdownPage) pathOr(fallback string) string {
+	if page == nil {
+		return fallback
+	}
+	return page.pagePath
+}
+
+func markdownParentDir(dir string) string {
+	parent := path.Dir(dir)
+	if parent == "." || parent == "/" {
+		return ""
+	}
+	return parent
+}
+
+func markdownDirDepth(dir string) int {
+	if dir == "" {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// markdownTitle names a page without a title: its first heading, else its
+// slug made readable.
+func markdownTitle(page *markdownPage) string {
+	for _, line := range strings.Split(page.body, "\n") {
+		if m := markdownATXHeading.FindStringSubmatch(line); m != nil && len(m[1]) == 1 && m[2] != "" {
+			return m[2]
+		}
+	}
+	slug := path.Base(page.dir)
+	if page.dir == "" {
+		return "Home"
+	}
+	words := strings.Fields(strings.NewReplacer("-", " ", "_", " ").Replace(slug))
+	if len(words) == 0 {
+		return slug
+	}
+	words[0] = strings.ToUpper(words[0][:1]) + words[0][1:]
+	return strings.Join(words, " ")
+}
+
+func writeMarkdownPageFields(app core.App, page *markdownPage, bodyField string, fileToPath, pathToPageId map[string]string, warnings *[]ImportWarning) error {
+	writer, err := newPageFieldWriter(app, page.record)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(page.frontmatter))
+	for key := range page.frontmatter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if markdownPageKeys[key] {
+			continue
+		}
+		field := writer.field(key)
+		if field == nil {
+			*warnings = append(*warnings, ImportWarning{
+				Kind:    "orphaned_page_field",
+				File:    page.file,
+				Path:    "frontmatter." + key,
+				Field:   key,
+				Message: fmt.Sprintf("%s sets %q, but its page type has no such field; it was not imported.", page.file, key),
+			})
+			continue
+		}
+		value := markdownFieldValue(page.frontmatter[key], field.GetString("type"))
+		writer.check(field, value, page.frontmatter, "frontmatter."+key, constraintWarnings(warnings, page.file, ""))
+		if err := writer.set(field, value, pathToPageId); err != nil {
+			return fmt.Errorf("failed to save %s for %s: %w", key, page.file, err)
+		}
+	}
+
+	body := strings.TrimSpace(page.body)
+	if body == "" {
+		return nil
+	}
+	var target *core.Record
+	if bodyField != "" {
+		target = writer.field(bodyField)
+	} else {
+		for _, field := range writer.fields {
+			if t := field.GetString("type"); t == "markdown" || t == "rich-text" {
+				target = field
+				break
+			}
+		}
+	}
+	if target == nil || (target.GetString("type") != "markdown" && target.GetString("type") != "rich-text") {
+		*warnings = append(*warnings, ImportWarning{
+			Kind:    "unmapped_field",
+			File:    page.file,
+			Path:    "body",
+			Field:   bodyField,
+			Message: fmt.Sprintf("%s has a body, but its page type has no markdown or rich-text field %q to hold it; it was not imported.", page.file, bodyField),
+		})
+		return nil
+	}
+
+	body = rewriteMarkdownFileLinks(body, path.Dir(page.file), fileToPath) + "\n"
+	var value interface{} = body
+	if target.GetString("type") == "rich-text" {
+		value = markdownToRichText(body)
+	}
+	writer.check(target, value, page.frontmatter, "body", constraintWarnings(warnings, page.file, ""))
+	return writer.set(target, value, pathToPageId)
+}
+
+// markdownFieldValue converts a frontmatter value into what a field of
+// fieldType stores.
+func markdownFieldValue(value interface{}, fieldType string) interface{} {
+	if t, ok := value.(time.Time); ok {
+		if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+			value = t.Format("2006-01-02")
+		} else {
+			value = t.Format(time.RFC3339)
+		}
+	}
+	s, isString := value.(string)
+	switch {
+	case fieldType == "rich-text" && isString:
+		return markdownToRichText(s)
+	case fieldType == "link" && isString:
+		return map[string]interface{}{"url": s, "label": ""}
+	case fieldType == "image" && isString:
+		return map[string]interface{}{"url": s, "src": "", "alt": "", "size": nil, "width": nil, "height": nil}
+	}
+	return normalizeValueForStorage(value)
+}
+
+var markdownFileLink = regexp.MustCompile(`(\]\(\s*<?)([^)\s>:]+\.(?:md|markdown))(#[^)\s>]*)?`)
+
+// rewriteMarkdownFileLinks points links between the imported files
+// ("../about.md") at the pages they became.
+func rewriteMarkdownFileLinks(body, dir string, fileToPath map[string]string) string {
+	return markdownFileLink.ReplaceAllStringFunc(body, func(m string) string {
+		parts := markdownFileLink.FindStringSubmatch(m)
+		target := path.Clean(path.Join(dir, parts[2]))
+		if strings.HasPrefix(parts[2], "/") {
+			target = strings.TrimPrefix(path.Clean(parts[2]), "/")
+		}
+		pagePath, ok := fileToPath[target]
+		if !ok {
+			return m
+		}
+		return parts[1] + "/" + pagePath + parts[3]
+	})
+}
+
+var frontmatterDelimiter = regexp.MustCompile(`(?m)^---[ \t]*\r?$`)
+
+// splitFrontmatter separates leading YAML frontmatter from the body.
+func splitFrontmatter(data []byte) (map[string]interface{}, string, error) {
+	text := strings.TrimPrefix(string(data), "\uFEFF")
+	frontmatter := map[string]interface{}{}
+	if !strings.HasPrefix(text, "---") {
+		return frontmatter, text, nil
+	}
+	rest := text[3:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 && strings.TrimSpace(rest[:i]) == "" {
+		rest = rest[i+1:]
+	} else {
+		return frontmatter, text, nil
+	}
+	loc := frontmatterDelimiter.FindStringIndex(rest)
+	if loc == nil {
+		return nil, "", fmt.Errorf("frontmatter is not closed with ---")
+	}
+	if err := yaml.Unmarshal([]byte(rest[:loc[0]]), &frontmatter); err != nil {
+		return nil, "", fmt.Errorf("invalid frontmatter: %w", err)
+	}
+	if frontmatter == nil {
+		frontmatter = map[string]interface{}{}
+	}
+	return frontmatter, strings.TrimLeft(rest[loc[1]:], "\r\n"), nil
+}
+
+type MarkdownExportOptions struct {
+	// PageType limits the export to pages of one page type (by name or
+	// folder name); every page when empty.
+	PageType string
+	// BodyField is the key of the field written as the Markdown body.
+	BodyField string
+}
+
+func handleMarkdownExport(pb *pocketbase.PocketBase, e *core.RequestEvent) error {
+	site, err := requireSiteAccess(pb, e, false, ScopeExport)
+	if err != nil {
+		return err
+	}
+	query := e.Request.URL.Query()
+
+	var buf bytes.Buffer
+	if err := exportMarkdownPages(pb, site, &buf, MarkdownExportOptions{
+		PageType:  query.Get("page_type"),
+		BodyField: query.Get("body_field"),
+	}); err != nil {
+		return e.BadRequestError("Markdown export failed: "+err.Error(), err)
+	}
+
+	filename := asciiHeaderFilename(sanitizeFilename(site.GetString("name"))) + "-markdown.zip"
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	e.Response.Header().Set("Content-Type", "application/zip")
+	e.Response.Header().Set("Content-Disposition", disposition)
+	_, err = e.Response.Write(buf.Bytes())
+	return err
+}
+
+// exportMarkdownPages writes pages as Markdown files in the layout
+// importMarkdownPages reads. Rich-text values become Markdown and links to
+// pages become paths, so the files make sense outside Primo.
+func exportMarkdownPages(pb *pocketbase.PocketBase, site *core.Record, w io.Writer, opts MarkdownExportOptions) error {
+	pageTypes, err := pb.FindRecordsByFilter("page_types", "site = {:site}", "", 0, 0, map[string]any{"site": site.Id})
+	if err != nil {
+		return err
+	}
+	var only *core.Record
+	if opts.PageType != "" {
+		if only, err = resolvePageTypeByName(pageTypes, opts.PageType, ""); err != nil {
+			return err
+		}
+	}
+	pageTypeFolders := make(map[string]string, len(pageTypes))
+	for _, pageType := range pageTypes {
+		pageTypeFolders[pageType.Id] = exportFolderName(pageType)
+	}
+
+	pageByPath, err := sitePagesByPath(pb, site.Id)
+	if err != nil {
+		return err
+	}
+	idToPath := make(map[string]string, len(pageByPath))
+	hasChildren := map[string]bool{}
+	paths := make([]string, 0, len(pageByPath))
+	for pagePath, page := range pageByPath {
+		idToPath[page.Id] = pagePath
+		hasChildren[page.GetString("parent")] = true
+		paths = append(paths, pagePath)
+	}
+	sort.Strings(paths)
+
+	zw := zip.NewWriter(w)
+	for _, pagePath := range paths {
+		page := pageByPath[pagePath]
+		if only != nil && page.GetString("page_type") != only.Id {
+			continue
+		}
+
+		writer, err := newPageFieldWriter(pb, page)
+		if err != nil {
+			return err
+		}
+		bodyField := ""
+		if opts.BodyField != "" {
+			if field := writer.field(opts.BodyField); field != nil {
+				bodyField = field.Id
+			}
+		} else {
+			for _, field := range writer.fields {
+				if t := field.GetString("type"); t == "markdown" || t == "rich-text" {
+					bodyField = field.Id
+					break
+				}
+			}
+		}
+
+		frontmatter := &orderedMap{keys: []string{"title", "page_type"}, values: map[string]interface{}{
+			"title":     page.GetString("name"),
+			"page_type": pageTypeFolders[page.GetString("page_type")],
+		}}
+		body := ""
+		for _, field := range writer.fields {
+			entry := writer.entryByField[field.Id]
+			if entry == nil {
+				continue
+			}
+			value := markdownExportValue(normalizeValue(entry.Get("value")), field.GetString("type"), idToPath)
+			if field.Id == bodyField {
+				body, _ = value.(string)
+				continue
+			}
+			if value == nil || value == "" {
+				continue
+			}
+			key := field.GetString("key")
+			if key == "" {
+				key = field.GetString("name")
+			}
+			frontmatter.keys = append(frontmatter.keys, key)
+			frontmatter.values[key] = value
+		}
+
+		out, err := yaml.Marshal(frontmatter)
+		if err != nil {
+			return err
+		}
+		content := "---\n" + string(out) + "---\n"
+		if body = strings.TrimSpace(body); body != "" {
+			content += "\n" + body + "\n"
+		}
+
+		filename := pagePath + ".md"
+		if pagePath == "" {
+			filename = "index.md"
+		} else if hasChildren[page.Id] {
+			filename = pagePath + "/index.md"
+		}
+		if err := writeFileToZip(zw, filename, []byte(content)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// markdownExportValue is the frontmatter (or body) form of a stored value.
+func markdownExportValue(value interface{}, fieldType string, idToPath map[string]string) interface{} {
+	switch fieldType {
+	case "rich-text":
+		if value == nil {
+			return nil
+		}
+		return strings.TrimSpace(richTextToMarkdown(value))
+	case "link":
+		if link, ok := value.(map[string]interface{}); ok {
+			if pagePath, found := idToPath[getString(link, "page")]; found {
+				out := map[string]interface{}{"url": "/" + pagePath, "label": getString(link, "label")}
+				return out
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+)
+
+func TestMarkdownPagesRoundTrip(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	project := map[string]string{
+		"site.yaml":                      "name: Docs\n",
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"page-types/doc/config.yaml":     "name: Doc\n",
+		"page-types/doc/fields.yaml": "- name: body\n  type: rich-text\n" +
+			"- name: summary\n  type: markdown\n" +
+			"- name: updated\n  type: date\n",
+		"page-types/doc/layout.yaml": "{}\n",
+		"pages/index.yaml":           "name: Home\npage_type: default\nsections: []\n",
+		"site/fields.yaml":           "[]\n",
+		"site/content.yaml":          "{}\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	files := map[string][]byte{
+		"docs/index.md": []byte("---\ntitle: Documentation\n---\nStart with [setup](guides/setup.md).\n"),
+		"docs/guides/setup.md": []byte("---\ntitle: Setup\nsummary: Getting *started*\nupdated: 2024-02-01\n" +
+			"colour: blue\n---\n# Setup\n\nRun the **installer**, then read [the docs](../index.md#top).\n\n- one\n- two\n"),
+		"docs/guides/draft.md": []byte("---\ndraft: true\n---\nNot yet.\n"),
+		"docs/notes.txt":       []byte("ignored"),
+	}
+	result, err := importMarkdownPages(app, site, files, MarkdownImportOptions{PageType: "doc"})
+	if err != nil {
+		t.Fatalf("markdown import: %v", err)
+	}
+
+	paths := map[string]MarkdownImportItem{}
+	for _, page := range result.Pages {
+		paths[page.Path] = page
+	}
+	for _, want := range []string{"docs", "docs/guides", "docs/guides/setup"} {
+		if paths[want].ID == "" || !paths[want].Created {
+			t.Fatalf("expected a new page at %s, got %+v", want, result.Pages)
+		}
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0].File != "docs/guides/draft.md" {
+		t.Fatalf("expected the draft skipped, got %+v", result.Skipped)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Field != "colour" {
+		t.Fatalf("expected a warning for the unknown key, got %+v", result.Warnings)
+	}
+
+	guides, err := app.FindRecordById("pages", paths["docs/guides"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guides.GetString("name") != "Guides" || guides.GetString("parent") != paths["docs"].ID {
+		t.Fatalf("expected a Guides page under docs, got %q under %q", guides.GetString("name"), guides.GetString("parent"))
+	}
+
+	fields, _ := app.FindAllRecords("page_type_fields")
+	fieldKeys := map[string]string{}
+	for _, field := range fields {
+		fieldKeys[field.Id] = field.GetString("key")
+	}
+	values := map[string]string{}
+	entries, _ := app.FindAllRecords("page_entries", dbx.HashExp{"page": paths["docs/guides/setup"].ID})
+	for _, entry := range entries {
+		values[fieldKeys[entry.GetString("field")]] = entry.GetString("value")
+	}
+	for _, want := range []string{`"type":"heading"`, `"type":"bold"`, `"href":"/docs#top"`, `"type":"bulletList"`} {
+		if !strings.Contains(values["body"], want) {
+			t.Fatalf("expected body to contain %s, got %s", want, values["body"])
+		}
+	}
+	if values["summary"] != `"Getting *started*"` || values["updated"] != `"2024-02-01"` {
+		t.Fatalf("unexpected frontmatter values summary %s updated %s", values["summary"], values["updated"])
+	}
+
+	var archive bytes.Buffer
+	if err := exportMarkdownPages(app, site, &archive, MarkdownExportOptions{PageType: "doc"}); err != nil {
+		t.Fatalf("markdown export: %v", err)
+	}
+	exported := map[string]string{}
+	zr, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		data, err := readZipEntry(f, uploadMaxSize(app))
+		if err != nil {
+			t.Fatal(err)
+		}
+		exported[f.Name] = string(data)
+	}
+	if _, ok := exported["index.md"]; ok {
+		t.Fatalf("expected only doc pages exported, got %v", exported)
+	}
+	setup := exported["docs/guides/setup.md"]
+	for _, want := range []string{"title: Setup\n", "page_type: doc\n", "summary: Getting *started*\n", "**installer**", "- one\n"} {
+		if !strings.Contains(setup, want) {
+			t.Fatalf("expected the exported setup page to contain %q, got:\n%s", want, setup)
+		}
+	}
+	if _, ok := exported["docs/guides/index.md"]; !ok {
+		t.Fatalf("expected parents exported as index.md, got %v", exported)
+	}
+
+	// Importing the export again updates the same pages.
+	files = map[string][]byte{}
+	for name, content := range exported {
+		files[name] = []byte(content)
+	}
+	again, err := importMarkdownPages(app, site, files, MarkdownImportOptions{})
+	if err != nil {
+		t.Fatalf("re-import: %v", err)
+	}
+	for _, page := range again.Pages {
+		if page.Created || page.ID != paths[page.Path].ID {
+			t.Fatalf("expected a re-import to update in place, got %+v", again.Pages)
+		}
+	}
+}
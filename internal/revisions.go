@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"gopkg.in/yaml.v3"
+)
+
+// Each exported source file is a view of one record (a block, page type,
+// page or the site itself), so the hash of the bytes export would write for
+// it serves as that record's revision. Export stores them under "revisions"
+// in .primo/manifest.json; on the next push the import re-renders the
+// current state and compares, which catches edits made in the CMS since the
+// pull without tracking versions per collection.
+//
+// site.yaml is left out: it carries exported_at, and import only ever takes
+// name/host/group from it, never clobbering anything with it.
+var revisionTrackedPrefixes = []string{"blocks/", "page-types/", "pages/", "site/"}
+
+func revisionTracked(name string) bool {
+	for _, prefix := range revisionTrackedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// zipEntryCreator is the part of *zip.Writer the export helpers use, so a
+// site export can hash its entries as they are written.
+type zipEntryCreator interface {
+	Create(name string) (io.Writer, error)
+}
+
+// revisionWriter hashes every tracked entry as it is streamed into the
+// archive.
+type revisionWriter struct {
+	zw     *zip.Writer
+	hashes map[string]hash.Hash
+}
+
+func newRevisionWriter(zw *zip.Writer) *revisionWriter {
+	return &revisionWriter{zw: zw, hashes: map[string]hash.Hash{}}
+}
+
+func (rw *revisionWriter) Create(name string) (io.Writer, error) {
+	w, err := rw.zw.Create(name)
+	if err != nil || !revisionTracked(name) {
+		return w, err
+	}
+	h := sha256.New()
+	rw.hashes[name] = h
+	return io.MultiWriter(w, h), nil
+}
+
+func (rw *revisionWriter) revisions() map[string]string {
+	revisions := make(map[string]string, len(rw.hashes))
+	for name, h := range rw.hashes {
+		revisions[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return revisions
+}
+
+func revisionHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ImportConflict is a file changed both in the CMS since it was exported
+// and in the pushed archive. Fields lists the YAML paths (e.g.
+// "sections[1].content.heading") where the pushed file disagrees with the
+// CMS; it is empty for source files, which conflict as a whole.
+type ImportConflict struct {
+	File   string   `json:"file"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// findImportConflicts compares the revisions an archive was exported at
+// with the site's current state. A file conflicts when the CMS copy has
+// moved on and the pushed copy differs from it — including a pushed copy
+// that is simply the stale export, since importing it would revert the CMS
+// edit, and a file deleted from the archive after the CMS edited it.
+// Archives without revisions (older CLIs, hand-built zips) are not
+// checked.
+func findImportConflicts(pb *pocketbase.PocketBase, site *core.Record, incoming map[string][]byte) ([]ImportConflict, error) {
+	var manifest struct {
+		Revisions map[string]string `json:"revisions"`
+	}
+	if data, ok := incoming[".primo/manifest.json"]; ok {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse .primo/manifest.json: %w", err)
+		}
+	}
+	if len(manifest.Revisions) == 0 {
+		return nil, nil
+	}
+
+	current, err := renderSiteFiles(pb, site)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := []ImportConflict{}
+	for name, base := range manifest.Revisions {
+		existing := current[name]
+		pushed, ok := incoming[name]
+		if !ok {
+			// Deleted locally: the delete only wins if the CMS copy is
+			// still the one it was exported as.
+			if _, stillThere := current[name]; stillThere && revisionHash(existing) != base {
+				conflicts = append(conflicts, ImportConflict{File: name})
+			}
+			continue
+		}
+		if revisionHash(existing) == base || bytes.Equal(pushed, existing) {
+			continue
+		}
+		conflicts = append(conflicts, ImportConflict{
+			File:   name,
+			Fields: conflictingFields(name, existing, pushed),
+		})
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].File < conflicts[j].File })
+
+	return conflicts, nil
+}
+
+// renderSiteFiles returns the tracked source files export would write for
+// the site right now. It only reads, so it is safe for conflict checks and
+// previews: uploads (untracked, and the one part of export that may write)
+// are left out.
+func renderSiteFiles(pb *pocketbase.PocketBase, site *core.Record) (map[string][]byte, error) {
+	var buf bytes.Buffer
+	if err := exportSiteToZip(pb, site, &buf, ExportOptions{sourceFilesOnly: true}); err != nil {
+		return nil, fmt.Errorf("failed to render current site: %w", err)
+	}
+	fsys, err := zipImportFS(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	files, err := readImportFiles(fsys)
+	if err != nil {
+		return nil, err
+	}
+	for name := range files {
+		if !revisionTracked(name) {
+			delete(files, name)
+		}
+	}
+	return files, nil
+}
+
+// siteRevisions returns the revision of every tracked file as export would
+// write it now, for a push to hand back so the CLI's manifest moves to the
+// state it just pushed.
+func siteRevisions(pb *pocketbase.PocketBase, site *core.Record) (map[string]string, error) {
+	files, err := renderSiteFiles(pb, site)
+	if err != nil {
+		return nil, err
+	}
+	revisions := make(map[string]string, len(files))
+	for name, data := range files {
+		revisions[name] = revisionHash(data)
+	}
+	return revisions, nil
+}
+
+// conflictingFields lists where two versions of a YAML file disagree.
+// Source files, and YAML that doesn't parse, are reported without fields.
+func conflictingFields(name string, current, pushed []byte) []string {
+	switch path.Ext(name) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return nil
+	}
+
+	var a, b interface{}
+	if yaml.Unmarshal(current, &a) != nil || yaml.Unmarshal(pushed, &b) != nil {
+		return nil
+	}
+
+	changes := diffValues("", a, b)
+	fields := make([]string, 0, len(changes))
+	for _, change := range changes {
+		fields = append(fields, change.Path)
+	}
+	return fields
+}
@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+)
+
+func TestImportDetectsConcurrentCMSEdits(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	files := map[string]string{
+		"blocks/hero/config.yaml":      "name: hero\n",
+		"blocks/hero/component.svelte": "<section>{heading}</section>\n",
+		"blocks/hero/fields.yaml": "" +
+			"- name: heading\n" +
+			"  label: Heading\n" +
+			"  type: text\n",
+		"blocks/hero/content.yaml":       "{}\n",
+		"page-types/default/config.yaml": "name: Default\nallowed_blocks:\n  - hero\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml": "" +
+			"name: Home\n" +
+			"page_type: Default\n" +
+			"sections:\n" +
+			"  - block: hero\n" +
+			"    content:\n" +
+			"      heading: Original\n",
+		"site/fields.yaml":  "[]\n",
+		"site/content.yaml": "{}\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, files), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	pulled := exportSiteBytes(t, app, site, ExportOptions{})
+
+	conflicts, err := findImportConflicts(app, site, readImportTestFiles(t, pulled))
+	if err != nil {
+		t.Fatalf("check unchanged: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("expected a fresh export to push cleanly, got %#v", conflicts)
+	}
+
+	// Someone edits the heading in the CMS after the pull.
+	cmsEdit := map[string]string{}
+	for name, content := range files {
+		cmsEdit[name] = content
+	}
+	cmsEdit["pages/index.yaml"] = strings.Replace(files["pages/index.yaml"], "Original", "Edited in CMS", 1)
+	if _, err := processImport(app, site, zipFiles(t, cmsEdit), false); err != nil {
+		t.Fatalf("cms edit: %v", err)
+	}
+
+	conflicts, err = findImportConflicts(app, site, readImportTestFiles(t, pulled))
+	if err != nil {
+		t.Fatalf("check stale: %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0].File != "pages/index.yaml" {
+		t.Fatalf("expected a conflict on pages/index.yaml, got %#v", conflicts)
+	}
+	if !slices.Contains(conflicts[0].Fields, "sections[0].content.heading") {
+		t.Fatalf("expected the heading to be reported, got %#v", conflicts[0].Fields)
+	}
+
+	// Deleting the file locally doesn't quietly drop the CMS edit either.
+	deleted := readImportTestFiles(t, pulled)
+	delete(deleted, "pages/index.yaml")
+	conflicts, err = findImportConflicts(app, site, deleted)
+	if err != nil {
+		t.Fatalf("check deleted: %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0].File != "pages/index.yaml" {
+		t.Fatalf("expected the deleted file reported, got %#v", conflicts)
+	}
+
+	// A pull after the edit carries the new revisions and pushes cleanly.
+	repulled := exportSiteBytes(t, app, site, ExportOptions{})
+	conflicts, err = findImportConflicts(app, site, readImportTestFiles(t, repulled))
+	if err != nil {
+		t.Fatalf("check repulled: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts after pulling again, got %#v", conflicts)
+	}
+}
+
+func TestPushReturnsRevisionsForTheNextPush(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+	project := map[string]string{
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: Default\nsections: []\n",
+		"pages/about.yaml":               "name: About\npage_type: Default\nsections: []\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	local := readImportTestFiles(t, exportSiteBytes(t, app, site, ExportOptions{}))
+	push := func(files map[string][]byte) (int, map[string]string) {
+		t.Helper()
+		archive := map[string]string{}
+		for name, data := range files {
+			archive[name] = string(data)
+		}
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", "site.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(zipFiles(t, archive)); err != nil {
+			t.Fatal(err)
+		}
+		if err := form.Close(); err != nil {
+			t.Fatal(err)
+		}
+		e := newTestRequestEvent(app, http.MethodPost, "/", &body)
+		e.Request.Header.Set("Content-Type", form.FormDataContentType())
+		e.Request.RemoteAddr = "127.0.0.1:1234"
+		e.Request.SetPathValue("siteId", site.Id)
+		if err := handleImport(app, e, false); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+		rec := e.Response.(*httptest.ResponseRecorder)
+		var result struct {
+			Revisions map[string]string `json:"revisions"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("push: status %d: %s", rec.Code, rec.Body.String())
+		}
+		return rec.Code, result.Revisions
+	}
+	// What the CLI does with a push response: record the revisions as the
+	// new base in .primo/manifest.json.
+	rebase := func(revisions map[string]string) {
+		t.Helper()
+		manifest := map[string]any{}
+		if err := json.Unmarshal(local[".primo/manifest.json"], &manifest); err != nil {
+			t.Fatal(err)
+		}
+		manifest["revisions"] = revisions
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		local[".primo/manifest.json"] = data
+	}
+
+	local["pages/index.yaml"] = []byte(strings.Replace(string(local["pages/index.yaml"]), "Home", "Welcome", 1))
+	status, revisions := push(local)
+	if status != http.StatusOK || revisions["pages/index.yaml"] != revisionHash(local["pages/index.yaml"]) {
+		t.Fatalf("expected the pushed page's revision returned, got %v", revisions)
+	}
+	rebase(revisions)
+
+	// A second push straight after, without pulling, only conflicts if
+	// the CMS changed something since.
+	local["pages/about.yaml"] = []byte(strings.Replace(string(local["pages/about.yaml"]), "About", "About us", 1))
+	conflicts, err := findImportConflicts(app, site, local)
+	if err != nil {
+		t.Fatalf("check second push: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("expected the second push to go through, got %#v", conflicts)
+	}
+
+	// Once the CMS has moved on, the same push is refused.
+	cmsEdit := map[string]string{}
+	for name, data := range readImportTestFiles(t, exportSiteBytes(t, app, site, ExportOptions{})) {
+		cmsEdit[name] = string(data)
+	}
+	cmsEdit["pages/about.yaml"] = strings.Replace(cmsEdit["pages/about.yaml"], "About", "About the CMS", 1)
+	if _, err := processImport(app, site, zipFiles(t, cmsEdit), false); err != nil {
+		t.Fatalf("cms edit: %v", err)
+	}
+	if status, _ := push(local); status != http.StatusConflict {
+		t.Fatalf("expected a push over a CMS edit refused, got %d", status)
+	}
+}
+
+func TestExportBackfillsUploadMetadataOnlyWhenAsked(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+	project := map[string]string{
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "[]\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: Default\nsections: []\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	upload, err := createSiteUpload(app, fsys, site, "legacy.txt", []byte("legacy bytes"), siteUploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An upload from before the metadata columns.
+	if _, err := app.DB().Update("site_uploads", dbx.Params{"hash": "", "size": 0}, dbx.HashExp{"id": upload.Id}).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	storedHash := func() string {
+		t.Helper()
+		record, err := app.FindRecordById("site_uploads", upload.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record.GetString("hash")
+	}
+
+	pulled := exportSiteBytes(t, app, site, ExportOptions{})
+	var manifest map[string]UploadManifestEntry
+	if err := json.Unmarshal([]byte(readZipFile(t, pulled, "uploads/.manifest.json")), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if entry := manifest[upload.GetString("file")]; entry.Hash != revisionHash([]byte("legacy bytes")) || entry.Size != len("legacy bytes") {
+		t.Fatalf("expected the manifest filled in from the bytes, got %+v", entry)
+	}
+	if _, err := findImportConflicts(app, site, readImportTestFiles(t, pulled)); err != nil {
+		t.Fatalf("check conflicts: %v", err)
+	}
+	if got := storedHash(); got != "" {
+		t.Fatalf("expected export and the conflict check to leave the upload alone, got hash %q", got)
+	}
+
+	exportSiteBytes(t, app, site, ExportOptions{BackfillUploadMetadata: true})
+	if got := storedHash(); got != revisionHash([]byte("legacy bytes")) {
+		t.Fatalf("expected the backfill to save the hash, got %q", got)
+	}
+}
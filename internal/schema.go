@@ -0,0 +1,790 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+)
+
+// JSON Schemas for the project files primo pull writes and push reads, so
+// editors can flag a typo in fields.yaml or an orphaned key in content.yaml
+// before the import has to. Schemas are keyed by the path (or glob) they
+// apply to, which is the shape yaml-language-server's yaml.schemas setting
+// takes. The per-site variant types every content.yaml, page and layout
+// section from that block's or page type's actual fields.
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+type jsonSchema = map[string]interface{}
+
+// RegisterSchemaEndpoint serves the generic and per-site schemas and adds
+// a `schemas` command printing the same.
+func RegisterSchemaEndpoint(pb *pocketbase.PocketBase) error {
+	pb.OnServe().BindFunc(func(serveEvent *core.ServeEvent) error {
+		serveEvent.Router.GET("/api/palacms/schemas", func(e *core.RequestEvent) error {
+			return respondWithSchemas(e, projectSchemas(nil))
+		})
+
+		serveEvent.Router.GET("/api/palacms/sites/{siteId}/schemas", func(e *core.RequestEvent) error {
+			site, err := requireSiteAccess(pb, e, false, ScopeContentRead)
+			if err != nil {
+				return err
+			}
+			schemas, err := siteProjectSchemas(pb, site)
+			if err != nil {
+				return e.InternalServerError("Failed to build schemas: "+err.Error(), err)
+			}
+			return respondWithSchemas(e, schemas)
+		})
+
+		return serveEvent.Next()
+	})
+
+	var siteId, file string
+	command := &cobra.Command{
+		Use:   "schemas",
+		Short: "Print JSON Schemas for the site project files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			schemas := projectSchemas(nil)
+			if siteId != "" {
+				site, err := pb.FindRecordById("sites", siteId)
+				if err != nil {
+					return fmt.Errorf("site %q not found: %w", siteId, err)
+				}
+				schemas, err = siteProjectSchemas(pb, site)
+				if err != nil {
+					return err
+				}
+			}
+
+			var out interface{} = schemas
+			if file != "" {
+				schema, ok := schemaForFile(schemas, file)
+				if !ok {
+					return fmt.Errorf("no schema for %s", file)
+				}
+				out = schema
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(out)
+		},
+	}
+	command.Flags().StringVar(&siteId, "site", "", "type content files from this site's fields")
+	command.Flags().StringVar(&file, "file", "", "print only the schema for this project path, e.g. blocks/hero/content.yaml")
+	pb.RootCmd.AddCommand(command)
+
+	return nil
+}
+
+// respondWithSchemas returns every schema, or with ?file= just the one for
+// that project path so an editor can point at the URL directly.
+func respondWithSchemas(e *core.RequestEvent, schemas map[string]jsonSchema) error {
+	if file := e.Request.URL.Query().Get("file"); file != "" {
+		schema, ok := schemaForFile(schemas, file)
+		if !ok {
+			return e.NotFoundError("No schema for "+file, nil)
+		}
+		return e.JSON(200, schema)
+	}
+	return e.JSON(200, map[string]interface{}{
+		"schemas": schemas,
+	})
+}
+
+// schemaForFile finds the schema for a project path, preferring an exact
+// (per-site) entry over a glob.
+func schemaForFile(schemas map[string]jsonSchema, file string) (jsonSchema, bool) {
+	if schema, ok := schemas[file]; ok {
+		return schema, true
+	}
+	patterns := make([]string, 0, len(schemas))
+	for pattern := range schemas {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if schemaPathMatches(pattern, file) {
+			return schemas[pattern], true
+		}
+	}
+	return nil, false
+}
+
+// schemaPathMatches matches the globs used as schema keys: * within one
+// path segment, ** across any number of them.
+func schemaPathMatches(pattern, file string) bool {
+	if rest, ok := strings.CutPrefix(pattern, "pages/**/"); ok {
+		if !strings.HasPrefix(file, "pages/") {
+			return false
+		}
+		parts := strings.Split(file, "/")
+		return globSegmentMatches(rest, parts[len(parts)-1])
+	}
+	patternParts := strings.Split(pattern, "/")
+	fileParts := strings.Split(file, "/")
+	if len(patternParts) != len(fileParts) {
+		return false
+	}
+	for i := range patternParts {
+		if !globSegmentMatches(patternParts[i], fileParts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func globSegmentMatches(pattern, segment string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(segment, suffix)
+	}
+	return pattern == segment
+}
+
+// schemaRefs holds the names a site's files may refer to, so per-site
+// schemas can enumerate them. nil means any string.
+type schemaRefs struct {
+	Blocks     []string
+	PageTypes  []string
+	SiteFields []string
+	PageFields []string // "<page-type>--<field-key>"
+}
+
+// projectSchemas returns the schemas that hold for any site.
+func projectSchemas(refs *schemaRefs) map[string]jsonSchema {
+	section := sectionSchema(refs, nil)
+	return map[string]jsonSchema{
+		"site.yaml":                withDialect(siteYAMLSchema()),
+		"site/fields.yaml":         withDialect(fieldsFileSchema(refs)),
+		"site/content.yaml":        withDialect(anyContentSchema()),
+		"blocks/*/config.yaml":     withDialect(blockConfigSchema()),
+		"blocks/*/fields.yaml":     withDialect(fieldsFileSchema(refs)),
+		"blocks/*/content.yaml":    withDialect(anyContentSchema()),
+		"page-types/*/config.yaml": withDialect(pageTypeConfigSchema(refs)),
+		"page-types/*/fields.yaml": withDialect(fieldsFileSchema(refs)),
+		"page-types/*/layout.yaml": withDialect(layoutSchema(section, refs)),
+		"pages/**/*.yaml":          withDialect(pageSchema(section, refs, nil)),
+		"uploads/.manifest.json":   withDialect(jsonSchema{"type": "object"}),
+		".primo/manifest.json":     withDialect(jsonSchema{"type": "object"}),
+	}
+}
+
+// siteProjectSchemas adds exact per-file schemas for a site's blocks, page
+// types and site fields on top of the generic ones.
+func siteProjectSchemas(pb *pocketbase.PocketBase, site *core.Record) (map[string]jsonSchema, error) {
+	siteFields, err := pb.FindRecordsByFilter("site_fields", "site = {:site}", "+index", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch site fields: %w", err)
+	}
+	symbols, err := pb.FindRecordsByFilter("site_symbols", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blocks: %w", err)
+	}
+	pageTypes, err := pb.FindRecordsByFilter("page_types", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch page types: %w", err)
+	}
+
+	refs := &schemaRefs{}
+	for _, field := range schemaFieldsFromRecords(siteFields) {
+		refs.SiteFields = append(refs.SiteFields, field.Key)
+	}
+
+	blockContent := make(map[string]jsonSchema, len(symbols))
+	for _, symbol := range symbols {
+		records, err := pb.FindRecordsByFilter("site_symbol_fields", "symbol = {:symbol}", "+index", 0, 0, dbx.Params{"symbol": symbol.Id})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch fields for block %s: %w", symbol.Id, err)
+		}
+		folder := exportFolderName(symbol)
+		refs.Blocks = append(refs.Blocks, folder)
+		blockContent[folder] = contentSchema(schemaFieldsFromRecords(records), refs)
+	}
+
+	pageContent := make(map[string]jsonSchema, len(pageTypes))
+	for _, pageType := range pageTypes {
+		records, err := pb.FindRecordsByFilter("page_type_fields", "page_type = {:pt}", "+index", 0, 0, dbx.Params{"pt": pageType.Id})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch fields for page type %s: %w", pageType.Id, err)
+		}
+		folder := exportFolderName(pageType)
+		refs.PageTypes = append(refs.PageTypes, folder)
+		fields := schemaFieldsFromRecords(records)
+		for _, field := range fields {
+			refs.PageFields = append(refs.PageFields, folder+"--"+field.Key)
+		}
+		pageContent[folder] = contentSchema(fields, refs)
+	}
+	sort.Strings(refs.Blocks)
+	sort.Strings(refs.PageTypes)
+	sort.Strings(refs.SiteFields)
+	sort.Strings(refs.PageFields)
+
+	schemas := projectSchemas(refs)
+	section := sectionSchema(refs, blockContent)
+	schemas["page-types/*/layout.yaml"] = withDialect(layoutSchema(section, refs))
+	schemas["pages/**/*.yaml"] = withDialect(pageSchema(section, refs, pageContent))
+	schemas["site/content.yaml"] = withDialect(contentSchema(schemaFieldsFromRecords(siteFields), refs))
+	for folder, schema := range blockContent {
+		schemas["blocks/"+folder+"/content.yaml"] = withDialect(schema)
+	}
+	return schemas, nil
+}
+
+// exportFolderName is the folder export writes a block or page type to.
+func exportFolderName(record *core.Record) string {
+	if name := sanitizeFilename(record.GetString("name")); name != "" {
+		return name
+	}
+	return record.Id
+}
+
+func withDialect(schema jsonSchema) jsonSchema {
+	out := jsonSchema{"$schema": jsonSchemaDialect}
+	for key, value := range schema {
+		out[key] = value
+	}
+	return out
+}
+
+func stringSchema() jsonSchema { return jsonSchema{"type": "string"} }
+
+func enumOrString(values []string) jsonSchema {
+	if values == nil {
+		return stringSchema()
+	}
+	return jsonSchema{"type": "string", "enum": values}
+}
+
+func siteYAMLSchema() jsonSchema {
+	return jsonSchema{
+		"type": "object",
+		"properties": jsonSchema{
+			"name":        stringSchema(),
+			"host":        stringSchema(),
+			"site_id":     stringSchema(),
+			"group":       stringSchema(),
+			"exported_at": stringSchema(),
+			"version":     jsonSchema{"type": "string", "description": fmt.Sprintf("Export format; this instance writes %s", currentExportFormat)},
+		},
+	}
+}
+
+func blockConfigSchema() jsonSchema {
+	return jsonSchema{
+		"type": "object",
+		"properties": jsonSchema{
+			"_id":  stringSchema(),
+			"name": stringSchema(),
+		},
+		"additionalProperties": false,
+	}
+}
+
+func pageTypeConfigSchema(refs *schemaRefs) jsonSchema {
+	var blocks []string
+	if refs != nil {
+		blocks = refs.Blocks
+	}
+	return jsonSchema{
+		"type": "object",
+		"properties": jsonSchema{
+			"_id":            stringSchema(),
+			"name":           stringSchema(),
+			"icon":           stringSchema(),
+			"color":          stringSchema(),
+			"allowed_blocks": jsonSchema{"type": "array", "items": enumOrString(blocks)},
+		},
+		"additionalProperties": false,
+	}
+}
+
+func anyContentSchema() jsonSchema {
+	return jsonSchema{"type": "object"}
+}
+
+// fieldsFileSchema describes fields.yaml: a list of field definitions,
+// each with the config its type takes.
+func fieldsFileSchema(refs *schemaRefs) jsonSchema {
+	configs := fieldConfigSchemas()
+	types := make([]string, 0, len(configs))
+	for fieldType := range configs {
+		types = append(types, fieldType)
+	}
+	sort.Strings(types)
+
+	rules := make([]interface{}, 0, len(types))
+	for _, fieldType := range types {
+		config := jsonSchema{"allOf": []interface{}{configs[fieldType], fieldConstraintsSchema(fieldType)}}
+		if overlay := fieldConfigReferences(fieldType, refs); overlay != nil {
+			config = jsonSchema{"allOf": []interface{}{configs[fieldType], fieldConstraintsSchema(fieldType), overlay}}
+		}
+		rules = append(rules, jsonSchema{
+			"if":   jsonSchema{"properties": jsonSchema{"type": jsonSchema{"const": fieldType}}},
+			"then": jsonSchema{"properties": jsonSchema{"config": config}},
+		})
+	}
+
+	return jsonSchema{
+		"type":  "array",
+		"items": jsonSchema{"$ref": "#/$defs/field"},
+		"$defs": jsonSchema{
+			"field": jsonSchema{
+				"type":     "object",
+				"required": []string{"name", "type"},
+				"properties": jsonSchema{
+					"_id":         stringSchema(),
+					"name":        jsonSchema{"type": "string", "minLength": 1},
+					"label":       stringSchema(),
+					"type":        jsonSchema{"type": "string", "enum": types},
+					"config":      true,
+					"options":     jsonSchema{"description": "Older name for config"},
+					"placeholder": stringSchema(),
+					"parent":      stringSchema(),
+					"subfields":   jsonSchema{"type": "array", "items": jsonSchema{"$ref": "#/$defs/field"}},
+					"migrate": jsonSchema{
+						"type": "object",
+						"properties": jsonSchema{
+							"renamed_from": stringSchema(),
+							"from_type":    jsonSchema{"type": "string", "enum": types},
+						},
+						"additionalProperties": false,
+					},
+				},
+				"additionalProperties": false,
+				"allOf":                rules,
+			},
+		},
+	}
+}
+
+// fieldConfigReferences types the config keys that, in project files,
+// name another page type or field rather than holding a record id.
+func fieldConfigReferences(fieldType string, refs *schemaRefs) jsonSchema {
+	var pageTypes, siteFields, pageFields []string
+	if refs != nil {
+		pageTypes, siteFields, pageFields = refs.PageTypes, refs.SiteFields, refs.PageFields
+	}
+	switch fieldType {
+	case "page", "page-list":
+		return jsonSchema{"properties": jsonSchema{"page_type": enumOrString(pageTypes)}}
+	case "site-field":
+		return jsonSchema{"properties": jsonSchema{"field": enumOrString(siteFields)}}
+	case "page-field":
+		return jsonSchema{"properties": jsonSchema{"field": enumOrString(pageFields)}}
+	}
+	return nil
+}
+
+// fieldConstraintsSchema types config.validation with the rules
+// fieldType takes (see fieldConstraints).
+func fieldConstraintsSchema(fieldType string) jsonSchema {
+	count := jsonSchema{"type": "integer", "minimum": 0}
+	rules := jsonSchema{"required": jsonSchema{"type": "boolean"}}
+	switch fieldType {
+	case "text", "markdown", "url", "icon", "date", "select":
+		rules["minLength"] = count
+		rules["maxLength"] = count
+		rules["pattern"] = jsonSchema{"type": "string", "format": "regex"}
+	case "number", "slider":
+		rules["min"] = jsonSchema{"type": "number"}
+		rules["max"] = jsonSchema{"type": "number"}
+	case "repeater":
+		rules = jsonSchema{"minItems": count, "maxItems": count}
+	case "image":
+		for _, key := range []string{"minWidth", "maxWidth", "minHeight", "maxHeight"} {
+			rules[key] = count
+		}
+		rules["maxSizeMB"] = jsonSchema{"type": "number", "exclusiveMinimum": 0}
+	case "group", "info":
+		return jsonSchema{}
+	}
+	return jsonSchema{"properties": jsonSchema{"validation": jsonSchema{
+		"type":                 "object",
+		"properties":           rules,
+		"additionalProperties": false,
+	}}}
+}
+
+// sectionSchema describes a page or layout section. With blockContent,
+// each block's content is typed from its fields.
+func sectionSchema(refs *schemaRefs, blockContent map[string]jsonSchema) jsonSchema {
+	var blocks []string
+	if refs != nil {
+		blocks = refs.Blocks
+	}
+	schema := jsonSchema{
+		"type":     "object",
+		"required": []string{"block"},
+		"properties": jsonSchema{
+			"_id":     stringSchema(),
+			"block":   enumOrString(blocks),
+			"content": anyContentSchema(),
+		},
+		"additionalProperties": false,
+	}
+	if len(blockContent) > 0 {
+		schema["allOf"] = contentRules("block", blockContent)
+	}
+	return schema
+}
+
+func layoutSchema(section jsonSchema, refs *schemaRefs) jsonSchema {
+	var blocks []string
+	if refs != nil {
+		blocks = refs.Blocks
+	}
+	return jsonSchema{
+		"type": []string{"object", "null"},
+		"properties": jsonSchema{
+			"header":         jsonSchema{"type": "array", "items": section},
+			"footer":         jsonSchema{"type": "array", "items": section},
+			"allowed_blocks": jsonSchema{"type": "array", "items": enumOrString(blocks)},
+		},
+		"additionalProperties": false,
+	}
+}
+
+func pageSchema(section jsonSchema, refs *schemaRefs, pageContent map[string]jsonSchema) jsonSchema {
+	var pageTypes []string
+	if refs != nil {
+		pageTypes = refs.PageTypes
+	}
+	schema := jsonSchema{
+		"type": "object",
+		"properties": jsonSchema{
+			"_id":       stringSchema(),
+			"name":      stringSchema(),
+			"slug":      stringSchema(),
+			"page_type": enumOrString(pageTypes),
+			"content":   anyContentSchema(),
+			"fields":    jsonSchema{"type": "object", "description": "Older name for content"},
+			"sections":  jsonSchema{"type": "array", "items": section},
+		},
+		"additionalProperties": false,
+	}
+	if len(pageContent) > 0 {
+		schema["allOf"] = contentRules("page_type", pageContent)
+	}
+	return schema
+}
+
+// contentRules types "content" by the value of a discriminating key
+// (block, page_type).
+func contentRules(key string, content map[string]jsonSchema) []interface{} {
+	names := make([]string, 0, len(content))
+	for name := range content {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rules := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		rules = append(rules, jsonSchema{
+			"if":   jsonSchema{"properties": jsonSchema{key: jsonSchema{"const": name}}, "required": []string{key}},
+			"then": jsonSchema{"properties": jsonSchema{"content": content[name]}},
+		})
+	}
+	return rules
+}
+
+// schemaField is a field definition as far as the schemas care.
+type schemaField struct {
+	Key       string
+	Label     string
+	Type      string
+	Config    map[string]interface{}
+	Subfields []schemaField
+}
+
+func schemaFieldsFromRecords(records []*core.Record) []schemaField {
+	byParent := make(map[string][]*core.Record)
+	for _, record := range records {
+		byParent[record.GetString("parent")] = append(byParent[record.GetString("parent")], record)
+	}
+
+	var build func(parent string) []schemaField
+	build = func(parent string) []schemaField {
+		children := byParent[parent]
+		sort.SliceStable(children, func(i, j int) bool { return children[i].GetInt("index") < children[j].GetInt("index") })
+		fields := make([]schemaField, 0, len(children))
+		for _, record := range children {
+			key := record.GetString("key")
+			if key == "" {
+				key = record.GetString("name")
+			}
+			var config map[string]interface{}
+			record.UnmarshalJSONField("config", &config)
+			fields = append(fields, schemaField{
+				Key:       key,
+				Label:     record.GetString("label"),
+				Type:      record.GetString("type"),
+				Config:    config,
+				Subfields: build(record.Id),
+			})
+		}
+		return fields
+	}
+	return build("")
+}
+
+// contentSchema types a content map from its fields. Keys without a field
+// are rejected, since the import would quarantine them.
+func contentSchema(fields []schemaField, refs *schemaRefs) jsonSchema {
+	properties := jsonSchema{}
+	for _, field := range fields {
+		if field.Key == "" {
+			continue
+		}
+		value := fieldValueSchema(field, refs)
+		if field.Label != "" {
+			value["title"] = field.Label
+		}
+		properties[field.Key] = value
+	}
+	return jsonSchema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// fieldValueSchema is the shape a field's value takes in content.yaml and
+// pages, matching what export writes and the editor stores.
+func fieldValueSchema(field schemaField, refs *schemaRefs) jsonSchema {
+	switch field.Type {
+	case "text", "markdown", "url", "icon", "date":
+		return jsonSchema{"type": []string{"string", "null"}}
+	case "select":
+		values := []interface{}{}
+		options, _ := field.Config["options"].([]interface{})
+		for _, option := range options {
+			if option, ok := option.(map[string]interface{}); ok {
+				values = append(values, option["value"])
+			}
+		}
+		if len(values) == 0 {
+			return jsonSchema{"type": []string{"string", "null"}}
+		}
+		return jsonSchema{"enum": append(values, "", nil)}
+	case "number", "slider":
+		return jsonSchema{"type": []string{"number", "null"}}
+	case "switch":
+		return jsonSchema{"type": []string{"boolean", "null"}}
+	case "rich-text":
+		return jsonSchema{"anyOf": []interface{}{
+			jsonSchema{
+				"type":       "object",
+				"properties": jsonSchema{"type": jsonSchema{"const": "doc"}, "content": jsonSchema{"type": "array"}},
+			},
+			jsonSchema{"type": []string{"string", "null"}},
+		}}
+	case "image":
+		return jsonSchema{
+			"type": []string{"object", "null"},
+			"properties": jsonSchema{
+				"url":    stringSchema(),
+				"src":    stringSchema(),
+				"alt":    stringSchema(),
+				"upload": jsonSchema{"type": []string{"string", "null"}},
+				"size":   jsonSchema{"type": []string{"number", "null"}},
+				"width":  jsonSchema{"type": []string{"number", "null"}},
+				"height": jsonSchema{"type": []string{"number", "null"}},
+			},
+		}
+	case "link":
+		return jsonSchema{
+			"type": []string{"object", "null"},
+			"properties": jsonSchema{
+				"label": stringSchema(),
+				"text":  stringSchema(),
+				"url":   stringSchema(),
+				"page":  stringSchema(),
+			},
+		}
+	case "page":
+		return jsonSchema{"type": []string{"string", "object", "null"}}
+	case "page-list":
+		return jsonSchema{"type": []string{"array", "null"}}
+	case "repeater":
+		return jsonSchema{"type": []string{"array", "null"}, "items": contentSchema(field.Subfields, refs)}
+	case "group":
+		group := contentSchema(field.Subfields, refs)
+		group["type"] = []string{"object", "null"}
+		return group
+	case "page-field", "site-field", "info":
+		return jsonSchema{"description": fmt.Sprintf("%s fields have no content of their own", field.Type)}
+	}
+	return jsonSchema{}
+}
+
+var (
+	fieldConfigSchemasOnce  sync.Once
+	fieldConfigSchemasCache map[string]jsonSchema
+)
+
+// fieldConfigSchemas maps each field type to the JSON Schema of its
+// config. It is read from the zod field models validation runs
+// (common/index.cjs), falling back to builtinFieldConfigSchemas when the
+// bundle doesn't carry them (a build without the frontend).
+func fieldConfigSchemas() map[string]jsonSchema {
+	fieldConfigSchemasOnce.Do(func() {
+		schemas, err := fieldConfigSchemasFromModels(commonScript)
+		if err != nil || len(schemas) == 0 {
+			schemas = builtinFieldConfigSchemas()
+		}
+		fieldConfigSchemasCache = schemas
+	})
+	return fieldConfigSchemasCache
+}
+
+// zodFieldTypesScript walks the Field discriminated union behind the
+// site_symbol_fields model and converts each member's config to JSON
+// Schema. It covers the zod constructs the field models use.
+const zodFieldTypesScript = `(function (models) {
+	function toSchema(s) {
+		var def = s && s._zod && s._zod.def;
+		if (!def) return {};
+		switch (def.type) {
+		case 'string': return { type: 'string' };
+		case 'boolean': return { type: 'boolean' };
+		case 'number':
+			var isInt = (def.checks || []).some(function (c) {
+				var d = c && c._zod && c._zod.def;
+				return d && typeof d.format === 'string' && d.format.indexOf('int') >= 0;
+			});
+			return { type: isInt ? 'integer' : 'number' };
+		case 'literal': return def.values.length === 1 ? { const: def.values[0] } : { enum: def.values };
+		case 'enum': return { enum: Object.keys(def.entries).map(function (k) { return def.entries[k]; }) };
+		case 'array': return { type: 'array', items: toSchema(def.element) };
+		case 'optional': return toSchema(def.innerType);
+		case 'default': return toSchema(def.innerType);
+		case 'nullable': return { anyOf: [toSchema(def.innerType), { type: 'null' }] };
+		case 'union': return { anyOf: def.options.map(toSchema) };
+		case 'intersection': return { allOf: [toSchema(def.left), toSchema(def.right)] };
+		case 'object':
+			var properties = {}, required = [];
+			Object.keys(def.shape).forEach(function (key) {
+				var child = def.shape[key];
+				properties[key] = toSchema(child);
+				var t = child._zod.def.type;
+				if (t !== 'optional' && t !== 'default') required.push(key);
+			});
+			var out = { type: 'object', properties: properties };
+			if (required.length) out.required = required;
+			return out;
+		}
+		return {};
+	}
+
+	var model = models && models.site_symbol_fields;
+	var def = model && model._zod && model._zod.def;
+	if (def && def.type === 'intersection') def = def.left._zod.def;
+	if (!def || def.type !== 'union') return null;
+
+	var types = {};
+	def.options.forEach(function (option) {
+		var shape = option._zod.def.shape;
+		var type = shape.type._zod.def.values[0];
+		types[type] = shape.config ? toSchema(shape.config) : {};
+	});
+	return JSON.stringify(types);
+})`
+
+func fieldConfigSchemasFromModels(script string) (map[string]jsonSchema, error) {
+	vm := goja.New()
+	if _, err := vm.RunString("globalThis.exports = {};class File {};" + script); err != nil {
+		return nil, err
+	}
+	walker, err := vm.RunString(zodFieldTypesScript)
+	if err != nil {
+		return nil, err
+	}
+	walk, ok := goja.AssertFunction(walker)
+	if !ok {
+		return nil, fmt.Errorf("field type walker is not a function")
+	}
+	result, err := walk(goja.Undefined(), vm.GlobalObject().Get("exports").ToObject(vm).Get("models"))
+	if err != nil {
+		return nil, err
+	}
+	if goja.IsNull(result) || goja.IsUndefined(result) {
+		return nil, nil
+	}
+
+	var schemas map[string]jsonSchema
+	if err := json.Unmarshal([]byte(result.String()), &schemas); err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// builtinFieldConfigSchemas mirrors src/lib/common/models/fields for
+// builds whose common bundle is empty.
+func builtinFieldConfigSchemas() map[string]jsonSchema {
+	condition := jsonSchema{
+		"anyOf": []interface{}{
+			jsonSchema{
+				"type": "object",
+				"properties": jsonSchema{
+					"field":      jsonSchema{"type": []string{"string", "null"}},
+					"comparison": jsonSchema{"enum": []string{"=", "!="}},
+					"value":      jsonSchema{},
+				},
+				"required": []string{"field", "comparison", "value"},
+			},
+			jsonSchema{"type": "null"},
+		},
+	}
+	nullableObject := func(properties jsonSchema, required ...string) jsonSchema {
+		properties["condition"] = condition
+		object := jsonSchema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			object["required"] = required
+		}
+		return jsonSchema{"anyOf": []interface{}{object, jsonSchema{"type": "null"}}}
+	}
+	pageType := func() jsonSchema {
+		return nullableObject(jsonSchema{"page_type": jsonSchema{"type": "string", "minLength": 1}}, "page_type")
+	}
+
+	schemas := map[string]jsonSchema{
+		"image": nullableObject(jsonSchema{
+			"maxSizeMB":        jsonSchema{"type": "number", "exclusiveMinimum": 0},
+			"maxWidthOrHeight": jsonSchema{"type": "integer", "exclusiveMinimum": 0},
+		}),
+		"page":      pageType(),
+		"page-list": pageType(),
+		"select": nullableObject(jsonSchema{
+			"options": jsonSchema{
+				"type": "array",
+				"items": jsonSchema{
+					"type": "object",
+					"properties": jsonSchema{
+						"value": stringSchema(),
+						"label": stringSchema(),
+						"icon":  stringSchema(),
+					},
+					"required": []string{"value", "label"},
+				},
+			},
+		}, "options"),
+	}
+	for _, fieldType := range []string{"repeater", "group", "text", "markdown", "rich-text", "link", "icon", "number", "date", "url", "page-field", "site-field", "slider", "switch", "info"} {
+		schemas[fieldType] = jsonSchema{}
+	}
+	return schemas
+}
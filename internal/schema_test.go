@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestProjectSchemasTypeContentFromFields(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+
+	files := map[string]string{
+		"site.yaml":                    "name: Schema Site\n",
+		"blocks/hero/config.yaml":      "name: hero\n",
+		"blocks/hero/component.svelte": "<h1>{heading}</h1>\n",
+		"blocks/hero/fields.yaml": "- name: heading\n  type: text\n" +
+			"- name: align\n  type: select\n  config:\n    options:\n      - value: left\n        label: Left\n      - value: right\n        label: Right\n" +
+			"- name: items\n  type: repeater\n  subfields:\n    - name: title\n      type: text\n",
+		"blocks/hero/content.yaml":       "{}\n",
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "- name: summary\n  type: markdown\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: default\nsections: []\n",
+		"site/fields.yaml":               "- name: tagline\n  type: text\n",
+		"site/content.yaml":              "{}\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, files), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	schemas, err := siteProjectSchemas(app, site)
+	if err != nil {
+		t.Fatalf("site schemas: %v", err)
+	}
+
+	hero, ok := schemaForFile(schemas, "blocks/hero/content.yaml")
+	if !ok {
+		t.Fatalf("expected a schema for blocks/hero/content.yaml")
+	}
+	if hero["additionalProperties"] != false {
+		t.Fatalf("expected unknown content keys to be rejected, got %v", hero["additionalProperties"])
+	}
+	properties := hero["properties"].(jsonSchema)
+	if got := properties["heading"].(jsonSchema)["type"]; !reflect.DeepEqual(got, []string{"string", "null"}) {
+		t.Fatalf("expected heading to be a string, got %v", got)
+	}
+	if got := properties["align"].(jsonSchema)["enum"]; !reflect.DeepEqual(got, []interface{}{"left", "right", "", nil}) {
+		t.Fatalf("expected align to enumerate its options, got %v", got)
+	}
+	items := properties["items"].(jsonSchema)["items"].(jsonSchema)
+	if _, ok := items["properties"].(jsonSchema)["title"]; !ok {
+		t.Fatalf("expected repeater items to be typed from subfields, got %v", items)
+	}
+
+	page, ok := schemaForFile(schemas, "pages/blog/first-post.yaml")
+	if !ok {
+		t.Fatalf("expected nested pages to match pages/**/*.yaml")
+	}
+	if got := page["properties"].(jsonSchema)["page_type"].(jsonSchema)["enum"]; !reflect.DeepEqual(got, []string{"default"}) {
+		t.Fatalf("expected page_type to list the site's page types, got %v", got)
+	}
+	if len(page["allOf"].([]interface{})) != 1 {
+		t.Fatalf("expected page content to be typed per page type, got %v", page["allOf"])
+	}
+
+	fields := projectSchemas(nil)["blocks/*/fields.yaml"]
+	field := fields["$defs"].(jsonSchema)["field"].(jsonSchema)
+	types := field["properties"].(jsonSchema)["type"].(jsonSchema)["enum"].([]string)
+	for _, want := range []string{"repeater", "group", "page-field", "site-field"} {
+		if !slices.Contains(types, want) {
+			t.Fatalf("expected field types to include %s, got %v", want, types)
+		}
+	}
+}
+
+func TestFieldConfigSchemasFromZodModels(t *testing.T) {
+	// The shape zod v4 gives the Field union behind site_symbol_fields.
+	script := `
+		function z(def) { return { _zod: { def: def } }; }
+		var str = z({ type: 'string' });
+		exports.models = {
+			site_symbol_fields: z({ type: 'intersection', right: z({ type: 'object', shape: {} }), left: z({ type: 'union', options: [
+				z({ type: 'object', shape: { type: z({ type: 'literal', values: ['text'] }) } }),
+				z({ type: 'object', shape: {
+					type: z({ type: 'literal', values: ['page'] }),
+					config: z({ type: 'object', shape: { page_type: str, condition: z({ type: 'optional', innerType: str }) } }),
+				} }),
+			] }) }),
+		};
+	`
+	schemas, err := fieldConfigSchemasFromModels(script)
+	if err != nil {
+		t.Fatalf("walk models: %v", err)
+	}
+	if len(schemas) != 2 {
+		t.Fatalf("expected one schema per field type, got %v", schemas)
+	}
+	page := schemas["page"]
+	if !reflect.DeepEqual(page["required"], []interface{}{"page_type"}) {
+		t.Fatalf("expected page_type to be required and condition optional, got %v", page)
+	}
+
+	if schemas, err := fieldConfigSchemasFromModels("exports.models = {};"); err != nil || schemas != nil {
+		t.Fatalf("expected an empty bundle to yield no schemas, got %v, %v", schemas, err)
+	}
+}
@@ -85,6 +85,20 @@ func ServeSites(pb *pocketbase.PocketBase) error {
 				fileName = "index.html"
 			}
 
+			// Resize parameters on an upload serve a cached variant instead
+			// of the original. Sizes outside the allowlist are rejected
+			// rather than silently ignored so a typo doesn't ship full-size
+			// images unnoticed.
+			if strings.HasPrefix(reqPath, "_uploads/") && !strings.HasPrefix(reqPath, imageVariantsDir) && exists {
+				opts, ok, err := parseImageVariantOptions(requestEvent.Request.URL.Query(), imageSizeAllowlist())
+				if err != nil {
+					return requestEvent.BadRequestError(err.Error(), err)
+				}
+				if ok {
+					return serveImageVariant(requestEvent, fs, reqHost, fileKey, opts)
+				}
+			}
+
 			reader, err := fs.GetReader(fileKey)
 			if err != nil {
 				return err
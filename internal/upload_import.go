@@ -0,0 +1,481 @@
+package internal
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// uploadMetadataFile is the optional file at the root of a bulk upload zip
+// mapping archive paths to per-file metadata.
+const uploadMetadataFile = "metadata.json"
+
+// storedNameSuffix matches the "_<10 random chars>" PocketBase appends to
+// stored filenames, so an original name can be recovered for uploads that
+// predate the name column.
+var storedNameSuffix = regexp.MustCompile(`_[a-zA-Z0-9]{10}(\.[^.]*)?$`)
+
+// Collision strategies for bulk upload import, applied when an upload with
+// the same folder and original filename already exists in the site.
+const (
+	uploadConflictRename  = "rename"
+	uploadConflictSkip    = "skip"
+	uploadConflictReplace = "replace"
+)
+
+type uploadImportMetadata struct {
+	Alt    string `json:"alt"`
+	Folder string `json:"folder"`
+}
+
+// UploadImportResult reports what a bulk upload import did per archive path.
+type UploadImportResult struct {
+	Created  []UploadImportItem `json:"created"`
+	Replaced []UploadImportItem `json:"replaced"`
+	Skipped  []UploadImportItem `json:"skipped"`
+}
+
+type UploadImportItem struct {
+	Path string `json:"path"`
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+func handleBulkUploadImport(pb *pocketbase.PocketBase, e *core.RequestEvent) error {
+	site, err := requireSiteAccess(pb, e, true, ScopeImport)
+	if err != nil {
+		return err
+	}
+
+	if err := e.Request.ParseMultipartForm(32 << 20); err != nil {
+		return e.BadRequestError("Failed to parse form", err)
+	}
+
+	file, header, err := e.Request.FormFile("file")
+	if err != nil {
+		return e.BadRequestError("No file uploaded", err)
+	}
+	defer file.Close()
+
+	// multipart.File is an io.ReaderAt, so the archive is read in place
+	// rather than buffered whole.
+	reader, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		return e.BadRequestError("Invalid ZIP file", err)
+	}
+
+	onConflict := e.Request.FormValue("on_conflict")
+	switch onConflict {
+	case "":
+		onConflict = uploadConflictRename
+	case uploadConflictRename, uploadConflictSkip, uploadConflictReplace:
+	default:
+		return e.BadRequestError("Invalid on_conflict (expected rename, skip or replace)", nil)
+	}
+
+	result, err := importUploadArchive(pb, site, reader, strings.Trim(e.Request.FormValue("folder"), "/"), onConflict)
+	if err != nil {
+		return e.BadRequestError("Upload import failed: "+err.Error(), err)
+	}
+
+	return e.JSON(200, result)
+}
+
+// importUploadArchive creates site_uploads for every file in the archive.
+// Folder structure inside the zip becomes the upload folder (under
+// baseFolder), unless metadata.json overrides it. The whole archive is
+// imported in one transaction: a bad file leaves the site untouched rather
+// than half-populated.
+func importUploadArchive(pb *pocketbase.PocketBase, site *core.Record, reader *zip.Reader, baseFolder, onConflict string) (*UploadImportResult, error) {
+	maxSize := uploadMaxSize(pb)
+	metadata := map[string]uploadImportMetadata{}
+	for _, f := range reader.File {
+		if f.Name != uploadMetadataFile {
+			continue
+		}
+		data, err := readZipEntry(f, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", uploadMetadataFile, err)
+		}
+	}
+
+	fsys, err := pb.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+
+	result := &UploadImportResult{
+		Created:  []UploadImportItem{},
+		Replaced: []UploadImportItem{},
+		Skipped:  []UploadImportItem{},
+	}
+
+	writes := newBlobWrites(fsys)
+	err = pb.RunInTransaction(func(txApp core.App) error {
+		existing, err := txApp.FindRecordsByFilter("site_uploads", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+		if err != nil {
+			return err
+		}
+		taken := make(map[string]*core.Record, len(existing))
+		for _, upload := range existing {
+			taken[uploadFolderKey(upload.GetString("folder"), uploadOriginalName(upload))] = upload
+		}
+
+		for _, f := range reader.File {
+			if f.FileInfo().IsDir() || f.Name == uploadMetadataFile || isHiddenArchivePath(f.Name) {
+				continue
+			}
+
+			meta := metadata[f.Name]
+			folder := meta.Folder
+			if folder == "" {
+				folder = strings.Trim(path.Join(baseFolder, path.Dir(f.Name)), "./")
+			}
+			name := path.Base(f.Name)
+
+			data, err := readZipEntry(f, maxSize)
+			if err != nil {
+				return err
+			}
+			if len(data) == 0 {
+				result.Skipped = append(result.Skipped, UploadImportItem{Path: f.Name, Name: name})
+				continue
+			}
+
+			if current := taken[uploadFolderKey(folder, name)]; current != nil {
+				switch onConflict {
+				case uploadConflictSkip:
+					result.Skipped = append(result.Skipped, UploadImportItem{Path: f.Name, ID: current.Id, Name: name})
+					continue
+				case uploadConflictReplace:
+					if err := replaceSiteUploadBytes(txApp, writes, current, data); err != nil {
+						return fmt.Errorf("failed to replace %s: %w", f.Name, err)
+					}
+					if meta.Alt != "" {
+						current.Set("alt", meta.Alt)
+						if err := txApp.SaveNoValidate(current); err != nil {
+							return err
+						}
+					}
+					result.Replaced = append(result.Replaced, UploadImportItem{Path: f.Name, ID: current.Id, Name: name})
+					continue
+				default:
+					name = uniqueUploadName(taken, folder, name)
+				}
+			}
+
+			upload, err := createSiteUpload(txApp, fsys, site, name, data, siteUploadOptions{
+				Folder: folder,
+				Alt:    meta.Alt,
+				Writes: writes,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to import %s: %w", f.Name, err)
+			}
+			taken[uploadFolderKey(folder, name)] = upload
+			result.Created = append(result.Created, UploadImportItem{Path: f.Name, ID: upload.Id, Name: name})
+		}
+
+		return nil
+	})
+	writes.finish(pb, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+type siteUploadOptions struct {
+	// ID keeps the upload's id from another instance, so entry values that
+	// reference it keep resolving. Ignored if it is not a valid record id
+	// or is already taken; the upload then gets a fresh one.
+	ID string
+	// StoredName keeps the stored filename as well, so /api/files URLs in
+	// content keep resolving. A fresh suffixed name is generated otherwise.
+	StoredName string
+	Folder     string
+	Alt        string
+	// Writes collects the blob written when the upload is created inside
+	// a transaction, so it can be removed if the transaction rolls back.
+	Writes *blobWrites
+}
+
+// createSiteUpload stores data as a blob and creates the site_uploads row
+// pointing at it, with the same derived metadata an upload through the
+// dashboard would get.
+func createSiteUpload(app core.App, fsys *filesystem.System, site *core.Record, name string, data []byte, opts siteUploadOptions) (*core.Record, error) {
+	collection, err := app.FindCollectionByNameOrId("site_uploads")
+	if err != nil {
+		return nil, err
+	}
+
+	storedName := opts.StoredName
+	if storedName == "" {
+		file, err := filesystem.NewFileFromBytes(data, name)
+		if err != nil {
+			return nil, err
+		}
+		storedName = file.Name
+	}
+
+	var hash string
+	if opts.Writes != nil {
+		hash, err = opts.Writes.store(app, data)
+	} else {
+		hash, err = storeBlob(app, fsys, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upload := newBlobUploadRecord(collection, storedName, hash)
+	if opts.ID != "" {
+		// SaveNoValidate skips the id field's checks, so they run here.
+		idField, _ := collection.Fields.GetByName("id").(*core.TextField)
+		if idField != nil && idField.ValidatePlainValue(opts.ID) == nil {
+			if _, err := app.FindRecordById("site_uploads", opts.ID); err != nil {
+				upload.Set("id", opts.ID)
+			}
+		}
+	}
+	upload.Set("site", site.Id)
+	upload.Set("name", name)
+	upload.Set("folder", opts.Folder)
+	upload.Set("alt", opts.Alt)
+	setUploadMetadata(upload, data)
+
+	if err := app.SaveNoValidate(upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// replaceSiteUploadBytes points an existing upload at new bytes, keeping its
+// id and filename so everything referencing it picks up the new file.
+// The blob references are moved explicitly because the write bypasses the
+// file field (there is no new file for the update hook to intern). The old
+// blob (or a legacy upload's per-record copy) is only let go of through
+// writes, once the transaction has committed.
+func replaceSiteUploadBytes(app core.App, writes *blobWrites, upload *core.Record, data []byte) error {
+	hash, err := writes.store(app, data)
+	if err != nil {
+		return err
+	}
+	previous := upload.GetString("blob")
+	if previous == hash {
+		return nil
+	}
+
+	if err := acquireBlob(app, hash); err != nil {
+		return err
+	}
+	upload.Set("blob", hash)
+	setUploadMetadata(upload, data)
+	if err := app.SaveNoValidate(upload); err != nil {
+		return err
+	}
+
+	if previous != "" {
+		writes.release(previous)
+	} else {
+		// Legacy upload: drop the per-record copy it no longer serves.
+		writes.orphan(upload.BaseFilesPath() + "/" + upload.GetString("file"))
+	}
+	return nil
+}
+
+// uploadOriginalName is the filename an upload was uploaded as: the name
+// column when set, else the stored name with PocketBase's suffix removed.
+func uploadOriginalName(upload *core.Record) string {
+	if name := upload.GetString("name"); name != "" {
+		return name
+	}
+	stored := upload.GetString("file")
+	if match := storedNameSuffix.FindStringIndex(stored); match != nil {
+		return stored[:match[0]] + path.Ext(stored)
+	}
+	return stored
+}
+
+func uploadFolderKey(folder, name string) string {
+	return strings.ToLower(strings.Trim(folder, "/") + "/" + name)
+}
+
+// uniqueUploadName appends -1, -2, ... before the extension until the name
+// is free in the folder.
+func uniqueUploadName(taken map[string]*core.Record, folder, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+		if taken[uploadFolderKey(folder, candidate)] == nil {
+			return candidate
+		}
+	}
+}
+
+// isHiddenArchivePath skips OS metadata that archivers like to include
+// (__MACOSX resource forks, .DS_Store and other dotfiles).
+func isHiddenArchivePath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadMaxSize is the largest file the site_uploads file field accepts,
+// which archive imports hold their entries to as well.
+func uploadMaxSize(app core.App) int64 {
+	collection, err := app.FindCachedCollectionByNameOrId("site_uploads")
+	if err == nil {
+		if field, ok := collection.Fields.GetByName("file").(*core.FileField); ok && field.MaxSize > 0 {
+			return field.MaxSize
+		}
+	}
+	return core.DefaultFileFieldMaxSize
+}
+
+// readZipEntry reads an archive entry of at most maxSize bytes. The size in
+// the zip header can't be trusted, so the read itself is capped too.
+func readZipEntry(f *zip.File, maxSize int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(maxSize) {
+		return nil, fmt.Errorf("%s is larger than %d bytes", f.Name, maxSize)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", f.Name, maxSize)
+	}
+	return data, nil
+}
+
+// importSiteUploadFiles restores upload binaries from the uploads/
+// directory of a site archive, reading one file at a time from source. The manifest (when present) supplies the id
+// each file had on the source instance; uploads are recreated under that id
+// and stored name so image values and /api/files URLs in the imported
+// content resolve without rewriting. Files whose bytes already exist under
+// the same name in the site are left alone, which keeps re-importing an
+// export a no-op. The uploads are created in one transaction, so a failure
+// part way leaves neither rows nor blobs behind.
+func importSiteUploadFiles(pb *pocketbase.PocketBase, site *core.Record, source fs.FS, manifestData []byte, previewOnly bool, diff *ImportDiff, warnings *[]ImportWarning) error {
+	manifest := map[string]UploadManifestEntry{}
+	if manifestData != nil {
+		if err := json.Unmarshal(manifestData, &manifest); err != nil {
+			return fmt.Errorf("failed to parse uploads/.manifest.json: %w", err)
+		}
+	}
+
+	existing, err := pb.FindRecordsByFilter("site_uploads", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return fmt.Errorf("failed to fetch uploads: %w", err)
+	}
+	byName := make(map[string]*core.Record, len(existing))
+	byId := make(map[string]*core.Record, len(existing))
+	for _, upload := range existing {
+		byName[upload.GetString("file")] = upload
+		byId[upload.Id] = upload
+	}
+
+	entries, err := fs.ReadDir(source, "uploads")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read uploads/: %w", err)
+	}
+
+	var added []string
+	for _, e := range entries {
+		filePath := "uploads/" + e.Name()
+		if !e.Type().IsRegular() || !isImportUploadFile(filePath) || isHiddenArchivePath(filePath) {
+			continue
+		}
+		if info, err := e.Info(); err != nil || info.Size() == 0 {
+			continue
+		}
+
+		storedName := e.Name()
+		entry := manifest[storedName]
+		if byName[storedName] != nil || (entry.ID != "" && byId[entry.ID] != nil) {
+			continue
+		}
+		added = append(added, filePath)
+	}
+	diff.Site.Added = append(diff.Site.Added, added...)
+	if previewOnly || len(added) == 0 {
+		return nil
+	}
+
+	fsys, err := pb.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	maxSize := uploadMaxSize(pb)
+	var restored []ImportWarning
+	writes := newBlobWrites(fsys)
+	err = pb.RunInTransaction(func(txApp core.App) error {
+		for _, filePath := range added {
+			storedName := strings.TrimPrefix(filePath, "uploads/")
+			entry := manifest[storedName]
+			name := storedName
+			if match := storedNameSuffix.FindStringIndex(storedName); match != nil {
+				name = storedName[:match[0]] + path.Ext(storedName)
+			}
+			data, err := readImportFile(source, filePath, maxSize)
+			if err != nil {
+				return err
+			}
+			upload, err := createSiteUpload(txApp, fsys, site, name, data, siteUploadOptions{
+				ID:         entry.ID,
+				StoredName: storedName,
+				Alt:        entry.Alt,
+				Writes:     writes,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to import %s: %w", filePath, err)
+			}
+			if entry.ID != "" && upload.Id != entry.ID {
+				restored = append(restored, ImportWarning{
+					Kind:    "upload_id_changed",
+					File:    "uploads/.manifest.json",
+					Path:    storedName,
+					Message: fmt.Sprintf("Upload %q could not keep its id %q and was given %q; content that references the old id will not find it", storedName, entry.ID, upload.Id),
+				})
+			}
+		}
+		return nil
+	})
+	writes.finish(pb, err)
+	if err != nil {
+		return err
+	}
+	*warnings = append(*warnings, restored...)
+	return nil
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// UploadManifestEntry is one file's record in uploads/.manifest.json. The
+// derived fields (dimensions, mime, size, hash) are informational on
+// import — they are always recomputed from the bytes — while alt and the
+// focal point are editor data and round-trip.
+type UploadManifestEntry struct {
+	ID         string               `json:"id"`
+	URL        string               `json:"url"`
+	Width      int                  `json:"width,omitempty"`
+	Height     int                  `json:"height,omitempty"`
+	Mime       string               `json:"mime,omitempty"`
+	Size       int                  `json:"size,omitempty"`
+	Hash       string               `json:"hash,omitempty"`
+	Alt        string               `json:"alt,omitempty"`
+	FocalPoint *UploadManifestFocal `json:"focal_point,omitempty"`
+}
+
+type UploadManifestFocal struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// RegisterUploadMetadata fills in the derived metadata columns of
+// site_uploads whenever a new file is attached, so every consumer (export,
+// generate, the editor) can rely on them without re-reading the blob.
+func RegisterUploadMetadata(pb *pocketbase.PocketBase) error {
+	handler := func(event *core.RecordEvent) error {
+		files := event.Record.GetUnsavedFiles("file")
+		if len(files) > 0 {
+			if err := setUploadMetadataFromFile(event.Record, files[len(files)-1]); err != nil {
+				return err
+			}
+		}
+		return event.Next()
+	}
+
+	pb.OnRecordCreate("site_uploads").BindFunc(handler)
+	pb.OnRecordUpdate("site_uploads").BindFunc(handler)
+
+	// The editor sends the focal point's coordinates; setting either one
+	// marks the upload as having a focal point, even at (0, 0).
+	focal := func(e *core.RecordRequestEvent) error {
+		if info, err := e.RequestInfo(); err == nil {
+			_, x := info.Body["focal_x"]
+			_, y := info.Body["focal_y"]
+			if _, flag := info.Body["has_focal"]; (x || y) && !flag {
+				e.Record.Set("has_focal", true)
+			}
+		}
+		return e.Next()
+	}
+	pb.OnRecordCreateRequest("site_uploads").BindFunc(focal)
+	pb.OnRecordUpdateRequest("site_uploads").BindFunc(focal)
+
+	return nil
+}
+
+func setUploadMetadataFromFile(record *core.Record, file *filesystem.File) error {
+	reader, err := file.Reader.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	setUploadMetadata(record, data)
+	return nil
+}
+
+// setUploadMetadata stores the metadata derived from an upload's bytes.
+// Dimensions are only known for raster formats Go can decode; for anything
+// else (SVG, PDF, video) they are left at zero rather than guessed.
+func setUploadMetadata(record *core.Record, data []byte) {
+	sum := sha256.Sum256(data)
+	record.Set("hash", hex.EncodeToString(sum[:]))
+	record.Set("size", len(data))
+	record.Set("mime", mimetype.Detect(data).String())
+
+	width, height := 0, 0
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		width, height = config.Width, config.Height
+	}
+	record.Set("width", width)
+	record.Set("height", height)
+}
+
+// ensureUploadMetadata fills in the derived columns for uploads created
+// before the metadata hook existed, reading the blob from storage. With
+// save the record is updated too, so the cost is only paid once; without
+// it only the in-memory record changes.
+func ensureUploadMetadata(app core.App, fsys *filesystem.System, upload *core.Record, save bool) error {
+	if upload.GetString("hash") != "" {
+		return nil
+	}
+
+	name := upload.GetString("file")
+	if name == "" {
+		return nil
+	}
+
+	reader, err := fsys.GetReader(uploadStorageKey(upload))
+	if err != nil {
+		return fmt.Errorf("failed to open upload %s: %w", upload.Id, err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read upload %s: %w", upload.Id, err)
+	}
+
+	setUploadMetadata(upload, data)
+	if !save {
+		return nil
+	}
+	return app.SaveNoValidate(upload)
+}
+
+func uploadManifestEntry(upload *core.Record) UploadManifestEntry {
+	entry := UploadManifestEntry{
+		ID:     upload.Id,
+		URL:    fmt.Sprintf("/api/files/site_uploads/%s/%s", upload.Id, upload.GetString("file")),
+		Width:  upload.GetInt("width"),
+		Height: upload.GetInt("height"),
+		Mime:   upload.GetString("mime"),
+		Size:   upload.GetInt("size"),
+		Hash:   upload.GetString("hash"),
+		Alt:    upload.GetString("alt"),
+	}
+	// Without a focal point consumers center the image; (0, 0) is a
+	// deliberate top-left focus, hence the separate flag.
+	if upload.GetBool("has_focal") {
+		entry.FocalPoint = &UploadManifestFocal{X: upload.GetFloat("focal_x"), Y: upload.GetFloat("focal_y")}
+	}
+	return entry
+}
+
+// applyUploadManifest copies the editor-supplied metadata (alt text, focal
+// point) from uploads/.manifest.json onto the site's existing uploads.
+// Entries are matched by id first, then by filename, so a manifest edited by
+// hand or carried over from another site still lands. Uploads named in the
+// manifest that don't exist here are reported, not created — the manifest
+// carries metadata, not file bytes.
+func applyUploadManifest(pb *pocketbase.PocketBase, site *core.Record, data []byte, previewOnly bool, warnings *[]ImportWarning) error {
+	manifest := map[string]UploadManifestEntry{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to parse uploads/.manifest.json: %w", err)
+	}
+
+	uploads, err := pb.FindRecordsByFilter("site_uploads", "site = {:site}", "", 0, 0, dbx.Params{"site": site.Id})
+	if err != nil {
+		return fmt.Errorf("failed to fetch uploads: %w", err)
+	}
+	byId := make(map[string]*core.Record, len(uploads))
+	byName := make(map[string]*core.Record, len(uploads))
+	for _, upload := range uploads {
+		byId[upload.Id] = upload
+		byName[upload.GetString("file")] = upload
+	}
+
+	for filename, entry := range manifest {
+		upload := byId[entry.ID]
+		if upload == nil {
+			upload = byName[filename]
+		}
+		if upload == nil {
+			*warnings = append(*warnings, ImportWarning{
+				Kind:    "missing_upload",
+				File:    "uploads/.manifest.json",
+				Path:    filename,
+				Message: fmt.Sprintf("Upload %q is listed in the manifest but does not exist in this site; its metadata was skipped", filename),
+			})
+			continue
+		}
+
+		focalX, focalY := 0.0, 0.0
+		if entry.FocalPoint != nil {
+			focalX, focalY = entry.FocalPoint.X, entry.FocalPoint.Y
+		}
+		hasFocal := entry.FocalPoint != nil
+		if upload.GetString("alt") == entry.Alt && upload.GetBool("has_focal") == hasFocal && upload.GetFloat("focal_x") == focalX && upload.GetFloat("focal_y") == focalY {
+			continue
+		}
+		if previewOnly {
+			continue
+		}
+
+		upload.Set("alt", entry.Alt)
+		upload.Set("focal_x", focalX)
+		upload.Set("focal_y", focalY)
+		upload.Set("has_focal", hasFocal)
+		if err := pb.Save(upload); err != nil {
+			return fmt.Errorf("failed to update upload %s: %w", filename, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	imagepng "image/png"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+func TestUploadManifestRoundTripsMetadata(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	if err := RegisterUploadMetadata(app); err != nil {
+		t.Fatalf("register upload metadata: %v", err)
+	}
+
+	site := createImportTestSite(t, app)
+
+	var png bytes.Buffer
+	if err := imagepng.Encode(&png, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	file, err := filesystem.NewFileFromBytes(png.Bytes(), "hero.png")
+	if err != nil {
+		t.Fatalf("new file: %v", err)
+	}
+	uploads, err := app.FindCollectionByNameOrId("site_uploads")
+	if err != nil {
+		t.Fatalf("find site_uploads: %v", err)
+	}
+	upload := core.NewRecord(uploads)
+	upload.Set("site", site.Id)
+	upload.Set("file", file)
+	if err := app.Save(upload); err != nil {
+		t.Fatalf("save upload: %v", err)
+	}
+
+	exported := exportSiteBytes(t, app, site, ExportOptions{})
+
+	manifest := map[string]UploadManifestEntry{}
+	if err := json.Unmarshal([]byte(readZipFile(t, exported, "uploads/.manifest.json")), &manifest); err != nil {
+		t.Fatalf("parse manifest: %v", err)
+	}
+	entry, ok := manifest[upload.GetString("file")]
+	if !ok {
+		t.Fatalf("manifest missing %s: %#v", upload.GetString("file"), manifest)
+	}
+	if entry.Width != 40 || entry.Height != 30 || entry.Mime != "image/png" || entry.Size != png.Len() || len(entry.Hash) != 64 {
+		t.Fatalf("unexpected derived metadata: %#v", entry)
+	}
+
+	entry.Alt = "A blank hero"
+	entry.FocalPoint = &UploadManifestFocal{X: 0.25, Y: 0.75}
+	manifest[upload.GetString("file")] = entry
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("marshal manifest: %v", err)
+	}
+
+	if _, err := processImport(app, site, zipFiles(t, map[string]string{"uploads/.manifest.json": string(manifestJSON)}), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	updated, err := app.FindRecordById("site_uploads", upload.Id)
+	if err != nil {
+		t.Fatalf("reload upload: %v", err)
+	}
+	if updated.GetString("alt") != "A blank hero" || updated.GetFloat("focal_x") != 0.25 || updated.GetFloat("focal_y") != 0.75 {
+		t.Fatalf("manifest metadata not applied: alt=%q focal=(%v,%v)", updated.GetString("alt"), updated.GetFloat("focal_x"), updated.GetFloat("focal_y"))
+	}
+
+	// The top-left corner is a focal point too, not the absence of one.
+	entry.FocalPoint = &UploadManifestFocal{X: 0, Y: 0}
+	manifest[upload.GetString("file")] = entry
+	manifestJSON, err = json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("marshal manifest: %v", err)
+	}
+	if _, err := processImport(app, site, zipFiles(t, map[string]string{"uploads/.manifest.json": string(manifestJSON)}), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	exported = exportSiteBytes(t, app, site, ExportOptions{})
+	manifest = map[string]UploadManifestEntry{}
+	if err := json.Unmarshal([]byte(readZipFile(t, exported, "uploads/.manifest.json")), &manifest); err != nil {
+		t.Fatalf("parse manifest: %v", err)
+	}
+	if focal := manifest[upload.GetString("file")].FocalPoint; focal == nil || focal.X != 0 || focal.Y != 0 {
+		t.Fatalf("expected the (0, 0) focal point to round-trip, got %+v", focal)
+	}
+}
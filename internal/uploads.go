@@ -0,0 +1,453 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// defaultUploadGracePeriod keeps freshly uploaded files out of the unused
+// report. An editor typically uploads an image and only then picks it in a
+// field; without a grace period that window looks exactly like garbage.
+const defaultUploadGracePeriod = 7 * 24 * time.Hour
+
+// siteEntryCollections lists every entry collection together with the
+// filter that scopes it to one site, mirroring the SiteIDField paths used
+// by the API rules.
+var siteEntryCollections = []struct {
+	Name   string
+	Filter string
+}{
+	{"site_entries", "field.site = {:site}"},
+	{"site_symbol_entries", "field.symbol.site = {:site}"},
+	{"page_type_entries", "field.page_type.site = {:site}"},
+	{"page_entries", "page.site = {:site}"},
+	{"page_section_entries", "section.page.site = {:site}"},
+	{"page_type_section_entries", "section.page_type.site = {:site}"},
+}
+
+// UnusedUpload is one row of the unused-uploads report.
+type UnusedUpload struct {
+	ID      string `json:"id"`
+	File    string `json:"file"`
+	Size    int    `json:"size"`
+	Created string `json:"created"`
+	Trashed string `json:"trashed,omitempty"`
+}
+
+// RegisterUploadsEndpoint exposes the unused-upload report and the trash
+// workflow built on it: trash takes unreferenced uploads off the published
+// site and out of generate, restore brings them back (published again on
+// the next generate), and empty-trash deletes them for good. It also
+// reports a site's logical vs physical storage usage and accepts bulk zip
+// imports of new uploads.
+func RegisterUploadsEndpoint(pb *pocketbase.PocketBase) error {
+	// A trashed upload is only served to the site's editors, who may want
+	// to look at it before restoring or deleting it.
+	pb.OnFileDownloadRequest("site_uploads").BindFunc(func(event *core.FileDownloadRequestEvent) error {
+		if err := checkTrashedUploadAccess(event.RequestEvent, event.Record); err != nil {
+			return err
+		}
+		return event.Next()
+	})
+
+	pb.OnServe().BindFunc(func(serveEvent *core.ServeEvent) error {
+		serveEvent.Router.POST("/api/palacms/sites/{siteId}/uploads/import", func(e *core.RequestEvent) error {
+			return handleBulkUploadImport(pb, e)
+		})
+
+		serveEvent.Router.GET("/api/palacms/sites/{siteId}/uploads/unused", func(e *core.RequestEvent) error {
+			site, err := requireSiteAccess(pb, e, false, ScopeContentRead)
+			if err != nil {
+				return err
+			}
+
+			grace, err := uploadGracePeriod(e.Request.URL.Query().Get("grace"))
+			if err != nil {
+				return e.BadRequestError("Invalid grace period", err)
+			}
+
+			unused, err := findUnusedUploads(pb, site.Id, grace, time.Now())
+			if err != nil {
+				return e.InternalServerError("Scan failed: "+err.Error(), err)
+			}
+
+			trashed, err := pb.FindRecordsByFilter("site_uploads", "site = {:site} && trashed != ''", "trashed", 0, 0, dbx.Params{"site": site.Id})
+			if err != nil {
+				return e.InternalServerError("Failed to load trash", err)
+			}
+
+			return e.JSON(200, map[string]interface{}{
+				"grace_period": grace.String(),
+				"unused":       unusedUploadRows(unused),
+				"trashed":      unusedUploadRows(trashed),
+			})
+		})
+
+		serveEvent.Router.GET("/api/palacms/sites/{siteId}/uploads/usage", func(e *core.RequestEvent) error {
+			site, err := requireSiteAccess(pb, e, false, ScopeContentRead)
+			if err != nil {
+				return err
+			}
+
+			usage, err := siteUploadUsage(pb, site.Id)
+			if err != nil {
+				return e.InternalServerError("Usage failed: "+err.Error(), err)
+			}
+
+			return e.JSON(200, usage)
+		})
+
+		serveEvent.Router.POST("/api/palacms/sites/{siteId}/uploads/trash", func(e *core.RequestEvent) error {
+			site, err := requireSiteAccess(pb, e, true, ScopeContentWrite)
+			if err != nil {
+				return err
+			}
+
+			body := struct {
+				IDs   []string `json:"ids"`
+				Grace string   `json:"grace"`
+			}{}
+			if err := e.BindBody(&body); err != nil {
+				return e.BadRequestError("Invalid request body", err)
+			}
+
+			grace, err := uploadGracePeriod(body.Grace)
+			if err != nil {
+				return e.BadRequestError("Invalid grace period", err)
+			}
+
+			trashed, skipped, err := trashUnusedUploads(pb, site.Id, body.IDs, grace, time.Now())
+			if err != nil {
+				return e.InternalServerError("Trash failed: "+err.Error(), err)
+			}
+
+			return e.JSON(200, map[string]interface{}{
+				"trashed": trashed,
+				"skipped": skipped,
+			})
+		})
+
+		serveEvent.Router.POST("/api/palacms/sites/{siteId}/uploads/restore", func(e *core.RequestEvent) error {
+			site, err := requireSiteAccess(pb, e, true, ScopeContentWrite)
+			if err != nil {
+				return err
+			}
+
+			body := struct {
+				IDs []string `json:"ids"`
+			}{}
+			if err := e.BindBody(&body); err != nil {
+				return e.BadRequestError("Invalid request body", err)
+			}
+
+			restored, err := restoreTrashedUploads(pb, site.Id, body.IDs)
+			if err != nil {
+				return e.InternalServerError("Restore failed: "+err.Error(), err)
+			}
+
+			return e.JSON(200, map[string]interface{}{
+				"restored": restored,
+			})
+		})
+
+		serveEvent.Router.POST("/api/palacms/sites/{siteId}/uploads/empty-trash", func(e *core.RequestEvent) error {
+			site, err := requireSiteAccess(pb, e, true, ScopeContentWrite)
+			if err != nil {
+				return err
+			}
+
+			body := struct {
+				OlderThan string `json:"older_than"`
+			}{}
+			if err := e.BindBody(&body); err != nil {
+				return e.BadRequestError("Invalid request body", err)
+			}
+
+			var olderThan time.Duration
+			if body.OlderThan != "" {
+				olderThan, err = time.ParseDuration(body.OlderThan)
+				if err != nil {
+					return e.BadRequestError("Invalid older_than duration", err)
+				}
+			}
+
+			deleted, err := emptyUploadTrash(pb, site.Id, time.Now().Add(-olderThan))
+			if err != nil {
+				return e.InternalServerError("Empty trash failed: "+err.Error(), err)
+			}
+
+			return e.JSON(200, map[string]interface{}{
+				"deleted": deleted,
+			})
+		})
+
+		return serveEvent.Next()
+	})
+
+	return nil
+}
+
+// uploadGracePeriod parses an explicit Go duration, falling back to
+// PRIMO_UPLOAD_GRACE_PERIOD and then to the built-in default.
+func uploadGracePeriod(raw string) (time.Duration, error) {
+	if raw == "" {
+		raw = getenvCompat("PRIMO_UPLOAD_GRACE_PERIOD", "PALA_UPLOAD_GRACE_PERIOD")
+	}
+	if raw == "" {
+		return defaultUploadGracePeriod, nil
+	}
+	grace, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+	if grace < 0 {
+		return 0, fmt.Errorf("grace period must not be negative")
+	}
+	return grace, nil
+}
+
+// collectUploadReferenceText gathers every string in a site that can point
+// at an upload: entry values of all kinds (image values carry the upload
+// id, links/rich text/markdown carry URLs containing the filename), block
+// source, and the site and page-type head/foot. Uploads are then matched by
+// substring, which is safe because both record ids and PocketBase's
+// suffixed filenames are effectively unique tokens.
+func collectUploadReferenceText(app core.App, siteId string) (string, error) {
+	var corpus strings.Builder
+	params := dbx.Params{"site": siteId}
+
+	for _, source := range siteEntryCollections {
+		entries, err := app.FindRecordsByFilter(source.Name, source.Filter, "", 0, 0, params)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch %s: %w", source.Name, err)
+		}
+		for _, entry := range entries {
+			corpus.WriteString(entry.GetString("value"))
+			corpus.WriteByte('\n')
+		}
+	}
+
+	symbols, err := app.FindRecordsByFilter("site_symbols", "site = {:site}", "", 0, 0, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch site_symbols: %w", err)
+	}
+	for _, symbol := range symbols {
+		for _, column := range []string{"html", "css", "js", "raw_source"} {
+			corpus.WriteString(symbol.GetString(column))
+			corpus.WriteByte('\n')
+		}
+	}
+
+	pageTypes, err := app.FindRecordsByFilter("page_types", "site = {:site}", "", 0, 0, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch page_types: %w", err)
+	}
+	for _, pageType := range pageTypes {
+		corpus.WriteString(pageType.GetString("head"))
+		corpus.WriteByte('\n')
+		corpus.WriteString(pageType.GetString("foot"))
+		corpus.WriteByte('\n')
+	}
+
+	site, err := app.FindRecordById("sites", siteId)
+	if err != nil {
+		return "", err
+	}
+	corpus.WriteString(site.GetString("head"))
+	corpus.WriteByte('\n')
+	corpus.WriteString(site.GetString("foot"))
+
+	return corpus.String(), nil
+}
+
+// findUnusedUploads returns live (untrashed) uploads older than the grace
+// period that nothing in the site references.
+func findUnusedUploads(app core.App, siteId string, grace time.Duration, now time.Time) ([]*core.Record, error) {
+	uploads, err := app.FindRecordsByFilter("site_uploads", "site = {:site} && trashed = ''", "created", 0, 0, dbx.Params{"site": siteId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch uploads: %w", err)
+	}
+
+	corpus, err := collectUploadReferenceText(app, siteId)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := now.Add(-grace)
+	unused := []*core.Record{}
+	for _, upload := range uploads {
+		if upload.GetDateTime("created").Time().After(cutoff) {
+			continue
+		}
+		if strings.Contains(corpus, upload.Id) {
+			continue
+		}
+		if name := upload.GetString("file"); name != "" && strings.Contains(corpus, name) {
+			continue
+		}
+		unused = append(unused, upload)
+	}
+
+	return unused, nil
+}
+
+// trashUnusedUploads moves the requested uploads to the trash. The scan is
+// re-run rather than trusting the ids, so an upload that became referenced
+// (or was uploaded inside the grace period) since the report was fetched is
+// skipped instead of disappearing from the published site.
+func trashUnusedUploads(app core.App, siteId string, ids []string, grace time.Duration, now time.Time) ([]string, []string, error) {
+	unused, err := findUnusedUploads(app, siteId, grace, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	unusedById := make(map[string]*core.Record, len(unused))
+	for _, upload := range unused {
+		unusedById[upload.Id] = upload
+	}
+
+	trashed := []string{}
+	skipped := []string{}
+	err = app.RunInTransaction(func(txApp core.App) error {
+		for _, id := range ids {
+			upload := unusedById[id]
+			if upload == nil {
+				skipped = append(skipped, id)
+				continue
+			}
+			upload.Set("trashed", types.NowDateTime())
+			if err := txApp.Save(upload); err != nil {
+				return err
+			}
+			trashed = append(trashed, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Unused uploads aren't linked from the published pages, but their
+	// published copies are still reachable by URL until the next generate.
+	if len(trashed) > 0 {
+		if err := unpublishUploads(app, siteId, trashed, unusedById); err != nil {
+			app.Logger().Warn("Failed to remove trashed uploads from the published site", "site", siteId, "error", err)
+		}
+	}
+
+	return trashed, skipped, nil
+}
+
+// unpublishUploads deletes the published copies of the given uploads,
+// with any resized variants of them.
+func unpublishUploads(app core.App, siteId string, ids []string, uploads map[string]*core.Record) error {
+	site, err := app.FindRecordById("sites", siteId)
+	if err != nil {
+		return err
+	}
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	host := site.GetString("host")
+	prefix := "sites/" + host + "/_uploads/"
+	for _, id := range ids {
+		name := uploads[id].GetString("file")
+		if name == "" {
+			continue
+		}
+		if exists, _ := fsys.Exists(prefix + name); exists {
+			if err := fsys.Delete(prefix + name); err != nil {
+				return err
+			}
+		}
+
+		variants, err := fsys.List(imageVariantDir(host, name))
+		if err != nil {
+			return err
+		}
+		for _, variant := range variants {
+			if !isImageVariantOf(host, name, variant.Key) {
+				continue
+			}
+			if err := fsys.Delete(variant.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreTrashedUploads takes the given uploads back out of the trash.
+// Ids that aren't in the site's trash are ignored.
+func restoreTrashedUploads(app core.App, siteId string, ids []string) ([]string, error) {
+	restored := []string{}
+	for _, id := range ids {
+		upload, err := app.FindFirstRecordByFilter("site_uploads", "id = {:id} && site = {:site} && trashed != ''", dbx.Params{"id": id, "site": siteId})
+		if err != nil {
+			continue
+		}
+		upload.Set("trashed", "")
+		if err := app.Save(upload); err != nil {
+			return nil, err
+		}
+		restored = append(restored, upload.Id)
+	}
+	return restored, nil
+}
+
+// checkTrashedUploadAccess answers 404 for a trashed upload unless the
+// caller may view its site.
+func checkTrashedUploadAccess(e *core.RequestEvent, upload *core.Record) error {
+	if upload.GetString("trashed") == "" {
+		return nil
+	}
+	site, err := e.App.FindRecordById("sites", upload.GetString("site"))
+	if err == nil && checkSiteAccess(e, site, false, ScopeContentRead) == nil {
+		return nil
+	}
+	return e.NotFoundError("", nil)
+}
+
+// emptyUploadTrash permanently deletes trashed uploads moved to the trash
+// before cutoff. Deleting the record also removes the file from storage.
+func emptyUploadTrash(app core.App, siteId string, cutoff time.Time) ([]string, error) {
+	trashed, err := app.FindRecordsByFilter("site_uploads", "site = {:site} && trashed != ''", "", 0, 0, dbx.Params{"site": siteId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch trash: %w", err)
+	}
+
+	deleted := []string{}
+	for _, upload := range trashed {
+		if upload.GetDateTime("trashed").Time().After(cutoff) {
+			continue
+		}
+		if err := app.Delete(upload); err != nil {
+			return nil, fmt.Errorf("failed to delete upload %s: %w", upload.Id, err)
+		}
+		deleted = append(deleted, upload.Id)
+	}
+
+	return deleted, nil
+}
+
+func unusedUploadRows(uploads []*core.Record) []UnusedUpload {
+	rows := make([]UnusedUpload, len(uploads))
+	for i, upload := range uploads {
+		rows[i] = UnusedUpload{
+			ID:      upload.Id,
+			File:    upload.GetString("file"),
+			Size:    upload.GetInt("size"),
+			Created: upload.GetString("created"),
+			Trashed: upload.GetString("trashed"),
+		}
+	}
+	return rows
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadTrashLifecycle(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	site := createImportTestSite(t, app)
+	project := map[string]string{
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "- name: cover\n  type: image\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"pages/index.yaml":               "name: Home\npage_type: default\nsections: []\n",
+	}
+	if _, err := processImport(app, site, zipFiles(t, project), false); err != nil {
+		t.Fatalf("import project: %v", err)
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	used, err := createSiteUpload(app, fsys, site, "used.png", testPNG(t, 10, 10), siteUploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused, err := createSiteUpload(app, fsys, site, "unused.png", testPNG(t, 12, 12), siteUploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := sitePagesByPath(app, site.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := newPageFieldWriter(app, pages[""])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.set(writer.field("cover"), map[string]interface{}{"url": "", "alt": "", "upload": used.Id}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both are inside the grace period until it has passed.
+	now := time.Now()
+	if found, err := findUnusedUploads(app, site.Id, time.Hour, now); err != nil || len(found) != 0 {
+		t.Fatalf("expected new uploads kept out of the report, got %d (%v)", len(found), err)
+	}
+	later := now.Add(2 * time.Hour)
+	found, err := findUnusedUploads(app, site.Id, time.Hour, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Id != unused.Id {
+		t.Fatalf("expected only the unreferenced upload reported, got %v", unusedUploadRows(found))
+	}
+
+	host := site.GetString("host")
+	published := "sites/" + host + "/_uploads/" + unused.GetString("file")
+	variant := imageVariantKey(host, unused.GetString("file"), imageVariantOptions{Width: 320, Fit: "cover"})
+	// A variant of another upload that only shares the stem.
+	name := unused.GetString("file")
+	sibling := imageVariantKey(host, strings.TrimSuffix(name, path.Ext(name))+".jpg", imageVariantOptions{Width: 320, Fit: "cover"})
+	for _, key := range []string{published, variant, sibling} {
+		if err := fsys.Upload([]byte("published"), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trashed, skipped, err := trashUnusedUploads(app, site.Id, []string{used.Id, unused.Id}, time.Hour, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(trashed, []string{unused.Id}) || !slices.Equal(skipped, []string{used.Id}) {
+		t.Fatalf("expected only the unused upload trashed, got %v skipped %v", trashed, skipped)
+	}
+	for _, key := range []string{published, variant} {
+		if exists, _ := fsys.Exists(key); exists {
+			t.Fatalf("expected %s removed from the published site", key)
+		}
+	}
+	if exists, _ := fsys.Exists(sibling); !exists {
+		t.Fatalf("expected %s, another upload's variant, kept", sibling)
+	}
+
+	// Trashed files are hidden from the public but not from editors.
+	record, err := app.FindRecordById("site_uploads", unused.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := newTestRequestEvent(app, http.MethodGet, "/", nil)
+	if got := apiErrorStatus(t, checkTrashedUploadAccess(public, record)); got != http.StatusNotFound {
+		t.Fatalf("expected a trashed upload hidden from the public, got %d", got)
+	}
+	local := newTestRequestEvent(app, http.MethodGet, "/", nil)
+	local.Request.RemoteAddr = "127.0.0.1:1234"
+	if err := checkTrashedUploadAccess(local, record); err != nil {
+		t.Fatalf("expected a trashed upload served to the site's editors: %v", err)
+	}
+
+	restored, err := restoreTrashedUploads(app, site.Id, []string{unused.Id, used.Id})
+	if err != nil || !slices.Equal(restored, []string{unused.Id}) {
+		t.Fatalf("expected the trashed upload restored, got %v (%v)", restored, err)
+	}
+	if _, _, err := trashUnusedUploads(app, site.Id, []string{unused.Id}, time.Hour, later); err != nil {
+		t.Fatal(err)
+	}
+
+	// Emptying the trash only deletes what was trashed before the cutoff.
+	deleted, err := emptyUploadTrash(app, site.Id, time.Now().Add(-time.Hour))
+	if err != nil || len(deleted) != 0 {
+		t.Fatalf("expected recently trashed uploads kept, got %v (%v)", deleted, err)
+	}
+	deleted, err = emptyUploadTrash(app, site.Id, time.Now().Add(time.Second))
+	if err != nil || !slices.Equal(deleted, []string{unused.Id}) {
+		t.Fatalf("expected the trashed upload deleted, got %v (%v)", deleted, err)
+	}
+	if _, err := app.FindRecordById("site_uploads", unused.Id); err == nil {
+		t.Fatal("expected the upload record gone")
+	}
+	if _, err := app.FindRecordById("site_uploads", used.Id); err != nil {
+		t.Fatalf("expected the used upload kept: %v", err)
+	}
+}
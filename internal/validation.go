@@ -2,6 +2,7 @@ package internal
 
 import (
 	_ "embed"
+	"runtime"
 
 	"github.com/dop251/goja"
 	"github.com/pocketbase/pocketbase"
@@ -14,78 +15,144 @@ import (
 var commonScript string
 
 func RegisterValidation(pb *pocketbase.PocketBase) error {
-	prog, err := goja.Compile("validation.js", "globalThis.exports = {};class File {};"+commonScript, true)
+	validators, err := newValidatorPool(commonScript)
 	if err != nil {
 		return err
 	}
 
 	pb.OnRecordValidate().BindFunc(func(event *core.RecordEvent) error {
-		// Skip validation in dev mode for performance (import creates hundreds of records)
-		if DevMode {
-			return event.Next()
+		if err := validators.validate(event.Record); err != nil {
+			return err
 		}
+		return event.Next()
+	})
 
-		var err error
+	return nil
+}
 
-		// Evaluate models
-		vm := goja.New()
-		if _, err := vm.RunProgram(prog); err != nil {
-			return err
-		}
-		models := vm.GlobalObject().
-			Get("exports").ToObject(vm).
-			Get("models").ToObject(vm)
-
-		// Select model for validation
-		collection := event.Record.Collection()
-		model := models.Get(collection.Name)
-		if model == nil {
-			return event.Next()
-		}
-		validate, ok := goja.AssertFunction(model.ToObject(vm).Get("parse"))
-		if !ok {
-			return event.Next()
+// validatorPool hands out runtimes with the models already evaluated.
+// Evaluating common/index.cjs costs far more than a validation, and
+// imports validate hundreds of records, so runtimes are kept and reused.
+// A goja runtime is not safe for concurrent use; each is held by one
+// validation at a time.
+type validatorPool struct {
+	program *goja.Program
+	idle    chan *validator
+}
+
+// validator is a runtime with the models evaluated, and the parse
+// functions looked up so far.
+type validator struct {
+	vm        *goja.Runtime
+	models    *goja.Object
+	jsonParse goja.Callable
+	parsers   map[string]goja.Callable // collection name -> parse; nil without a model
+}
+
+func newValidatorPool(script string) (*validatorPool, error) {
+	program, err := goja.Compile("validation.js", "globalThis.exports = {};class File {};"+script, true)
+	if err != nil {
+		return nil, err
+	}
+	pool := &validatorPool{program: program, idle: make(chan *validator, runtime.GOMAXPROCS(0))}
+
+	// One runtime up front, so a script that fails to evaluate stops
+	// startup rather than every save.
+	v, err := pool.newValidator()
+	if err != nil {
+		return nil, err
+	}
+	pool.put(v)
+	return pool, nil
+}
+
+func (p *validatorPool) newValidator() (*validator, error) {
+	vm := goja.New()
+	if _, err := vm.RunProgram(p.program); err != nil {
+		return nil, err
+	}
+	jsonParse, _ := goja.AssertFunction(vm.GlobalObject().Get("JSON").ToObject(vm).Get("parse"))
+	return &validator{
+		vm:        vm,
+		models:    vm.GlobalObject().Get("exports").ToObject(vm).Get("models").ToObject(vm),
+		jsonParse: jsonParse,
+		parsers:   map[string]goja.Callable{},
+	}, nil
+}
+
+// get takes an idle runtime, or evaluates a new one when all are busy.
+func (p *validatorPool) get() (*validator, error) {
+	select {
+	case v := <-p.idle:
+		return v, nil
+	default:
+		return p.newValidator()
+	}
+}
+
+// put returns a runtime for reuse. Beyond one per CPU, runtimes are
+// dropped; more can't be busy at once for long.
+func (p *validatorPool) put(v *validator) {
+	select {
+	case p.idle <- v:
+	default:
+	}
+}
+
+// validate parses record with the model for its collection, if there is
+// one.
+func (p *validatorPool) validate(record *core.Record) error {
+	v, err := p.get()
+	if err != nil {
+		return err
+	}
+	defer p.put(v)
+
+	// Select model for validation
+	collection := record.Collection()
+	parse, ok := v.parsers[collection.Name]
+	if !ok {
+		if model := v.models.Get(collection.Name); model != nil {
+			parse, _ = goja.AssertFunction(model.ToObject(v.vm).Get("parse"))
 		}
+		v.parsers[collection.Name] = parse
+	}
+	if parse == nil {
+		return nil
+	}
 
-		// Gather and parse values
-		values := vm.NewObject()
-		for _, field := range collection.Fields {
-			name := field.GetName()
-			value := event.Record.Get(name)
-
-			if field.Type() == "json" {
-				val := value.(types.JSONRaw)
-				value, err = vm.RunString("(" + val.String() + ")")
-				if err != nil {
-					return err
-				}
-			}
+	// Gather and parse values
+	values := v.vm.NewObject()
+	for _, field := range collection.Fields {
+		name := field.GetName()
+		value := record.Get(name)
 
-			if field.Type() == "file" {
-				// File fields are validated as strings of filenames
-				switch val := value.(type) {
-				case []*filesystem.File:
-					names := make([]string, len(val))
-					for index, file := range val {
-						names[index] = file.Name
-					}
-					value = names
-				case *filesystem.File:
-					value = val.Name
-				}
+		if field.Type() == "json" {
+			val := value.(types.JSONRaw)
+			value, err = v.jsonParse(goja.Undefined(), v.vm.ToValue(val.String()))
+			if err != nil {
+				return err
 			}
-
-			values.Set(name, value)
 		}
 
-		// Validate
-		_, err = validate(goja.Undefined(), values)
-		if err != nil {
-			return err
+		if field.Type() == "file" {
+			// File fields are validated as strings of filenames
+			switch val := value.(type) {
+			case []*filesystem.File:
+				names := make([]string, len(val))
+				for index, file := range val {
+					names[index] = file.Name
+				}
+				value = names
+			case *filesystem.File:
+				value = val.Name
+			}
 		}
 
-		return event.Next()
-	})
+		values.Set(name, value)
+	}
 
-	return nil
+	// Validate
+	_, err = parse(goja.Undefined(), values)
+	return err
 }
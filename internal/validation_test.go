@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const testValidationScript = `
+exports.models = {
+	sites: {
+		parse(values) {
+			if (!values.name) throw new Error("name is required")
+			return values
+		}
+	},
+	site_fields: {
+		parse(values) {
+			if (values.config !== null && typeof values.config !== "object") throw new Error("config must be an object")
+			return values
+		}
+	}
+}
+`
+
+func TestValidatorPoolConcurrent(t *testing.T) {
+	app := newImportTestApp(t)
+	defer app.ResetBootstrapState()
+
+	validators, err := newValidatorPool(testValidationScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sites, err := app.FindCollectionByNameOrId("sites")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := app.FindCollectionByNameOrId("site_fields")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				site := core.NewRecord(sites)
+				site.Set("name", fmt.Sprintf("Site %d-%d", worker, i))
+				field := core.NewRecord(fields)
+				field.Set("config", map[string]interface{}{"options": []string{"a"}})
+				for _, record := range []*core.Record{site, field} {
+					if err := validators.validate(record); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("expected valid records to pass, got %v", err)
+	}
+
+	// Rejections come back as errors, and leave the runtime usable.
+	if err := validators.validate(core.NewRecord(sites)); err == nil || !strings.Contains(err.Error(), "name is required") {
+		t.Fatalf("expected a site without a name to be rejected, got %v", err)
+	}
+	field := core.NewRecord(fields)
+	field.Set("config", "plain")
+	if err := validators.validate(field); err == nil || !strings.Contains(err.Error(), "config must be an object") {
+		t.Fatalf("expected a string config to be rejected, got %v", err)
+	}
+	site := core.NewRecord(sites)
+	site.Set("name", "After")
+	if err := validators.validate(site); err != nil {
+		t.Fatalf("expected validation to work after a rejection, got %v", err)
+	}
+}
+
+// largeSiteProject is a project of the size that made validation too slow
+// for dev mode: blocks with repeaters, and pages full of sections.
+func largeSiteProject(pages int) map[string]string {
+	project := map[string]string{
+		"page-types/default/config.yaml": "name: Default\n",
+		"page-types/default/fields.yaml": "- name: subtitle\n  type: text\n- name: summary\n  type: markdown\n",
+		"page-types/default/layout.yaml": "{}\n",
+		"site/fields.yaml":               "- name: brand\n  type: text\n",
+		"site/content.yaml":              "brand: Acme\n",
+	}
+	for b := 0; b < 10; b++ {
+		dir := fmt.Sprintf("blocks/block-%d/", b)
+		project[dir+"config.yaml"] = fmt.Sprintf("name: Block %d\n", b)
+		project[dir+"component.svelte"] = "<section>{heading}</section>\n"
+		project[dir+"fields.yaml"] = "" +
+			"- name: heading\n  type: text\n" +
+			"- name: body\n  type: markdown\n" +
+			"- name: items\n  type: repeater\n  subfields:\n    - name: label\n      type: text\n    - name: url\n      type: url\n"
+		project[dir+"content.yaml"] = "{}\n"
+	}
+	project["pages/index.yaml"] = "name: Home\npage_type: default\nsections: []\n"
+	for p := 0; p < pages; p++ {
+		var page strings.Builder
+		fmt.Fprintf(&page, "name: Page %d\npage_type: default\ncontent:\n  subtitle: Subtitle %d\n  summary: Some **text**\nsections:\n", p, p)
+		for s := 0; s < 3; s++ {
+			fmt.Fprintf(&page, "  - block: block-%d\n    content:\n      heading: Heading %d\n      body: Body\n      items:\n", (p+s)%10, s)
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(&page, "        - label: Item %d\n          url: /page-%d\n", i, i)
+			}
+		}
+		project[fmt.Sprintf("pages/page-%d.yaml", p)] = page.String()
+	}
+	return project
+}
+
+func BenchmarkImportLargeSite(b *testing.B) {
+	for _, validate := range []bool{false, true} {
+		b.Run(fmt.Sprintf("validation=%t", validate), func(b *testing.B) {
+			app := newImportTestApp(b)
+			defer app.ResetBootstrapState()
+			if validate {
+				if err := RegisterValidation(app); err != nil {
+					b.Fatal(err)
+				}
+			}
+			groupID, err := ensureDefaultGroup(app)
+			if err != nil {
+				b.Fatal(err)
+			}
+			project := zipFiles(b, largeSiteProject(200))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				site := newBenchmarkSite(b, app, groupID, i)
+				if _, err := processImport(app, site, project, false); err != nil {
+					b.Fatalf("import project: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkValidateRecord(b *testing.B) {
+	app := newImportTestApp(b)
+	defer app.ResetBootstrapState()
+
+	validators, err := newValidatorPool(testValidationScript)
+	if err != nil {
+		b.Fatal(err)
+	}
+	sites, err := app.FindCollectionByNameOrId("sites")
+	if err != nil {
+		b.Fatal(err)
+	}
+	site := core.NewRecord(sites)
+	site.Set("name", "Benchmark")
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := validators.validate(site); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func newBenchmarkSite(b *testing.B, app *pocketbase.PocketBase, groupID string, i int) *core.Record {
+	b.Helper()
+	b.StopTimer()
+	defer b.StartTimer()
+
+	sites, err := app.FindCollectionByNameOrId("sites")
+	if err != nil {
+		b.Fatal(err)
+	}
+	site := core.NewRecord(sites)
+	site.Set("name", fmt.Sprintf("Benchmark %d", i))
+	site.Set("host", fmt.Sprintf("benchmark-%d.localhost", i))
+	site.Set("group", groupID)
+	if err := app.Save(site); err != nil {
+		b.Fatal(err)
+	}
+	return site
+}
@@ -38,6 +38,18 @@ func setup(pb *pocketbase.PocketBase) error {
 		return err
 	}
 
+	if err := internal.RegisterFieldConstraints(pb); err != nil {
+		return err
+	}
+
+	if err := internal.RegisterUploadMetadata(pb); err != nil {
+		return err
+	}
+
+	if err := internal.RegisterUploadBlobs(pb); err != nil {
+		return err
+	}
+
 	if err := internal.RegisterEmailInvitation(pb); err != nil {
 		return err
 	}
@@ -90,6 +102,38 @@ func setup(pb *pocketbase.PocketBase) error {
 		return err
 	}
 
+	if err := internal.RegisterSchemaEndpoint(pb); err != nil {
+		return err
+	}
+
+	if err := internal.RegisterUploadsEndpoint(pb); err != nil {
+		return err
+	}
+
+	if err := internal.RegisterIntegrityCheck(pb); err != nil {
+		return err
+	}
+
+	if err := internal.RegisterContentHistory(pb); err != nil {
+		return err
+	}
+
+	if err := internal.RegisterFormsEndpoint(pb); err != nil {
+		return err
+	}
+
+	if err := internal.RegisterSiteAPIKeys(pb); err != nil {
+		return err
+	}
+
+	if err := internal.RegisterContentAPIEndpoint(pb); err != nil {
+		return err
+	}
+
+	if err := internal.RegisterGraphQLEndpoint(pb); err != nil {
+		return err
+	}
+
 	if err := internal.RegisterBootstrapEndpoint(pb); err != nil {
 		return err
 	}
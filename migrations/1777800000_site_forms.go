@@ -0,0 +1,161 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Static sites served from ServeSites have nowhere to POST a contact or
+// signup form. site_forms declares which forms a site accepts (and which
+// fields they may carry), and form_submissions stores what visitors send.
+// Submissions are only ever created by the public forms endpoint, which
+// runs as the app, so the collection has no create/update rule; site
+// collaborators can read and delete them like any other site-owned record.
+func init() {
+	m.Register(
+		func(app core.App) error {
+			sites, err := app.FindCollectionByNameOrId("sites")
+			if err != nil {
+				return err
+			}
+
+			baseRule := "(@request.auth.serverRole != \"\") || (@collection.site_role_assignments.user.id ?= @request.auth.id && @collection.site_role_assignments.site.id ?= site.id)"
+
+			forms := core.NewCollection("base", "site_forms")
+			forms.ListRule = &baseRule
+			forms.ViewRule = &baseRule
+			forms.CreateRule = &baseRule
+			forms.UpdateRule = &baseRule
+			forms.DeleteRule = &baseRule
+			forms.Fields.Add(
+				&core.TextField{
+					Name:                "id",
+					Min:                 15,
+					Max:                 15,
+					Pattern:             "^[a-z0-9]+$",
+					AutogeneratePattern: "[a-z0-9]{15}",
+					System:              true,
+					Required:            true,
+					PrimaryKey:          true,
+				},
+				&core.RelationField{
+					Name:          "site",
+					CollectionId:  sites.Id,
+					CascadeDelete: true,
+					Required:      true,
+				},
+				&core.TextField{
+					Name:     "key",
+					Pattern:  "^[a-z0-9_-]+$",
+					Max:      64,
+					Required: true,
+				},
+				&core.TextField{
+					Name: "name",
+				},
+				&core.JSONField{
+					Name: "fields",
+				},
+				&core.JSONField{
+					Name: "required_fields",
+				},
+				&core.TextField{
+					Name: "honeypot",
+				},
+				&core.NumberField{
+					Name:    "rate_limit",
+					Min:     types.Pointer(0.0),
+					OnlyInt: true,
+				},
+				&core.TextField{
+					Name: "notify_email",
+				},
+				&core.URLField{
+					Name: "redirect",
+				},
+				&core.AutodateField{
+					Name:     "created",
+					OnCreate: true,
+					OnUpdate: false,
+					System:   true,
+				},
+				&core.AutodateField{
+					Name:     "updated",
+					OnCreate: true,
+					OnUpdate: true,
+					System:   true,
+				},
+			)
+			forms.AddIndex("idx_site_forms_site_key", true, "site, key", "")
+			if err := app.Save(forms); err != nil {
+				return err
+			}
+
+			submissions := core.NewCollection("base", "form_submissions")
+			submissions.ListRule = &baseRule
+			submissions.ViewRule = &baseRule
+			submissions.CreateRule = nil
+			submissions.UpdateRule = nil
+			submissions.DeleteRule = &baseRule
+			submissions.Fields.Add(
+				&core.TextField{
+					Name:                "id",
+					Min:                 15,
+					Max:                 15,
+					Pattern:             "^[a-z0-9]+$",
+					AutogeneratePattern: "[a-z0-9]{15}",
+					System:              true,
+					Required:            true,
+					PrimaryKey:          true,
+				},
+				&core.RelationField{
+					Name:          "site",
+					CollectionId:  sites.Id,
+					CascadeDelete: true,
+					Required:      true,
+				},
+				&core.RelationField{
+					Name:          "form",
+					CollectionId:  forms.Id,
+					CascadeDelete: true,
+					Required:      true,
+				},
+				&core.JSONField{
+					Name: "data",
+				},
+				&core.TextField{
+					Name: "ip",
+				},
+				&core.TextField{
+					Name: "user_agent",
+				},
+				&core.AutodateField{
+					Name:     "created",
+					OnCreate: true,
+					OnUpdate: false,
+					System:   true,
+				},
+				&core.AutodateField{
+					Name:     "updated",
+					OnCreate: true,
+					OnUpdate: true,
+					System:   true,
+				},
+			)
+			return app.Save(submissions)
+		},
+		func(app core.App) error {
+			for _, name := range []string{"form_submissions", "site_forms"} {
+				collection, err := app.FindCollectionByNameOrId(name)
+				if err != nil {
+					continue
+				}
+				if err := app.Delete(collection); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+}
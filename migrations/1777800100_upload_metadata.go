@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// site_uploads only held the file, so exported image values carried a URL
+// with no dimensions or accessibility data. width/height/mime/size/hash are
+// derived from the bytes by a record hook whenever the file changes; alt and
+// the focal point (fractions of width/height, 0..1) are editor-supplied.
+// Existing rows stay empty until their metadata is backfilled on export.
+func init() {
+	m.Register(
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("site_uploads")
+			if err != nil {
+				return err
+			}
+
+			fields := []core.Field{
+				&core.NumberField{Name: "width", Min: types.Pointer(0.0), OnlyInt: true},
+				&core.NumberField{Name: "height", Min: types.Pointer(0.0), OnlyInt: true},
+				&core.TextField{Name: "mime", Max: 255},
+				&core.NumberField{Name: "size", Min: types.Pointer(0.0), OnlyInt: true},
+				&core.TextField{Name: "hash", Max: 64},
+				&core.TextField{Name: "alt", Max: 1000},
+				&core.NumberField{Name: "focal_x", Min: types.Pointer(0.0), Max: types.Pointer(1.0)},
+				&core.NumberField{Name: "focal_y", Min: types.Pointer(0.0), Max: types.Pointer(1.0)},
+			}
+			for _, field := range fields {
+				if collection.Fields.GetByName(field.GetName()) != nil {
+					continue
+				}
+				collection.Fields.Add(field)
+			}
+
+			return app.Save(collection)
+		},
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("site_uploads")
+			if err != nil {
+				return err
+			}
+
+			for _, name := range []string{"width", "height", "mime", "size", "hash", "alt", "focal_x", "focal_y"} {
+				if field := collection.Fields.GetByName(name); field != nil {
+					collection.Fields.RemoveById(field.GetId())
+				}
+			}
+
+			return app.Save(collection)
+		},
+	)
+}
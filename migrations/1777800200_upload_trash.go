@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Unused uploads are moved to a trash before they are deleted so a scanner
+// false positive (an upload referenced from somewhere the scanner doesn't
+// look) can be undone. trashed holds when the upload was moved; empty means
+// the upload is live. Trashed uploads are excluded from generate.
+func init() {
+	m.Register(
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("site_uploads")
+			if err != nil {
+				return err
+			}
+
+			if collection.Fields.GetByName("trashed") == nil {
+				collection.Fields.Add(&core.DateField{
+					Name: "trashed",
+				})
+			}
+
+			return app.Save(collection)
+		},
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("site_uploads")
+			if err != nil {
+				return err
+			}
+
+			if field := collection.Fields.GetByName("trashed"); field != nil {
+				collection.Fields.RemoveById(field.GetId())
+			}
+
+			return app.Save(collection)
+		},
+	)
+}
@@ -0,0 +1,142 @@
+package migrations
+
+import (
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Upload bytes move to content-addressed storage (blobs/<hash>) so sites
+// that share logos and stock photos, and every clone of a site, share one
+// copy. upload_blobs keeps a reference count per hash so a blob is only
+// removed once the last site_uploads/library_uploads row pointing at it is
+// gone. It is internal bookkeeping, so it has no API rules (superusers
+// only). Only the server sets blob: a client that could would point its
+// upload at another site's bytes without taking a reference. Existing
+// uploads keep their per-record file until they are first cloned or
+// re-uploaded.
+func init() {
+	m.Register(
+		func(app core.App) error {
+			blobs := core.NewCollection("base", "upload_blobs")
+			blobs.Fields.Add(
+				&core.TextField{
+					Name:                "id",
+					Min:                 15,
+					Max:                 15,
+					Pattern:             "^[a-z0-9]+$",
+					AutogeneratePattern: "[a-z0-9]{15}",
+					System:              true,
+					Required:            true,
+					PrimaryKey:          true,
+				},
+				&core.TextField{
+					Name:     "hash",
+					Min:      64,
+					Max:      64,
+					Required: true,
+				},
+				&core.NumberField{
+					Name:    "size",
+					Min:     types.Pointer(0.0),
+					OnlyInt: true,
+				},
+				&core.TextField{
+					Name: "mime",
+					Max:  255,
+				},
+				&core.NumberField{
+					Name:    "refs",
+					Min:     types.Pointer(0.0),
+					OnlyInt: true,
+				},
+				&core.AutodateField{
+					Name:     "created",
+					OnCreate: true,
+					OnUpdate: false,
+					System:   true,
+				},
+				&core.AutodateField{
+					Name:     "updated",
+					OnCreate: true,
+					OnUpdate: true,
+					System:   true,
+				},
+			)
+			blobs.AddIndex("idx_upload_blobs_hash", true, "hash", "")
+			if err := app.Save(blobs); err != nil {
+				return err
+			}
+
+			for _, name := range []string{"site_uploads", "library_uploads"} {
+				collection, err := app.FindCollectionByNameOrId(name)
+				if err != nil {
+					return err
+				}
+				if collection.Fields.GetByName("blob") == nil {
+					collection.Fields.Add(&core.TextField{
+						Name: "blob",
+						Max:  64,
+					})
+				}
+				collection.CreateRule = guardUploadBlobRule(collection.CreateRule)
+				collection.UpdateRule = guardUploadBlobRule(collection.UpdateRule)
+				if err := app.Save(collection); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		func(app core.App) error {
+			for _, name := range []string{"site_uploads", "library_uploads"} {
+				collection, err := app.FindCollectionByNameOrId(name)
+				if err != nil {
+					return err
+				}
+				if field := collection.Fields.GetByName("blob"); field != nil {
+					collection.Fields.RemoveById(field.GetId())
+				}
+				collection.CreateRule = unguardUploadBlobRule(collection.CreateRule)
+				collection.UpdateRule = unguardUploadBlobRule(collection.UpdateRule)
+				if err := app.Save(collection); err != nil {
+					return err
+				}
+			}
+
+			blobs, err := app.FindCollectionByNameOrId("upload_blobs")
+			if err != nil {
+				return nil
+			}
+			return app.Delete(blobs)
+		},
+	)
+}
+
+const uploadBlobGuard = "@request.body.blob:isset = false"
+
+// guardUploadBlobRule adds the blob guard to a create or update rule. A
+// nil rule (superusers only) is left alone.
+func guardUploadBlobRule(rule *string) *string {
+	if rule == nil {
+		return nil
+	}
+	guarded := uploadBlobGuard
+	if *rule != "" {
+		guarded = "(" + *rule + ") && " + uploadBlobGuard
+	}
+	return &guarded
+}
+
+func unguardUploadBlobRule(rule *string) *string {
+	if rule == nil {
+		return nil
+	}
+	unguarded := strings.TrimSuffix(*rule, uploadBlobGuard)
+	if unguarded != "" {
+		unguarded = strings.TrimSuffix(strings.TrimPrefix(unguarded, "("), ") && ")
+	}
+	return &unguarded
+}
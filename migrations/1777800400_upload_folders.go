@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Bulk imports bring hundreds of files at once, so uploads get a folder to
+// group them by and the original filename (PocketBase suffixes the stored
+// name with random characters) to detect collisions against.
+func init() {
+	m.Register(
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("site_uploads")
+			if err != nil {
+				return err
+			}
+
+			for _, name := range []string{"folder", "name"} {
+				if collection.Fields.GetByName(name) == nil {
+					collection.Fields.Add(&core.TextField{
+						Name: name,
+						Max:  500,
+					})
+				}
+			}
+
+			return app.Save(collection)
+		},
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("site_uploads")
+			if err != nil {
+				return err
+			}
+
+			for _, name := range []string{"folder", "name"} {
+				if field := collection.Fields.GetByName(name); field != nil {
+					collection.Fields.RemoveById(field.GetId())
+				}
+			}
+
+			return app.Save(collection)
+		},
+	)
+}
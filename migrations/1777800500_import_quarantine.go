@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Page and section content that doesn't match the schema at import time
+// (a field removed from fields.yaml, a repeater pushed before its
+// subfields) is kept in import_quarantine instead of being dropped. section
+// and page are plain ids rather than relations so a quarantined value
+// outlives the record it came from until someone purges it. Rows are only
+// written by the import, so there is no create/update rule.
+func init() {
+	m.Register(
+		func(app core.App) error {
+			sites, err := app.FindCollectionByNameOrId("sites")
+			if err != nil {
+				return err
+			}
+
+			baseRule := "(@request.auth.serverRole != \"\") || (@collection.site_role_assignments.user.id ?= @request.auth.id && @collection.site_role_assignments.site.id ?= site.id)"
+
+			collection := core.NewCollection("base", "import_quarantine")
+			collection.ListRule = &baseRule
+			collection.ViewRule = &baseRule
+			collection.CreateRule = nil
+			collection.UpdateRule = nil
+			collection.DeleteRule = &baseRule
+			collection.Fields.Add(
+				&core.TextField{
+					Name:                "id",
+					Min:                 15,
+					Max:                 15,
+					Pattern:             "^[a-z0-9]+$",
+					AutogeneratePattern: "[a-z0-9]{15}",
+					System:              true,
+					Required:            true,
+					PrimaryKey:          true,
+				},
+				&core.RelationField{
+					Name:          "site",
+					CollectionId:  sites.Id,
+					CascadeDelete: true,
+					Required:      true,
+				},
+				&core.TextField{
+					Name: "kind",
+				},
+				&core.TextField{
+					Name: "file",
+				},
+				&core.TextField{
+					Name: "path",
+				},
+				&core.TextField{
+					Name: "block",
+				},
+				&core.TextField{
+					Name: "page",
+				},
+				&core.TextField{
+					Name: "section",
+				},
+				&core.TextField{
+					Name: "target",
+				},
+				&core.JSONField{
+					Name: "value",
+				},
+				&core.AutodateField{
+					Name:     "created",
+					OnCreate: true,
+					OnUpdate: false,
+					System:   true,
+				},
+				&core.AutodateField{
+					Name:     "updated",
+					OnCreate: true,
+					OnUpdate: true,
+					System:   true,
+				},
+			)
+			collection.AddIndex("idx_import_quarantine_site", false, "site", "")
+			return app.Save(collection)
+		},
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("import_quarantine")
+			if err != nil {
+				return nil
+			}
+			return app.Delete(collection)
+		},
+	)
+}
@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// site_api_keys hold the keys frontends send to the headless content API
+// (Authorization: Bearer <key>). A key only ever reads the site it belongs
+// to. The key itself is generated on create and can't be changed, so a
+// leaked key is revoked by deleting it and creating a new one.
+func init() {
+	m.Register(
+		func(app core.App) error {
+			sites, err := app.FindCollectionByNameOrId("sites")
+			if err != nil {
+				return err
+			}
+
+			baseRule := "(@request.auth.serverRole != \"\") || (@collection.site_role_assignments.user.id ?= @request.auth.id && @collection.site_role_assignments.site.id ?= site.id)"
+			updateRule := "(" + baseRule + ") && @request.body.key:isset = false && @request.body.site:isset = false"
+
+			collection := core.NewCollection("base", "site_api_keys")
+			collection.ListRule = &baseRule
+			collection.ViewRule = &baseRule
+			collection.CreateRule = &baseRule
+			collection.UpdateRule = &updateRule
+			collection.DeleteRule = &baseRule
+			collection.Fields.Add(
+				&core.TextField{
+					Name:                "id",
+					Min:                 15,
+					Max:                 15,
+					Pattern:             "^[a-z0-9]+$",
+					AutogeneratePattern: "[a-z0-9]{15}",
+					System:              true,
+					Required:            true,
+					PrimaryKey:          true,
+				},
+				&core.RelationField{
+					Name:          "site",
+					CollectionId:  sites.Id,
+					CascadeDelete: true,
+					Required:      true,
+				},
+				&core.TextField{
+					Name: "name",
+				},
+				&core.TextField{
+					Name:                "key",
+					Min:                 40,
+					Max:                 40,
+					Pattern:             "^[a-zA-Z0-9]+$",
+					AutogeneratePattern: "[a-zA-Z0-9]{40}",
+					Required:            true,
+				},
+				&core.AutodateField{
+					Name:     "created",
+					OnCreate: true,
+					OnUpdate: false,
+					System:   true,
+				},
+				&core.AutodateField{
+					Name:     "updated",
+					OnCreate: true,
+					OnUpdate: true,
+					System:   true,
+				},
+			)
+			collection.AddIndex("idx_site_api_keys_key", true, "key", "")
+			collection.AddIndex("idx_site_api_keys_site", false, "site", "")
+			return app.Save(collection)
+		},
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("site_api_keys")
+			if err != nil {
+				return nil
+			}
+			return app.Delete(collection)
+		},
+	)
+}
@@ -0,0 +1,104 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// API keys grow from read-only content keys into credentials for remote
+// automation (export, import, publish), so they are no longer kept in
+// plain text: only a SHA-256 of the key is stored, and the key is shown
+// once, in the create response. Each key carries the scopes it may use,
+// an optional expiry, and when it was last used. Keys created before this
+// keep working with the content:read scope they implicitly had.
+func init() {
+	m.Register(
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("site_api_keys")
+			if err != nil {
+				return err
+			}
+
+			collection.Fields.Add(
+				&core.TextField{
+					Name:   "key_hash",
+					Hidden: true,
+				},
+				&core.TextField{
+					Name: "hint",
+					Max:  16,
+				},
+				&core.SelectField{
+					Name:      "scopes",
+					MaxSelect: 5,
+					Values:    []string{"content:read", "content:write", "publish", "export", "import"},
+				},
+				&core.DateField{
+					Name: "expires",
+				},
+				&core.DateField{
+					Name: "last_used",
+				},
+			)
+			if err := app.Save(collection); err != nil {
+				return err
+			}
+
+			existing, err := app.FindAllRecords(collection)
+			if err != nil {
+				return err
+			}
+			for _, key := range existing {
+				sum := sha256.Sum256([]byte(key.GetString("key")))
+				key.Set("key_hash", hex.EncodeToString(sum[:]))
+				key.Set("hint", key.GetString("key")[:4])
+				key.Set("scopes", []string{"content:read"})
+				if err := app.SaveNoValidate(key); err != nil {
+					return err
+				}
+			}
+
+			// The guards apply to both branches of the access rule, so the
+			// rule is parenthesized before they are added.
+			updateRule := "((@request.auth.serverRole != \"\") || (@collection.site_role_assignments.user.id ?= @request.auth.id && @collection.site_role_assignments.site.id ?= site.id))"
+			updateRule += " && @request.body.key_hash:isset = false && @request.body.hint:isset = false && @request.body.site:isset = false && @request.body.last_used:isset = false"
+			collection.UpdateRule = &updateRule
+			collection.RemoveIndex("idx_site_api_keys_key")
+			collection.Fields.RemoveByName("key")
+			collection.AddIndex("idx_site_api_keys_key_hash", true, "key_hash", "key_hash != ''")
+			return app.Save(collection)
+		},
+		func(app core.App) error {
+			// The plain keys are gone; keys issued since can't be recovered,
+			// so rolling back removes them all.
+			collection, err := app.FindCollectionByNameOrId("site_api_keys")
+			if err != nil {
+				return nil
+			}
+			if _, err := app.DB().NewQuery("DELETE FROM {{site_api_keys}}").Execute(); err != nil {
+				return err
+			}
+
+			updateRule := "((@request.auth.serverRole != \"\") || (@collection.site_role_assignments.user.id ?= @request.auth.id && @collection.site_role_assignments.site.id ?= site.id))"
+			updateRule += " && @request.body.key:isset = false && @request.body.site:isset = false"
+			collection.UpdateRule = &updateRule
+			collection.RemoveIndex("idx_site_api_keys_key_hash")
+			for _, name := range []string{"key_hash", "hint", "scopes", "expires", "last_used"} {
+				collection.Fields.RemoveByName(name)
+			}
+			collection.Fields.Add(&core.TextField{
+				Name:                "key",
+				Min:                 40,
+				Max:                 40,
+				Pattern:             "^[a-zA-Z0-9]+$",
+				AutogeneratePattern: "[a-zA-Z0-9]{40}",
+				Required:            true,
+			})
+			collection.AddIndex("idx_site_api_keys_key", true, "key", "")
+			return app.Save(collection)
+		},
+	)
+}
@@ -0,0 +1,108 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// content_revisions record every create, update and delete of pages,
+// sections, blocks and entries with the values before and after. The
+// server writes them; through the API they can only be read, so history
+// can't be edited after the fact.
+func init() {
+	m.Register(
+		func(app core.App) error {
+			sites, err := app.FindCollectionByNameOrId("sites")
+			if err != nil {
+				return err
+			}
+			users, err := app.FindCollectionByNameOrId("users")
+			if err != nil {
+				return err
+			}
+
+			baseRule := "(@request.auth.serverRole != \"\") || (@collection.site_role_assignments.user.id ?= @request.auth.id && @collection.site_role_assignments.site.id ?= site.id)"
+
+			collection := core.NewCollection("base", "content_revisions")
+			collection.ListRule = &baseRule
+			collection.ViewRule = &baseRule
+			collection.Fields.Add(
+				&core.TextField{
+					Name:                "id",
+					Min:                 15,
+					Max:                 15,
+					Pattern:             "^[a-z0-9]+$",
+					AutogeneratePattern: "[a-z0-9]{15}",
+					System:              true,
+					Required:            true,
+					PrimaryKey:          true,
+				},
+				&core.RelationField{
+					Name:          "site",
+					CollectionId:  sites.Id,
+					CascadeDelete: true,
+					Required:      true,
+				},
+				&core.TextField{
+					Name:     "collection",
+					Required: true,
+				},
+				&core.TextField{
+					Name:     "record",
+					Required: true,
+				},
+				&core.SelectField{
+					Name:      "action",
+					Values:    []string{"create", "update", "delete"},
+					MaxSelect: 1,
+					Required:  true,
+				},
+				&core.RelationField{
+					Name:         "user",
+					CollectionId: users.Id,
+				},
+				// The page, block and section the record belongs to, kept as
+				// plain ids so the history outlives them.
+				&core.TextField{
+					Name: "page",
+				},
+				&core.TextField{
+					Name: "symbol",
+				},
+				&core.TextField{
+					Name: "section",
+				},
+				&core.JSONField{
+					Name: "before",
+				},
+				&core.JSONField{
+					Name: "after",
+				},
+				// restored_from is the revision a restore brought the record
+				// back to.
+				&core.TextField{
+					Name: "restored_from",
+				},
+				&core.AutodateField{
+					Name:     "created",
+					OnCreate: true,
+					OnUpdate: false,
+					System:   true,
+				},
+			)
+			collection.AddIndex("idx_content_revisions_site", false, "site, created", "")
+			collection.AddIndex("idx_content_revisions_record", false, "collection, record", "")
+			collection.AddIndex("idx_content_revisions_page", false, "page", "")
+			collection.AddIndex("idx_content_revisions_symbol", false, "symbol", "")
+			collection.AddIndex("idx_content_revisions_section", false, "section", "")
+			return app.Save(collection)
+		},
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("content_revisions")
+			if err != nil {
+				return nil
+			}
+			return app.Delete(collection)
+		},
+	)
+}
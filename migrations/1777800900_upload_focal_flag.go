@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// A focal point of (0, 0) is the top-left corner, a real choice, so the
+// coordinates alone can't say whether one was set. has_focal does. Rows
+// with a non-zero focal point already had one.
+func init() {
+	m.Register(
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("site_uploads")
+			if err != nil {
+				return err
+			}
+			if collection.Fields.GetByName("has_focal") == nil {
+				collection.Fields.Add(&core.BoolField{Name: "has_focal"})
+				if err := app.Save(collection); err != nil {
+					return err
+				}
+			}
+
+			_, err = app.DB().NewQuery("UPDATE site_uploads SET has_focal = TRUE WHERE focal_x != 0 OR focal_y != 0").Execute()
+			return err
+		},
+		func(app core.App) error {
+			collection, err := app.FindCollectionByNameOrId("site_uploads")
+			if err != nil {
+				return err
+			}
+			if field := collection.Fields.GetByName("has_focal"); field != nil {
+				collection.Fields.RemoveById(field.GetId())
+			}
+			return app.Save(collection)
+		},
+	)
+}
@@ -357,11 +357,13 @@ export const useContent = <Collection extends keyof typeof ENTITY_COLLECTIONS>(e
 							: URL.createObjectURL(upload.file))
 				const input_url: string | undefined = normalized_value.url as string | undefined
 				const url = input_url || upload_url
-				const alt: string = (normalized_value.alt as string) ?? ''
-				const width: number | null | undefined = normalized_value.width as number | null | undefined
-				const height: number | null | undefined = normalized_value.height as number | null | undefined
-				content[entry.locale]![field.key] = { alt, url, width, height }
-				content[entry.locale]![field.key] = { alt, url }
+				// Alt text, dimensions and the focal point come from the upload
+				// unless the value sets its own (an external URL has no upload).
+				const alt: string = (normalized_value.alt as string) || upload?.alt || ''
+				const width: number | null | undefined = (normalized_value.width as number | null | undefined) ?? (upload?.width || undefined)
+				const height: number | null | undefined = (normalized_value.height as number | null | undefined) ?? (upload?.height || undefined)
+				const focal_point = upload?.has_focal ? { x: upload.focal_x ?? 0, y: upload.focal_y ?? 0 } : null
+				content[entry.locale]![field.key] = { alt, url, width, height, focal_point }
 			}
 
 			// Handle page fields specially - get content from the page entity
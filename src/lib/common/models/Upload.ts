@@ -2,7 +2,13 @@ import { z } from 'zod'
 
 export const Upload = z.object({
 	id: z.string().nonempty(),
-	file: z.string().nonempty().or(z.file())
+	file: z.string().nonempty().or(z.file()),
+	width: z.number().optional(),
+	height: z.number().optional(),
+	alt: z.string().optional(),
+	focal_x: z.number().optional(),
+	focal_y: z.number().optional(),
+	has_focal: z.boolean().optional()
 })
 
 export type Upload = z.infer<typeof Upload>